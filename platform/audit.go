@@ -0,0 +1,694 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+var auditWorker *AuditWorker
+
+// The default retention of audit log, which is configurable by API.
+const auditDefaultRetention = 90 * 24 * time.Hour
+
+// The max size of request body to parse for summary, and the max size of summary.
+const auditMaxBody = 64 * 1024
+const auditMaxSummary = 1024
+
+// All authenticated requests except GET are audited, and these endpoints are audited even if not
+// authenticated, for example, login. The value is the field of request body which is required to audit
+// the request, or * to audit all requests. Some endpoints are also used to query the configure, for
+// example, the forward secret is updated only if there is an action.
+var auditEndpoints = map[string]string{
+	"/terraform/v1/mgmt/init":                          "password",
+	"/terraform/v1/mgmt/login":                         "*",
+	"/terraform/v1/mgmt/limits/update":                 "*",
+	"/terraform/v1/mgmt/openai/update":                 "*",
+	"/terraform/v1/mgmt/beian/update":                  "*",
+	"/terraform/v1/mgmt/hphls/update":                  "*",
+	"/terraform/v1/mgmt/hlsll/update":                  "*",
+	"/terraform/v1/mgmt/auto-self-signed-certificate":  "*",
+	"/terraform/v1/mgmt/ssl":                           "*",
+	"/terraform/v1/mgmt/letsencrypt":                   "*",
+	"/terraform/v1/mgmt/streams/kickoff":               "*",
+	"/terraform/v1/mgmt/hooks/apply":                   "*",
+	"/terraform/v1/mgmt/audit/retention":               "days",
+	"/terraform/v1/mgmt/token/revoke":                  "*",
+	"/terraform/v1/mgmt/logout":                        "*",
+	"/terraform/v1/mgmt/logout-all":                    "*",
+	"/terraform/v1/mgmt/sessions/remove":               "*",
+	"/terraform/v1/mgmt/oidc/update":                   "*",
+	"/terraform/v1/mgmt/oidc/callback":                 "*",
+	"/terraform/v1/mgmt/secrets/rotate":                "*",
+	"/terraform/v1/hooks/srs/secret/update":            "*",
+	"/terraform/v1/hooks/srs/secret/disable":           "*",
+	"/terraform/v1/hooks/srs/secret/legacy":            "*",
+	"/terraform/v1/hooks/srs/publish/token":            "*",
+	"/terraform/v1/hooks/srs/play/token":               "*",
+	"/terraform/v1/hooks/srs/play/apply":               "*",
+	"/terraform/v1/hooks/srs/ip/rules/update":          "*",
+	"/terraform/v1/hooks/srs/ip/rules/remove":          "*",
+	"/terraform/v1/hooks/srs/ip/rules/reset":           "*",
+	"/terraform/v1/hooks/record/apply":                 "*",
+	"/terraform/v1/hooks/record/remove":                "*",
+	"/terraform/v1/hooks/record/end":                   "*",
+	"/terraform/v1/hooks/record/post-processing":       "*",
+	"/terraform/v1/hooks/dvr/apply":                    "*",
+	"/terraform/v1/hooks/vod/apply":                    "*",
+	"/terraform/v1/ffmpeg/forward/secret":              "action",
+	"/terraform/v1/ffmpeg/forward/destinations/create": "*",
+	"/terraform/v1/ffmpeg/forward/destinations/update": "*",
+	"/terraform/v1/ffmpeg/forward/destinations/remove": "*",
+	"/terraform/v1/ffmpeg/forward/destinations/import": "*",
+	"/terraform/v1/ffmpeg/vlive/secret":                "action",
+	"/terraform/v1/ffmpeg/vlive/playlist/update":       "platform",
+	"/terraform/v1/ffmpeg/vlive/playlist/append":       "platform",
+	"/terraform/v1/ffmpeg/vlive/grid/update":           "platform",
+	"/terraform/v1/ffmpeg/vlive/profile/update":        "platform",
+	"/terraform/v1/ffmpeg/vlive/seek":                  "platform",
+	"/terraform/v1/ffmpeg/vlive/remote":                "*",
+	"/terraform/v1/ffmpeg/vlive/s3/update":             "*",
+	"/terraform/v1/ffmpeg/overlay/update":              "platform",
+	"/terraform/v1/ffmpeg/overlay/ticker":              "platform",
+	"/terraform/v1/ffmpeg/vlive/source":                "*",
+	"/terraform/v1/ffmpeg/camera/secret":               "action",
+	"/terraform/v1/ffmpeg/camera/source":               "*",
+	"/terraform/v1/ffmpeg/camera/onvif/credential":     "*",
+	"/terraform/v1/ffmpeg/camera/ptz/preset":           "uuid",
+	"/terraform/v1/ffmpeg/camera/motion":               "platform",
+	"/terraform/v1/ffmpeg/mosaic/update":               "*",
+	"/terraform/v1/ffmpeg/mosaic/remove":               "uuid",
+	"/terraform/v1/ffmpeg/transcode/apply":             "*",
+	"/terraform/v1/tencent/cam/secret":                 "*",
+	"/terraform/v1/live/room/create":                   "*",
+	"/terraform/v1/live/room/update":                   "*",
+	"/terraform/v1/live/room/remove":                   "*",
+	"/terraform/v1/dubbing/create":                     "*",
+	"/terraform/v1/dubbing/update":                     "*",
+	"/terraform/v1/dubbing/remove":                     "*",
+	"/terraform/v1/ai/ocr/apply":                       "*",
+	"/terraform/v1/ai/ocr/reset":                       "*",
+	"/terraform/v1/ai/transcript/apply":                "*",
+	"/terraform/v1/ai/transcript/reset":                "*",
+	"/terraform/v1/ai/transcript/clear-subtitle":       "*",
+	"/terraform/v1/hls/input/create":                   "*",
+	"/terraform/v1/hls/input/update":                   "*",
+	"/terraform/v1/hls/input/delete":                   "*",
+	"/terraform/v1/srt/input/create":                   "*",
+	"/terraform/v1/srt/input/update":                   "*",
+	"/terraform/v1/srt/input/delete":                   "*",
+	"/terraform/v1/bypass/transcode/create":            "*",
+	"/terraform/v1/bypass/transcode/update":            "*",
+	"/terraform/v1/bypass/transcode/delete":            "*",
+	"/terraform/v1/monitoring/config/update":           "*",
+}
+
+// AuditEntry is a record of the management API call which changes the state of system.
+type AuditEntry struct {
+	// The entry id.
+	ID string `json:"id"`
+	// The time of request.
+	Time string `json:"time"`
+	// The actor who makes the request, for example, apiSecret, session(id) or token(id).
+	Actor string `json:"actor"`
+	// The source IP and user agent of request.
+	IP        string `json:"ip"`
+	UserAgent string `json:"ua,omitempty"`
+	// The endpoint and method of request.
+	Endpoint string `json:"endpoint"`
+	Method   string `json:"method"`
+	// The redacted request summary.
+	Request string `json:"request,omitempty"`
+	// The result, ok or error.
+	Result string `json:"result"`
+	// The HTTP status of response.
+	Status int `json:"status"`
+	// The error message, if failed.
+	Error string `json:"error,omitempty"`
+}
+
+func (v *AuditEntry) String() string {
+	return fmt.Sprintf("id=%v, time=%v, actor=%v, ip=%v, endpoint=%v, method=%v, result=%v, status=%v",
+		v.ID, v.Time, v.Actor, v.IP, v.Endpoint, v.Method, v.Result, v.Status)
+}
+
+// AuditFilter is the filter to query audit entries, all fields are optional.
+type AuditFilter struct {
+	// Match the actor exactly.
+	Actor string `json:"actor"`
+	// Match the source IP exactly.
+	IP string `json:"ip"`
+	// Match the endpoint by substring.
+	Endpoint string `json:"endpoint"`
+	// Match the result, ok or error.
+	Result string `json:"result"`
+	// The time range in RFC3339.
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+func (v *AuditFilter) String() string {
+	return fmt.Sprintf("actor=%v, ip=%v, endpoint=%v, result=%v, start=%v, end=%v",
+		v.Actor, v.IP, v.Endpoint, v.Result, v.Start, v.End)
+}
+
+// Match whether the entry matches the filter, except the time range.
+func (v *AuditFilter) Match(entry *AuditEntry) bool {
+	if v.Actor != "" && v.Actor != entry.Actor {
+		return false
+	}
+	if v.IP != "" && v.IP != entry.IP {
+		return false
+	}
+	if v.Endpoint != "" && !strings.Contains(entry.Endpoint, v.Endpoint) {
+		return false
+	}
+	if v.Result != "" && v.Result != entry.Result {
+		return false
+	}
+	return true
+}
+
+// auditSensitiveKeys is the keys of request to redact, matched by substring in lower case.
+var auditSensitiveKeys = []string{"secret", "password", "passwd", "token", "key", "credential"}
+
+// auditRedact redact the sensitive fields of request, including the password in URL.
+func auditRedact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			sensitive := false
+			for _, key := range auditSensitiveKeys {
+				if strings.Contains(strings.ToLower(k), key) {
+					sensitive = true
+					break
+				}
+			}
+
+			if sensitive {
+				if s, ok := e.(string); !ok || s != "" {
+					v[k] = "***"
+				}
+			} else {
+				v[k] = auditRedact(e)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, e := range v {
+			v[i] = auditRedact(e)
+		}
+		return v
+	case string:
+		if strings.Contains(v, "://") && strings.Contains(v, "@") {
+			if u, err := RebuildStreamURL(v); err == nil && u.User != nil {
+				if _, ok := u.User.Password(); ok {
+					u.User = url.UserPassword(u.User.Username(), "xxx")
+					return u.String()
+				}
+			}
+		}
+		return v
+	}
+	return v
+}
+
+// auditRequestSummary build the redacted summary of request body.
+func auditRequestSummary(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if len(body) > auditMaxBody {
+		return fmt.Sprintf("body %vB", len(body))
+	}
+
+	var obj interface{}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return fmt.Sprintf("body %vB, not json", len(body))
+	}
+
+	b, err := json.Marshal(auditRedact(obj))
+	if err != nil {
+		return fmt.Sprintf("body %vB", len(body))
+	}
+
+	summary := string(b)
+	if len(summary) > auditMaxSummary {
+		summary = summary[:auditMaxSummary] + "..."
+	}
+	return summary
+}
+
+// auditClaims parse the claims of token in request, by the bearer or the token in query or body. The bearer is
+// true if the request uses the bearer secret, which is the admin. The claims is nil if not authenticated.
+func auditClaims(r *http.Request, body []byte) (claims *MgmtTokenClaims, bearer bool) {
+	token := r.URL.Query().Get("token")
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		authParts := strings.Split(authorization, " ")
+		if len(authParts) != 2 {
+			return nil, false
+		}
+		if authParts[1] == envApiSecret() {
+			return nil, true
+		}
+		// The bearer is the access token of session.
+		token = authParts[1]
+	}
+
+	if token == "" && len(body) > 0 {
+		var obj struct {
+			Token string `json:"token"`
+		}
+		_ = json.Unmarshal(body, &obj)
+		token = obj.Token
+	}
+	if token == "" {
+		return nil, false
+	}
+
+	claims, err := parseMgmtToken(envApiSecret(), token)
+	if err != nil {
+		return nil, false
+	}
+	return claims, false
+}
+
+// auditActor identify the actor of request by the claims of token, see auditClaims. The actor is anonymous if
+// not authenticated.
+func auditActor(ctx context.Context, claims *MgmtTokenClaims, bearer bool) string {
+	if bearer {
+		return "apiSecret"
+	}
+	if claims == nil {
+		return "anonymous"
+	}
+
+	// Resolve the subject of session, the password login is the admin.
+	var user string
+	if claims.SessionID != "" {
+		if session, err := loadMgmtSession(ctx, claims.SessionID); err == nil && session != nil {
+			user = ChooseNotEmpty(session.User, mgmtRoleAdmin)
+		}
+	}
+	return mgmtSessionActor(claims, user)
+}
+
+// auditResponseWriter record the status and error of response.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (v *auditResponseWriter) WriteHeader(statusCode int) {
+	v.status = statusCode
+	v.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (v *auditResponseWriter) Write(b []byte) (int, error) {
+	if v.body.Len() < auditMaxSummary {
+		v.body.Write(b)
+	}
+	return v.ResponseWriter.Write(b)
+}
+
+type AuditWorker struct {
+	// The global context.
+	ctx context.Context
+	wg  sync.WaitGroup
+}
+
+func NewAuditWorker() *AuditWorker {
+	return &AuditWorker{}
+}
+
+func (v *AuditWorker) Close() error {
+	v.wg.Wait()
+	return nil
+}
+
+func (v *AuditWorker) Start(ctx context.Context) error {
+	v.ctx = ctx
+
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+
+		for {
+			if err := v.cleanup(ctx); err != nil {
+				logger.Wf(ctx, "audit: cleanup err %+v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(1 * time.Hour):
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Serve the request by next handler, and record the audit entry if the request is authenticated and not
+// GET, or the endpoint is in auditEndpoints. The role of session is authorized by Authenticate, and also here
+// to audit the denied request, see mgmtViewerEndpoints.
+func (v *AuditWorker) Serve(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	setMgmtEndpoint(r)
+
+	field, ok := auditEndpoints[r.URL.Path]
+
+	// The uploaded file is large, so we never parse the body.
+	upload := strings.HasPrefix(r.URL.Path, "/terraform/v1/ffmpeg/vlive/upload/")
+
+	// Read the body to summarize, and restore it for the next handler.
+	var body []byte
+	if (ok || r.Method != http.MethodGet) && !upload && r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(io.LimitReader(r.Body, auditMaxBody+1)); err != nil {
+			ohttp.WriteError(v.ctx, w, r, errors.Wrapf(err, "read body"))
+			return
+		}
+		r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	}
+
+	// The viewer is only allowed to query the state of system.
+	claims, bearer := auditClaims(r, body)
+	var denied error
+	if claims != nil {
+		denied = authorizeMgmtRole(claims.Role, r.Method, r.URL.Path)
+	}
+
+	if denied == nil {
+		if !ok && r.Method == http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if ok && field != "*" {
+			var obj map[string]interface{}
+			_ = json.Unmarshal(body, &obj)
+			if e, ok := obj[field]; !ok || e == "" || e == float64(0) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		// Ignore the request which is not authenticated, for example, the callback of SRS.
+		if !ok && claims == nil && !bearer {
+			next.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	entry := &AuditEntry{
+		ID: uuid.NewString(), Time: time.Now().Format(time.RFC3339), Actor: auditActor(v.ctx, claims, bearer),
+		IP: httpClientIP(r), UserAgent: r.UserAgent(), Endpoint: r.URL.Path, Method: r.Method,
+		Request: auditRequestSummary(body),
+	}
+
+	aw := &auditResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	if denied != nil {
+		writeForbidden(v.ctx, aw, r, denied)
+	} else {
+		next.ServeHTTP(aw, r)
+	}
+
+	entry.Status, entry.Result = aw.status, "ok"
+	if aw.status >= http.StatusBadRequest {
+		entry.Result, entry.Error = "error", strings.TrimSpace(aw.body.String())
+	}
+
+	if err := v.Record(v.ctx, entry); err != nil {
+		logger.Wf(v.ctx, "audit: record %v err %+v", entry.String(), err)
+	}
+}
+
+// Record save the entry to redis.
+func (v *AuditWorker) Record(ctx context.Context, entry *AuditEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrapf(err, "marshal %v", entry.String())
+	}
+
+	score := float64(time.Now().UnixMilli())
+	if err := rdb.ZAdd(ctx, SRS_AUDIT_LOG, &redis.Z{Score: score, Member: string(b)}).Err(); err != nil {
+		return errors.Wrapf(err, "zadd %v %v %v", SRS_AUDIT_LOG, score, string(b))
+	}
+
+	logger.Tf(ctx, "audit: record ok, %v", entry.String())
+	return nil
+}
+
+// Query the entries by filter, from the latest to the oldest, at most limit entries.
+func (v *AuditWorker) Query(ctx context.Context, filter *AuditFilter, limit int) ([]*AuditEntry, error) {
+	min, max := "-inf", "+inf"
+	if filter.Start != "" {
+		if t, err := time.Parse(time.RFC3339, filter.Start); err != nil {
+			return nil, errors.Wrapf(err, "parse start %v", filter.Start)
+		} else {
+			min = fmt.Sprintf("%v", t.UnixMilli())
+		}
+	}
+	if filter.End != "" {
+		if t, err := time.Parse(time.RFC3339, filter.End); err != nil {
+			return nil, errors.Wrapf(err, "parse end %v", filter.End)
+		} else {
+			max = fmt.Sprintf("%v", t.UnixMilli())
+		}
+	}
+
+	entries := []*AuditEntry{}
+	for offset := int64(0); len(entries) < limit; {
+		const pageSize = 500
+		members, err := rdb.ZRevRangeByScore(ctx, SRS_AUDIT_LOG, &redis.ZRangeBy{
+			Min: min, Max: max, Offset: offset, Count: pageSize,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			return nil, errors.Wrapf(err, "zrevrangebyscore %v %v %v", SRS_AUDIT_LOG, max, min)
+		}
+
+		for _, member := range members {
+			var entry AuditEntry
+			if err := json.Unmarshal([]byte(member), &entry); err != nil {
+				return nil, errors.Wrapf(err, "unmarshal %v", member)
+			}
+
+			if filter.Match(&entry) && len(entries) < limit {
+				entries = append(entries, &entry)
+			}
+		}
+
+		if len(members) < pageSize {
+			break
+		}
+		offset += pageSize
+	}
+
+	return entries, nil
+}
+
+// queryRetention load the retention of audit log.
+func (v *AuditWorker) queryRetention(ctx context.Context) (time.Duration, error) {
+	days, err := rdb.HGet(ctx, SRS_AUDIT_CONFIG, "retention").Int()
+	if err != nil && err != redis.Nil {
+		return 0, errors.Wrapf(err, "hget %v retention", SRS_AUDIT_CONFIG)
+	}
+	if days <= 0 {
+		return auditDefaultRetention, nil
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// cleanup remove the expired entries.
+func (v *AuditWorker) cleanup(ctx context.Context) error {
+	retention, err := v.queryRetention(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "query retention")
+	}
+
+	max := fmt.Sprintf("%v", time.Now().Add(-retention).UnixMilli())
+	removed, err := rdb.ZRemRangeByScore(ctx, SRS_AUDIT_LOG, "-inf", max).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "zremrangebyscore %v -inf %v", SRS_AUDIT_LOG, max)
+	}
+
+	if removed > 0 {
+		logger.Tf(ctx, "audit: cleanup ok, retention=%v, removed=%v", retention, removed)
+	}
+	return nil
+}
+
+func (v *AuditWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/mgmt/audit/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var limit int
+			var filter AuditFilter
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				Limit *int    `json:"limit"`
+				*AuditFilter
+			}{
+				Token: &token, Limit: &limit, AuditFilter: &filter,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if limit <= 0 {
+				limit = 100
+			} else if limit > 1000 {
+				return errors.Errorf("limit %v exceed 1000", limit)
+			}
+
+			entries, err := v.Query(ctx, &filter, limit)
+			if err != nil {
+				return errors.Wrapf(err, "query %v", filter.String())
+			}
+
+			ohttp.WriteData(ctx, w, r, entries)
+			logger.Tf(ctx, "audit query ok, %v, limit=%v, entries=%v, token=%vB",
+				filter.String(), limit, len(entries), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/audit/export"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, format string
+			var filter AuditFilter
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string `json:"token"`
+				Format *string `json:"format"`
+				*AuditFilter
+			}{
+				Token: &token, Format: &format, AuditFilter: &filter,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if format == "" {
+				format = "json"
+			} else if format != "json" && format != "csv" {
+				return errors.Errorf("invalid format %v", format)
+			}
+
+			entries, err := v.Query(ctx, &filter, 100000)
+			if err != nil {
+				return errors.Wrapf(err, "query %v", filter.String())
+			}
+
+			filename := fmt.Sprintf("audit-%v.%v", time.Now().Format("20060102150405"), format)
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%v", filename))
+
+			if format == "csv" {
+				w.Header().Set("Content-Type", "text/csv")
+				cw := csv.NewWriter(w)
+				_ = cw.Write([]string{"id", "time", "actor", "ip", "ua", "endpoint", "method", "request", "result", "status", "error"})
+				for _, e := range entries {
+					_ = cw.Write([]string{e.ID, e.Time, e.Actor, e.IP, e.UserAgent, e.Endpoint, e.Method,
+						e.Request, e.Result, fmt.Sprintf("%v", e.Status), e.Error})
+				}
+				cw.Flush()
+			} else {
+				w.Header().Set("Content-Type", ohttp.HttpJson)
+				if err := json.NewEncoder(w).Encode(entries); err != nil {
+					return errors.Wrapf(err, "encode")
+				}
+			}
+
+			logger.Tf(ctx, "audit export ok, %v, format=%v, entries=%v, token=%vB",
+				filter.String(), format, len(entries), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/audit/retention"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var days int
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				Days  *int    `json:"days"`
+			}{
+				Token: &token, Days: &days,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			// Update the retention if days is specified, or query it.
+			if days < 0 {
+				return errors.Errorf("invalid days %v", days)
+			} else if days > 0 {
+				if err := rdb.HSet(ctx, SRS_AUDIT_CONFIG, "retention", days).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hset %v retention %v", SRS_AUDIT_CONFIG, days)
+				}
+				if err := v.cleanup(ctx); err != nil {
+					return errors.Wrapf(err, "cleanup")
+				}
+			}
+
+			retention, err := v.queryRetention(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "query retention")
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Days int `json:"days"`
+			}{
+				Days: int(retention / (24 * time.Hour)),
+			})
+			logger.Tf(ctx, "audit retention ok, days=%v, retention=%v, token=%vB", days, retention, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
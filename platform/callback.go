@@ -660,6 +660,101 @@ func (v *CallbackWorker) OnOCR(ctx context.Context, action SrsAction, taskUUID s
 	return nil
 }
 
+// OnSystemMessage post the system event, for example, the login failure, with the fields of message.
+// Note that the system events are always posted if callback is enabled, because they're used to alert.
+func (v *CallbackWorker) OnSystemMessage(ctx context.Context, action SrsAction, message map[string]interface{}) error {
+	var config CallbackConfig
+	func() {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		config = v.ephemeralConfig
+	}()
+
+	if !config.All || config.Target == "" {
+		return nil
+	}
+
+	req := map[string]interface{}{}
+	for k, e := range message {
+		req[k] = e
+	}
+	req["request_id"] = uuid.NewString()
+	req["action"] = string(action)
+	req["opaque"] = config.Opaque
+
+	if err := v.postEvent(ctx, &config, req); err != nil {
+		return errors.Wrapf(err, "callback with conf %v, req %v", config.String(), req)
+	}
+	return nil
+}
+
+// postEvent post the request to callback target, and check the response code.
+func (v *CallbackWorker) postEvent(ctx context.Context, config *CallbackConfig, req interface{}) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrapf(err, "marshal req")
+	}
+
+	if err := rdb.HSet(ctx, SRS_HOOKS, "req", string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v req %v", SRS_HOOKS, string(b))
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, config.Target, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrapf(err, "new request")
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	client := http.DefaultClient
+	if strings.HasPrefix(config.Target, "https://") {
+		client = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+				},
+			},
+		}
+	}
+
+	res, err := client.Do(r)
+	if err != nil {
+		return errors.Wrapf(err, "http post with %s", string(b))
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("response status %v", res.StatusCode)
+	}
+
+	b2, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrapf(err, "read body")
+	}
+
+	if err := rdb.HSet(ctx, SRS_HOOKS, "res", string(b2)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v res %v", SRS_HOOKS, string(b2))
+	}
+
+	// The response is a number, or a JSON object with code.
+	var code int
+	if c, err := strconv.ParseInt(string(b2), 10, 64); err == nil {
+		code = int(c)
+	} else if err := json.Unmarshal(b2, &struct {
+		Code *int `json:"code"`
+	}{
+		Code: &code,
+	}); err != nil {
+		return errors.Wrapf(err, "unmarshal response %v", string(b2))
+	}
+
+	if code != 0 {
+		return errors.Errorf("response code %v, body %v", code, string(b2))
+	}
+
+	logger.Tf(ctx, "callback ok, post %v with %s, response %v", config.String(), string(b), string(b2))
+	return nil
+}
+
 type CallbackConfig struct {
 	// The callback target.
 	Target string `json:"target"`
@@ -0,0 +1,174 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// The online state of IP camera.
+const (
+	// The camera is streaming.
+	cameraOnline = "online"
+	// The camera is unavailable, the FFmpeg keeps retrying.
+	cameraOffline = "offline"
+)
+
+// The reason of camera offline, classified from the logs of FFmpeg.
+const (
+	cameraReasonAuth    = "auth"
+	cameraReasonRefused = "refused"
+	cameraReasonTimeout = "timeout"
+	cameraReasonNoVideo = "no-video"
+	cameraReasonUnknown = "unknown"
+)
+
+// The patterns of FFmpeg logs for each reason, in lower case. Note that the auth error is checked first,
+// because the camera might close the connection after rejecting the credential.
+var cameraFailurePatterns = []struct {
+	reason   string
+	patterns []string
+}{
+	{reason: cameraReasonAuth, patterns: []string{
+		"401 unauthorized", "403 forbidden", "unauthorized", "authorization failed", "authentication failed",
+	}},
+	{reason: cameraReasonRefused, patterns: []string{
+		"connection refused", "no route to host", "network is unreachable",
+	}},
+	{reason: cameraReasonTimeout, patterns: []string{
+		"connection timed out", "operation timed out", "timeout",
+	}},
+	{reason: cameraReasonNoVideo, patterns: []string{
+		"matches no streams", "does not contain any stream", "could not find codec parameters",
+	}},
+}
+
+// classifyCameraFailure classify the reason of offline, by the extra logs of FFmpeg, from the last log.
+func classifyCameraFailure(logs []string) (reason, line string) {
+	for i := len(logs) - 1; i >= 0; i-- {
+		lower := strings.ToLower(logs[i])
+		for _, e := range cameraFailurePatterns {
+			for _, pattern := range e.patterns {
+				if strings.Contains(lower, pattern) {
+					return e.reason, logs[i]
+				}
+			}
+		}
+	}
+
+	if len(logs) > 0 {
+		return cameraReasonUnknown, logs[len(logs)-1]
+	}
+	return cameraReasonUnknown, ""
+}
+
+// CameraHealth is the online state of IP camera, and the uptime while the task is running, that is, the
+// camera is enabled and in the scheduled window.
+type CameraHealth struct {
+	// The online state, empty if not running.
+	State string `json:"state"`
+	// The reason of offline.
+	Reason string `json:"reason,omitempty"`
+	// The log of offline.
+	Error string `json:"error,omitempty"`
+	// The time of current state.
+	Since string `json:"since,omitempty"`
+	// The last time camera is online, and offline.
+	LastOnline  string `json:"lastOnline,omitempty"`
+	LastOffline string `json:"lastOffline,omitempty"`
+	// The percentage of online duration while running.
+	Uptime float64 `json:"uptime"`
+
+	// The start time of current state.
+	since time.Time
+	// The duration while running, and online, excluding the current state.
+	running, online time.Duration
+
+	// To protect the fields.
+	lock sync.Mutex
+}
+
+func NewCameraHealth() *CameraHealth {
+	return &CameraHealth{}
+}
+
+// update switch to the state, and accumulate the duration of previous state.
+func (v *CameraHealth) update(now time.Time, state string) {
+	if v.State != "" && !v.since.IsZero() {
+		duration := now.Sub(v.since)
+		v.running += duration
+		if v.State == cameraOnline {
+			v.online += duration
+		}
+	}
+
+	if v.State != state {
+		v.State, v.Since = state, ""
+		if state != "" {
+			v.Since = now.Format(time.RFC3339)
+		}
+	}
+	v.since = now
+}
+
+// OnReady update the state when FFmpeg is streaming, return the previous and current state.
+func (v *CameraHealth) OnReady(now time.Time) (from, to string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	from = v.State
+	v.update(now, cameraOnline)
+	v.Reason, v.Error = "", ""
+	v.LastOnline = now.Format(time.RFC3339)
+	return from, v.State
+}
+
+// OnExit update the state when FFmpeg exits unexpectedly, return the previous and current state.
+func (v *CameraHealth) OnExit(now time.Time, reason, line string) (from, to string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	from = v.State
+	v.update(now, cameraOffline)
+	v.Reason, v.Error = reason, line
+	if from != cameraOffline {
+		v.LastOffline = now.Format(time.RFC3339)
+	}
+	return from, v.State
+}
+
+// OnStop update the state when task is not running, for example, disabled or out of schedule.
+func (v *CameraHealth) OnStop(now time.Time) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.update(now, "")
+	v.Reason, v.Error = "", ""
+}
+
+// Query get a copy of health, with the uptime till now.
+func (v *CameraHealth) Query(now time.Time) *CameraHealth {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	running, online := v.running, v.online
+	if v.State != "" && !v.since.IsZero() {
+		running += now.Sub(v.since)
+		if v.State == cameraOnline {
+			online += now.Sub(v.since)
+		}
+	}
+
+	var uptime float64
+	if running > 0 {
+		uptime = float64(int(float64(online)/float64(running)*10000)) / 100
+	}
+
+	return &CameraHealth{
+		State: v.State, Reason: v.Reason, Error: v.Error, Since: v.Since,
+		LastOnline: v.LastOnline, LastOffline: v.LastOffline, Uptime: uptime,
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCameraHealth_Classify(t *testing.T) {
+	for _, e := range []struct {
+		logs   []string
+		reason string
+		line   string
+	}{
+		{logs: nil, reason: cameraReasonUnknown, line: ""},
+		{logs: []string{"Stream mapping:", "Conversion failed!"}, reason: cameraReasonUnknown, line: "Conversion failed!"},
+		{logs: []string{"[tcp @ 0x1] Connection to tcp://192.168.1.10:554 failed: Connection refused"}, reason: cameraReasonRefused},
+		{logs: []string{"[rtsp @ 0x1] method DESCRIBE failed: 401 Unauthorized", "Connection reset by peer"}, reason: cameraReasonAuth},
+		{logs: []string{"[tcp @ 0x1] Connection to tcp://192.168.1.10:554 failed: Connection timed out"}, reason: cameraReasonTimeout},
+		{logs: []string{"Stream map '0:v' matches no streams."}, reason: cameraReasonNoVideo},
+	} {
+		reason, line := classifyCameraFailure(e.logs)
+		if reason != e.reason {
+			t.Errorf("Fail for %v, expect %v, actual %v", e.logs, e.reason, reason)
+		}
+		if e.line != "" && line != e.line {
+			t.Errorf("Fail for %v, expect line %v, actual %v", e.logs, e.line, line)
+		}
+	}
+}
+
+func TestCameraHealth_State(t *testing.T) {
+	health := NewCameraHealth()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if h := health.Query(now); h.State != "" || h.Uptime != 0 {
+		t.Errorf("Fail for %v %v", h.State, h.Uptime)
+	}
+
+	if from, to := health.OnReady(now); from != "" || to != cameraOnline {
+		t.Errorf("Fail for %v to %v", from, to)
+	}
+	if from, to := health.OnExit(now.Add(30*time.Second), cameraReasonRefused, "refused"); from != cameraOnline || to != cameraOffline {
+		t.Errorf("Fail for %v to %v", from, to)
+	}
+	if from, to := health.OnExit(now.Add(35*time.Second), cameraReasonRefused, "refused"); from != cameraOffline || to != cameraOffline {
+		t.Errorf("Fail for %v to %v", from, to)
+	}
+
+	h := health.Query(now.Add(40 * time.Second))
+	if h.State != cameraOffline || h.Reason != cameraReasonRefused || h.Uptime != 75 {
+		t.Errorf("Fail for %v %v %v", h.State, h.Reason, h.Uptime)
+	}
+	if h.LastOffline != "2024-01-01T00:00:30Z" || h.Since != "2024-01-01T00:00:30Z" {
+		t.Errorf("Fail for %v %v", h.LastOffline, h.Since)
+	}
+
+	// The stopped duration is not counted.
+	health.OnStop(now.Add(40 * time.Second))
+	if from, to := health.OnReady(now.Add(100 * time.Second)); from != "" || to != cameraOnline {
+		t.Errorf("Fail for %v to %v", from, to)
+	}
+	if h := health.Query(now.Add(140 * time.Second)); h.Reason != "" || h.Uptime != 87.5 {
+		t.Errorf("Fail for %v %v", h.Reason, h.Uptime)
+	}
+}
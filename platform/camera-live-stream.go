@@ -46,6 +46,14 @@ func (v *CameraWorker) GetTask(platform string) *CameraTask {
 	return nil
 }
 
+// OnSchedule update the schedules of all tasks, called by crontab.
+func (v *CameraWorker) OnSchedule(ctx context.Context, now time.Time) {
+	v.tasks.Range(func(key, value interface{}) bool {
+		value.(*CameraTask).onSchedule(ctx, now)
+		return true
+	})
+}
+
 func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/ffmpeg/camera/secret"
 	logger.Tf(ctx, "Handle %v", ep)
@@ -107,6 +115,10 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 					}
 					if err = targetConf.Update(&userConf); err != nil {
 						return errors.Wrapf(err, "update %v with %v", targetConf.String(), userConf.String())
+					} else if err = validateEgressOutput(targetConf.Server, targetConf.Output); err != nil {
+						return errors.Wrapf(err, "validate output %v", targetConf.String())
+					} else if err = validateSchedules(targetConf.Schedules); err != nil {
+						return errors.Wrapf(err, "validate schedules %v", targetConf.String())
 					} else if newB, err := json.Marshal(&targetConf); err != nil {
 						return errors.Wrapf(err, "marshal %v", targetConf.String())
 					} else if err = rdb.HSet(ctx, SRS_CAMERA_CONFIG, userConf.Platform, string(newB)).Err(); err != nil && err != redis.Nil {
@@ -134,6 +146,7 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 						if err = json.Unmarshal([]byte(v), &obj); err != nil {
 							return errors.Wrapf(err, "unmarshal %v %v", k, v)
 						}
+						obj.Streams = redactOnvifSources(obj.Streams)
 						confObjs[k] = &obj
 					}
 				}
@@ -177,8 +190,12 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 					var pid int32
 					var inputUUID, frame, update, starttime, ready string
+					var health *CameraHealth
 					if task := cameraWorker.GetTask(config.Platform); task != nil {
 						pid, inputUUID, frame, update, starttime, ready = task.queryFrame()
+						if task.health != nil {
+							health = task.health.Query(time.Now())
+						}
 					}
 
 					elem := map[string]interface{}{
@@ -186,10 +203,18 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 						"enabled":    config.Enabled,
 						"custom":     config.Customed,
 						"label":      config.Label,
-						"files":      config.Streams,
+						"files":      redactOnvifSources(config.Streams),
 						"extraAudio": config.ExtraAudio,
 					}
 
+					if schedule := querySchedule(config.Schedules); schedule != nil {
+						elem["schedule"] = schedule
+					}
+
+					if health != nil {
+						elem["health"] = health
+					}
+
 					if pid > 0 {
 						elem["source"] = inputUUID
 						elem["start"] = starttime
@@ -291,6 +316,8 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 				Target string `json:"target"`
 				// The source type.
 				Type FFprobeSourceType `json:"type"`
+				// The ONVIF device and profile, for stream from ONVIF profiles.
+				Onvif *OnvifSource `json:"onvif"`
 			}
 
 			var token, platform string
@@ -432,6 +459,7 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 					Target: stream.Target,
 					Type:   stream.Type,
 					Format: &format.Format, Video: matchVideo, Audio: matchAudio,
+					Onvif: stream.Onvif,
 				}
 
 				parsedStreams = append(parsedStreams, parsedStream)
@@ -448,6 +476,7 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 				}
 			}
 
+			restoreOnvifPasswords(parsedStreams, confObj.Streams)
 			confObj.Streams = parsedStreams
 
 			if b, err := json.Marshal(&confObj); err != nil {
@@ -467,7 +496,7 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 				Platform string           `json:"platform"`
 				Files    []*FFprobeSource `json:"files"`
 			}{
-				Platform: platform, Files: parsedStreams,
+				Platform: platform, Files: redactOnvifSources(parsedStreams),
 			})
 			logger.Tf(ctx, "Camera:: Update ok, token=%vB", len(token))
 			return nil
@@ -476,6 +505,9 @@ func (v *CameraWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 		}
 	})
 
+	v.handleOnvif(ctx, handler)
+	v.handlePTZ(ctx, handler)
+	v.handleMotion(ctx, handler)
 	return nil
 }
 
@@ -610,16 +642,24 @@ type CameraConfigure struct {
 	Customed bool `json:"custom"`
 	// The label for this configure.
 	Label string `json:"label"`
+	// The protocol options of output, for example, the passphrase of SRT.
+	Output *EgressOutput `json:"output,omitempty"`
+	// The windows in which the task runs, always run if empty.
+	Schedules []*TaskSchedule `json:"schedules,omitempty"`
 	// The extra audio stream strategy.
 	ExtraAudio string `json:"extraAudio"`
+	// The logo, text and ticker overlays.
+	Overlay *TaskOverlay `json:"overlay,omitempty"`
+	// The motion recording.
+	Motion *CameraMotion `json:"motion,omitempty"`
 
 	// The input files for IP camera.
 	Streams []*FFprobeSource `json:"files"`
 }
 
 func (v CameraConfigure) String() string {
-	return fmt.Sprintf("platform=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v, files=%v, extraAudio=%v",
-		v.Platform, v.Server, v.Secret, v.Enabled, v.Customed, v.Label, v.Streams, v.ExtraAudio,
+	return fmt.Sprintf("platform=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v, output=%v, files=%v, extraAudio=%v",
+		v.Platform, v.Server, v.Secret, v.Enabled, v.Customed, v.Label, v.Output.String(), v.Streams, v.ExtraAudio,
 	)
 }
 
@@ -630,8 +670,22 @@ func (v *CameraConfigure) Update(u *CameraConfigure) error {
 	v.Label = u.Label
 	v.Enabled = u.Enabled
 	v.Customed = u.Customed
-	v.Streams = append([]*FFprobeSource{}, u.Streams...)
+	if u.Output != nil {
+		v.Output = u.Output
+	}
+	if u.Schedules != nil {
+		v.Schedules = u.Schedules
+	}
+	streams := append([]*FFprobeSource{}, u.Streams...)
+	restoreOnvifPasswords(streams, v.Streams)
+	v.Streams = streams
 	v.ExtraAudio = u.ExtraAudio
+	if u.Overlay != nil {
+		v.Overlay = u.Overlay
+	}
+	if u.Motion != nil {
+		v.Motion = u.Motion
+	}
 	return nil
 }
 
@@ -659,6 +713,10 @@ type CameraTask struct {
 	starttime *time.Time
 	// The first ready time.
 	firstReadyTime *time.Time
+	// The state of schedules, updated by crontab.
+	schedule *ScheduleState
+	// The online state of camera.
+	health *CameraHealth
 
 	// The context for current task.
 	cancel context.CancelFunc
@@ -758,8 +816,39 @@ func (v *CameraTask) queryFrame() (int32, string, string, string, string, string
 	return v.PID, v.inputUUID, v.frame, update, starttime, ready
 }
 
+// queryInput get the input of task, and whether it's streaming.
+func (v *CameraTask) queryInput() (string, bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.Input, v.PID > 0 && v.firstReadyTime != nil
+}
+
+// onSchedule update the state of schedules, and stop FFmpeg when the window is over.
+func (v *CameraTask) onSchedule(ctx context.Context, now time.Time) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.schedule == nil {
+		return
+	}
+
+	from, to := v.schedule.Update(v.config.Schedules, now)
+	if from == to {
+		return
+	}
+
+	logger.Tf(ctx, "Camera: Schedule changed, platform=%v, active=%v, state is %v", v.Platform, to, v.schedule.Query().String())
+	if !to && v.cancel != nil {
+		v.cancel()
+	}
+}
+
 func (v *CameraTask) Initialize(ctx context.Context, w *CameraWorker) error {
 	v.cameraWorker = w
+	v.schedule = NewScheduleState()
+	v.schedule.Update(v.config.Schedules, time.Now())
+	v.health = NewCameraHealth()
 	logger.Tf(ctx, "Camera: Initialize uuid=%v, platform=%v", v.UUID, v.Platform)
 
 	if err := v.saveTask(ctx); err != nil {
@@ -787,14 +876,16 @@ func (v *CameraTask) Run(ctx context.Context) error {
 	}
 
 	pfn := func(ctx context.Context) error {
-		// Ignore when not enabled.
-		if !v.config.Enabled {
+		// Ignore when not enabled, or not in the scheduled window.
+		if !v.config.Enabled || !v.schedule.IsActive() {
+			v.health.OnStop(time.Now())
 			return nil
 		}
 
 		// Use a active stream as input.
 		input := selectInputFile()
 		if input == nil {
+			v.health.OnStop(time.Now())
 			return nil
 		}
 
@@ -830,17 +921,22 @@ func (v *CameraTask) doCameraStreaming(ctx context.Context, input *FFprobeSource
 	// Create context for current task.
 	parentCtx := ctx
 	ctx, cancel := context.WithCancel(ctx)
-	v.cancel = cancel
+
+	// The task is stopped by user, for example, restart or out of schedule, which is not offline.
+	stopCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+	v.cancel = func() {
+		stop()
+		cancel()
+	}
 
 	// Build input URL.
 	host := "localhost"
 
 	// Build output URL.
-	outputServer := strings.ReplaceAll(v.config.Server, "localhost", host)
-	if !strings.HasSuffix(outputServer, "/") && !strings.HasPrefix(v.config.Secret, "/") && v.config.Secret != "" {
-		outputServer += "/"
-	}
-	outputURL := fmt.Sprintf("%v%v", outputServer, v.config.Secret)
+	outputURL, outputArgs := buildEgressOutput(
+		strings.ReplaceAll(v.config.Server, "localhost", host), v.config.Secret, v.config.Output,
+	)
 
 	// Create a heartbeat to poll and manage the status of FFmpeg process.
 	heartbeat := NewFFmpegHeartbeat(cancel)
@@ -870,23 +966,31 @@ func (v *CameraTask) doCameraStreaming(ctx context.Context, input *FFprobeSource
 		args = append(args, "-i", input.Target)
 	}
 	// Whether insert extra audio stream.
+	stream := ffmpegCopyStreams()
 	if v.config.ExtraAudio == "silent" {
-		args = append(args,
-			"-f", "lavfi", "-i", "anullsrc=channel_layout=stereo:sample_rate=44100", // Silent audio stream.
-			"-map", "0:v", "-map", "1:a", // Ignore the original audio stream.
-			"-c:a", "aac", "-ac", "2", "-ar", "44100", "-b:a", "20k", // Encode audio stream.
-			"-c:v", "copy", // Copy video stream.
-		)
-	} else {
-		args = append(args, "-c", "copy")
+		// Silent audio stream, ignore the original audio stream.
+		index := stream.addInput("-f", "lavfi", "-i", "anullsrc=channel_layout=stereo:sample_rate=44100")
+		stream.videoMap, stream.audioMap = "0:v", fmt.Sprintf("%v:a", index)
+		stream.audioCodec = []string{"-c:a", "aac", "-ac", "2", "-ar", "44100", "-b:a", "20k"}
 	}
-	// If RTMP use flv, if SRT use mpegts, otherwise do not set.
-	if strings.HasPrefix(outputURL, "rtmp://") || strings.HasPrefix(outputURL, "rtmps://") {
-		args = append(args, "-f", "flv")
-	} else if strings.HasPrefix(outputURL, "srt://") {
-		args = append(args, "-pes_payload_size", "0", "-f", "mpegts")
+	// The logo, text and ticker overlays, which re-encode the video.
+	title := v.config.Label
+	if title == "" {
+		title = v.Platform
 	}
-	args = append(args, outputURL)
+	if err := applyOverlay(stream, v.config.Overlay, fmt.Sprintf("%v-%v", overlayKindCamera, v.Platform), title); err != nil {
+		return errors.Wrapf(err, "overlay")
+	}
+	args = append(args, stream.inputs...)
+	args = append(args, stream.outputs()...)
+	// The format and protocol options of output, for example, flv for RTMP, mpegts for SRT.
+	args = append(args, outputArgs...)
+	// Sign the output by publish token, because the legacy secret may be disabled.
+	publishURL, err := platformPublishURL(ctx, outputURL)
+	if err != nil {
+		return errors.Wrapf(err, "sign %v", outputURL)
+	}
+	args = append(args, publishURL)
 	// Create the command object.
 	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 
@@ -920,6 +1024,30 @@ func (v *CameraTask) doCameraStreaming(ctx context.Context, input *FFprobeSource
 		return errors.Wrapf(err, "save task %v", v.String())
 	}
 
+	// Detect the motion and record the events, stop with the FFmpeg process.
+	if motion := v.config.Motion; motion != nil && motion.Enabled {
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		defer cancel()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			recorder := NewCameraMotionRecorder(v.Platform, motion)
+			for ctx.Err() == nil {
+				if err := recorder.Run(ctx, input); err != nil {
+					logger.Wf(ctx, "Camera: Ignore motion of platform=%v err %+v", v.Platform, err)
+				}
+
+				select {
+				case <-ctx.Done():
+				case <-time.After(3500 * time.Millisecond):
+				}
+			}
+		}()
+	}
+
 	// Pull the latest log frame.
 	heartbeat.Polling(ctx, stderr)
 	go func() {
@@ -928,6 +1056,7 @@ func (v *CameraTask) doCameraStreaming(ctx context.Context, input *FFprobeSource
 			return
 		case <-heartbeat.firstReadyCtx.Done():
 			v.firstReadyTime = &heartbeat.firstReadyTime
+			v.onHealth(ctx, true, "", "")
 		}
 
 		for {
@@ -953,5 +1082,41 @@ func (v *CameraTask) doCameraStreaming(ctx context.Context, input *FFprobeSource
 		v.Platform, input.Target, v.PID, err,
 	)
 
+	// The camera is offline if FFmpeg quits unexpectedly, or is killed by heartbeat when stuck.
+	if stopCtx.Err() == nil && parentCtx.Err() == nil {
+		reason, line := classifyCameraFailure(heartbeat.extraLogs)
+		if reason == cameraReasonUnknown && ctx.Err() != nil {
+			reason = cameraReasonTimeout
+		}
+		v.onHealth(parentCtx, false, reason, line)
+	}
+
 	return err
 }
+
+// onHealth update the online state, and emit event if camera goes offline, or recovers from offline.
+func (v *CameraTask) onHealth(ctx context.Context, online bool, reason, line string) {
+	var from, to string
+	if online {
+		from, to = v.health.OnReady(time.Now())
+	} else {
+		from, to = v.health.OnExit(time.Now(), reason, line)
+	}
+	if from == to || (from != cameraOffline && to != cameraOffline) {
+		return
+	}
+
+	health := v.health.Query(time.Now())
+	logger.Wf(ctx, "Camera: platform=%v, state %v to %v, reason=%v, uptime=%v, log=%v",
+		v.Platform, from, to, health.Reason, health.Uptime, health.Error)
+
+	message := map[string]interface{}{
+		"platform": v.Platform, "label": v.config.Label, "from": from, "to": to,
+		"reason": health.Reason, "error": health.Error, "since": health.Since, "uptime": health.Uptime,
+	}
+	go func() {
+		if err := callbackWorker.OnSystemMessage(ctx, SrsActionOnCameraHealth, message); err != nil {
+			logger.Wf(ctx, "Camera: health event platform=%v err %+v", v.Platform, err)
+		}
+	}()
+}
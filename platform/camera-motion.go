@@ -0,0 +1,637 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	// The size and rate of gray frames for detection, which is small enough to compare in Go.
+	motionFrameWidth  = 160
+	motionFrameHeight = 90
+	motionFrameRate   = 2
+	// The duration of segments in seconds, which is the precision of pre-roll.
+	motionSegmentDuration = 2
+	// The max duration of an event, start a new event if motion lasts longer.
+	motionMaxDuration = 30 * time.Minute
+	// The max number of mask regions.
+	motionMaxMasks = 16
+)
+
+// CameraMotion is the configure of motion recording for IP camera, which detects the motion by comparing
+// the sampled frames, and records the stream with pre-roll until it's quiet.
+type CameraMotion struct {
+	// Whether enabled.
+	Enabled bool `json:"enabled"`
+	// The sensitivity, 1 to 100, the larger the more sensitive.
+	Sensitivity int `json:"sensitivity,omitempty"`
+	// The pre-roll in seconds, the video before the motion to record.
+	PreRoll float64 `json:"preRoll,omitempty"`
+	// The quiet period in seconds, stop recording if no motion in this period.
+	Quiet float64 `json:"quiet,omitempty"`
+	// The regions to ignore, for example, the clock or trees in the wind.
+	Masks []*CameraMotionMask `json:"masks,omitempty"`
+}
+
+func (v *CameraMotion) String() string {
+	return fmt.Sprintf("enabled=%v, sensitivity=%v, preRoll=%v, quiet=%v, masks=%v",
+		v.Enabled, v.Sensitivity, v.PreRoll, v.Quiet, len(v.Masks),
+	)
+}
+
+func (v *CameraMotion) Initialize() {
+	if v.Sensitivity == 0 {
+		v.Sensitivity = 50
+	}
+	if v.PreRoll == 0 {
+		v.PreRoll = 5
+	}
+	if v.Quiet == 0 {
+		v.Quiet = 10
+	}
+}
+
+func (v *CameraMotion) Validate() error {
+	if v.Sensitivity < 1 || v.Sensitivity > 100 {
+		return errors.Errorf("invalid sensitivity %v, should in [1, 100]", v.Sensitivity)
+	}
+	if v.PreRoll < 0 || v.PreRoll > 60 {
+		return errors.Errorf("invalid preRoll %v, should in [0, 60]", v.PreRoll)
+	}
+	if v.Quiet < 1 || v.Quiet > 600 {
+		return errors.Errorf("invalid quiet %v, should in [1, 600]", v.Quiet)
+	}
+	if len(v.Masks) > motionMaxMasks {
+		return errors.Errorf("too many masks %v, max %v", len(v.Masks), motionMaxMasks)
+	}
+	for _, mask := range v.Masks {
+		if err := mask.Validate(); err != nil {
+			return errors.Wrapf(err, "mask %v", mask.String())
+		}
+	}
+	return nil
+}
+
+// CameraMotionMask is a rectangle region in ratio of the frame, for example, x=0.5 is the center.
+type CameraMotionMask struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+func (v *CameraMotionMask) String() string {
+	return fmt.Sprintf("x=%v, y=%v, width=%v, height=%v", v.X, v.Y, v.Width, v.Height)
+}
+
+func (v *CameraMotionMask) Validate() error {
+	if v.X < 0 || v.Y < 0 || v.Width <= 0 || v.Height <= 0 {
+		return errors.New("invalid region")
+	}
+	if v.X+v.Width > 1 || v.Y+v.Height > 1 {
+		return errors.New("out of frame")
+	}
+	return nil
+}
+
+// CameraMotionEvent is the motion event of a record artifact.
+type CameraMotionEvent struct {
+	// The camera platform.
+	Platform string `json:"platform"`
+	// The time the motion is detected.
+	Start string `json:"start"`
+	// The time the recording stops, empty if recording.
+	Stop string `json:"stop,omitempty"`
+	// The peak ratio of changed pixels.
+	Score float64 `json:"score"`
+	// The url of thumbnail, empty if not generated.
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+func (v *CameraMotionEvent) String() string {
+	return fmt.Sprintf("platform=%v, start=%v, stop=%v, score=%.3f, thumbnail=%v",
+		v.Platform, v.Start, v.Stop, v.Score, v.Thumbnail,
+	)
+}
+
+// motionDetector compares the gray frame with the previous one, by the ratio of changed pixels which are
+// not masked.
+type motionDetector struct {
+	// Whether the pixel is masked, ignored by detection.
+	masked []bool
+	// The number of pixels not masked.
+	pixels int
+	// The min difference of a changed pixel.
+	threshold int
+	// The min ratio of changed pixels for motion.
+	ratio float64
+	// The previous frame.
+	previous []byte
+}
+
+func newMotionDetector(width, height int, motion *CameraMotion) *motionDetector {
+	v := &motionDetector{masked: make([]bool, width*height)}
+
+	for _, mask := range motion.Masks {
+		x0, y0 := int(mask.X*float64(width)), int(mask.Y*float64(height))
+		x1 := int(math.Min(math.Ceil((mask.X+mask.Width)*float64(width)), float64(width)))
+		y1 := int(math.Min(math.Ceil((mask.Y+mask.Height)*float64(height)), float64(height)))
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				v.masked[y*width+x] = true
+			}
+		}
+	}
+	for _, masked := range v.masked {
+		if !masked {
+			v.pixels++
+		}
+	}
+
+	// The most sensitive is 10 and 0.2%, while the least is 50 and 20%.
+	v.threshold = 10 + (100-motion.Sensitivity)*40/100
+	v.ratio = 0.002 + float64(100-motion.Sensitivity)*0.002
+	return v
+}
+
+// Detect returns the ratio of changed pixels, and whether it's motion.
+func (v *motionDetector) Detect(frame []byte) (float64, bool) {
+	if len(frame) != len(v.masked) {
+		return 0, false
+	}
+	if v.previous == nil {
+		v.previous = append([]byte{}, frame...)
+		return 0, false
+	}
+
+	var changed int
+	for i, p := range frame {
+		if v.masked[i] {
+			continue
+		}
+		if d := int(p) - int(v.previous[i]); d >= v.threshold || -d >= v.threshold {
+			changed++
+		}
+	}
+	copy(v.previous, frame)
+
+	if v.pixels == 0 {
+		return 0, false
+	}
+	score := float64(changed) / float64(v.pixels)
+	return score, score >= v.ratio
+}
+
+// motionSegment is a ts segment generated by FFmpeg.
+type motionSegment struct {
+	// The ts file path.
+	File string
+	// The duration in seconds.
+	Duration float64
+}
+
+// parseMotionSegments parse the complete lines of segment list in csv, in format of file,start,end. Return
+// the segments and the number of bytes consumed.
+func parseMotionSegments(dir string, b []byte) ([]*motionSegment, int) {
+	var segments []*motionSegment
+	var consumed int
+	for {
+		pos := bytes.IndexByte(b[consumed:], '\n')
+		if pos < 0 {
+			break
+		}
+
+		line := strings.TrimSpace(string(b[consumed : consumed+pos]))
+		consumed += pos + 1
+
+		fields := strings.Split(line, ",")
+		if len(fields) != 3 {
+			continue
+		}
+		start, err0 := strconv.ParseFloat(fields[1], 64)
+		end, err1 := strconv.ParseFloat(fields[2], 64)
+		if err0 != nil || err1 != nil {
+			continue
+		}
+
+		segments = append(segments, &motionSegment{
+			File: path.Join(dir, path.Base(fields[0])), Duration: end - start,
+		})
+	}
+	return segments, consumed
+}
+
+// motionState is the state of recording, which keeps the segments for pre-roll, and stops after the quiet
+// period.
+type motionState struct {
+	preRoll float64
+	quiet   time.Duration
+	// The segments before motion, for pre-roll.
+	segments []*motionSegment
+	// The start and last time of motion, zero if not recording.
+	start, last time.Time
+}
+
+func newMotionState(motion *CameraMotion) *motionState {
+	return &motionState{
+		preRoll: motion.PreRoll, quiet: time.Duration(motion.Quiet * float64(time.Second)),
+	}
+}
+
+func (v *motionState) Recording() bool {
+	return !v.start.IsZero()
+}
+
+// OnMotion update the last time of motion, return true if starts recording.
+func (v *motionState) OnMotion(now time.Time) bool {
+	v.last = now
+	if v.Recording() {
+		return false
+	}
+
+	v.start = now
+	return true
+}
+
+// OnSegment return the segments to record and to drop, and whether stops recording.
+func (v *motionState) OnSegment(now time.Time, segment *motionSegment) (records, drops []*motionSegment, stop bool) {
+	if !v.Recording() {
+		v.segments = append(v.segments, segment)
+
+		// Drop the oldest segment if the others cover the pre-roll.
+		for len(v.segments) > 1 {
+			var duration float64
+			for _, s := range v.segments[1:] {
+				duration += s.Duration
+			}
+			if duration < v.preRoll {
+				break
+			}
+			drops, v.segments = append(drops, v.segments[0]), v.segments[1:]
+		}
+		return
+	}
+
+	records, v.segments = append(v.segments, segment), nil
+	if now.Sub(v.last) >= v.quiet || now.Sub(v.start) >= motionMaxDuration {
+		v.start, v.last = time.Time{}, time.Time{}
+		stop = true
+	}
+	return
+}
+
+// CameraMotionRecorder detect the motion of IP camera stream, and record the events to artifacts.
+type CameraMotionRecorder struct {
+	// The camera platform.
+	platform string
+	// The configure of motion.
+	motion *CameraMotion
+	// The working directory for segments.
+	dir string
+
+	// The artifact of current event, nil if not recording.
+	artifact *M3u8VoDArtifact
+	// Wait for the events to finish.
+	wg sync.WaitGroup
+}
+
+func NewCameraMotionRecorder(platform string, motion *CameraMotion) *CameraMotionRecorder {
+	return &CameraMotionRecorder{
+		platform: platform, motion: motion, dir: path.Join(dirMotionPath, platform),
+	}
+}
+
+func (v *CameraMotionRecorder) Run(ctx context.Context, input *FFprobeSource) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The events are finished in background, after the segments are cleanup.
+	defer v.wg.Wait()
+
+	// The recorded segments are moved to record directory, so all segments here are useless.
+	if err := os.RemoveAll(v.dir); err != nil {
+		return errors.Wrapf(err, "remove %v", v.dir)
+	}
+	if err := os.MkdirAll(v.dir, 0755); err != nil {
+		return errors.Wrapf(err, "mkdir %v", v.dir)
+	}
+	defer os.RemoveAll(v.dir)
+
+	args := []string{"-fflags", "nobuffer"}
+	if strings.HasPrefix(input.Target, "rtsp://") {
+		args = append(args, "-rtsp_transport", "tcp")
+	}
+	if strings.Contains(input.Target, "://") {
+		if u, err := RebuildStreamURL(input.Target); err != nil {
+			return errors.Wrapf(err, "rebuild %v", input.Target)
+		} else {
+			args = append(args, "-i", u.String())
+		}
+	} else {
+		args = append(args, "-i", input.Target)
+	}
+	// The segments to record, transcode the audio because G.711 is not allowed by TS.
+	list := path.Join(v.dir, "segments.csv")
+	args = append(args, "-map", "0:v:0", "-map", "0:a:0?", "-c:v", "copy", "-c:a", "aac", "-b:a", "64k",
+		"-f", "segment", "-segment_format", "mpegts", "-segment_time", fmt.Sprintf("%v", motionSegmentDuration),
+		"-segment_list", list, "-segment_list_type", "csv", path.Join(v.dir, "%d.ts"),
+	)
+	// The small gray frames to detect motion.
+	args = append(args, "-map", "0:v:0", "-an",
+		"-vf", fmt.Sprintf("fps=%v,scale=%v:%v,format=gray", motionFrameRate, motionFrameWidth, motionFrameHeight),
+		"-f", "rawvideo", "pipe:1",
+	)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Wrapf(err, "pipe process")
+	}
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "execute ffmpeg %v", strings.Join(args, " "))
+	}
+	logger.Tf(ctx, "Camera: Motion start, platform=%v, input=%v, pid=%v, %v",
+		v.platform, input.Target, cmd.Process.Pid, v.motion.String())
+
+	frames := make(chan []byte)
+	go func() {
+		defer close(frames)
+		for {
+			frame := make([]byte, motionFrameWidth*motionFrameHeight)
+			if _, err := io.ReadFull(stdout, frame); err != nil {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case frames <- frame:
+			}
+		}
+	}()
+
+	detector := newMotionDetector(motionFrameWidth, motionFrameHeight, v.motion)
+	state := newMotionState(v.motion)
+	var offset int
+
+	// Consume the new segments in list.
+	pollSegments := func(ctx context.Context) {
+		b, err := os.ReadFile(list)
+		if err != nil || len(b) <= offset {
+			return
+		}
+
+		segments, consumed := parseMotionSegments(v.dir, b[offset:])
+		offset += consumed
+
+		for _, segment := range segments {
+			records, drops, stop := state.OnSegment(time.Now(), segment)
+			for _, drop := range drops {
+				os.Remove(drop.File)
+			}
+			if err := v.recordSegments(ctx, records); err != nil {
+				logger.Wf(ctx, "Camera: Motion ignore record %v err %+v", v.platform, err)
+			}
+			if stop {
+				v.finishEvent(ctx)
+			}
+		}
+	}
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for done := false; !done; {
+		select {
+		case <-ticker.C:
+			pollSegments(ctx)
+		case frame, ok := <-frames:
+			if !ok {
+				done = true
+				break
+			}
+
+			score, motion := detector.Detect(frame)
+			if !motion {
+				break
+			}
+
+			if state.OnMotion(time.Now()) {
+				v.startEvent(ctx, score)
+			} else if v.artifact != nil && v.artifact.Motion.Score < score {
+				v.artifact.Motion.Score = score
+			}
+		}
+	}
+
+	// When canceled, we should still write to redis, so we must not use ctx(which is cancelled).
+	finalCtx := logger.WithContext(context.Background())
+	err = cmd.Wait()
+	pollSegments(finalCtx)
+	v.finishEvent(finalCtx)
+
+	logger.Tf(ctx, "Camera: Motion done, platform=%v, input=%v, err=%v", v.platform, input.Target, err)
+	return err
+}
+
+func (v *CameraMotionRecorder) startEvent(ctx context.Context, score float64) {
+	now := time.Now().Format(time.RFC3339)
+	v.artifact = &M3u8VoDArtifact{
+		UUID: uuid.NewString(), Vhost: "__defaultVhost__", App: "camera", Stream: v.platform,
+		Processing: true, Update: now,
+		Motion: &CameraMotionEvent{Platform: v.platform, Start: now, Score: score},
+	}
+
+	if err := saveMotionArtifact(ctx, v.artifact); err != nil {
+		logger.Wf(ctx, "Camera: Motion ignore save %v err %+v", v.artifact.String(), err)
+	}
+	logger.Tf(ctx, "Camera: Motion event start, uuid=%v, %v", v.artifact.UUID, v.artifact.Motion.String())
+}
+
+// recordSegments move the segments to the record directory of event.
+func (v *CameraMotionRecorder) recordSegments(ctx context.Context, segments []*motionSegment) error {
+	if len(segments) == 0 || v.artifact == nil {
+		return nil
+	}
+
+	artifact := v.artifact
+	tsDir := path.Join("record", artifact.UUID)
+	if err := os.MkdirAll(tsDir, 0755); err != nil {
+		return errors.Wrapf(err, "mkdir %v", tsDir)
+	}
+
+	for _, segment := range segments {
+		stats, err := os.Stat(segment.File)
+		if err != nil {
+			continue
+		}
+
+		tsid := uuid.NewString()
+		key := path.Join(tsDir, fmt.Sprintf("%v.ts", tsid))
+		if err := os.Rename(segment.File, key); err != nil {
+			return errors.Wrapf(err, "rename %v to %v", segment.File, key)
+		}
+
+		artifact.Files = append(artifact.Files, &TsFile{
+			Key: key, TsID: tsid, File: key, SeqNo: uint64(len(artifact.Files)),
+			Duration: segment.Duration, Size: uint64(stats.Size()),
+		})
+	}
+	artifact.NN = len(artifact.Files)
+	artifact.Update = time.Now().Format(time.RFC3339)
+
+	// Generate the thumbnail by the last segment, which contains the motion.
+	if event := artifact.Motion; event.Thumbnail == "" && len(artifact.Files) > 0 {
+		ts := artifact.Files[len(artifact.Files)-1].Key
+		thumbnail := path.Join(tsDir, "thumbnail.jpg")
+		if b, err := exec.CommandContext(ctx, "ffmpeg", "-i", ts, "-frames:v", "1", "-vf", "scale=320:-2",
+			"-y", thumbnail,
+		).CombinedOutput(); err != nil {
+			logger.Wf(ctx, "Camera: Motion ignore thumbnail %v err %v %v", thumbnail, err, string(b))
+		} else {
+			event.Thumbnail = fmt.Sprintf("/terraform/v1/hooks/record/hls/%v/thumbnail.jpg", artifact.UUID)
+		}
+	}
+
+	if err := saveMotionArtifact(ctx, artifact); err != nil {
+		return errors.Wrapf(err, "save %v", artifact.String())
+	}
+	return nil
+}
+
+// finishEvent generate the m3u8 and mp4 of event in background, like the record of stream.
+func (v *CameraMotionRecorder) finishEvent(ctx context.Context) {
+	artifact := v.artifact
+	if artifact == nil {
+		return
+	}
+	v.artifact = nil
+
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+
+		now := time.Now().Format(time.RFC3339)
+		artifact.Motion.Stop = now
+
+		if err := func() error {
+			contentType, m3u8Body, duration, err := buildVodM3u8ForLocal(ctx, artifact.Files, false, "")
+			if err != nil {
+				return errors.Wrapf(err, "build vod")
+			}
+
+			hls := path.Join("record", artifact.UUID, "index.m3u8")
+			if err := os.WriteFile(hls, []byte(m3u8Body), 0644); err != nil {
+				return errors.Wrapf(err, "write hls %v", hls)
+			}
+			logger.Tf(ctx, "Camera: Motion record to %v ok, type=%v, duration=%v", hls, contentType, duration)
+
+			mp4 := path.Join("record", artifact.UUID, "index.mp4")
+			if b, err := exec.CommandContext(ctx, "ffmpeg", "-i", hls, "-c", "copy", "-y", mp4).CombinedOutput(); err != nil {
+				return errors.Wrapf(err, "covert to mp4 %v err %v", mp4, string(b))
+			}
+			return nil
+		}(); err != nil {
+			logger.Wf(ctx, "Camera: Motion ignore finish %v err %+v", artifact.UUID, err)
+		}
+
+		artifact.Processing = false
+		artifact.Done, artifact.Update = now, time.Now().Format(time.RFC3339)
+		if err := saveMotionArtifact(ctx, artifact); err != nil {
+			logger.Wf(ctx, "Camera: Motion ignore save %v err %+v", artifact.String(), err)
+		}
+		logger.Tf(ctx, "Camera: Motion event done, uuid=%v, files=%v, %v",
+			artifact.UUID, len(artifact.Files), artifact.Motion.String())
+	}()
+}
+
+func saveMotionArtifact(ctx context.Context, artifact *M3u8VoDArtifact) error {
+	if b, err := json.Marshal(artifact); err != nil {
+		return errors.Wrapf(err, "marshal %v", artifact.String())
+	} else if err = rdb.HSet(ctx, SRS_RECORD_M3U8_ARTIFACT, artifact.UUID, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", SRS_RECORD_M3U8_ARTIFACT, artifact.UUID, string(b))
+	}
+	return nil
+}
+
+func (v *CameraWorker) handleMotion(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/ffmpeg/camera/motion"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, platform string
+			var motion CameraMotion
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string       `json:"token"`
+				Platform *string       `json:"platform"`
+				Motion   *CameraMotion `json:"motion"`
+			}{
+				Token: &token, Platform: &platform, Motion: &motion,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			motion.Initialize()
+			if err := motion.Validate(); err != nil {
+				return errors.Wrapf(err, "validate motion")
+			}
+
+			var config CameraConfigure
+			if b, err := rdb.HGet(ctx, SRS_CAMERA_CONFIG, platform).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v %v", SRS_CAMERA_CONFIG, platform)
+			} else if b == "" {
+				return errors.Errorf("no camera %v", platform)
+			} else if err = json.Unmarshal([]byte(b), &config); err != nil {
+				return errors.Wrapf(err, "unmarshal %v", b)
+			}
+
+			config.Motion = &motion
+			if b, err := json.Marshal(config); err != nil {
+				return errors.Wrapf(err, "marshal %v", config.String())
+			} else if err = rdb.HSet(ctx, SRS_CAMERA_CONFIG, platform, string(b)).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v %v %v", SRS_CAMERA_CONFIG, platform, string(b))
+			}
+
+			// Restart the task to apply the motion recording.
+			if task := v.GetTask(platform); task != nil {
+				if err := task.Restart(ctx); err != nil {
+					return errors.Wrapf(err, "restart task %v", platform)
+				}
+			}
+
+			ohttp.WriteData(ctx, w, r, &motion)
+			logger.Tf(ctx, "Camera: Update motion ok, platform=%v, %v, token=%vB", platform, motion.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
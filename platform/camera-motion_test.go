@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCameraMotion_Validate(t *testing.T) {
+	for _, e := range []struct {
+		motion *CameraMotion
+		ok     bool
+	}{
+		{motion: &CameraMotion{}, ok: true},
+		{motion: &CameraMotion{Sensitivity: 100, PreRoll: 60, Quiet: 600}, ok: true},
+		{motion: &CameraMotion{Masks: []*CameraMotionMask{{X: 0.5, Y: 0, Width: 0.5, Height: 0.2}}}, ok: true},
+		{motion: &CameraMotion{Sensitivity: 101}, ok: false},
+		{motion: &CameraMotion{PreRoll: -1}, ok: false},
+		{motion: &CameraMotion{Quiet: 601}, ok: false},
+		{motion: &CameraMotion{Masks: []*CameraMotionMask{{X: 0.6, Y: 0, Width: 0.5, Height: 0.2}}}, ok: false},
+		{motion: &CameraMotion{Masks: []*CameraMotionMask{{X: 0, Y: 0, Width: 0, Height: 0.2}}}, ok: false},
+	} {
+		e.motion.Initialize()
+		if err := e.motion.Validate(); (err == nil) != e.ok {
+			t.Errorf("Fail for %v, expect ok=%v, err %v", e.motion.String(), e.ok, err)
+		}
+	}
+}
+
+func TestCameraMotion_Detect(t *testing.T) {
+	width, height := 10, 10
+	frame := func(x0, y0, x1, y1 int, value byte) []byte {
+		b := make([]byte, width*height)
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				b[y*width+x] = value
+			}
+		}
+		return b
+	}
+
+	// The right half is masked.
+	motion := &CameraMotion{Sensitivity: 50, Masks: []*CameraMotionMask{{X: 0.5, Y: 0, Width: 0.5, Height: 1}}}
+	detector := newMotionDetector(width, height, motion)
+	if detector.pixels != 50 {
+		t.Errorf("Fail for pixels %v", detector.pixels)
+	}
+
+	for index, e := range []struct {
+		frame  []byte
+		score  float64
+		motion bool
+	}{
+		// The first frame, never motion.
+		{frame: frame(0, 0, 0, 0, 0), score: 0, motion: false},
+		// The masked region changed.
+		{frame: frame(5, 0, 10, 10, 255), score: 0, motion: false},
+		// The small difference is noise.
+		{frame: frame(0, 0, 10, 10, 20), score: 0, motion: false},
+		// A small object moves in.
+		{frame: frame(0, 0, 2, 2, 200), score: 0.08, motion: false},
+		// A large object moves in.
+		{frame: frame(0, 0, 5, 4, 200), score: 0.32, motion: true},
+	} {
+		if score, motion := detector.Detect(e.frame); score != e.score || motion != e.motion {
+			t.Errorf("Fail for #%v, expect %v %v, actual %v %v", index, e.score, e.motion, score, motion)
+		}
+	}
+
+	// The most sensitive detects the small object.
+	detector = newMotionDetector(width, height, &CameraMotion{Sensitivity: 100})
+	detector.Detect(frame(0, 0, 0, 0, 0))
+	if _, motion := detector.Detect(frame(0, 0, 1, 1, 20)); !motion {
+		t.Errorf("Fail for sensitive")
+	}
+}
+
+func TestCameraMotion_ParseSegments(t *testing.T) {
+	segments, consumed := parseMotionSegments("record/motion/cam", []byte("0.ts,0.000000,2.002000\n1.ts,2.002000,4.004000\n2.ts,4.00"))
+	if len(segments) != 2 || consumed != 46 {
+		t.Errorf("Fail for segments %v, consumed %v", len(segments), consumed)
+	} else if s := segments[1]; s.File != "record/motion/cam/1.ts" || s.Duration != 2.002 {
+		t.Errorf("Fail for segment %v %v", s.File, s.Duration)
+	}
+}
+
+func TestCameraMotion_State(t *testing.T) {
+	state := newMotionState(&CameraMotion{PreRoll: 4, Quiet: 5})
+	now := time.Now()
+	segment := func(name string) *motionSegment {
+		return &motionSegment{File: name, Duration: 2}
+	}
+
+	// Keep the segments for pre-roll, and drop the older.
+	for _, name := range []string{"0", "1", "2"} {
+		if records, _, _ := state.OnSegment(now, segment(name)); len(records) != 0 {
+			t.Errorf("Fail for records %v", len(records))
+		}
+	}
+	if _, drops, _ := state.OnSegment(now, segment("3")); len(drops) != 1 || drops[0].File != "1" {
+		t.Errorf("Fail for drops %v", drops)
+	}
+
+	// Record the pre-roll when motion.
+	if !state.OnMotion(now) || state.OnMotion(now.Add(time.Second)) {
+		t.Errorf("Fail for motion")
+	}
+	if records, _, stop := state.OnSegment(now.Add(2*time.Second), segment("4")); stop || len(records) != 3 || records[0].File != "2" {
+		t.Errorf("Fail for records %v, stop %v", len(records), stop)
+	}
+	if records, _, stop := state.OnSegment(now.Add(4*time.Second), segment("5")); stop || len(records) != 1 {
+		t.Errorf("Fail for records %v, stop %v", len(records), stop)
+	}
+
+	// Stop after quiet.
+	if records, _, stop := state.OnSegment(now.Add(6*time.Second), segment("6")); !stop || len(records) != 1 || state.Recording() {
+		t.Errorf("Fail for records %v, stop %v", len(records), stop)
+	}
+	if records, _, _ := state.OnSegment(now.Add(8*time.Second), segment("7")); len(records) != 0 {
+		t.Errorf("Fail for records %v", len(records))
+	}
+}
@@ -36,6 +36,28 @@ func (v *CrontabWorker) Start(ctx context.Context) error {
 			if err := fastCache.Refresh(ctx); err != nil {
 				logger.Wf(ctx, "crontab: refresh fast cache err %v", err)
 			}
+			if err := ipRuleHits.Flush(ctx, time.Now()); err != nil {
+				logger.Wf(ctx, "crontab: flush ip rule hits err %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(3 * time.Second):
+			}
+		}
+	}()
+
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+
+		// Drive the scheduled windows of forward, vLive and IP camera tasks.
+		for {
+			now := time.Now()
+			forwardWorker.OnSchedule(ctx, now)
+			vLiveWorker.OnSchedule(ctx, now)
+			cameraWorker.OnSchedule(ctx, now)
 
 			select {
 			case <-ctx.Done():
@@ -377,6 +377,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 					"nn":       len(metadata.Files),
 					"duration": duration,
 					"size":     size,
+					"motion":   metadata.Motion,
 				})
 			}
 
@@ -513,6 +514,33 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 		return nil
 	}
 
+	jpgHandler := func(w http.ResponseWriter, r *http.Request) error {
+		// Format is :uuid/thumbnail.jpg
+		filename := r.URL.Path[len("/terraform/v1/hooks/record/hls/"):]
+		uuid := path.Dir(filename)
+		if len(uuid) == 0 || path.Base(filename) != "thumbnail.jpg" {
+			return errors.Errorf("invalid uuid %v from %v of %v", uuid, filename, r.URL.Path)
+		}
+
+		if m3u8Metadata, err := rdb.HGet(ctx, SRS_RECORD_M3U8_ARTIFACT, uuid).Result(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hget %v %v", SRS_RECORD_M3U8_ARTIFACT, uuid)
+		} else if m3u8Metadata == "" {
+			return errors.Errorf("no m3u8 of uuid=%v", uuid)
+		}
+
+		jpgFilePath := path.Join("record", uuid, "thumbnail.jpg")
+		jpgFile, err := os.Open(jpgFilePath)
+		if err != nil {
+			return errors.Wrapf(err, "open file %v", jpgFilePath)
+		}
+		defer jpgFile.Close()
+
+		w.Header().Set("Content-Type", "image/jpeg")
+		io.Copy(w, jpgFile)
+		logger.Tf(ctx, "record serve thumbnail ok, uuid=%v, jpg=%v", uuid, jpgFilePath)
+		return nil
+	}
+
 	ep = "/terraform/v1/hooks/record/hls/"
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
@@ -523,6 +551,8 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 				return tsHandler(w, r)
 			} else if strings.HasSuffix(r.URL.Path, ".mp4") {
 				return mp4Handler(w, r)
+			} else if strings.HasSuffix(r.URL.Path, ".jpg") {
+				return jpgHandler(w, r)
 			}
 
 			return errors.Errorf("invalid handler for %v", r.URL.Path)
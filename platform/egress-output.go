@@ -0,0 +1,324 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+)
+
+// The protocols of output target, for forward, vLive and IP camera.
+var egressProtocols = []string{"rtmp", "rtmps", "srt", "whip"}
+
+// The range of SRT passphrase length, required by libsrt.
+const egressSRTPassphraseMin = 10
+const egressSRTPassphraseMax = 79
+
+// The max latency of SRT in milliseconds.
+const egressSRTLatencyMax = 10000
+
+// The timeout to test the connection of output.
+const egressTestTimeout = 15 * time.Second
+
+// The muxers supported by FFmpeg, probed once when used, see probeFFmpegMuxer.
+var ffmpegMuxers map[string]bool
+var ffmpegMuxersOnce sync.Once
+
+// ffmpegHasMuxer whether FFmpeg supports the muxer, for example, the whip muxer requires FFmpeg 8+.
+var ffmpegHasMuxer = probeFFmpegMuxer
+
+func probeFFmpegMuxer(name string) bool {
+	ffmpegMuxersOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		b, err := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-muxers").Output()
+		if err != nil {
+			logger.Wf(ctx, "ignore probe ffmpeg muxers err %+v", err)
+		}
+		ffmpegMuxers = parseFFmpegMuxers(string(b))
+	})
+	return ffmpegMuxers[name]
+}
+
+// parseFFmpegMuxers parse the output of ffmpeg -muxers, the line is flags and names, for example:
+//
+//	E  flv             FLV (Flash Video)
+//	E  whip            WHIP(WebRTC-HTTP ingestion protocol) muxer
+func parseFFmpegMuxers(output string) map[string]bool {
+	muxers := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.Contains(fields[0], "E") || strings.Trim(fields[0], "DE") != "" {
+			continue
+		}
+		for _, name := range strings.Split(fields[1], ",") {
+			muxers[name] = true
+		}
+	}
+	return muxers
+}
+
+// EgressOutput is the protocol options of output target. The server and secret are still the url of
+// target, and these options are applied by protocol.
+type EgressOutput struct {
+	// For SRT, the passphrase to encrypt the stream, 10 to 79 characters.
+	Passphrase string `json:"passphrase,omitempty"`
+	// For SRT, the latency in milliseconds.
+	Latency int `json:"latency,omitempty"`
+	// For SRT, the streamid, for example, #!::r=live/livestream,m=publish
+	StreamID string `json:"streamid,omitempty"`
+	// For RTMPS, whether verify the certificate of server.
+	Verify bool `json:"verify,omitempty"`
+	// For WHIP, the bearer token to authorize, use secret if empty.
+	Token string `json:"token,omitempty"`
+}
+
+func (v *EgressOutput) String() string {
+	if v == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("passphrase=%vB, latency=%v, streamid=%v, verify=%v, token=%vB",
+		len(v.Passphrase), v.Latency, v.StreamID, v.Verify, len(v.Token),
+	)
+}
+
+// egressProtocolOf get the protocol by the scheme of server, empty if no scheme. The WHIP endpoint is a
+// HTTP or HTTPS url.
+func egressProtocolOf(server string) string {
+	protocol := forwardProtocolOf(server)
+	if protocol == "http" || protocol == "https" {
+		return "whip"
+	}
+	return protocol
+}
+
+// validateEgressOutput validate the output target by server and options, the opts is optional.
+func validateEgressOutput(server string, opts *EgressOutput) error {
+	if server == "" {
+		return errors.New("no server")
+	}
+
+	protocol := egressProtocolOf(server)
+	if !slicesContains(egressProtocols, protocol) {
+		return errors.Errorf("invalid protocol of server %v", server)
+	}
+
+	if protocol == "whip" && !ffmpegHasMuxer("whip") {
+		return errors.Errorf("whip not supported by FFmpeg, which requires FFmpeg 8+ with whip muxer, server %v", server)
+	}
+
+	u, err := url.Parse(server)
+	if err != nil {
+		return errors.Wrapf(err, "parse %v", server)
+	}
+	if u.Hostname() == "" {
+		return errors.Errorf("no host of server %v", server)
+	}
+
+	if opts == nil {
+		return nil
+	}
+
+	if protocol != "srt" && (opts.Passphrase != "" || opts.Latency != 0 || opts.StreamID != "") {
+		return errors.Errorf("passphrase, latency and streamid are only for srt, server %v", server)
+	}
+	if protocol != "rtmps" && opts.Verify {
+		return errors.Errorf("verify is only for rtmps, server %v", server)
+	}
+	if protocol != "whip" && opts.Token != "" {
+		return errors.Errorf("token is only for whip, server %v", server)
+	}
+
+	if opts.Passphrase != "" && (len(opts.Passphrase) < egressSRTPassphraseMin || len(opts.Passphrase) > egressSRTPassphraseMax) {
+		return errors.Errorf("passphrase should be %v to %v characters, actual %v",
+			egressSRTPassphraseMin, egressSRTPassphraseMax, len(opts.Passphrase))
+	}
+	if opts.Latency < 0 || opts.Latency > egressSRTLatencyMax {
+		return errors.Errorf("latency should be 0 to %vms, actual %v", egressSRTLatencyMax, opts.Latency)
+	}
+	return nil
+}
+
+// buildEgressOutput build the output url and the output arguments of FFmpeg, by the server, secret and
+// options, the opts is optional.
+func buildEgressOutput(server, secret string, opts *EgressOutput) (outputURL string, args []string) {
+	if opts == nil {
+		opts = &EgressOutput{}
+	}
+
+	switch egressProtocolOf(server) {
+	case "srt":
+		// The options of SRT are in the query of url, and the latency is in microseconds.
+		outputURL = forwardOutputURL(server, secret)
+		var queries []string
+		if opts.StreamID != "" {
+			queries = append(queries, fmt.Sprintf("streamid=%v", url.QueryEscape(opts.StreamID)))
+		}
+		if opts.Passphrase != "" {
+			queries = append(queries, fmt.Sprintf("passphrase=%v", url.QueryEscape(opts.Passphrase)))
+		}
+		if opts.Latency > 0 {
+			queries = append(queries, fmt.Sprintf("latency=%v", opts.Latency*1000))
+		}
+		if len(queries) > 0 {
+			if strings.Contains(outputURL, "?") {
+				outputURL += "&"
+			} else {
+				outputURL += "?"
+			}
+			outputURL += strings.Join(queries, "&")
+		}
+		args = []string{"-f", "mpegts", "-pes_payload_size", "0"}
+	case "rtmps":
+		outputURL = forwardOutputURL(server, secret)
+		args = []string{"-f", "flv"}
+		if opts.Verify {
+			args = append(args, "-tls_verify", "1")
+		}
+	case "whip":
+		// The WHIP endpoint is the server, and the secret is the bearer token. WebRTC requires opus.
+		outputURL = server
+		args = []string{"-c:a", "libopus", "-ar", "48000", "-ac", "2", "-f", "whip"}
+		if token := opts.Token; token != "" || secret != "" {
+			if token == "" {
+				token = secret
+			}
+			args = append(args, "-authorization", token)
+		}
+	default:
+		outputURL = forwardOutputURL(server, secret)
+		args = forwardOutputFormat(outputURL)
+	}
+
+	return
+}
+
+// testEgressOutput test the connection to output target, by publishing a test stream for a while.
+// Return the reason and log of failure if failed.
+func testEgressOutput(ctx context.Context, server, secret string, opts *EgressOutput) (reason, line string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, egressTestTimeout)
+	defer cancel()
+
+	// For RTMP and RTMPS, connect to server first, for a clear error of network or certificate.
+	if protocol := egressProtocolOf(server); protocol == "rtmp" || protocol == "rtmps" {
+		u, err := url.Parse(server)
+		if err != nil {
+			return forwardReasonUnknown, err.Error(), errors.Wrapf(err, "parse %v", server)
+		}
+
+		port := u.Port()
+		if port == "" && protocol == "rtmp" {
+			port = "1935"
+		} else if port == "" {
+			port = "443"
+		}
+		address := net.JoinHostPort(u.Hostname(), port)
+
+		var conn net.Conn
+		dialer := &net.Dialer{Timeout: 5 * time.Second}
+		if protocol == "rtmps" {
+			conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{
+				ServerName: u.Hostname(), InsecureSkipVerify: opts == nil || !opts.Verify,
+			})
+		} else {
+			conn, err = dialer.DialContext(ctx, "tcp", address)
+		}
+		if err != nil {
+			reason, line = classifyForwardFailure("", []string{err.Error()})
+			return reason, line, errors.Wrapf(err, "connect %v", address)
+		}
+		conn.Close()
+	}
+
+	outputURL, outputArgs := buildEgressOutput(server, secret, opts)
+	args := []string{
+		"-re", "-f", "lavfi", "-i", "testsrc=size=320x240:rate=25",
+		"-f", "lavfi", "-i", "sine=frequency=1000:sample_rate=48000", "-t", "3",
+		"-c:v", "libx264", "-profile:v", "baseline", "-preset", "ultrafast", "-tune", "zerolatency",
+		"-pix_fmt", "yuv420p", "-g", "25", "-c:a", "aac", "-ac", "2",
+	}
+	args = append(args, outputArgs...)
+	args = append(args, outputURL)
+
+	b, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		var logs []string
+		for _, l := range strings.Split(string(b), "\n") {
+			if l = strings.TrimSpace(l); l != "" {
+				logs = append(logs, l)
+			}
+		}
+		reason, line = classifyForwardFailure("", logs)
+		if ctx.Err() != nil {
+			reason = forwardReasonTimeout
+		}
+		return reason, line, errors.Wrapf(err, "publish to %v", server)
+	}
+
+	return "", "", nil
+}
+
+func handleEgressOutputService(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/ffmpeg/output/test"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, server, secret string
+			var opts EgressOutput
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string       `json:"token"`
+				Server *string       `json:"server"`
+				Secret *string       `json:"secret"`
+				Output *EgressOutput `json:"output"`
+			}{
+				Token: &token, Server: &server, Secret: &secret, Output: &opts,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if err := validateEgressOutput(server, &opts); err != nil {
+				return errors.Wrapf(err, "validate")
+			}
+
+			starttime := time.Now()
+			reason, line, err := testEgressOutput(ctx, server, secret, &opts)
+			if err != nil {
+				logger.Wf(ctx, "test output server=%v, reason=%v, err %+v", server, reason, err)
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				OK       bool   `json:"ok"`
+				Protocol string `json:"protocol"`
+				Reason   string `json:"reason,omitempty"`
+				Error    string `json:"error,omitempty"`
+				Elapsed  int64  `json:"elapsed"`
+			}{
+				OK: err == nil, Protocol: egressProtocolOf(server), Reason: reason, Error: line,
+				Elapsed: int64(time.Since(starttime) / time.Millisecond),
+			})
+			logger.Tf(ctx, "test output ok, server=%v, ok=%v, token=%vB", server, err == nil, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEgressOutput_Validate(t *testing.T) {
+	hasMuxer := ffmpegHasMuxer
+	defer func() { ffmpegHasMuxer = hasMuxer }()
+	ffmpegHasMuxer = func(name string) bool { return true }
+
+	for _, e := range []struct {
+		server string
+		opts   *EgressOutput
+		err    bool
+	}{
+		{server: "rtmp://a.com/live"},
+		{server: "rtmps://a.com/live", opts: &EgressOutput{Verify: true}},
+		{server: "srt://a.com:10080", opts: &EgressOutput{Passphrase: "0123456789", Latency: 200, StreamID: "#!::r=live/s"}},
+		{server: "https://a.com/rtc/v1/whip/", opts: &EgressOutput{Token: "t1"}},
+		{server: "", err: true},
+		{server: "udp://a.com:1234", err: true},
+		{server: "rtmp:///live", err: true},
+		{server: "rtmp://a.com/live", opts: &EgressOutput{Verify: true}, err: true},
+		{server: "rtmp://a.com/live", opts: &EgressOutput{Passphrase: "0123456789"}, err: true},
+		{server: "rtmps://a.com/live", opts: &EgressOutput{Token: "t1"}, err: true},
+		{server: "srt://a.com:10080", opts: &EgressOutput{Passphrase: "012345678"}, err: true},
+		{server: "srt://a.com:10080", opts: &EgressOutput{Passphrase: strings.Repeat("0", 80)}, err: true},
+		{server: "srt://a.com:10080", opts: &EgressOutput{Latency: -1}, err: true},
+		{server: "srt://a.com:10080", opts: &EgressOutput{Latency: 10001}, err: true},
+	} {
+		if err := validateEgressOutput(e.server, e.opts); (err != nil) != e.err {
+			t.Errorf("Fail for %v %v, err %+v", e.server, e.opts.String(), err)
+		}
+	}
+}
+
+func TestEgressOutput_NoWhipMuxer(t *testing.T) {
+	hasMuxer := ffmpegHasMuxer
+	defer func() { ffmpegHasMuxer = hasMuxer }()
+	ffmpegHasMuxer = func(name string) bool { return name != "whip" }
+
+	if err := validateEgressOutput("https://a.com/rtc/v1/whip/", nil); err == nil || !strings.Contains(err.Error(), "FFmpeg 8+") {
+		t.Errorf("Fail for whip without muxer, err %+v", err)
+	}
+	if err := validateEgressOutput("rtmp://a.com/live", nil); err != nil {
+		t.Errorf("Fail for rtmp, err %+v", err)
+	}
+}
+
+func TestEgressOutput_ParseMuxers(t *testing.T) {
+	muxers := parseFFmpegMuxers(strings.Join([]string{
+		"File formats:",
+		" D. = Demuxing supported",
+		" .E = Muxing supported",
+		" --",
+		"  E  flv             FLV (Flash Video)",
+		" DE  hls             Apple HTTP Live Streaming",
+		"  E  mpegts          MPEG-TS (MPEG-2 Transport Stream)",
+		"  E  whip            WHIP(WebRTC-HTTP ingestion protocol) muxer",
+		" D   aac             raw ADTS AAC (Advanced Audio Coding)",
+	}, "\n"))
+	for _, e := range []struct {
+		name   string
+		expect bool
+	}{
+		{name: "flv", expect: true},
+		{name: "hls", expect: true},
+		{name: "mpegts", expect: true},
+		{name: "whip", expect: true},
+		{name: "aac"},
+		{name: "="},
+	} {
+		if muxers[e.name] != e.expect {
+			t.Errorf("Fail for %v, expect %v, muxers %v", e.name, e.expect, muxers)
+		}
+	}
+
+	if muxers := parseFFmpegMuxers("  E  flv  FLV (Flash Video)"); muxers["whip"] {
+		t.Errorf("Fail for whip without muxer, muxers %v", muxers)
+	}
+}
+
+func TestEgressOutput_Build(t *testing.T) {
+	for _, e := range []struct {
+		server, secret string
+		opts           *EgressOutput
+		output         string
+		args           string
+	}{
+		{server: "rtmp://a.com/live", secret: "s1", output: "rtmp://a.com/live/s1", args: "-f flv"},
+		{server: "rtmps://a.com/live", secret: "s1", output: "rtmps://a.com/live/s1", args: "-f flv"},
+		{server: "rtmps://a.com/live", secret: "s1", opts: &EgressOutput{Verify: true},
+			output: "rtmps://a.com/live/s1", args: "-f flv -tls_verify 1"},
+		{server: "srt://a.com:10080", output: "srt://a.com:10080", args: "-f mpegts -pes_payload_size 0"},
+		{server: "srt://a.com:10080", opts: &EgressOutput{StreamID: "#!::r=live/s,m=publish", Passphrase: "0123456789", Latency: 200},
+			output: "srt://a.com:10080?streamid=%23%21%3A%3Ar%3Dlive%2Fs%2Cm%3Dpublish&passphrase=0123456789&latency=200000",
+			args:   "-f mpegts -pes_payload_size 0"},
+		{server: "srt://a.com:10080?mode=caller", opts: &EgressOutput{Latency: 1},
+			output: "srt://a.com:10080?mode=caller&latency=1000", args: "-f mpegts -pes_payload_size 0"},
+		{server: "https://a.com/whip/?app=live&stream=s", secret: "s1", output: "https://a.com/whip/?app=live&stream=s",
+			args: "-c:a libopus -ar 48000 -ac 2 -f whip -authorization s1"},
+		{server: "https://a.com/whip/", secret: "s1", opts: &EgressOutput{Token: "t1"}, output: "https://a.com/whip/",
+			args: "-c:a libopus -ar 48000 -ac 2 -f whip -authorization t1"},
+		{server: "https://a.com/whip/", output: "https://a.com/whip/", args: "-c:a libopus -ar 48000 -ac 2 -f whip"},
+	} {
+		output, args := buildEgressOutput(e.server, e.secret, e.opts)
+		if output != e.output || strings.Join(args, " ") != e.args {
+			t.Errorf("Fail for %v %v %v, expect %v %v, actual %v %v",
+				e.server, e.secret, e.opts.String(), e.output, e.args, output, args)
+		}
+	}
+}
+
+func TestEgressOutput_TeeSlave(t *testing.T) {
+	for _, e := range []struct {
+		server, secret string
+		opts           *EgressOutput
+		args           []string
+		slave          string
+		err            bool
+	}{
+		{server: "rtmps://a.com/live", secret: "s1", opts: &EgressOutput{Verify: true},
+			slave: "[f=flv:tls_verify=1:onfail=ignore]rtmps://a.com/live/s1"},
+		{server: "rtmp://a.com/live", secret: "s1", args: []string{"-f", "flv", "-rtmp_app", `a:b]'c|d\e`},
+			slave: `[f=flv:rtmp_app=a\\:b\\\]\\\'c\|d\\\\e:onfail=ignore]rtmp://a.com/live/s1`},
+		{server: "https://a.com/rtc/v1/whip/", secret: "s1", err: true},
+		{server: "https://a.com/rtc/v1/whip/", secret: "s1", args: []string{"-f", "flv"}, err: true},
+		{server: "rtmp://a.com/live", secret: "s1", args: []string{"-c:a", "libopus", "-f", "flv"}, err: true},
+	} {
+		output, args := buildEgressOutput(e.server, e.secret, e.opts)
+		if e.args != nil {
+			args = e.args
+		}
+		if v, err := forwardTeeSlave(output, args); (err != nil) != e.err || v != e.slave {
+			t.Errorf("Fail for %v %v, expect %v, actual %v, err %+v", e.server, args, e.slave, v, err)
+		}
+	}
+}
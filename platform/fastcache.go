@@ -3,7 +3,10 @@
 // SPDX-License-Identifier: MIT
 package main
 
-import "context"
+import (
+	"context"
+	"sync"
+)
 
 var fastCache *FastCache
 
@@ -12,6 +15,13 @@ type FastCache struct {
 	HLSHighPerformance bool
 	// Whether deliver HLS in low latency mode.
 	HLSLowLatency bool
+	// The patterns of streams which require play token, see isPlayProtected.
+	PlayAuthPatterns map[string]string
+	// The CIDR rules to allow or deny clients, see verifyIPRules.
+	IPRules []*IPRule
+
+	// To protect the patterns and rules, which are replaced when refresh.
+	lock sync.RWMutex
 }
 
 func NewFastCache() *FastCache {
@@ -31,5 +41,29 @@ func (v *FastCache) Refresh(ctx context.Context) error {
 		v.HLSHighPerformance = false
 	}
 
+	if vs, err := rdb.HGetAll(ctx, SRS_PLAY_AUTH).Result(); err == nil {
+		v.lock.Lock()
+		v.PlayAuthPatterns = vs
+		v.lock.Unlock()
+	}
+
+	if rules, err := loadIPRules(ctx); err == nil {
+		v.lock.Lock()
+		v.IPRules = rules
+		v.lock.Unlock()
+	}
+
 	return nil
 }
+
+func (v *FastCache) playAuthPatterns() map[string]string {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	return v.PlayAuthPatterns
+}
+
+func (v *FastCache) ipRules() []*IPRule {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	return v.IPRules
+}
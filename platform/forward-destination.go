@@ -0,0 +1,528 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The protocols of forward destination, note that the WHIP leg is not forwarded by tee muxer, because WHIP
+// requires opus audio, see forwardTeeLeg.
+var forwardProtocols = []string{"rtmp", "rtmps", "srt", "whip"}
+
+// The max number of destinations to import at once.
+const forwardImportLimit = 1000
+
+// forwardProtocolOf get the protocol by the scheme of server, empty if no scheme.
+func forwardProtocolOf(server string) string {
+	if index := strings.Index(server, "://"); index > 0 {
+		return strings.ToLower(server[:index])
+	}
+	return ""
+}
+
+// Initialize set the default values of destination.
+func (v *ForwardConfigure) Initialize() {
+	if v.Protocol == "" {
+		v.Protocol = egressProtocolOf(v.Server)
+	}
+	if v.Label == "" {
+		v.Label = v.Platform
+	}
+}
+
+// Validate the destination, which should be initialized.
+func (v *ForwardConfigure) Validate() error {
+	if v.Server == "" && len(v.Legs) == 0 {
+		return errors.New("no server")
+	}
+
+	if v.Server != "" {
+		if !slicesContains(forwardProtocols, v.Protocol) {
+			return errors.Errorf("invalid protocol %v", v.Protocol)
+		}
+		if p := egressProtocolOf(v.Server); p != v.Protocol {
+			return errors.Errorf("protocol %v not match server %v", v.Protocol, v.Server)
+		}
+		if err := validateEgressOutput(v.Server, v.Output); err != nil {
+			return errors.Wrapf(err, "invalid output")
+		}
+	}
+
+	for _, leg := range v.Legs {
+		if leg.Name == "" || leg.Server == "" {
+			return errors.Errorf("invalid leg %v", leg.String())
+		}
+		if !slicesContains(forwardProtocols, egressProtocolOf(leg.Server)) {
+			return errors.Errorf("invalid protocol of leg %v", leg.String())
+		}
+		if err := validateEgressOutput(leg.Server, leg.Output); err != nil {
+			return errors.Wrapf(err, "invalid output of leg %v", leg.Name)
+		}
+	}
+
+	for _, tag := range v.Tags {
+		if strings.TrimSpace(tag) == "" {
+			return errors.New("empty tag")
+		}
+	}
+
+	if err := validateSchedules(v.Schedules); err != nil {
+		return errors.Wrapf(err, "invalid schedules")
+	}
+	return nil
+}
+
+// HasTag whether the destination has the tag.
+func (v *ForwardConfigure) HasTag(tag string) bool {
+	for _, t := range v.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateForwardConfigure migrate the legacy platform configure, which is keyed by platform, to the
+// destination keyed by id. Return false if it's already a destination.
+func migrateForwardConfigure(key string, conf *ForwardConfigure) bool {
+	if conf.ID != "" && conf.ID == key {
+		return false
+	}
+
+	conf.ID = uuid.NewString()
+	if conf.Platform == "" {
+		conf.Platform = key
+	}
+	conf.Initialize()
+	return true
+}
+
+// migrateForwardDestinations migrate the legacy platforms, such as wx, bilibili, kuaishou and
+// forwarding-x, to destinations, and keep the platform as alias for the legacy API.
+func migrateForwardDestinations(ctx context.Context) error {
+	configs, err := rdb.HGetAll(ctx, SRS_FORWARD_CONFIG).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_FORWARD_CONFIG)
+	}
+
+	for key, config := range configs {
+		var conf ForwardConfigure
+		if err := json.Unmarshal([]byte(config), &conf); err != nil {
+			return errors.Wrapf(err, "unmarshal %v %v", key, config)
+		}
+
+		if !migrateForwardConfigure(key, &conf) {
+			continue
+		}
+
+		if err := saveForwardDestination(ctx, &conf); err != nil {
+			return errors.Wrapf(err, "save %v", conf.String())
+		}
+		if err := rdb.HDel(ctx, SRS_FORWARD_CONFIG, key).Err(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hdel %v %v", SRS_FORWARD_CONFIG, key)
+		}
+		logger.Tf(ctx, "forward migrate platform %v to destination %v", key, conf.ID)
+	}
+
+	return nil
+}
+
+// queryForwardDestinations load all destinations, keyed by id.
+func queryForwardDestinations(ctx context.Context) (map[string]*ForwardConfigure, error) {
+	configs, err := rdb.HGetAll(ctx, SRS_FORWARD_CONFIG).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_FORWARD_CONFIG)
+	}
+
+	objs := make(map[string]*ForwardConfigure)
+	for id, config := range configs {
+		var obj ForwardConfigure
+		if err := json.Unmarshal([]byte(config), &obj); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v %v", id, config)
+		}
+		objs[id] = &obj
+	}
+	return objs, nil
+}
+
+// verifyForwardLimit check whether the number of destinations exceeds the SRS_FORWARD_LIMIT, if create the
+// number of new destinations.
+func verifyForwardLimit(ctx context.Context, creates int) error {
+	if envForwardLimit() == "" || creates <= 0 {
+		return nil
+	}
+
+	limit, err := strconv.ParseInt(envForwardLimit(), 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "parse env forward limit %v", envForwardLimit())
+	}
+
+	n, err := rdb.HLen(ctx, SRS_FORWARD_CONFIG).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hlen %v", SRS_FORWARD_CONFIG)
+	}
+
+	if n+int64(creates) > limit {
+		return errors.Errorf("too many destinations %v+%v, limit %v", n, creates, limit)
+	}
+	return nil
+}
+
+// queryForwardDestinationByPlatform load the destination by legacy platform, nil if not exists. The platform
+// should be unique, see verifyForwardPlatforms, and the one with smallest id is used if not.
+func queryForwardDestinationByPlatform(ctx context.Context, platform string) (*ForwardConfigure, error) {
+	objs, err := queryForwardDestinations(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "query destinations")
+	}
+
+	var target *ForwardConfigure
+	for id, obj := range objs {
+		if obj.Platform == platform && (target == nil || id < target.ID) {
+			target = obj
+		}
+	}
+	return target, nil
+}
+
+// verifyForwardPlatforms check whether the legacy platform of targets is unique, if save the targets over the
+// destinations, because the legacy API queries the destination by platform, see queryForwardDestinationByPlatform.
+func verifyForwardPlatforms(objs map[string]*ForwardConfigure, targets []*ForwardConfigure) error {
+	merged := make(map[string]*ForwardConfigure)
+	for id, obj := range objs {
+		merged[id] = obj
+	}
+	for _, target := range targets {
+		merged[target.ID] = target
+	}
+
+	for _, target := range targets {
+		if target.Platform == "" {
+			continue
+		}
+		for id, obj := range merged {
+			if id != target.ID && obj.Platform == target.Platform {
+				return errors.Errorf("platform %v of %v conflicts with %v", target.Platform, target.ID, id)
+			}
+		}
+	}
+	return nil
+}
+
+func saveForwardDestination(ctx context.Context, conf *ForwardConfigure) error {
+	if b, err := json.Marshal(conf); err != nil {
+		return errors.Wrapf(err, "marshal %v", conf.String())
+	} else if err = rdb.HSet(ctx, SRS_FORWARD_CONFIG, conf.ID, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %vB", SRS_FORWARD_CONFIG, conf.ID, len(b))
+	}
+	return nil
+}
+
+// handleDestinations handle the API of forward destinations.
+func (v *ForwardWorker) handleDestinations(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/ffmpeg/forward/destinations/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, id, tag string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				ID    *string `json:"id"`
+				Tag   *string `json:"tag"`
+			}{
+				Token: &token, ID: &id, Tag: &tag,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			objs, err := queryForwardDestinations(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "query destinations")
+			}
+
+			res := make([]*ForwardConfigure, 0)
+			for _, obj := range objs {
+				if id != "" && obj.ID != id {
+					continue
+				}
+				if tag != "" && !obj.HasTag(tag) {
+					continue
+				}
+				res = append(res, obj)
+			}
+
+			sort.Slice(res, func(i, j int) bool {
+				if res[i].Label != res[j].Label {
+					return res[i].Label < res[j].Label
+				}
+				return res[i].ID < res[j].ID
+			})
+
+			ohttp.WriteData(ctx, w, r, res)
+			logger.Tf(ctx, "forward query destinations ok, id=%v, tag=%v, total=%v, token=%vB",
+				id, tag, len(res), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	// To check the limit and platforms, and save destinations atomically.
+	var createLock sync.Mutex
+
+	// Build the target over the destinations, create a destination if no id, or update the destination by
+	// id. The target is validated, but not saved.
+	prepare := func(objs map[string]*ForwardConfigure, conf *ForwardConfigure) (*ForwardConfigure, error) {
+		target := &ForwardConfigure{ID: uuid.NewString()}
+		if conf.ID != "" {
+			obj, ok := objs[conf.ID]
+			if !ok {
+				return nil, errors.Errorf("no destination %v", conf.ID)
+			}
+			copied := *obj
+			target = &copied
+		}
+
+		if err := target.Update(conf); err != nil {
+			return nil, errors.Wrapf(err, "update %v", target.String())
+		}
+		target.Initialize()
+		if err := target.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "validate %v", target.String())
+		}
+		return target, nil
+	}
+
+	// Save all targets by one HSET, then restart the forwarding if exists, or it will be started by worker.
+	save := func(ctx context.Context, targets []*ForwardConfigure) error {
+		values := make(map[string]interface{})
+		for _, target := range targets {
+			b, err := json.Marshal(target)
+			if err != nil {
+				return errors.Wrapf(err, "marshal %v", target.String())
+			}
+			values[target.ID] = string(b)
+		}
+		if err := rdb.HSet(ctx, SRS_FORWARD_CONFIG, values).Err(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hset %v %v destinations", SRS_FORWARD_CONFIG, len(values))
+		}
+
+		for _, target := range targets {
+			if task := v.GetTask(target.ID); task != nil {
+				if err := task.Restart(ctx); err != nil {
+					return errors.Wrapf(err, "restart task %v", target.String())
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, action := range []string{"create", "update"} {
+		ep = fmt.Sprintf("/terraform/v1/ffmpeg/forward/destinations/%v", action)
+		logger.Tf(ctx, "Handle %v", ep)
+
+		create := action == "create"
+		handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+			if err := func() error {
+				var token string
+				var conf ForwardConfigure
+				if err := ParseBody(ctx, r.Body, &struct {
+					Token *string `json:"token"`
+					*ForwardConfigure
+				}{
+					Token: &token, ForwardConfigure: &conf,
+				}); err != nil {
+					return errors.Wrapf(err, "parse body")
+				}
+
+				apiSecret := envApiSecret()
+				if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+					return errors.Wrapf(err, "authenticate")
+				}
+
+				if create {
+					conf.ID = ""
+				} else if conf.ID == "" {
+					return errors.New("no id")
+				}
+
+				createLock.Lock()
+				defer createLock.Unlock()
+
+				if create {
+					if err := verifyForwardLimit(ctx, 1); err != nil {
+						return errors.Wrapf(err, "create")
+					}
+				}
+
+				objs, err := queryForwardDestinations(ctx)
+				if err != nil {
+					return errors.Wrapf(err, "query destinations")
+				}
+
+				target, err := prepare(objs, &conf)
+				if err != nil {
+					return errors.Wrapf(err, "prepare")
+				}
+				if err := verifyForwardPlatforms(objs, []*ForwardConfigure{target}); err != nil {
+					return errors.Wrapf(err, "verify %v", target.String())
+				}
+
+				if err := save(ctx, []*ForwardConfigure{target}); err != nil {
+					return errors.Wrapf(err, "save")
+				}
+
+				ohttp.WriteData(ctx, w, r, target)
+				logger.Tf(ctx, "forward upsert destination ok, create=%v, id=%v, label=%v, token=%vB",
+					create, target.ID, target.Label, len(token))
+				return nil
+			}(); err != nil {
+				ohttp.WriteError(ctx, w, r, err)
+			}
+		})
+	}
+
+	ep = "/terraform/v1/ffmpeg/forward/destinations/remove"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, id string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				ID    *string `json:"id"`
+			}{
+				Token: &token, ID: &id,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if id == "" {
+				return errors.New("no id")
+			}
+
+			if err := rdb.HDel(ctx, SRS_FORWARD_CONFIG, id).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hdel %v %v", SRS_FORWARD_CONFIG, id)
+			}
+			v.RemoveTask(ctx, id)
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "forward remove destination ok, id=%v, token=%vB", id, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/forward/destinations/import"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var confs []*ForwardConfigure
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token        *string              `json:"token"`
+				Destinations *[]*ForwardConfigure `json:"destinations"`
+			}{
+				Token: &token, Destinations: &confs,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if len(confs) == 0 {
+				return errors.New("no destinations")
+			} else if len(confs) > forwardImportLimit {
+				return errors.Errorf("too many destinations %v, limit %v", len(confs), forwardImportLimit)
+			}
+
+			createLock.Lock()
+			defer createLock.Unlock()
+
+			objs, err := queryForwardDestinations(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "query destinations")
+			}
+
+			// Validate all destinations before import, so nothing is imported if any is invalid. Note
+			// that the destination with id is updated, so it must exist.
+			var creates int
+			var targets []*ForwardConfigure
+			updates := make(map[string]bool)
+			for i, conf := range confs {
+				if conf == nil {
+					return errors.Errorf("empty destination #%v", i)
+				}
+				if conf.ID == "" {
+					creates++
+				} else if updates[conf.ID] {
+					return errors.Errorf("duplicated destination #%v %v", i, conf.ID)
+				} else {
+					updates[conf.ID] = true
+				}
+
+				target, err := prepare(objs, conf)
+				if err != nil {
+					return errors.Wrapf(err, "prepare #%v %v", i, conf.Label)
+				}
+				targets = append(targets, target)
+			}
+
+			if err := verifyForwardPlatforms(objs, targets); err != nil {
+				return errors.Wrapf(err, "import")
+			}
+			if err := verifyForwardLimit(ctx, creates); err != nil {
+				return errors.Wrapf(err, "import")
+			}
+
+			if err := save(ctx, targets); err != nil {
+				return errors.Wrapf(err, "save")
+			}
+
+			var ids []string
+			for _, target := range targets {
+				ids = append(ids, target.ID)
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				IDs []string `json:"ids"`
+			}{
+				IDs: ids,
+			})
+			logger.Tf(ctx, "forward import destinations ok, total=%v, token=%vB", len(ids), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestForwardDestination_Migrate(t *testing.T) {
+	conf := &ForwardConfigure{Platform: "wx", Server: "rtmp://wx.com/live", Secret: "s1", Enabled: true}
+	if !migrateForwardConfigure("wx", conf) {
+		t.Errorf("Fail for should migrate")
+	} else if conf.ID == "" || conf.Platform != "wx" || conf.Protocol != "rtmp" || conf.Label != "wx" {
+		t.Errorf("Fail for conf %v", conf.String())
+	}
+
+	// The migrated destination is keyed by id.
+	if migrateForwardConfigure(conf.ID, conf) {
+		t.Errorf("Fail for should not migrate %v", conf.String())
+	}
+
+	// The platform is set by key, if not exists.
+	conf = &ForwardConfigure{Server: "srt://a.com:10080", Label: "SRT"}
+	if !migrateForwardConfigure("forwarding-1-abc", conf) {
+		t.Errorf("Fail for should migrate")
+	} else if conf.Platform != "forwarding-1-abc" || conf.Protocol != "srt" || conf.Label != "SRT" {
+		t.Errorf("Fail for conf %v", conf.String())
+	}
+}
+
+func TestForwardDestination_Validate(t *testing.T) {
+	hasMuxer := ffmpegHasMuxer
+	defer func() { ffmpegHasMuxer = hasMuxer }()
+	ffmpegHasMuxer = func(name string) bool { return true }
+
+	for _, e := range []struct {
+		conf ForwardConfigure
+		err  bool
+	}{
+		{conf: ForwardConfigure{Server: "rtmp://a.com/live"}},
+		{conf: ForwardConfigure{Server: "rtmps://a.com/live"}},
+		{conf: ForwardConfigure{Server: "srt://a.com:10080"}},
+		{conf: ForwardConfigure{}, err: true},
+		{conf: ForwardConfigure{Server: "https://a.com/rtc/v1/whip/?app=live&stream=livestream"}},
+		{conf: ForwardConfigure{Server: "udp://a.com:1234"}, err: true},
+		{conf: ForwardConfigure{Server: "srt://a.com:10080", Output: &EgressOutput{Passphrase: "short"}}, err: true},
+		{conf: ForwardConfigure{Server: "a.com/live"}, err: true},
+		{conf: ForwardConfigure{Server: "rtmp://a.com/live", Protocol: "srt"}, err: true},
+		{conf: ForwardConfigure{Server: "rtmp://a.com/live", Tags: []string{"a", " "}}, err: true},
+		{conf: ForwardConfigure{Legs: []*ForwardLegConfigure{{Name: "a", Server: "rtmp://a.com/live"}}}},
+		{conf: ForwardConfigure{Legs: []*ForwardLegConfigure{{Name: "a", Server: "udp://a.com"}}}, err: true},
+		{conf: ForwardConfigure{Legs: []*ForwardLegConfigure{{Server: "rtmp://a.com/live"}}}, err: true},
+		{conf: ForwardConfigure{Legs: []*ForwardLegConfigure{{Name: "a", Server: "https://a.com/whip"}}}},
+	} {
+		e.conf.Initialize()
+		if err := e.conf.Validate(); (err != nil) != e.err {
+			t.Errorf("Fail for %v, err %+v", e.conf.String(), err)
+		}
+	}
+}
+
+func TestForwardDestination_Update(t *testing.T) {
+	conf := &ForwardConfigure{
+		ID: "id1", Platform: "wx", Tags: []string{"a"}, Server: "rtmp://a.com/live",
+		Legs: []*ForwardLegConfigure{{Name: "a", Server: "rtmp://a.com/live"}},
+	}
+
+	// The legacy API does not know the id, tags and legs.
+	if err := conf.Update(&ForwardConfigure{Platform: "wx", Server: "rtmp://b.com/live", Enabled: true}); err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if conf.ID != "id1" || len(conf.Tags) != 1 || len(conf.Legs) != 1 || conf.Server != "rtmp://b.com/live" {
+		t.Errorf("Fail for conf %v", conf.String())
+	}
+
+	// The empty tags and legs are updated.
+	if err := conf.Update(&ForwardConfigure{Tags: []string{}, Legs: []*ForwardLegConfigure{}}); err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if conf.Platform != "wx" || len(conf.Tags) != 0 || len(conf.Legs) != 0 {
+		t.Errorf("Fail for conf %v", conf.String())
+	}
+}
+
+func TestForwardDestination_VerifyPlatforms(t *testing.T) {
+	objs := map[string]*ForwardConfigure{
+		"id1": {ID: "id1", Platform: "wx"},
+		"id2": {ID: "id2", Platform: "bilibili"},
+		"id3": {ID: "id3"},
+	}
+
+	for _, e := range []struct {
+		targets []*ForwardConfigure
+		err     bool
+	}{
+		{targets: []*ForwardConfigure{{ID: "id4"}}},
+		{targets: []*ForwardConfigure{{ID: "id4"}, {ID: "id5"}}},
+		{targets: []*ForwardConfigure{{ID: "id4", Platform: "kuaishou"}}},
+		{targets: []*ForwardConfigure{{ID: "id1", Platform: "wx"}}},
+		{targets: []*ForwardConfigure{{ID: "id1", Platform: "kuaishou"}, {ID: "id4", Platform: "wx"}}},
+		{targets: []*ForwardConfigure{{ID: "id4", Platform: "wx"}}, err: true},
+		{targets: []*ForwardConfigure{{ID: "id2", Platform: "wx"}}, err: true},
+		{targets: []*ForwardConfigure{{ID: "id4", Platform: "kuaishou"}, {ID: "id5", Platform: "kuaishou"}}, err: true},
+	} {
+		if err := verifyForwardPlatforms(objs, e.targets); (err != nil) != e.err {
+			t.Errorf("Fail for targets=%v, err %+v", len(e.targets), err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// The health state of forward destination.
+const (
+	// The destination is forwarding, or not failed.
+	forwardHealthy = "healthy"
+	// The destination failed a few times, but might recover.
+	forwardDegraded = "degraded"
+	// The destination keeps failing.
+	forwardFailing = "failing"
+	// The destination rejects the stream key, which never recovers until user fixes it.
+	forwardAuthError = "auth-error"
+)
+
+// The reason of forward failure, classified from the logs of FFmpeg.
+const (
+	forwardReasonAuth    = "auth"
+	forwardReasonDNS     = "dns"
+	forwardReasonTLS     = "tls"
+	forwardReasonRefused = "refused"
+	forwardReasonReset   = "reset"
+	forwardReasonTimeout = "timeout"
+	// The source stream is unavailable, for example, it's unpublished, which is not the failure of
+	// destination, so the health is not changed.
+	forwardReasonInput   = "input"
+	forwardReasonUnknown = "unknown"
+)
+
+// The destination is failing if failed for this number of times continuously.
+const forwardFailingThreshold = 3
+
+// The backoff to restart FFmpeg when failed, doubled for each failure until the max.
+const forwardBackoffBase = 3500 * time.Millisecond
+const forwardBackoffMax = 5 * time.Minute
+
+// The patterns of FFmpeg logs for each reason, in lower case. Note that the auth error is checked first,
+// because the server might close the connection after rejecting the stream.
+var forwardFailurePatterns = []struct {
+	reason   string
+	patterns []string
+}{
+	{reason: forwardReasonAuth, patterns: []string{
+		"401 unauthorized", "403 forbidden", "unauthorized", "authentication failed", "auth failed",
+		"permission denied", "access denied", "netstream.publish.badname", "netstream.publish.rejected",
+		"netconnection.connect.rejected",
+	}},
+	{reason: forwardReasonDNS, patterns: []string{
+		"failed to resolve hostname", "name or service not known", "temporary failure in name resolution",
+		"nodename nor servname provided", "no address associated with hostname",
+	}},
+	{reason: forwardReasonRefused, patterns: []string{"connection refused"}},
+	{reason: forwardReasonReset, patterns: []string{"connection reset by peer", "broken pipe", "end of file"}},
+	{reason: forwardReasonTimeout, patterns: []string{"connection timed out", "operation timed out", "timeout"}},
+}
+
+// classifyForwardFailure classify the reason of failure, by the extra logs of FFmpeg, from the last
+// log. The log which is about the input URL is the failure of input, except the banner of input.
+func classifyForwardFailure(inputURL string, logs []string) (reason, line string) {
+	for i := len(logs) - 1; i >= 0; i-- {
+		if inputURL != "" && strings.Contains(logs[i], inputURL) && !strings.Contains(logs[i], "Input #") {
+			return forwardReasonInput, logs[i]
+		}
+
+		lower := strings.ToLower(logs[i])
+		for _, e := range forwardFailurePatterns {
+			for _, pattern := range e.patterns {
+				if strings.Contains(lower, pattern) {
+					return e.reason, logs[i]
+				}
+			}
+		}
+	}
+
+	if len(logs) > 0 {
+		return forwardReasonUnknown, logs[len(logs)-1]
+	}
+	return forwardReasonUnknown, ""
+}
+
+// forwardBackoff get the duration to wait before restart, by the number of continuous failures.
+func forwardBackoff(failures int) time.Duration {
+	backoff := forwardBackoffBase
+	for i := 1; i < failures && backoff < forwardBackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > forwardBackoffMax {
+		backoff = forwardBackoffMax
+	}
+	return backoff
+}
+
+// ForwardHealth is the health of forward destination.
+type ForwardHealth struct {
+	// The health state.
+	State string `json:"state"`
+	// The number of continuous failures.
+	Failures int `json:"failures"`
+	// The total number of failures.
+	TotalFailures int `json:"totalFailures"`
+	// The reason of last failure.
+	Reason string `json:"reason,omitempty"`
+	// The log of last failure.
+	Error string `json:"error,omitempty"`
+	// The last update time of state.
+	Update string `json:"update,omitempty"`
+
+	// To protect the fields.
+	lock sync.Mutex
+}
+
+func NewForwardHealth() *ForwardHealth {
+	return &ForwardHealth{State: forwardHealthy}
+}
+
+// OnExit update the health when FFmpeg exits, return the previous and current state. The ready indicates
+// whether FFmpeg ever forwarded the stream, and the err is the exit error of FFmpeg.
+func (v *ForwardHealth) OnExit(ready bool, reason, line string, err error) (from, to string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	from = v.State
+	if ready {
+		v.Failures = 0
+	}
+
+	if err == nil || reason == forwardReasonInput {
+		if ready && v.State != forwardHealthy {
+			v.State, v.Reason, v.Error = forwardHealthy, "", ""
+			v.Update = time.Now().Format(time.RFC3339)
+		}
+		return from, v.State
+	}
+
+	v.Failures, v.TotalFailures = v.Failures+1, v.TotalFailures+1
+	v.Reason, v.Error, v.Update = reason, line, time.Now().Format(time.RFC3339)
+	if reason == forwardReasonAuth {
+		v.State = forwardAuthError
+	} else if v.Failures >= forwardFailingThreshold {
+		v.State = forwardFailing
+	} else {
+		v.State = forwardDegraded
+	}
+
+	return from, v.State
+}
+
+// Backoff get the duration to wait before restart.
+func (v *ForwardHealth) Backoff() time.Duration {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return forwardBackoff(v.Failures)
+}
+
+// Query get a copy of health.
+func (v *ForwardHealth) Query() *ForwardHealth {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return &ForwardHealth{
+		State: v.State, Failures: v.Failures, TotalFailures: v.TotalFailures, Reason: v.Reason,
+		Error: v.Error, Update: v.Update,
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestForwardHealth_Classify(t *testing.T) {
+	input := "rtmp://localhost/live/livestream"
+	for _, e := range []struct {
+		logs   []string
+		reason string
+	}{
+		{logs: nil, reason: forwardReasonUnknown},
+		{logs: []string{"Conversion failed!"}, reason: forwardReasonUnknown},
+		{logs: []string{
+			"Input #0, flv, from 'rtmp://localhost/live/livestream':",
+			"[rtmp @ 0x1] Server error: NetStream.Publish.BadName",
+			"Conversion failed!",
+		}, reason: forwardReasonAuth},
+		{logs: []string{
+			"Input #0, flv, from 'rtmp://localhost/live/livestream':",
+			"[tcp @ 0x1] Failed to resolve hostname a.invalid: Name or service not known",
+		}, reason: forwardReasonDNS},
+		{logs: []string{"[tcp @ 0x1] Connection to tcp://a.com:1935 failed: Connection refused"}, reason: forwardReasonRefused},
+		{logs: []string{"av_interleaved_write_frame(): Broken pipe", "Error writing trailer: Broken pipe"}, reason: forwardReasonReset},
+		{logs: []string{"[tcp @ 0x1] Connection to tcp://a.com:1935 failed: Connection timed out"}, reason: forwardReasonTimeout},
+		{logs: []string{"Input #0, flv, from 'rtmp://localhost/live/livestream':",
+			"rtmp://localhost/live/livestream: End of file"}, reason: forwardReasonInput},
+	} {
+		if reason, _ := classifyForwardFailure(input, e.logs); reason != e.reason {
+			t.Errorf("Fail for %v, expect %v, actual %v", e.logs, e.reason, reason)
+		}
+	}
+}
+
+func TestForwardHealth_Backoff(t *testing.T) {
+	for _, e := range []struct {
+		failures int
+		backoff  time.Duration
+	}{
+		{failures: 0, backoff: 3500 * time.Millisecond},
+		{failures: 1, backoff: 3500 * time.Millisecond},
+		{failures: 2, backoff: 7 * time.Second},
+		{failures: 3, backoff: 14 * time.Second},
+		{failures: 10, backoff: 5 * time.Minute},
+		{failures: 1000, backoff: 5 * time.Minute},
+	} {
+		if v := forwardBackoff(e.failures); v != e.backoff {
+			t.Errorf("Fail for failures=%v, expect %v, actual %v", e.failures, e.backoff, v)
+		}
+	}
+}
+
+func TestForwardHealth_State(t *testing.T) {
+	err := errors.New("exit status 1")
+	health := NewForwardHealth()
+
+	// The failure of input never changes the health.
+	if from, to := health.OnExit(false, forwardReasonInput, "", err); from != forwardHealthy || to != forwardHealthy {
+		t.Errorf("Fail for from=%v, to=%v", from, to)
+	}
+
+	for i, expect := range []string{forwardDegraded, forwardDegraded, forwardFailing, forwardFailing} {
+		if _, to := health.OnExit(false, forwardReasonRefused, "refused", err); to != expect {
+			t.Errorf("Fail for #%v, expect %v, actual %v", i, expect, to)
+		}
+	}
+	if v := health.Query(); v.Failures != 4 || v.TotalFailures != 4 || v.Reason != forwardReasonRefused {
+		t.Errorf("Fail for health %v", v)
+	}
+
+	// Recover when forwarded the stream, then auth error immediately.
+	if from, to := health.OnExit(true, forwardReasonInput, "", err); from != forwardFailing || to != forwardHealthy {
+		t.Errorf("Fail for from=%v, to=%v", from, to)
+	}
+	if _, to := health.OnExit(false, forwardReasonAuth, "403 Forbidden", err); to != forwardAuthError {
+		t.Errorf("Fail for to=%v", to)
+	}
+	if v := health.Query(); v.Failures != 1 || v.TotalFailures != 5 {
+		t.Errorf("Fail for health %v", v)
+	}
+}
@@ -0,0 +1,352 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+)
+
+// The status of leg, which is a destination of tee muxer.
+const (
+	// The leg is started, but not ready.
+	forwardLegPending = "pending"
+	// The leg is forwarding by the tee muxer.
+	forwardLegRunning = "running"
+	// The leg is failed, and will be restarted by a standalone FFmpeg process.
+	forwardLegFailed = "failed"
+	// The leg is forwarding by a standalone FFmpeg process, after it's failed in tee muxer. It will be
+	// back to the tee muxer when the tee process restarts.
+	forwardLegStandalone = "standalone"
+)
+
+// The log of tee muxer when a slave is failed, for example:
+//
+//	Slave muxer #1 failed: Broken pipe, continuing with 2/3 slaves.
+var forwardTeeSlaveFailed = regexp.MustCompile(`Slave muxer #(\d+) failed`)
+
+// The escaping of tee muxer, the slaves are split by |, and the options of slave are split by : and closed by
+// ], so the option value is escaped twice. See https://ffmpeg.org/ffmpeg-utils.html#Quoting-and-escaping
+var forwardTeeEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `|`, `\|`, `[`, `\[`, `]`, `\]`)
+var forwardTeeOptionEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`, `]`, `\]`)
+
+// ForwardLegConfigure is the configure for a destination of multiple destinations forwarding.
+type ForwardLegConfigure struct {
+	// The name of destination, for example, youtube
+	Name string `json:"name"`
+	// The RTMP server url, for example, rtmp://localhost/live
+	Server string `json:"server"`
+	// The RTMP stream and secret, for example, livestream
+	Secret string `json:"secret"`
+	// Whether enabled.
+	Enabled bool `json:"enabled"`
+	// The protocol options of output.
+	Output *EgressOutput `json:"output,omitempty"`
+}
+
+func (v *ForwardLegConfigure) String() string {
+	return fmt.Sprintf("name=%v, server=%v, secret=%vB, enabled=%v, output=%v",
+		v.Name, v.Server, len(v.Secret), v.Enabled, v.Output.String(),
+	)
+}
+
+// ForwardLeg is the state of a destination, for multiple destinations forwarding by tee muxer.
+type ForwardLeg struct {
+	// The name of destination.
+	Name string `json:"name"`
+	// The server of destination, without secret.
+	Server string `json:"server"`
+	// The status of leg.
+	Status string `json:"status"`
+	// The last error of leg.
+	Error string `json:"error,omitempty"`
+	// The last update time of status.
+	Update string `json:"update"`
+	// The number of restarts.
+	Restarts int `json:"restarts"`
+
+	// The output url.
+	output string
+	// The output arguments of FFmpeg, for example, the format.
+	args []string
+	// The pid of standalone FFmpeg process.
+	pid int32
+	// The index of slave in tee muxer, -1 if always run by standalone FFmpeg process, for example, WHIP.
+	slave int
+}
+
+func (v *ForwardLeg) setStatus(status, err string) {
+	v.Status, v.Error, v.Update = status, err, time.Now().Format(time.RFC3339)
+}
+
+// forwardOutputURL build the output URL by server and secret.
+func forwardOutputURL(server, secret string) string {
+	if !strings.HasSuffix(server, "/") && !strings.HasPrefix(secret, "/") && secret != "" {
+		server += "/"
+	}
+	return fmt.Sprintf("%v%v", server, secret)
+}
+
+// forwardOutputFormat get the arguments of FFmpeg for output URL. If RTMP use flv, if SRT use mpegts,
+// otherwise do not set.
+func forwardOutputFormat(outputURL string) []string {
+	if strings.HasPrefix(outputURL, "rtmp://") || strings.HasPrefix(outputURL, "rtmps://") {
+		return []string{"-f", "flv"}
+	} else if strings.HasPrefix(outputURL, "srt://") {
+		return []string{"-pes_payload_size", "0", "-f", "mpegts"}
+	}
+	return nil
+}
+
+// forwardTeeLeg whether the leg is able to forward by tee muxer, which copies all streams. The WHIP requires
+// opus audio, so it's always forwarded by standalone FFmpeg process, which encodes the audio.
+func forwardTeeLeg(outputURL string) bool {
+	return egressProtocolOf(outputURL) != "whip"
+}
+
+// forwardTeeSlave build the slave of tee muxer, which ignores the failure so other slaves continue. The
+// output arguments of FFmpeg are converted to the options of slave, use the default format if nil.
+func forwardTeeSlave(outputURL string, args []string) (string, error) {
+	if !forwardTeeLeg(outputURL) {
+		return "", errors.Errorf("%v not supported by tee", outputURL)
+	}
+	if args == nil {
+		args = forwardOutputFormat(outputURL)
+	}
+
+	escape := func(value string) string {
+		return forwardTeeEscaper.Replace(forwardTeeOptionEscaper.Replace(value))
+	}
+
+	var format string
+	var opts []string
+	for i := 0; i+1 < len(args); i += 2 {
+		if name := strings.TrimPrefix(args[i], "-"); name == "f" {
+			format = escape(args[i+1])
+		} else if strings.HasPrefix(name, "c:") || strings.HasPrefix(name, "codec") {
+			return "", errors.Errorf("codec %v not supported by tee", args[i])
+		} else {
+			opts = append(opts, fmt.Sprintf("%v=%v", name, escape(args[i+1])))
+		}
+	}
+	if format != "" {
+		opts = append([]string{fmt.Sprintf("f=%v", format)}, opts...)
+	}
+	opts = append(opts, "onfail=ignore")
+
+	return fmt.Sprintf("[%v]%v", strings.Join(opts, ":"), forwardTeeEscaper.Replace(outputURL)), nil
+}
+
+// forwardTeeOutput build the output of tee muxer for legs, the index of slave is the slave of leg. Return
+// empty if no leg is able to forward by tee muxer.
+func forwardTeeOutput(legs []*ForwardLeg) (string, error) {
+	var slaves []string
+	for _, leg := range legs {
+		if leg.slave < 0 {
+			continue
+		}
+
+		slave, err := forwardTeeSlave(leg.output, leg.args)
+		if err != nil {
+			return "", errors.Wrapf(err, "leg %v", leg.Name)
+		}
+		slaves = append(slaves, slave)
+	}
+	return strings.Join(slaves, "|"), nil
+}
+
+// forwardTeeFailedSlaves parse the index of failed slaves from the log of FFmpeg.
+func forwardTeeFailedSlaves(line string) []int {
+	var slaves []int
+	for _, m := range forwardTeeSlaveFailed.FindAllStringSubmatch(line, -1) {
+		if index, err := strconv.Atoi(m[1]); err == nil {
+			slaves = append(slaves, index)
+		}
+	}
+	return slaves
+}
+
+// buildLegs create the legs for the enabled destinations, and allocate the slave of tee muxer.
+func (v *ForwardTask) buildLegs() []*ForwardLeg {
+	var legs []*ForwardLeg
+	var slaves int
+	for _, conf := range v.config.Legs {
+		if !conf.Enabled {
+			continue
+		}
+
+		leg := &ForwardLeg{Name: conf.Name, Server: conf.Server, slave: -1}
+		leg.output, leg.args = buildEgressOutput(conf.Server, conf.Secret, conf.Output)
+		if forwardTeeLeg(leg.output) {
+			leg.slave, slaves = slaves, slaves+1
+		}
+		leg.setStatus(forwardLegPending, "")
+		legs = append(legs, leg)
+	}
+	return legs
+}
+
+// queryLegs get a copy of legs state.
+func (v *ForwardTask) queryLegs() []ForwardLeg {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var legs []ForwardLeg
+	for _, leg := range v.legs {
+		legs = append(legs, *leg)
+	}
+	return legs
+}
+
+// updateLegPIDs update the pids of standalone legs, to cleanup when restart.
+func (v *ForwardTask) updateLegPIDs() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.LegPIDs = nil
+	for _, leg := range v.legs {
+		if leg.pid > 0 {
+			v.LegPIDs = append(v.LegPIDs, leg.pid)
+		}
+	}
+}
+
+// onTeeReady mark the pending legs as running, when tee muxer is ready.
+func (v *ForwardTask) onTeeReady() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for _, leg := range v.legs {
+		if leg.slave >= 0 && leg.Status == forwardLegPending {
+			leg.setStatus(forwardLegRunning, "")
+		}
+	}
+}
+
+// onTeeLog detect the failed slaves of tee muxer, and restart the legs by standalone FFmpeg process,
+// so the other legs are not interrupted.
+func (v *ForwardTask) onTeeLog(ctx context.Context, inputURL, line string) {
+	for _, index := range forwardTeeFailedSlaves(line) {
+		v.lock.Lock()
+		var leg *ForwardLeg
+		for _, l := range v.legs {
+			if l.slave == index && l.Status != forwardLegFailed && l.Status != forwardLegStandalone {
+				leg = l
+				leg.setStatus(forwardLegFailed, line)
+			}
+		}
+		v.lock.Unlock()
+
+		if leg == nil {
+			continue
+		}
+
+		logger.Wf(ctx, "forward platform=%v leg=%v failed, restart it standalone, %v", v.Platform, leg.Name, line)
+		go v.runLeg(ctx, leg, inputURL)
+	}
+}
+
+// runLeg run the leg by standalone FFmpeg process, until the tee process restarts. The leg which is not able
+// to forward by tee muxer, is always run by this.
+func (v *ForwardTask) runLeg(ctx context.Context, leg *ForwardLeg, inputURL string) {
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(3 * time.Second):
+		}
+
+		v.lock.Lock()
+		leg.Restarts++
+		v.lock.Unlock()
+
+		err := v.doForwardLeg(ctx, leg, inputURL)
+
+		v.lock.Lock()
+		if err != nil {
+			leg.setStatus(forwardLegFailed, err.Error())
+		} else {
+			leg.setStatus(forwardLegFailed, "done")
+		}
+		v.lock.Unlock()
+
+		if ctx.Err() == nil {
+			logger.Wf(ctx, "forward platform=%v leg=%v standalone done, err %+v", v.Platform, leg.Name, err)
+		}
+	}
+}
+
+func (v *ForwardTask) doForwardLeg(ctx context.Context, leg *ForwardLeg, inputURL string) error {
+	parentCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	heartbeat := NewFFmpegHeartbeat(cancel)
+
+	args := []string{"-re", "-i", inputURL, "-c", "copy"}
+	args = append(args, leg.args...)
+	args = append(args, leg.output)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return errors.Wrapf(err, "pipe process")
+	}
+
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "execute ffmpeg %v", strings.Join(args, " "))
+	}
+
+	v.lock.Lock()
+	leg.pid = int32(cmd.Process.Pid)
+	v.lock.Unlock()
+	v.updateLegPIDs()
+	v.saveTask(parentCtx)
+
+	defer func() {
+		v.lock.Lock()
+		leg.pid = 0
+		v.lock.Unlock()
+		v.updateLegPIDs()
+		v.saveTask(parentCtx)
+	}()
+	logger.Tf(ctx, "forward leg start, platform=%v, leg=%v, pid=%v", v.Platform, leg.Name, leg.pid)
+
+	heartbeat.Polling(ctx, stderr)
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.firstReadyCtx.Done():
+		}
+
+		v.lock.Lock()
+		leg.setStatus(forwardLegStandalone, "")
+		v.lock.Unlock()
+
+		// Drain the frame logs, or the heartbeat is blocked.
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-heartbeat.FrameLogs:
+			}
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+	case <-heartbeat.PollingCtx.Done():
+	}
+
+	return cmd.Wait()
+}
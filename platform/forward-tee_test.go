@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestForwardTee_Output(t *testing.T) {
+	for _, e := range []struct {
+		server, secret string
+		output         string
+	}{
+		{server: "rtmp://a.com/live", secret: "s1", output: "rtmp://a.com/live/s1"},
+		{server: "rtmp://a.com/live/", secret: "s1", output: "rtmp://a.com/live/s1"},
+		{server: "rtmp://a.com/live", secret: "/s1", output: "rtmp://a.com/live/s1"},
+		{server: "rtmp://a.com/live/s1", secret: "", output: "rtmp://a.com/live/s1"},
+	} {
+		if v := forwardOutputURL(e.server, e.secret); v != e.output {
+			t.Errorf("Fail for %v %v, expect %v, actual %v", e.server, e.secret, e.output, v)
+		}
+	}
+
+	legs := []*ForwardLeg{
+		{output: "rtmp://a.com/live/s1"},
+		{output: "srt://b.com:10080?streamid=#!::r=live/s2,m=publish"},
+		{output: "rtmp://c.com/live/s3?k=[a|b]"},
+	}
+	expect := `[f=flv:onfail=ignore]rtmp://a.com/live/s1|` +
+		`[f=mpegts:pes_payload_size=0:onfail=ignore]srt://b.com:10080?streamid=#!::r=live/s2,m=publish|` +
+		`[f=flv:onfail=ignore]rtmp://c.com/live/s3?k=\[a\|b\]`
+	if v, err := forwardTeeOutput(legs); err != nil || v != expect {
+		t.Errorf("Fail for tee output, expect %v, actual %v, err %+v", expect, v, err)
+	}
+}
+
+func TestForwardTee_FailedSlaves(t *testing.T) {
+	for _, e := range []struct {
+		line   string
+		slaves []int
+	}{
+		{line: "frame=100 fps=25 size=1024kB time=00:00:04.00 speed=1x", slaves: nil},
+		{line: "[tee @ 0x7f] Slave muxer #1 failed: Broken pipe, continuing with 2/3 slaves.", slaves: []int{1}},
+		{line: "Slave muxer #0 failed: I/O error, continuing with 2/3 slaves. Slave muxer #2 failed: " +
+			"I/O error, continuing with 1/3 slaves.", slaves: []int{0, 2}},
+	} {
+		if v := forwardTeeFailedSlaves(e.line); fmt.Sprint(v) != fmt.Sprint(e.slaves) {
+			t.Errorf("Fail for %v, expect %v, actual %v", e.line, e.slaves, v)
+		}
+	}
+}
+
+func TestForwardTee_BuildLegs(t *testing.T) {
+	task := &ForwardTask{config: &ForwardConfigure{Legs: []*ForwardLegConfigure{
+		{Name: "a", Server: "rtmp://a.com/live", Secret: "s1", Enabled: true},
+		{Name: "b", Server: "rtmp://b.com/live", Secret: "s2"},
+		{Name: "c", Server: "https://c.com/rtc/v1/whip/", Secret: "s3", Enabled: true},
+		{Name: "d", Server: "srt://d.com:10080", Enabled: true},
+	}}}
+
+	legs := task.buildLegs()
+	if len(legs) != 3 || legs[0].Name != "a" || legs[1].Name != "c" || legs[2].Name != "d" {
+		t.Errorf("Fail for legs %v", legs)
+		return
+	}
+	if legs[0].output != "rtmp://a.com/live/s1" || legs[0].Status != forwardLegPending {
+		t.Errorf("Fail for leg %v", legs[0])
+	}
+
+	// The WHIP leg is not in tee muxer, so the slave of next leg is not changed.
+	if legs[0].slave != 0 || legs[1].slave != -1 || legs[2].slave != 1 {
+		t.Errorf("Fail for slaves %v %v %v", legs[0].slave, legs[1].slave, legs[2].slave)
+	}
+	if v, err := forwardTeeOutput(legs); err != nil || v != `[f=flv:onfail=ignore]rtmp://a.com/live/s1|`+
+		`[f=mpegts:pes_payload_size=0:onfail=ignore]srt://d.com:10080` {
+		t.Errorf("Fail for tee output %v, err %+v", v, err)
+	}
+
+	task.legs = legs
+	task.onTeeReady()
+	if v := task.queryLegs(); v[0].Status != forwardLegRunning || v[1].Status != forwardLegPending ||
+		v[2].Status != forwardLegRunning {
+		t.Errorf("Fail for legs %v", v)
+	}
+}
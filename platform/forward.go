@@ -31,7 +31,7 @@ type ForwardWorker struct {
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 
-	// The tasks we have started to forward streams,, key is platform in string, value is *ForwardTask.
+	// The tasks we have started to forward streams, key is id of destination, value is *ForwardTask.
 	tasks sync.Map
 }
 
@@ -39,13 +39,28 @@ func NewForwardWorker() *ForwardWorker {
 	return &ForwardWorker{}
 }
 
-func (v *ForwardWorker) GetTask(platform string) *ForwardTask {
-	if task, loaded := v.tasks.Load(platform); loaded {
+func (v *ForwardWorker) GetTask(id string) *ForwardTask {
+	if task, loaded := v.tasks.Load(id); loaded {
 		return task.(*ForwardTask)
 	}
 	return nil
 }
 
+// OnSchedule update the schedules of all tasks, called by crontab.
+func (v *ForwardWorker) OnSchedule(ctx context.Context, now time.Time) {
+	v.tasks.Range(func(key, value interface{}) bool {
+		value.(*ForwardTask).onSchedule(ctx, now)
+		return true
+	})
+}
+
+// RemoveTask stop the task of destination, and remove it.
+func (v *ForwardWorker) RemoveTask(ctx context.Context, id string) {
+	if task, loaded := v.tasks.LoadAndDelete(id); loaded {
+		task.(*ForwardTask).Stop(ctx)
+	}
+}
+
 func (v *ForwardWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/ffmpeg/forward/secret"
 	logger.Tf(ctx, "Handle %v", ep)
@@ -84,35 +99,41 @@ func (v *ForwardWorker) Handle(ctx context.Context, handler *http.ServeMux) erro
 					return errors.Errorf("invalid platform=%v", userConf.Platform)
 				}
 
-				if userConf.Server == "" {
+				if userConf.Server == "" && len(userConf.Legs) == 0 {
 					return errors.New("no server")
 				}
+				for _, leg := range userConf.Legs {
+					if leg.Name == "" || leg.Server == "" {
+						return errors.Errorf("invalid leg %v", leg.String())
+					}
+				}
 				if userConf.Server == "" && userConf.Secret == "" {
 					return errors.New("no secret")
 				}
 			}
 
 			if action == "update" {
-				var targetConf ForwardConfigure
-				if config, err := rdb.HGet(ctx, SRS_FORWARD_CONFIG, userConf.Platform).Result(); err != nil && err != redis.Nil {
-					return errors.Wrapf(err, "hget %v %v", SRS_FORWARD_CONFIG, userConf.Platform)
-				} else {
-					if config != "" {
-						if err = json.Unmarshal([]byte(config), &targetConf); err != nil {
-							return errors.Wrapf(err, "unmarshal %v", config)
-						}
-					}
-					if err = targetConf.Update(&userConf); err != nil {
-						return errors.Wrapf(err, "update %v with %v", targetConf.String(), userConf.String())
-					} else if newB, err := json.Marshal(&targetConf); err != nil {
-						return errors.Wrapf(err, "marshal %v", targetConf.String())
-					} else if err = rdb.HSet(ctx, SRS_FORWARD_CONFIG, userConf.Platform, string(newB)).Err(); err != nil && err != redis.Nil {
-						return errors.Wrapf(err, "hset %v %v %v", SRS_FORWARD_CONFIG, userConf.Platform, string(newB))
-					}
+				// The legacy platform is an alias of destination, create the destination if not exists.
+				targetConf, err := queryForwardDestinationByPlatform(ctx, userConf.Platform)
+				if err != nil {
+					return errors.Wrapf(err, "query %v", userConf.Platform)
+				} else if targetConf == nil {
+					targetConf = &ForwardConfigure{ID: uuid.NewString()}
+				}
+
+				if err = targetConf.Update(&userConf); err != nil {
+					return errors.Wrapf(err, "update %v with %v", targetConf.String(), userConf.String())
+				}
+				targetConf.Initialize()
+				if err := targetConf.Validate(); err != nil {
+					return errors.Wrapf(err, "validate %v", targetConf.String())
+				}
+				if err := saveForwardDestination(ctx, targetConf); err != nil {
+					return errors.Wrapf(err, "save %v", targetConf.String())
 				}
 
 				// Restart the forwarding if exists.
-				if task := v.GetTask(userConf.Platform); task != nil {
+				if task := v.GetTask(targetConf.ID); task != nil {
 					if err := task.Restart(ctx); err != nil {
 						return errors.Wrapf(err, "restart task %v", userConf.String())
 					}
@@ -122,16 +143,15 @@ func (v *ForwardWorker) Handle(ctx context.Context, handler *http.ServeMux) erro
 				logger.Tf(ctx, "Forward update secret ok, token=%vB", len(token))
 				return nil
 			} else {
+				// Only the destinations of legacy platform, keyed by platform.
 				confObjs := make(map[string]*ForwardConfigure)
-				if configs, err := rdb.HGetAll(ctx, SRS_FORWARD_CONFIG).Result(); err != nil && err != redis.Nil {
-					return errors.Wrapf(err, "hgetall %v", SRS_FORWARD_CONFIG)
+				if configs, err := queryForwardDestinations(ctx); err != nil {
+					return errors.Wrapf(err, "query destinations")
 				} else {
-					for k, v := range configs {
-						var obj ForwardConfigure
-						if err = json.Unmarshal([]byte(v), &obj); err != nil {
-							return errors.Wrapf(err, "unmarshal %v %v", k, v)
+					for _, obj := range configs {
+						if obj.Platform != "" {
+							confObjs[obj.Platform] = obj
 						}
-						confObjs[k] = &obj
 					}
 				}
 
@@ -174,15 +194,38 @@ func (v *ForwardWorker) Handle(ctx context.Context, handler *http.ServeMux) erro
 
 					var pid int32
 					var streamURL, frame, update, starttime, ready string
-					if task := v.GetTask(config.Platform); task != nil {
+					if task := v.GetTask(config.ID); task != nil {
 						pid, streamURL, frame, update, starttime, ready = task.queryFrame()
 					}
 
+					// Use id as platform for the destination which is not a legacy platform.
+					platform := config.Platform
+					if platform == "" {
+						platform = config.ID
+					}
+
 					elem := map[string]interface{}{
-						"platform": config.Platform,
+						"id":       config.ID,
+						"platform": platform,
 						"enabled":  config.Enabled,
 						"custom":   config.Customed,
 						"label":    config.Label,
+						"protocol": config.Protocol,
+						"tags":     config.Tags,
+					}
+
+					if schedule := querySchedule(config.Schedules); schedule != nil {
+						elem["schedule"] = schedule
+					}
+
+					if task := v.GetTask(config.ID); task != nil && len(config.Legs) > 0 {
+						if legs := task.queryLegs(); len(legs) > 0 {
+							elem["legs"] = legs
+						}
+					}
+
+					if task := v.GetTask(config.ID); task != nil && task.health != nil {
+						elem["health"] = task.health.Query()
 					}
 
 					if pid > 0 {
@@ -211,6 +254,8 @@ func (v *ForwardWorker) Handle(ctx context.Context, handler *http.ServeMux) erro
 		}
 	})
 
+	v.handleDestinations(ctx, handler)
+
 	return nil
 }
 
@@ -243,7 +288,7 @@ func (v *ForwardWorker) Start(ctx context.Context) error {
 				return errors.Wrapf(err, "unmarshal %v %v", uuid, obj)
 			}
 
-			if task.PID > 0 {
+			if task.PID > 0 || len(task.LegPIDs) > 0 {
 				task.cleanup(ctx)
 			}
 		}
@@ -253,6 +298,11 @@ func (v *ForwardWorker) Start(ctx context.Context) error {
 		}
 	}
 
+	// Migrate the legacy platforms to destinations.
+	if err := migrateForwardDestinations(ctx); err != nil {
+		return errors.Wrapf(err, "migrate destinations")
+	}
+
 	// Load all configurations from redis.
 	loadTasks := func() error {
 		configItems, err := rdb.HGetAll(ctx, SRS_FORWARD_CONFIG).Result()
@@ -263,38 +313,48 @@ func (v *ForwardWorker) Start(ctx context.Context) error {
 			return nil
 		}
 
-		for platform, configItem := range configItems {
+		for id, configItem := range configItems {
 			var config ForwardConfigure
 			if err = json.Unmarshal([]byte(configItem), &config); err != nil {
-				return errors.Wrapf(err, "unmarshal %v %v", platform, configItem)
+				return errors.Wrapf(err, "unmarshal %v %v", id, configItem)
 			}
 
+			// Ignore the legacy platform which is not migrated.
+			if config.ID != id {
+				continue
+			}
+
+			// The task is stopped when the destination is removed. Note that the stop must be set before the
+			// task is stored, or it might be removed before set, and never stopped.
+			taskCtx, taskCancel := context.WithCancel(ctx)
+
 			var task *ForwardTask
-			if tv, loaded := v.tasks.LoadOrStore(config.Platform, &ForwardTask{
-				UUID:     uuid.NewString(),
-				Platform: config.Platform,
-				config:   &config,
+			if tv, loaded := v.tasks.LoadOrStore(config.ID, &ForwardTask{
+				UUID:        uuid.NewString(),
+				Destination: config.ID,
+				Platform:    config.Platform,
+				config:      &config,
+				stop:        taskCancel,
 			}); loaded {
 				// Ignore if exists.
+				taskCancel()
 				continue
 			} else {
 				task = tv.(*ForwardTask)
-				logger.Tf(ctx, "Forward create platform=%v task is %v", platform, task.String())
+				logger.Tf(ctx, "Forward create destination=%v task is %v", id, task.String())
 			}
 
 			// Initialize object.
 			if err := task.Initialize(ctx, v); err != nil {
+				taskCancel()
 				return errors.Wrapf(err, "init %v", task.String())
 			}
 
-			// Store in memory object.
-			v.tasks.Store(platform, task)
-
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
 
-				if err := task.Run(ctx); err != nil {
+				if err := task.Run(taskCtx); err != nil {
 					logger.Wf(ctx, "run task %v err %+v", task.String(), err)
 				}
 			}()
@@ -331,11 +391,17 @@ func (v *ForwardWorker) Start(ctx context.Context) error {
 	return nil
 }
 
-// ForwardConfigure is the configure for forwarding.
+// ForwardConfigure is the configure for forwarding, which is a destination.
 type ForwardConfigure struct {
-	// The platform name, for example, wx
+	// The id of destination, which is the field of SRS_FORWARD_CONFIG.
+	ID string `json:"id"`
+	// The legacy platform name, for example, wx, which is an alias of destination.
 	Platform string `json:"platform"`
-	// the source stream in oryx.
+	// The protocol of destination, for example, rtmp.
+	Protocol string `json:"protocol"`
+	// The tags to group destinations.
+	Tags []string `json:"tags,omitempty"`
+	// The source stream in oryx, the stream name or app/stream, empty to select the latest active stream.
 	Stream string `json:"stream"`
 	// The RTMP server url, for example, rtmp://localhost/live
 	Server string `json:"server"`
@@ -347,22 +413,46 @@ type ForwardConfigure struct {
 	Customed bool `json:"custom"`
 	// The label for this configure.
 	Label string `json:"label"`
+	// The protocol options of output, for example, the passphrase of SRT.
+	Output *EgressOutput `json:"output,omitempty"`
+	// The windows in which the task runs, always run if empty.
+	Schedules []*TaskSchedule `json:"schedules,omitempty"`
+	// The destinations to forward by one FFmpeg process with tee muxer, so the stream is only pulled
+	// once for multiple destinations. The server and secret are ignored if there are legs.
+	Legs []*ForwardLegConfigure `json:"legs,omitempty"`
 }
 
 func (v *ForwardConfigure) String() string {
-	return fmt.Sprintf("platform=%v, stream=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v",
-		v.Platform, v.Stream, v.Server, v.Secret, v.Enabled, v.Customed, v.Label,
+	return fmt.Sprintf("id=%v, platform=%v, protocol=%v, tags=%v, stream=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v, output=%v, legs=%v",
+		v.ID, v.Platform, v.Protocol, v.Tags, v.Stream, v.Server, v.Secret, v.Enabled, v.Customed, v.Label, v.Output.String(), len(v.Legs),
 	)
 }
 
+// Update the configure, note that the id is never changed, and the tags, output and legs are only updated
+// if specified, because the legacy API does not know them.
 func (v *ForwardConfigure) Update(u *ForwardConfigure) error {
-	v.Platform = u.Platform
+	if u.Platform != "" {
+		v.Platform = u.Platform
+	}
+	v.Protocol = u.Protocol
 	v.Stream = u.Stream
 	v.Server = u.Server
 	v.Secret = u.Secret
 	v.Label = u.Label
 	v.Enabled = u.Enabled
 	v.Customed = u.Customed
+	if u.Output != nil {
+		v.Output = u.Output
+	}
+	if u.Schedules != nil {
+		v.Schedules = u.Schedules
+	}
+	if u.Tags != nil {
+		v.Tags = u.Tags
+	}
+	if u.Legs != nil {
+		v.Legs = u.Legs
+	}
 	return nil
 }
 
@@ -370,7 +460,9 @@ func (v *ForwardConfigure) Update(u *ForwardConfigure) error {
 type ForwardTask struct {
 	// The ID for task.
 	UUID string `json:"uuid"`
-	// The platform for task.
+	// The id of destination.
+	Destination string `json:"destination"`
+	// The legacy platform for task.
 	Platform string `json:"platform"`
 
 	// The input url.
@@ -382,6 +474,12 @@ type ForwardTask struct {
 
 	// FFmpeg pid.
 	PID int32 `json:"pid"`
+	// The pids of standalone FFmpeg process for failed legs.
+	LegPIDs []int32 `json:"legPids,omitempty"`
+	// The legs of tee muxer, for multiple destinations.
+	legs []*ForwardLeg
+	// The health of destination.
+	health *ForwardHealth
 	// FFmpeg last frame.
 	frame string
 	// The last update time.
@@ -390,9 +488,13 @@ type ForwardTask struct {
 	starttime *time.Time
 	// The first ready time.
 	firstReadyTime *time.Time
+	// The state of schedules, updated by crontab.
+	schedule *ScheduleState
 
 	// The context for current task.
 	cancel context.CancelFunc
+	// To stop the task, when destination is removed.
+	stop context.CancelFunc
 
 	// The configure for forwarding task.
 	config *ForwardConfigure
@@ -404,8 +506,8 @@ type ForwardTask struct {
 }
 
 func (v *ForwardTask) String() string {
-	return fmt.Sprintf("uuid=%v, platform=%v, input=%v, output=%v, pid=%v, frame=%vB, config is %v",
-		v.UUID, v.Platform, v.Input, v.Output, v.PID, len(v.frame), v.config.String(),
+	return fmt.Sprintf("uuid=%v, destination=%v, platform=%v, input=%v, output=%v, pid=%v, frame=%vB, config is %v",
+		v.UUID, v.Destination, v.Platform, v.Input, v.Output, v.PID, len(v.frame), v.config.String(),
 	)
 }
 
@@ -426,6 +528,12 @@ func (v *ForwardTask) cleanup(ctx context.Context) error {
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
+	for _, pid := range v.LegPIDs {
+		logger.Wf(ctx, "kill task leg pid=%v", pid)
+		syscall.Kill(int(pid), syscall.SIGKILL)
+	}
+	v.LegPIDs = nil
+
 	if v.PID <= 0 {
 		return nil
 	}
@@ -448,8 +556,8 @@ func (v *ForwardTask) Restart(ctx context.Context) error {
 	}
 
 	// Reload config from redis.
-	if b, err := rdb.HGet(ctx, SRS_FORWARD_CONFIG, v.Platform).Result(); err != nil {
-		return errors.Wrapf(err, "hget %v %v", SRS_FORWARD_CONFIG, v.Platform)
+	if b, err := rdb.HGet(ctx, SRS_FORWARD_CONFIG, v.Destination).Result(); err != nil {
+		return errors.Wrapf(err, "hget %v %v", SRS_FORWARD_CONFIG, v.Destination)
 	} else if err = json.Unmarshal([]byte(b), v.config); err != nil {
 		return errors.Wrapf(err, "unmarshal %v", b)
 	}
@@ -457,6 +565,22 @@ func (v *ForwardTask) Restart(ctx context.Context) error {
 	return nil
 }
 
+// Stop the task and the FFmpeg process, and remove the task from redis.
+func (v *ForwardTask) Stop(ctx context.Context) {
+	v.lock.Lock()
+	if v.stop != nil {
+		v.stop()
+	}
+	if v.cancel != nil {
+		v.cancel()
+	}
+	v.lock.Unlock()
+
+	if err := rdb.HDel(ctx, SRS_FORWARD_TASK, v.UUID).Err(); err != nil && err != redis.Nil {
+		logger.Wf(ctx, "hdel %v %v err %+v", SRS_FORWARD_TASK, v.UUID, err)
+	}
+}
+
 func (v *ForwardTask) updateFrame(frame string) {
 	v.lock.Lock()
 	defer v.lock.Unlock()
@@ -489,8 +613,31 @@ func (v *ForwardTask) queryFrame() (int32, string, string, string, string, strin
 	return v.PID, v.inputStreamURL, v.frame, update, starttime, ready
 }
 
+// onSchedule update the state of schedules, and stop FFmpeg when the window is over.
+func (v *ForwardTask) onSchedule(ctx context.Context, now time.Time) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.schedule == nil {
+		return
+	}
+
+	from, to := v.schedule.Update(v.config.Schedules, now)
+	if from == to {
+		return
+	}
+
+	logger.Tf(ctx, "forward schedule changed, platform=%v, active=%v, state is %v", v.Platform, to, v.schedule.Query().String())
+	if !to && v.cancel != nil {
+		v.cancel()
+	}
+}
+
 func (v *ForwardTask) Initialize(ctx context.Context, w *ForwardWorker) error {
 	v.forwardWorker = w
+	v.schedule = NewScheduleState()
+	v.schedule.Update(v.config.Schedules, time.Now())
+	v.health = NewForwardHealth()
 	logger.Tf(ctx, "forward initialize uuid=%v, platform=%v", v.UUID, v.Platform)
 
 	if err := v.saveTask(ctx); err != nil {
@@ -520,7 +667,7 @@ func (v *ForwardTask) Run(ctx context.Context) error {
 				return nil, errors.Wrapf(err, "unmarshal %v", v)
 			}
 			if streamName != "" {
-				if stream.Stream == streamName {
+				if stream.Stream == streamName || fmt.Sprintf("%v/%v", stream.App, stream.Stream) == streamName {
 					best = &stream
 					break
 				}
@@ -557,8 +704,8 @@ func (v *ForwardTask) Run(ctx context.Context) error {
 	}
 
 	pfn := func(ctx context.Context) error {
-		// Ignore when not enabled.
-		if !v.config.Enabled {
+		// Ignore when not enabled, or not in the scheduled window.
+		if !v.config.Enabled || !v.schedule.IsActive() {
 			return nil
 		}
 
@@ -582,11 +729,13 @@ func (v *ForwardTask) Run(ctx context.Context) error {
 
 	for ctx.Err() == nil {
 		if err := pfn(ctx); err != nil {
-			logger.Wf(ctx, "ignore %v err %+v", v.String(), err)
+			// Backoff if the destination keeps failing, to avoid flooding the destination.
+			backoff := v.health.Backoff()
+			logger.Wf(ctx, "ignore %v err %+v, retry after %v", v.String(), err, backoff)
 
 			select {
 			case <-ctx.Done():
-			case <-time.After(3500 * time.Millisecond):
+			case <-time.After(backoff):
 			}
 			continue
 		}
@@ -610,12 +759,26 @@ func (v *ForwardTask) doForward(ctx context.Context, input *SrsStream) error {
 	host := "localhost"
 	inputURL := fmt.Sprintf("rtmp://%v/%v/%v", host, input.App, input.Stream)
 
-	// Build output URL.
-	outputServer := strings.ReplaceAll(v.config.Server, "localhost", host)
-	if !strings.HasSuffix(outputServer, "/") && !strings.HasPrefix(v.config.Secret, "/") && v.config.Secret != "" {
-		outputServer += "/"
+	// Build output URL, or the output of tee muxer for multiple destinations.
+	outputURL, outputArgs := buildEgressOutput(
+		strings.ReplaceAll(v.config.Server, "localhost", host), v.config.Secret, v.config.Output,
+	)
+	legs := v.buildLegs()
+	if len(v.config.Legs) > 0 {
+		if len(legs) == 0 {
+			return nil
+		}
+
+		output, err := forwardTeeOutput(legs)
+		if err != nil {
+			return errors.Wrapf(err, "build tee")
+		}
+		outputURL = output
 	}
-	outputURL := fmt.Sprintf("%v%v", outputServer, v.config.Secret)
+
+	v.lock.Lock()
+	v.legs = legs
+	v.lock.Unlock()
 
 	// Create a heartbeat to poll and manage the status of FFmpeg process.
 	heartbeat := NewFFmpegHeartbeat(cancel)
@@ -632,24 +795,38 @@ func (v *ForwardTask) doForward(ctx context.Context, input *SrsStream) error {
 		args = append(args, "-rtsp_transport", "tcp")
 	}
 	// Rebuild the stream url, because it may contain special characters.
+	ffmpegInput := inputURL
 	if strings.Contains(inputURL, "://") {
 		if u, err := RebuildStreamURL(inputURL); err != nil {
 			return errors.Wrapf(err, "rebuild %v", inputURL)
 		} else {
-			args = append(args, "-i", u.String())
+			ffmpegInput = u.String()
 			heartbeat.Parse(u)
 		}
-	} else {
-		args = append(args, "-i", inputURL)
 	}
+	args = append(args, "-i", ffmpegInput)
 	args = append(args, "-c", "copy")
-	// If RTMP use flv, if SRT use mpegts, otherwise do not set.
-	if strings.HasPrefix(outputURL, "rtmp://") || strings.HasPrefix(outputURL, "rtmps://") {
-		args = append(args, "-f", "flv")
-	} else if strings.HasPrefix(outputURL, "srt://") {
-		args = append(args, "-pes_payload_size", "0", "-f", "mpegts")
+	if len(legs) > 0 && outputURL == "" {
+		// All legs are forwarded by standalone process, so the process only pulls the stream, to manage the
+		// lifecycle of legs.
+		args = append(args, "-map", "0", "-f", "null")
+		outputURL = "-"
+	} else if len(legs) > 0 {
+		args = append(args, "-map", "0", "-f", "tee")
+
+		// Restart the failed leg by standalone process, without interrupting other legs.
+		heartbeat.OnLog = func(line string) {
+			v.onTeeLog(ctx, ffmpegInput, line)
+		}
+	} else {
+		args = append(args, outputArgs...)
 	}
-	args = append(args, outputURL)
+	// Sign the output by publish token, because the legacy secret may be disabled.
+	publishURL, err := platformPublishURL(ctx, outputURL)
+	if err != nil {
+		return errors.Wrapf(err, "sign %v", outputURL)
+	}
+	args = append(args, publishURL)
 	// Create the command object.
 	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 
@@ -683,6 +860,13 @@ func (v *ForwardTask) doForward(ctx context.Context, input *SrsStream) error {
 		return errors.Wrapf(err, "save task %v", v.String())
 	}
 
+	// Start the legs which are not able to forward by tee muxer, for example, WHIP.
+	for _, leg := range legs {
+		if leg.slave < 0 {
+			go v.runLeg(ctx, leg, ffmpegInput)
+		}
+	}
+
 	// Pull the latest log frame.
 	heartbeat.Polling(ctx, stderr)
 	go func() {
@@ -691,6 +875,7 @@ func (v *ForwardTask) doForward(ctx context.Context, input *SrsStream) error {
 			return
 		case <-heartbeat.firstReadyCtx.Done():
 			v.firstReadyTime = &heartbeat.firstReadyTime
+			v.onTeeReady()
 		}
 
 		for {
@@ -717,5 +902,32 @@ func (v *ForwardTask) doForward(ctx context.Context, input *SrsStream) error {
 		v.Platform, input.StreamURL(), v.PID, err,
 	)
 
+	// Update the health of destination, by the reason of exit. Note that the process is never ready if
+	// the first ready context is not cancelled.
+	reason, line := classifyForwardFailure(ffmpegInput, heartbeat.extraLogs)
+	v.onExit(parentCtx, heartbeat.firstReadyCtx.Err() != nil, reason, line, err)
+
 	return err
 }
+
+// onExit update the health when FFmpeg exits, and emit event if the health changed.
+func (v *ForwardTask) onExit(ctx context.Context, ready bool, reason, line string, err error) {
+	from, to := v.health.OnExit(ready, reason, line, err)
+	if from == to {
+		return
+	}
+
+	health := v.health.Query()
+	logger.Wf(ctx, "forward destination=%v, platform=%v, health %v to %v, failures=%v, reason=%v, log=%v",
+		v.Destination, v.Platform, from, to, health.Failures, health.Reason, health.Error)
+
+	message := map[string]interface{}{
+		"destination": v.Destination, "platform": v.Platform, "label": v.config.Label,
+		"from": from, "to": to, "failures": health.Failures, "reason": health.Reason, "error": health.Error,
+	}
+	go func() {
+		if err := callbackWorker.OnSystemMessage(ctx, SrsActionOnForwardHealth, message); err != nil {
+			logger.Wf(ctx, "forward health event destination=%v err %+v", v.Destination, err)
+		}
+	}()
+}
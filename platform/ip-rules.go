@@ -0,0 +1,501 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+type IPRuleAction string
+
+const (
+	IPRuleActionAllow IPRuleAction = "allow"
+	IPRuleActionDeny  IPRuleAction = "deny"
+)
+
+type IPRuleScope string
+
+const (
+	IPRuleScopePublish IPRuleScope = "publish"
+	IPRuleScopePlay    IPRuleScope = "play"
+)
+
+// IPRule is a CIDR rule to allow or deny the client to publish or play streams. The rule applies to all
+// streams if no app, or all streams of app if no stream.
+type IPRule struct {
+	// The rule id.
+	ID string `json:"id"`
+	// The action, allow or deny.
+	Action IPRuleAction `json:"action"`
+	// The scope, publish or play.
+	Scope IPRuleScope `json:"scope"`
+	// The CIDR list, a single IP is also allowed, for example, 10.0.0.0/8 or 192.168.1.10.
+	CIDRs []string `json:"cidrs"`
+	// The app and stream to apply the rule to.
+	App    string `json:"app,omitempty"`
+	Stream string `json:"stream,omitempty"`
+	// The comment of rule.
+	Comment string `json:"comment,omitempty"`
+	// The update time of rule.
+	Update string `json:"update"`
+
+	// The parsed networks of CIDRs.
+	networks []*net.IPNet
+}
+
+func (v *IPRule) String() string {
+	return fmt.Sprintf("id=%v, action=%v, scope=%v, cidrs=%v, app=%v, stream=%v, update=%v",
+		v.ID, v.Action, v.Scope, strings.Join(v.CIDRs, ","), v.App, v.Stream, v.Update)
+}
+
+// Initialize check the rule and parse the CIDRs.
+func (v *IPRule) Initialize() error {
+	if v.Action != IPRuleActionAllow && v.Action != IPRuleActionDeny {
+		return errors.Errorf("invalid action %v", v.Action)
+	}
+	if v.Scope != IPRuleScopePublish && v.Scope != IPRuleScopePlay {
+		return errors.Errorf("invalid scope %v", v.Scope)
+	}
+	if v.App == "" && v.Stream != "" {
+		return errors.Errorf("no app for stream %v", v.Stream)
+	}
+	if len(v.CIDRs) == 0 {
+		return errors.New("no cidrs")
+	}
+
+	v.networks = nil
+	for _, cidr := range v.CIDRs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip == nil {
+				return errors.Errorf("invalid ip %v", cidr)
+			} else if ip.To4() != nil {
+				cidr = fmt.Sprintf("%v/32", cidr)
+			} else {
+				cidr = fmt.Sprintf("%v/128", cidr)
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return errors.Wrapf(err, "parse cidr %v", cidr)
+		}
+		v.networks = append(v.networks, network)
+	}
+	return nil
+}
+
+// Applies whether the rule applies to the stream.
+func (v *IPRule) Applies(scope IPRuleScope, app, stream string) bool {
+	if v.Scope != scope {
+		return false
+	}
+	if v.App != "" && v.App != app {
+		return false
+	}
+	if v.Stream != "" && v.Stream != stream {
+		return false
+	}
+	return true
+}
+
+// Contains whether the ip is in the CIDRs of rule.
+func (v *IPRule) Contains(ip net.IP) bool {
+	for _, network := range v.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIPRules find the rule which decides whether the client is allowed. A client is rejected if matches
+// any deny rule, or there are allow rules for the stream but the client matches none of them. The hit rule
+// is nil if no rule matches the client.
+func matchIPRules(rules []*IPRule, scope IPRuleScope, app, stream, clientIP string) (hit *IPRule, allowed bool) {
+	ip := net.ParseIP(clientIP)
+
+	var hasAllow bool
+	for _, rule := range rules {
+		if !rule.Applies(scope, app, stream) {
+			continue
+		}
+
+		if rule.Action == IPRuleActionDeny {
+			if ip != nil && rule.Contains(ip) {
+				return rule, false
+			}
+			continue
+		}
+
+		hasAllow = true
+		if hit == nil && ip != nil && rule.Contains(ip) {
+			hit = rule
+		}
+	}
+
+	return hit, hit != nil || !hasAllow
+}
+
+// loadIPRules load all the rules from redis, ignore the invalid ones.
+func loadIPRules(ctx context.Context) ([]*IPRule, error) {
+	configs, err := rdb.HGetAll(ctx, SRS_IP_RULES).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_IP_RULES)
+	}
+
+	var rules []*IPRule
+	for id, config := range configs {
+		var rule IPRule
+		if err := json.Unmarshal([]byte(config), &rule); err != nil {
+			logger.Wf(ctx, "ignore ip rule %v, err %+v", id, err)
+			continue
+		}
+		if err := rule.Initialize(); err != nil {
+			logger.Wf(ctx, "ignore ip rule %v, err %+v", id, err)
+			continue
+		}
+		rules = append(rules, &rule)
+	}
+	return rules, nil
+}
+
+// verifyIPRules verify whether the client is allowed to publish or play the stream, by the cached rules.
+func verifyIPRules(ctx context.Context, scope IPRuleScope, app, stream, clientIP string) error {
+	hit, allowed := matchIPRules(fastCache.ipRules(), scope, app, stream, clientIP)
+	if hit != nil {
+		ipRuleHits.Hit(time.Now(), hit.ID, scope, app, stream, clientIP)
+	}
+
+	if !allowed {
+		if hit != nil {
+			return errors.Errorf("%v %v/%v denied by rule %v, ip=%v", scope, app, stream, hit.ID, clientIP)
+		}
+		return errors.Errorf("%v %v/%v not allowed, ip=%v", scope, app, stream, clientIP)
+	}
+	return nil
+}
+
+// isPlatformStreamClient whether the client of SRS hooks is the platform, which is always allowed. The FFmpeg of
+// platform publish or play streams by RTMP from localhost. The WebRTC client is proxied by platform, so SRS reports
+// the loopback IP, and it's verified by the proxy with the real client IP, see verifyRtcIPRules.
+func isPlatformStreamClient(streamObj *SrsStream) bool {
+	if ip := net.ParseIP(streamObj.IP); ip == nil || !ip.IsLoopback() {
+		return false
+	}
+	return strings.HasPrefix(streamObj.TcUrl, "rtmp://") || strings.HasPrefix(streamObj.TcUrl, "webrtc://")
+}
+
+// verifyStreamIPRules verify the client of SRS hooks by rules, except the platform.
+func verifyStreamIPRules(ctx context.Context, scope IPRuleScope, streamObj *SrsStream) error {
+	if isPlatformStreamClient(streamObj) {
+		return nil
+	}
+	return verifyIPRules(ctx, scope, streamObj.App, streamObj.Stream, streamObj.IP)
+}
+
+// rtcIPRuleScope get the scope of WebRTC API, empty if not publish or play.
+func rtcIPRuleScope(endpoint string) IPRuleScope {
+	for _, prefix := range []string{"/rtc/v1/whip/", "/rtc/v1/publish/"} {
+		if strings.HasPrefix(endpoint, prefix) {
+			return IPRuleScopePublish
+		}
+	}
+	for _, prefix := range []string{"/rtc/v1/whep/", "/rtc/v1/whip-play/", "/rtc/v1/play/"} {
+		if strings.HasPrefix(endpoint, prefix) {
+			return IPRuleScopePlay
+		}
+	}
+	return ""
+}
+
+// verifyRtcIPRules verify the WebRTC client proxied by platform, by the real client IP.
+func verifyRtcIPRules(ctx context.Context, r *http.Request) error {
+	scope := rtcIPRuleScope(r.URL.Path)
+	if scope == "" {
+		return nil
+	}
+
+	app, stream, _, err := parseRtcStream(r)
+	if err != nil {
+		return errors.Wrapf(err, "parse stream")
+	}
+	return verifyIPRules(ctx, scope, app, stream, httpClientIP(r))
+}
+
+// The duration of a session, the requests of a client for the same stream in the duration are counted as one
+// hit, because the HLS player requests the m3u8 and ts files repeatedly.
+const ipRuleSessionTimeout = 60 * time.Second
+
+var ipRuleHits = NewIPRuleHits()
+
+// IPRuleHits count the hits of rules in memory, and flushed to redis by crontab, to avoid writing redis for
+// each HTTP request.
+type IPRuleHits struct {
+	// The last active time of sessions, keyed by rule, scope, stream and client IP.
+	sessions map[string]time.Time
+	// The hits of rules not flushed yet, keyed by rule id.
+	pending map[string]int64
+
+	// To protect the fields.
+	lock sync.Mutex
+}
+
+func NewIPRuleHits() *IPRuleHits {
+	return &IPRuleHits{sessions: make(map[string]time.Time), pending: make(map[string]int64)}
+}
+
+// Hit count the client for rule, return whether it's a new session.
+func (v *IPRuleHits) Hit(now time.Time, id string, scope IPRuleScope, app, stream, clientIP string) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	key := fmt.Sprintf("%v/%v/%v/%v/%v", id, scope, app, stream, clientIP)
+	last, ok := v.sessions[key]
+	v.sessions[key] = now
+	if ok && now.Sub(last) < ipRuleSessionTimeout {
+		return false
+	}
+
+	v.pending[id]++
+	return true
+}
+
+// Remove the pending hits and sessions of rule, or all rules if id is empty.
+func (v *IPRuleHits) Remove(id string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if id == "" {
+		v.sessions, v.pending = make(map[string]time.Time), make(map[string]int64)
+		return
+	}
+
+	delete(v.pending, id)
+	for key := range v.sessions {
+		if strings.HasPrefix(key, id+"/") {
+			delete(v.sessions, key)
+		}
+	}
+}
+
+// Flush the pending hits to redis, and expire the sessions.
+func (v *IPRuleHits) Flush(ctx context.Context, now time.Time) error {
+	v.lock.Lock()
+	pending := v.pending
+	v.pending = make(map[string]int64)
+	for key, last := range v.sessions {
+		if now.Sub(last) >= ipRuleSessionTimeout {
+			delete(v.sessions, key)
+		}
+	}
+	v.lock.Unlock()
+
+	for id, hits := range pending {
+		if err := rdb.HIncrBy(ctx, SRS_IP_RULES_HITS, id, hits).Err(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hincrby %v %v %v", SRS_IP_RULES_HITS, id, hits)
+		}
+	}
+	return nil
+}
+
+func handleIPRulesService(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/hooks/srs/ip/rules/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			configs, err := rdb.HGetAll(ctx, SRS_IP_RULES).Result()
+			if err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hgetall %v", SRS_IP_RULES)
+			}
+
+			hits, err := rdb.HGetAll(ctx, SRS_IP_RULES_HITS).Result()
+			if err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hgetall %v", SRS_IP_RULES_HITS)
+			}
+
+			type IPRuleWithHits struct {
+				IPRule
+				// The number of clients matched the rule.
+				Hits string `json:"hits"`
+			}
+
+			rules := []*IPRuleWithHits{}
+			for id, config := range configs {
+				var rule IPRuleWithHits
+				if err := json.Unmarshal([]byte(config), &rule.IPRule); err != nil {
+					return errors.Wrapf(err, "unmarshal %v %v", id, config)
+				}
+
+				rule.Hits = hits[id]
+				if rule.Hits == "" {
+					rule.Hits = "0"
+				}
+				rules = append(rules, &rule)
+			}
+
+			ohttp.WriteData(ctx, w, r, rules)
+			logger.Tf(ctx, "ip rules query ok, rules=%v, token=%vB", len(rules), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/srs/ip/rules/update"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var rule IPRule
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				*IPRule
+			}{
+				Token: &token, IPRule: &rule,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if err := rule.Initialize(); err != nil {
+				return errors.Wrapf(err, "init rule")
+			}
+
+			// Create a new rule if no id, or overwrite the rule.
+			if rule.ID == "" {
+				rule.ID = uuid.NewString()
+			} else if exists, err := rdb.HExists(ctx, SRS_IP_RULES, rule.ID).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hexists %v %v", SRS_IP_RULES, rule.ID)
+			} else if !exists {
+				return errors.Errorf("rule %v not exists", rule.ID)
+			}
+			rule.Update = time.Now().Format(time.RFC3339)
+
+			if b, err := json.Marshal(&rule); err != nil {
+				return errors.Wrapf(err, "marshal %v", rule.String())
+			} else if err := rdb.HSet(ctx, SRS_IP_RULES, rule.ID, string(b)).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v %v %v", SRS_IP_RULES, rule.ID, string(b))
+			}
+
+			if err := fastCache.Refresh(ctx); err != nil {
+				return errors.Wrapf(err, "refresh fast cache")
+			}
+
+			ohttp.WriteData(ctx, w, r, &rule)
+			logger.Tf(ctx, "ip rules update ok, %v, token=%vB", rule.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/srs/ip/rules/remove"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, id string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				ID    *string `json:"id"`
+			}{
+				Token: &token, ID: &id,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if id == "" {
+				return errors.New("no id")
+			}
+
+			if err := rdb.HDel(ctx, SRS_IP_RULES, id).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hdel %v %v", SRS_IP_RULES, id)
+			}
+			ipRuleHits.Remove(id)
+			if err := rdb.HDel(ctx, SRS_IP_RULES_HITS, id).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hdel %v %v", SRS_IP_RULES_HITS, id)
+			}
+
+			if err := fastCache.Refresh(ctx); err != nil {
+				return errors.Wrapf(err, "refresh fast cache")
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "ip rules remove ok, id=%v, token=%vB", id, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/srs/ip/rules/reset"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			ipRuleHits.Remove("")
+			if err := rdb.Del(ctx, SRS_IP_RULES_HITS).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "del %v", SRS_IP_RULES_HITS)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "ip rules reset hits ok, token=%vB", len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
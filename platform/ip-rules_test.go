@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIPRules_Match(t *testing.T) {
+	var rules []*IPRule
+	for _, rule := range []*IPRule{
+		{ID: "encoders", Action: IPRuleActionAllow, Scope: IPRuleScopePublish, CIDRs: []string{"10.0.0.0/8", "192.168.1.10"}},
+		{ID: "bad", Action: IPRuleActionDeny, Scope: IPRuleScopePublish, App: "live", CIDRs: []string{"10.1.0.0/16"}},
+		{ID: "abuser", Action: IPRuleActionDeny, Scope: IPRuleScopePlay, App: "live", Stream: "livestream", CIDRs: []string{"1.2.3.4"}},
+	} {
+		if err := rule.Initialize(); err != nil {
+			t.Errorf("Fail for err %+v", err)
+			return
+		}
+		rules = append(rules, rule)
+	}
+
+	for _, e := range []struct {
+		scope   IPRuleScope
+		app     string
+		stream  string
+		ip      string
+		hit     string
+		allowed bool
+	}{
+		{scope: IPRuleScopePublish, app: "live", stream: "livestream", ip: "10.0.0.1", hit: "encoders", allowed: true},
+		{scope: IPRuleScopePublish, app: "live", stream: "livestream", ip: "192.168.1.10", hit: "encoders", allowed: true},
+		{scope: IPRuleScopePublish, app: "live", stream: "livestream", ip: "192.168.1.11", hit: "", allowed: false},
+		{scope: IPRuleScopePublish, app: "live", stream: "livestream", ip: "10.1.2.3", hit: "bad", allowed: false},
+		{scope: IPRuleScopePublish, app: "other", stream: "livestream", ip: "10.1.2.3", hit: "encoders", allowed: true},
+		{scope: IPRuleScopePlay, app: "live", stream: "livestream", ip: "1.2.3.4", hit: "abuser", allowed: false},
+		{scope: IPRuleScopePlay, app: "live", stream: "other", ip: "1.2.3.4", hit: "", allowed: true},
+		{scope: IPRuleScopePlay, app: "live", stream: "livestream", ip: "5.6.7.8", hit: "", allowed: true},
+	} {
+		hit, allowed := matchIPRules(rules, e.scope, e.app, e.stream, e.ip)
+		var id string
+		if hit != nil {
+			id = hit.ID
+		}
+		if id != e.hit || allowed != e.allowed {
+			t.Errorf("Fail for %v, hit=%v, allowed=%v", e, id, allowed)
+		}
+	}
+
+	for _, rule := range []*IPRule{
+		{Action: "reject", Scope: IPRuleScopePlay, CIDRs: []string{"1.2.3.4"}},
+		{Action: IPRuleActionDeny, Scope: IPRuleScopePlay, CIDRs: []string{"1.2.3.4/33"}},
+		{Action: IPRuleActionDeny, Scope: IPRuleScopePlay, Stream: "livestream", CIDRs: []string{"1.2.3.4"}},
+		{Action: IPRuleActionDeny, Scope: IPRuleScopePlay},
+	} {
+		if err := rule.Initialize(); err == nil {
+			t.Errorf("Fail for invalid rule %v", rule.String())
+		}
+	}
+}
+
+func TestIPRules_Hits(t *testing.T) {
+	hits := NewIPRuleHits()
+	now := time.Now()
+
+	// The requests of the same session are counted once.
+	for _, e := range []struct {
+		offset time.Duration
+		ip     string
+		stream string
+		expect bool
+	}{
+		{offset: 0, ip: "10.0.0.1", stream: "livestream", expect: true},
+		{offset: 2 * time.Second, ip: "10.0.0.1", stream: "livestream", expect: false},
+		{offset: 4 * time.Second, ip: "10.0.0.2", stream: "livestream", expect: true},
+		{offset: 6 * time.Second, ip: "10.0.0.1", stream: "other", expect: true},
+		{offset: 50 * time.Second, ip: "10.0.0.1", stream: "livestream", expect: false},
+		{offset: 100 * time.Second, ip: "10.0.0.1", stream: "livestream", expect: false},
+		{offset: 200 * time.Second, ip: "10.0.0.1", stream: "livestream", expect: true},
+	} {
+		if ok := hits.Hit(now.Add(e.offset), "r1", IPRuleScopePlay, "live", e.stream, e.ip); ok != e.expect {
+			t.Errorf("Fail for %v, expect %v", e, e.expect)
+		}
+	}
+	if hits.pending["r1"] != 4 {
+		t.Errorf("Fail for pending %v", hits.pending["r1"])
+	}
+
+	hits.Remove("r1")
+	if len(hits.pending) != 0 || len(hits.sessions) != 0 {
+		t.Errorf("Fail for pending %v, sessions %v", len(hits.pending), len(hits.sessions))
+	}
+}
+
+func TestIPRules_PlatformClient(t *testing.T) {
+	for _, e := range []struct {
+		ip       string
+		tcUrl    string
+		platform bool
+	}{
+		{ip: "127.0.0.1", tcUrl: "rtmp://localhost/live", platform: true},
+		{ip: "::1", tcUrl: "webrtc://localhost/live", platform: true},
+		{ip: "127.0.0.1", tcUrl: "srt://localhost/live", platform: false},
+		{ip: "10.0.0.1", tcUrl: "rtmp://localhost/live", platform: false},
+	} {
+		if v := isPlatformStreamClient(&SrsStream{IP: e.ip, TcUrl: e.tcUrl}); v != e.platform {
+			t.Errorf("Fail for %v %v, expect %v", e.ip, e.tcUrl, e.platform)
+		}
+	}
+}
+
+func TestIPRules_RtcStream(t *testing.T) {
+	for _, e := range []struct {
+		method string
+		target string
+		body   string
+		scope  IPRuleScope
+		stream string
+	}{
+		{method: "POST", target: "/rtc/v1/whip/?app=live&stream=livestream", scope: IPRuleScopePublish, stream: "live/livestream"},
+		{method: "POST", target: "/rtc/v1/whep/?app=live&stream=livestream", scope: IPRuleScopePlay, stream: "live/livestream"},
+		{method: "POST", target: "/rtc/v1/publish/", body: `{"streamurl":"webrtc://localhost/live/livestream?secret=xxx"}`, scope: IPRuleScopePublish, stream: "live/livestream"},
+		{method: "POST", target: "/rtc/v1/play/", body: `{"streamurl":"webrtc://localhost/live/livestream"}`, scope: IPRuleScopePlay, stream: "live/livestream"},
+		{method: "GET", target: "/rtc/v1/nack/", scope: ""},
+	} {
+		r := httptest.NewRequest(e.method, e.target, strings.NewReader(e.body))
+		if scope := rtcIPRuleScope(r.URL.Path); scope != e.scope {
+			t.Errorf("Fail for %v, expect %v, got %v", e.target, e.scope, scope)
+		}
+		if e.scope == "" {
+			continue
+		}
+
+		if app, stream, _, err := parseRtcStream(r); err != nil {
+			t.Errorf("Fail for %v, err %+v", e.target, err)
+		} else if app+"/"+stream != e.stream {
+			t.Errorf("Fail for %v, expect %v, got %v/%v", e.target, e.stream, app, stream)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The failed login attempts are counted in the window, and reset when login ok.
+const loginFailureWindow = 15 * time.Minute
+
+// The client is locked out when the failures reach the threshold, and the lockout is doubled for each
+// failure after that, until the max lockout.
+const loginLockoutThreshold = 5
+const loginLockoutBase = 30 * time.Second
+const loginLockoutMax = time.Hour
+
+// The login is slowed down by delay when the failures of all clients reach the global threshold, which might
+// be a distributed attack from lots of IPs. All clients are locked out only when MGMT_LOGIN_GLOBAL_LOCKOUT is
+// on, and the lockout is shorter than the client lockout, to avoid locking the admin out for a long time. Note
+// that the allowlisted clients by MGMT_LOGIN_ALLOWLIST are never delayed or locked out by the global failures.
+const loginGlobalLockoutThreshold = 100
+const loginGlobalLockoutMax = 5 * time.Minute
+
+// The delay to response when login failed, to slow down the brute-force attack.
+const loginFailureDelay = 10 * time.Second
+
+// The client should pass the challenge when its failures reach the threshold, or the failures of all
+// clients reach the global threshold, which might be a distributed attack. Note that the challenge is
+// only required when the challenge hook is configured by MGMT_LOGIN_CHALLENGE.
+const loginChallengeThreshold = 3
+const loginGlobalChallengeThreshold = 50
+
+// loginLockoutDuration get the lockout duration for the number of failures, zero for no lockout.
+func loginLockoutDuration(failures int64) time.Duration {
+	return loginBackoff(failures, loginLockoutThreshold, loginLockoutMax)
+}
+
+// loginGlobalLockoutDuration get the lockout duration of all clients for the number of global failures, zero
+// for no lockout.
+func loginGlobalLockoutDuration(failures int64) time.Duration {
+	return loginBackoff(failures, loginGlobalLockoutThreshold, loginGlobalLockoutMax)
+}
+
+// loginBackoff start from the base duration when failures reach the threshold, and double for each failure
+// after that, until the max duration.
+func loginBackoff(failures, threshold int64, max time.Duration) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+
+	lockout := loginLockoutBase
+	for i := threshold; i < failures && lockout < max; i++ {
+		lockout *= 2
+	}
+	if lockout > max {
+		lockout = max
+	}
+	return lockout
+}
+
+// LoginGuard protects the login endpoint from brute-force attack.
+type LoginGuard struct {
+	// The client IP.
+	ip string
+}
+
+func NewLoginGuard(ip string) *LoginGuard {
+	return &LoginGuard{ip: ip}
+}
+
+func (v *LoginGuard) failuresKey() string {
+	return fmt.Sprintf("%v:ip:%v", SRS_LOGIN_FAILURES, v.ip)
+}
+
+func (v *LoginGuard) globalFailuresKey() string {
+	return fmt.Sprintf("%v:global", SRS_LOGIN_FAILURES)
+}
+
+func (v *LoginGuard) lockoutKey() string {
+	return fmt.Sprintf("%v:%v", SRS_LOGIN_LOCKOUT, v.ip)
+}
+
+func (v *LoginGuard) globalLockoutKey() string {
+	return fmt.Sprintf("%v:global", SRS_LOGIN_LOCKOUT)
+}
+
+// allowlisted whether the client is in MGMT_LOGIN_ALLOWLIST, which is not affected by the global failures.
+func (v *LoginGuard) allowlisted() bool {
+	return isTrustedProxy(parseTrustedProxies(envMgmtLoginAllowlist()), v.ip)
+}
+
+// Check whether the client is allowed to login, whether the challenge is required, and whether to delay
+// the login because of too many failures of all clients.
+func (v *LoginGuard) Check(ctx context.Context) (challenge, delay bool, err error) {
+	if ttl, err := rdb.TTL(ctx, v.lockoutKey()).Result(); err != nil && err != redis.Nil {
+		return false, false, errors.Wrapf(err, "ttl %v", v.lockoutKey())
+	} else if ttl > 0 {
+		return false, false, errors.Errorf("too many failures, locked, retry after %v", ttl.Round(time.Second))
+	}
+
+	failures, err := rdb.Get(ctx, v.failuresKey()).Int64()
+	if err != nil && err != redis.Nil {
+		return false, false, errors.Wrapf(err, "get %v", v.failuresKey())
+	}
+
+	// The allowlisted client is only limited by its own failures.
+	var globalFailures int64
+	if !v.allowlisted() {
+		if globalFailures, err = rdb.Get(ctx, v.globalFailuresKey()).Int64(); err != nil && err != redis.Nil {
+			return false, false, errors.Wrapf(err, "get %v", v.globalFailuresKey())
+		}
+
+		if envMgmtLoginGlobalLockout() == "on" {
+			if ttl, err := rdb.TTL(ctx, v.globalLockoutKey()).Result(); err != nil && err != redis.Nil {
+				return false, false, errors.Wrapf(err, "ttl %v", v.globalLockoutKey())
+			} else if ttl > 0 {
+				return false, false, errors.Errorf("too many failures of all clients, locked, retry after %v", ttl.Round(time.Second))
+			}
+		}
+	}
+
+	delay = globalFailures >= loginGlobalLockoutThreshold
+	if envMgmtLoginChallenge() != "" {
+		challenge = failures >= loginChallengeThreshold || globalFailures >= loginGlobalChallengeThreshold
+	}
+	return challenge, delay, nil
+}
+
+// VerifyChallenge verify the challenge by the hook, for example, a CAPTCHA service. The hook should
+// response HTTP 200 with code 0, like the callback.
+func (v *LoginGuard) VerifyChallenge(ctx context.Context, challenge string) error {
+	if challenge == "" {
+		return errors.New("challenge required")
+	}
+
+	b, err := json.Marshal(&struct {
+		IP        string `json:"ip"`
+		Challenge string `json:"challenge"`
+	}{
+		IP: v.ip, Challenge: challenge,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "marshal")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	hook := envMgmtLoginChallenge()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrapf(err, "new request %v", hook)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "post %v", hook)
+	}
+	defer res.Body.Close()
+
+	b2, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrapf(err, "read body")
+	}
+
+	var code int
+	if err := json.Unmarshal(b2, &struct {
+		Code *int `json:"code"`
+	}{
+		Code: &code,
+	}); err != nil {
+		return errors.Wrapf(err, "unmarshal %v", string(b2))
+	}
+
+	if res.StatusCode != http.StatusOK || code != 0 {
+		return errors.Errorf("invalid challenge, status=%v, response %v", res.StatusCode, string(b2))
+	}
+	return nil
+}
+
+// OnFailure count the failure, lockout the client if exceed the threshold, and emit the event.
+func (v *LoginGuard) OnFailure(ctx context.Context, r *http.Request, reason string) (lockout time.Duration, err error) {
+	var failures, globalFailures int64
+	for _, key := range []string{v.failuresKey(), v.globalFailuresKey()} {
+		n, err := rdb.Incr(ctx, key).Result()
+		if err != nil && err != redis.Nil {
+			return 0, errors.Wrapf(err, "incr %v", key)
+		}
+
+		// Start the window when the first failure.
+		if n == 1 {
+			if err := rdb.Expire(ctx, key, loginFailureWindow).Err(); err != nil && err != redis.Nil {
+				return 0, errors.Wrapf(err, "expire %v %v", key, loginFailureWindow)
+			}
+		}
+
+		if key == v.failuresKey() {
+			failures = n
+		} else {
+			globalFailures = n
+		}
+	}
+
+	// Lockout the client, and extend the window, so the failures won't be reset during lockout.
+	if lockout = loginLockoutDuration(failures); lockout > 0 {
+		if err := rdb.Set(ctx, v.lockoutKey(), failures, lockout).Err(); err != nil && err != redis.Nil {
+			return 0, errors.Wrapf(err, "set %v %v %v", v.lockoutKey(), failures, lockout)
+		}
+		if err := rdb.Expire(ctx, v.failuresKey(), lockout+loginFailureWindow).Err(); err != nil && err != redis.Nil {
+			return 0, errors.Wrapf(err, "expire %v %v", v.failuresKey(), lockout+loginFailureWindow)
+		}
+	}
+
+	// Lockout all clients when too many failures of all clients, and extend the window like the client. The
+	// global lockout is opt-in, because it also locks the admin out.
+	if globalLockout := loginGlobalLockoutDuration(globalFailures); globalLockout > 0 && envMgmtLoginGlobalLockout() == "on" {
+		key := v.globalLockoutKey()
+		if err := rdb.Set(ctx, key, globalFailures, globalLockout).Err(); err != nil && err != redis.Nil {
+			return 0, errors.Wrapf(err, "set %v %v %v", key, globalFailures, globalLockout)
+		}
+		if err := rdb.Expire(ctx, v.globalFailuresKey(), globalLockout+loginFailureWindow).Err(); err != nil && err != redis.Nil {
+			return 0, errors.Wrapf(err, "expire %v %v", v.globalFailuresKey(), globalLockout+loginFailureWindow)
+		}
+		if globalLockout > lockout && !v.allowlisted() {
+			lockout = globalLockout
+		}
+	}
+	logger.Wf(ctx, "login failed, ip=%v, failures=%v, global=%v, lockout=%v, reason=%v",
+		v.ip, failures, globalFailures, lockout, reason)
+
+	// Post event in goroutine, because the callback target might be slow.
+	message := map[string]interface{}{
+		"ip": v.ip, "ua": r.UserAgent(), "failures": failures, "lockout": int64(lockout / time.Second),
+		"reason": reason,
+	}
+	go func() {
+		if err := callbackWorker.OnSystemMessage(ctx, SrsActionOnLoginFailed, message); err != nil {
+			logger.Wf(ctx, "login event ip=%v err %+v", v.ip, err)
+		}
+	}()
+
+	return lockout, nil
+}
+
+// Delay the response of failure, or the login when too many failures of all clients, to slow down the
+// brute-force attack.
+func (v *LoginGuard) Delay(ctx context.Context) {
+	logger.Wf(ctx, "login delay, ip=%v, wait for %v", v.ip, loginFailureDelay)
+
+	select {
+	case <-time.After(loginFailureDelay):
+	case <-ctx.Done():
+	}
+}
+
+// OnSuccess reset the failures of client, when login by password or OIDC.
+func (v *LoginGuard) OnSuccess(ctx context.Context) error {
+	if err := rdb.Del(ctx, v.failuresKey(), v.lockoutKey()).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "del %v %v", v.failuresKey(), v.lockoutKey())
+	}
+	return nil
+}
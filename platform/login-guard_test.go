@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLoginGuard_LockoutDuration(t *testing.T) {
+	for _, e := range []struct {
+		failures int64
+		lockout  time.Duration
+	}{
+		{failures: 0, lockout: 0},
+		{failures: 4, lockout: 0},
+		{failures: 5, lockout: 30 * time.Second},
+		{failures: 6, lockout: time.Minute},
+		{failures: 8, lockout: 4 * time.Minute},
+		{failures: 12, lockout: time.Hour},
+		{failures: 1000, lockout: time.Hour},
+	} {
+		if v := loginLockoutDuration(e.failures); v != e.lockout {
+			t.Errorf("Fail for failures=%v, expect %v, actual %v", e.failures, e.lockout, v)
+		}
+	}
+}
+
+func TestLoginGuard_GlobalLockoutDuration(t *testing.T) {
+	for _, e := range []struct {
+		failures int64
+		lockout  time.Duration
+	}{
+		{failures: 0, lockout: 0},
+		{failures: 99, lockout: 0},
+		{failures: 100, lockout: 30 * time.Second},
+		{failures: 101, lockout: time.Minute},
+		{failures: 103, lockout: 4 * time.Minute},
+		{failures: 104, lockout: 5 * time.Minute},
+		{failures: 10000, lockout: 5 * time.Minute},
+	} {
+		if v := loginGlobalLockoutDuration(e.failures); v != e.lockout {
+			t.Errorf("Fail for failures=%v, expect %v, actual %v", e.failures, e.lockout, v)
+		}
+	}
+}
+
+func TestLoginGuard_Allowlist(t *testing.T) {
+	allowlist := os.Getenv("MGMT_LOGIN_ALLOWLIST")
+	os.Setenv("MGMT_LOGIN_ALLOWLIST", "192.168.1.10,10.0.0.0/8")
+	defer os.Setenv("MGMT_LOGIN_ALLOWLIST", allowlist)
+
+	for _, e := range []struct {
+		ip          string
+		allowlisted bool
+	}{
+		{ip: "192.168.1.10", allowlisted: true},
+		{ip: "10.1.2.3", allowlisted: true},
+		{ip: "192.168.1.11"},
+		{ip: "1.2.3.4"},
+		{ip: ""},
+	} {
+		if v := NewLoginGuard(e.ip).allowlisted(); v != e.allowlisted {
+			t.Errorf("Fail for ip=%v, expect %v, actual %v", e.ip, e.allowlisted, v)
+		}
+	}
+
+	os.Setenv("MGMT_LOGIN_ALLOWLIST", "")
+	if NewLoginGuard("192.168.1.10").allowlisted() {
+		t.Errorf("Fail for empty allowlist")
+	}
+}
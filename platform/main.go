@@ -90,6 +90,12 @@ func doMain(ctx context.Context) error {
 			conf.Pwd = pwd
 		}
 
+		// The master key should never be in the data volume, so ignore it in .env, see loadSecretMasterKey.
+		masterKeys := make(map[string]string)
+		for _, k := range []string{"PLATFORM_MASTER_KEY", "PLATFORM_MASTER_KEY_FILE", "PLATFORM_MASTER_KEY_PREVIOUS"} {
+			masterKeys[k] = os.Getenv(k)
+		}
+
 		// Note that we only use .env in mgmt.
 		envFile := path.Join(conf.Pwd, "containers/data/config/.env")
 		if _, err := os.Stat(envFile); err == nil {
@@ -97,6 +103,10 @@ func doMain(ctx context.Context) error {
 				return errors.Wrapf(err, "load %v", envFile)
 			}
 		}
+
+		for k, v := range masterKeys {
+			os.Setenv(k, v)
+		}
 	}
 
 	// For platform, default to development for Darwin.
@@ -118,8 +128,15 @@ func doMain(ctx context.Context) error {
 	setEnvDefault("HTTPS_LISTEN", "2443")
 	setEnvDefault("AUTO_SELF_SIGNED_CERTIFICATE", "on")
 
+	// The NGINX proxy is on the same host, to get the client IP from X-Forwarded-For.
+	setEnvDefault("TRUSTED_PROXIES", "127.0.0.1,::1")
+
+	// The master key of secrets is generated at the first run, and it must be out of the data volume.
+	setEnvDefault("PLATFORM_MASTER_KEY_FILE", path.Join(conf.Pwd, "containers/keys/.master.key"))
+
 	// For feature control.
 	setEnvDefault("NAME_LOOKUP", "on")
+	setEnvDefault("MGMT_LOGIN_GLOBAL_LOCKOUT", "off")
 	setEnvDefault("PLATFORM_DOCKER", "off")
 
 	// For multiple ports.
@@ -173,6 +190,11 @@ func doMain(ctx context.Context) error {
 	}
 	logger.Tf(ctx, "init rdb(redis client) ok")
 
+	// Setup the keyring to encrypt secrets in redis, before any secret is read.
+	if err := initSecretKeyring(ctx); err != nil {
+		return errors.Wrapf(err, "init secret keyring")
+	}
+
 	// For platform, we should initOS after redis.
 	// Setup the OS for redis, which should never depends on redis.
 	if err := initOS(ctx); err != nil {
@@ -204,6 +226,13 @@ func doMain(ctx context.Context) error {
 		return errors.Wrapf(err, "start callback worker")
 	}
 
+	// Create audit worker for management API.
+	auditWorker = NewAuditWorker()
+	defer auditWorker.Close()
+	if err := auditWorker.Start(ctx); err != nil {
+		return errors.Wrapf(err, "start audit worker")
+	}
+
 	// Create transcript worker for transcription.
 	transcriptWorker = NewTranscriptWorker()
 	defer transcriptWorker.Close()
@@ -261,6 +290,11 @@ func doMain(ctx context.Context) error {
 		return errors.Wrapf(err, "start forward worker")
 	}
 
+	// Initialize the cache for remote files of vLive.
+	if err := initMediaCache(ctx); err != nil {
+		return errors.Wrapf(err, "init media cache")
+	}
+
 	// Create worker for vLive.
 	vLiveWorker = NewVLiveWorker()
 	defer vLiveWorker.Close()
@@ -275,6 +309,13 @@ func doMain(ctx context.Context) error {
 		return errors.Wrapf(err, "start IP camera worker")
 	}
 
+	// Create worker for mosaic composite.
+	mosaicWorker = NewMosaicWorker()
+	defer mosaicWorker.Close()
+	if err := mosaicWorker.Start(ctx); err != nil {
+		return errors.Wrapf(err, "start mosaic worker")
+	}
+
 	// Create worker for crontab.
 	crontabWorker = NewCrontabWorker()
 	defer crontabWorker.Close()
@@ -501,9 +542,12 @@ func initPlatform(ctx context.Context) error {
 		logger.Tf(ctx, "disable srs dev for release enabled, r0=%v", r0)
 	}
 
-	// Setup the publish secret for first run.
+	// Setup the publish secret for first run. The existing install keeps publishing by the legacy secret, while
+	// the new install requires the publish token, see isPublishLegacyEnabled.
 	if publish, err := rdb.HGet(ctx, SRS_AUTH_SECRET, "pubSecret").Result(); err != nil && err != redis.Nil {
 		return errors.Wrapf(err, "hget %v pubSecret", SRS_AUTH_SECRET)
+	} else if err = rdb.HSetNX(ctx, SRS_AUTH_SECRET, "pubLegacy", fmt.Sprintf("%v", publish != "")).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hsetnx %v pubLegacy", SRS_AUTH_SECRET)
 	} else if publish == "" {
 		publish = strings.ReplaceAll(uuid.NewString(), "-", "")
 		if err = rdb.HSet(ctx, SRS_AUTH_SECRET, "pubSecret", publish).Err(); err != nil && err != redis.Nil {
@@ -514,6 +558,18 @@ func initPlatform(ctx context.Context) error {
 		}
 	}
 
+	// Setup the keys to sign the publish and play token, which should never be the api secret.
+	for _, field := range []string{"pubTokenKey", "playTokenKey"} {
+		if key, err := rdb.HGet(ctx, SRS_AUTH_SECRET, field).Result(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hget %v %v", SRS_AUTH_SECRET, field)
+		} else if key == "" {
+			key = strings.ReplaceAll(uuid.NewString()+uuid.NewString(), "-", "")
+			if err = rdb.HSet(ctx, SRS_AUTH_SECRET, field, key).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v %v %vB", SRS_AUTH_SECRET, field, len(key))
+			}
+		}
+	}
+
 	// Migrate from previous versions.
 	for _, migrate := range []struct {
 		PVK string
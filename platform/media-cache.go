@@ -0,0 +1,917 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The default size limit of media cache, in MB.
+const mediaCacheDefaultLimit = 10 * 1024
+
+// The timeout to fetch a remote file.
+const mediaCacheFetchTimeout = 2 * time.Hour
+
+// The interval and window to pre-fetch the remote files, before the scheduled play time.
+const mediaCachePrefetchInterval = 30 * time.Second
+const mediaCachePrefetchWindow = time.Hour
+
+// The payload hash for S3 request without body.
+const awsUnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// mediaCache is the global cache for remote files of vLive.
+var mediaCache *MediaCache
+
+// MediaCacheEntry is a remote file which is downloaded to cache.
+type MediaCacheEntry struct {
+	// The URL of remote file, http(s):// or s3://bucket/key
+	URL string `json:"url"`
+	// The local file in cache.
+	Target string `json:"target"`
+	// The size in bytes.
+	Size int64 `json:"size"`
+	// The SHA256 checksum in hex.
+	Checksum string `json:"checksum"`
+	// The fetch time, in RFC3339.
+	Update string `json:"update"`
+	// The last used time, in RFC3339Nano, to evict the least recently used file.
+	Used string `json:"used"`
+}
+
+func (v *MediaCacheEntry) String() string {
+	return fmt.Sprintf("url=%v, target=%v, size=%v, checksum=%v, update=%v, used=%v",
+		v.URL, v.Target, v.Size, v.Checksum, v.Update, v.Used,
+	)
+}
+
+func (v *MediaCacheEntry) used() time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, v.Used)
+	return t
+}
+
+// mediaCacheFetching is a fetching file, for other requests to wait for it.
+type mediaCacheFetching struct {
+	done chan struct{}
+	err  error
+}
+
+// MediaCache is a local cache of remote files, which evicts the least recently used files when the
+// size exceeds the limit. The index is stored in a json file next to each cached file, so it's rebuilt
+// from the directory after restart.
+type MediaCache struct {
+	// The directory of cache.
+	dir string
+	// The size limit in bytes.
+	limit int64
+	// The HTTP client to fetch files.
+	client *http.Client
+	// Load the config of S3-compatible storage.
+	s3Config func(ctx context.Context) (*MediaS3Config, error)
+
+	// The cached files, key is the key of URL.
+	entries map[string]*MediaCacheEntry
+	// The fetching files, to fetch only once for concurrent requests.
+	fetching map[string]*mediaCacheFetching
+	// The reference count of playing files, which are never evicted.
+	inUse map[string]int
+	// To protect the fields.
+	lock sync.Mutex
+}
+
+func NewMediaCache(dir string, limit int64) *MediaCache {
+	return &MediaCache{
+		dir: dir, limit: limit, client: &http.Client{}, s3Config: loadMediaS3Config,
+		entries:  make(map[string]*MediaCacheEntry),
+		fetching: make(map[string]*mediaCacheFetching),
+		inUse:    make(map[string]int),
+	}
+}
+
+// Initialize create the directory, and load the cached files.
+func (v *MediaCache) Initialize(ctx context.Context) error {
+	if err := os.MkdirAll(v.dir, 0755); err != nil {
+		return errors.Wrapf(err, "create dir %v", v.dir)
+	}
+
+	files, err := os.ReadDir(v.dir)
+	if err != nil {
+		return errors.Wrapf(err, "read dir %v", v.dir)
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for _, file := range files {
+		name := path.Join(v.dir, file.Name())
+
+		// The partial file is never completed, because the fetch is lost when restart.
+		if strings.HasSuffix(name, ".tmp") {
+			os.Remove(name)
+			continue
+		}
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		var entry MediaCacheEntry
+		if b, err := os.ReadFile(name); err != nil {
+			return errors.Wrapf(err, "read %v", name)
+		} else if err = json.Unmarshal(b, &entry); err != nil {
+			logger.Wf(ctx, "cache: Ignore invalid %v err %+v", name, err)
+			os.Remove(name)
+			continue
+		}
+
+		if _, err := os.Stat(entry.Target); err != nil {
+			os.Remove(name)
+			continue
+		}
+		v.entries[mediaCacheKey(entry.URL)] = &entry
+	}
+
+	logger.Tf(ctx, "cache: Initialize dir=%v, limit=%vMB, files=%v", v.dir, v.limit/1024/1024, len(v.entries))
+	return nil
+}
+
+// mediaCacheKey get the key of URL, which is also the name of cached file.
+func mediaCacheKey(u string) string {
+	h := sha256.Sum256([]byte(u))
+	return hex.EncodeToString(h[:16])
+}
+
+// parseMediaChecksum parse the SHA256 checksum in hex, with optional sha256: prefix.
+func parseMediaChecksum(checksum string) (string, error) {
+	checksum = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(checksum), "sha256:"))
+	if checksum == "" {
+		return "", nil
+	}
+	if b, err := hex.DecodeString(checksum); err != nil || len(b) != sha256.Size {
+		return "", errors.Errorf("invalid sha256 checksum %v", checksum)
+	}
+	return checksum, nil
+}
+
+// isMediaRemoteURL whether the target is a remote file, which should be fetched to cache.
+func isMediaRemoteURL(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" || u.Path == "" {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "s3"
+}
+
+// Fetch the remote file to cache if not cached, and verify the checksum if specified.
+func (v *MediaCache) Fetch(ctx context.Context, u, checksum string) (*MediaCacheEntry, error) {
+	key := mediaCacheKey(u)
+	checksum, err := parseMediaChecksum(checksum)
+	if err != nil {
+		return nil, errors.Wrapf(err, "checksum")
+	}
+
+	for {
+		v.lock.Lock()
+		// The remote file is changed if checksum mismatch, so fetch it again.
+		if entry, ok := v.entries[key]; ok && (checksum == "" || checksum == entry.Checksum) {
+			entry.Used = time.Now().Format(time.RFC3339Nano)
+			r := *entry
+			v.lock.Unlock()
+
+			// Persist the used time without lock, because it writes the file.
+			if err := v.saveEntry(&r); err != nil {
+				logger.Wf(ctx, "cache: Save %v err %+v", r.String(), err)
+			}
+			return &r, nil
+		}
+
+		// Wait for the fetching file, then check it again.
+		if fetching, ok := v.fetching[key]; ok {
+			v.lock.Unlock()
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-fetching.done:
+			}
+			if fetching.err != nil {
+				return nil, errors.Wrapf(fetching.err, "fetch %v", u)
+			}
+			continue
+		}
+
+		fetching := &mediaCacheFetching{done: make(chan struct{})}
+		v.fetching[key] = fetching
+		v.lock.Unlock()
+
+		entry, err := v.download(ctx, key, u, checksum)
+
+		var r MediaCacheEntry
+		v.lock.Lock()
+		delete(v.fetching, key)
+		if err == nil {
+			v.entries[key], r = entry, *entry
+		}
+		v.lock.Unlock()
+
+		fetching.err = err
+		close(fetching.done)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fetch %v", u)
+		}
+
+		v.Evict(ctx)
+		return &r, nil
+	}
+}
+
+// download the remote file to a temporary file, then rename it, so the player never uses a partial file.
+func (v *MediaCache) download(ctx context.Context, key, u, checksum string) (*MediaCacheEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, mediaCacheFetchTimeout)
+	defer cancel()
+
+	req, err := v.newRequest(ctx, u)
+	if err != nil {
+		return nil, errors.Wrapf(err, "request %v", u)
+	}
+
+	starttime := time.Now()
+	res, err := v.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get %v", req.URL.String())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, errors.Errorf("get %v status %v, %v", req.URL.String(), res.StatusCode, string(b))
+	}
+	if res.ContentLength > v.limit {
+		return nil, errors.Errorf("size %v exceeds cache limit %v", res.ContentLength, v.limit)
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse %v", u)
+	}
+	entry := &MediaCacheEntry{
+		URL: u, Target: path.Join(v.dir, fmt.Sprintf("%v%v", key, path.Ext(parsed.Path))),
+	}
+
+	tempFile := fmt.Sprintf("%v.%v.tmp", entry.Target, uuid.NewString())
+	defer os.Remove(tempFile)
+
+	f, err := os.OpenFile(tempFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %v", tempFile)
+	}
+	defer f.Close()
+
+	// The content length might be absent or wrong, so never download more than the limit.
+	h := sha256.New()
+	if entry.Size, err = io.Copy(io.MultiWriter(f, h), io.LimitReader(res.Body, v.limit+1)); err != nil {
+		return nil, errors.Wrapf(err, "copy %v to %v", u, tempFile)
+	}
+	if entry.Size > v.limit {
+		return nil, errors.Errorf("size exceeds cache limit %v", v.limit)
+	}
+	if res.ContentLength >= 0 && entry.Size != res.ContentLength {
+		return nil, errors.Errorf("size %v mismatch content length %v", entry.Size, res.ContentLength)
+	}
+	if err = f.Close(); err != nil {
+		return nil, errors.Wrapf(err, "close %v", tempFile)
+	}
+
+	entry.Checksum = hex.EncodeToString(h.Sum(nil))
+	if checksum != "" && checksum != entry.Checksum {
+		return nil, errors.Errorf("checksum %v mismatch %v", entry.Checksum, checksum)
+	}
+
+	if err = os.Rename(tempFile, entry.Target); err != nil {
+		return nil, errors.Wrapf(err, "rename %v to %v", tempFile, entry.Target)
+	}
+
+	now := time.Now()
+	entry.Update, entry.Used = now.Format(time.RFC3339), now.Format(time.RFC3339Nano)
+	if err = v.saveEntry(entry); err != nil {
+		return nil, errors.Wrapf(err, "save %v", entry.String())
+	}
+
+	logger.Tf(ctx, "cache: Fetch ok, cost=%v, %v", time.Since(starttime), entry.String())
+	return entry, nil
+}
+
+// newRequest create the request to fetch the remote file, sign it for S3.
+func (v *MediaCache) newRequest(ctx context.Context, u string) (*http.Request, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse %v", u)
+	}
+
+	if parsed.Scheme == "http" || parsed.Scheme == "https" {
+		return http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	}
+	if parsed.Scheme != "s3" {
+		return nil, errors.Errorf("invalid scheme %v", parsed.Scheme)
+	}
+
+	config, err := v.s3Config(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load s3 config")
+	}
+
+	objectURL, err := config.objectURL(parsed.Host, strings.TrimPrefix(parsed.Path, "/"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "object url of %v", u)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, objectURL, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "new request %v", objectURL)
+	}
+
+	// Access the public bucket anonymously, if no credential.
+	if config.AccessKey != "" {
+		signAWSv4(req, config.AccessKey, config.SecretKey, config.Region, "s3", time.Now())
+	}
+	return req, nil
+}
+
+func (v *MediaCache) saveEntry(entry *MediaCacheEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrapf(err, "marshal %v", entry.String())
+	}
+
+	name := path.Join(v.dir, fmt.Sprintf("%v.json", mediaCacheKey(entry.URL)))
+	if err = os.WriteFile(name, b, 0644); err != nil {
+		return errors.Wrapf(err, "write %v", name)
+	}
+	return nil
+}
+
+// Acquire the cached file of URL, fetch it if not cached. The file is never evicted until release.
+func (v *MediaCache) Acquire(ctx context.Context, u, checksum string) (*MediaCacheEntry, func(), error) {
+	key := mediaCacheKey(u)
+
+	// Hold the file before fetching, so it's never evicted by other fetches.
+	v.lock.Lock()
+	v.inUse[key]++
+	v.lock.Unlock()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			v.lock.Lock()
+			defer v.lock.Unlock()
+			if v.inUse[key]--; v.inUse[key] <= 0 {
+				delete(v.inUse, key)
+			}
+		})
+	}
+
+	entry, err := v.Fetch(ctx, u, checksum)
+	if err != nil {
+		release()
+		return nil, nil, errors.Wrapf(err, "fetch %v", u)
+	}
+	return entry, release, nil
+}
+
+// Evict the least recently used files, until the size is under the limit. The playing files are never
+// evicted, so the size might exceed the limit.
+func (v *MediaCache) Evict(ctx context.Context) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var size int64
+	var keys []string
+	for key, entry := range v.entries {
+		size += entry.Size
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return v.entries[keys[i]].used().Before(v.entries[keys[j]].used())
+	})
+
+	for _, key := range keys {
+		if size <= v.limit {
+			break
+		}
+		if v.inUse[key] > 0 {
+			continue
+		}
+
+		entry := v.entries[key]
+		os.Remove(entry.Target)
+		os.Remove(path.Join(v.dir, fmt.Sprintf("%v.json", key)))
+		delete(v.entries, key)
+		size -= entry.Size
+		logger.Tf(ctx, "cache: Evict file, size=%v, limit=%v, %v", size, v.limit, entry.String())
+	}
+}
+
+// Query the cached files, and the total size.
+func (v *MediaCache) Query() ([]*MediaCacheEntry, int64) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var size int64
+	var entries []*MediaCacheEntry
+	for _, entry := range v.entries {
+		r := *entry
+		entries = append(entries, &r)
+		size += entry.Size
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].used().After(entries[j].used())
+	})
+	return entries, size
+}
+
+// acquireVLiveSource get the source to play, the remote file is resolved to the file in cache, which is
+// fetched if not cached. Note that the release should be called after playing.
+func acquireVLiveSource(ctx context.Context, file *FFprobeSource) (*FFprobeSource, func(), error) {
+	if file.Type != FFprobeSourceTypeRemote {
+		return file, func() {}, nil
+	}
+
+	entry, release, err := mediaCache.Acquire(ctx, file.Target, file.Checksum)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "acquire %v", file.Target)
+	}
+
+	local := *file
+	local.Target = entry.Target
+	return &local, release, nil
+}
+
+// MediaS3Config is the S3-compatible object storage, for example, AWS S3 or MinIO.
+type MediaS3Config struct {
+	// The endpoint, for example, http://minio:9000 or https://s3.us-east-1.amazonaws.com
+	Endpoint string `json:"endpoint"`
+	// The region to sign the request.
+	Region string `json:"region"`
+	// The access key, access the public bucket anonymously if empty.
+	AccessKey string `json:"accessKey"`
+	// The secret key.
+	SecretKey string `json:"secretKey"`
+	// Whether use path-style URL, like http://minio:9000/bucket/key, which is required by MinIO.
+	PathStyle bool `json:"pathStyle"`
+}
+
+func (v *MediaS3Config) String() string {
+	return fmt.Sprintf("endpoint=%v, region=%v, accessKey=%v, secretKey=%vB, pathStyle=%v",
+		v.Endpoint, v.Region, v.AccessKey, len(v.SecretKey), v.PathStyle,
+	)
+}
+
+func (v *MediaS3Config) Initialize() {
+	if v.Region == "" {
+		v.Region = "us-east-1"
+	}
+	if v.Endpoint == "" {
+		v.Endpoint = fmt.Sprintf("https://s3.%v.amazonaws.com", v.Region)
+	}
+}
+
+func (v *MediaS3Config) Validate() error {
+	if u, err := url.Parse(v.Endpoint); err != nil {
+		return errors.Wrapf(err, "parse endpoint %v", v.Endpoint)
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("invalid endpoint %v", v.Endpoint)
+	}
+	if v.AccessKey != "" && v.SecretKey == "" {
+		return errors.New("no secret key")
+	}
+	return nil
+}
+
+// objectURL get the URL of object in bucket.
+func (v *MediaS3Config) objectURL(bucket, key string) (string, error) {
+	if bucket == "" || key == "" {
+		return "", errors.Errorf("invalid bucket %v key %v", bucket, key)
+	}
+
+	u, err := url.Parse(v.Endpoint)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse %v", v.Endpoint)
+	}
+
+	if v.PathStyle {
+		u.Path = path.Join("/", u.Path, bucket, key)
+	} else {
+		u.Host, u.Path = fmt.Sprintf("%v.%v", bucket, u.Host), path.Join("/", u.Path, key)
+	}
+	return u.String(), nil
+}
+
+func loadMediaS3Config(ctx context.Context) (*MediaS3Config, error) {
+	obj, err := rdb.HGetAll(ctx, SRS_MEDIA_S3).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_MEDIA_S3)
+	}
+
+	config := &MediaS3Config{
+		Endpoint: obj["endpoint"], Region: obj["region"],
+		AccessKey: obj["accessKey"], SecretKey: obj["secretKey"],
+		PathStyle: obj["pathStyle"] == "true",
+	}
+	config.Initialize()
+	return config, nil
+}
+
+// signAWSv4 sign the request by AWS Signature Version 4, in the Authorization header. The payload hash
+// is the x-amz-content-sha256 header, or UNSIGNED-PAYLOAD if not set.
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func signAWSv4(r *http.Request, accessKey, secretKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = awsUnsignedPayload
+		r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+	r.Header.Set("X-Amz-Date", amzDate)
+
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+
+	// The host, range and x-amz-* headers are signed.
+	headers := map[string]string{"host": host}
+	for name, values := range r.Header {
+		name = strings.ToLower(name)
+		if name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(fmt.Sprintf("%v:%v\n", name, headers[name]))
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := r.URL.Query()
+	var queries []string
+	for key, values := range query {
+		for _, value := range values {
+			queries = append(queries, fmt.Sprintf("%v=%v", awsURIEncode(key, true), awsURIEncode(value, true)))
+		}
+	}
+	sort.Strings(queries)
+
+	canonicalURI := r.URL.Path
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method, awsURIEncode(canonicalURI, false), strings.Join(queries, "&"),
+		canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%v/%v/%v/aws4_request", date, region, service)
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	r.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		accessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsURIEncode encode the string for AWS, all characters except the unreserved are encoded.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for _, b := range []byte(s) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' || (b == '/' && !encodeSlash) {
+			sb.WriteByte(b)
+		} else {
+			sb.WriteString(fmt.Sprintf("%%%02X", b))
+		}
+	}
+	return sb.String()
+}
+
+// initMediaCache create the global media cache, with the limit from env.
+func initMediaCache(ctx context.Context) error {
+	limit := int64(mediaCacheDefaultLimit)
+	if envMediaCacheLimit() != "" {
+		if iv, err := strconv.ParseInt(envMediaCacheLimit(), 10, 64); err != nil {
+			return errors.Wrapf(err, "parse SRS_MEDIA_CACHE_LIMIT %v", envMediaCacheLimit())
+		} else if iv > 0 {
+			limit = iv
+		}
+	}
+
+	mediaCache = NewMediaCache(path.Join(dirVLivePath, "cache"), limit*1024*1024)
+	if err := mediaCache.Initialize(ctx); err != nil {
+		return errors.Wrapf(err, "initialize cache")
+	}
+	return nil
+}
+
+// remoteFiles get the remote files of channel, which will be played in the window. For program grid,
+// only the programs in window and the fillers, otherwise all files.
+func (v *VLiveConfigure) remoteFiles(now time.Time, window time.Duration) []*FFprobeSource {
+	uuids := make(map[string]bool)
+	grid := v.Grid != nil && len(v.Grid.Programs) > 0
+	if grid {
+		for _, entry := range v.Grid.programs(v.Files, now, now.Add(window)) {
+			uuids[entry.Program.Source] = true
+		}
+		for _, filler := range v.Grid.Fillers {
+			uuids[filler] = true
+		}
+	}
+
+	var files []*FFprobeSource
+	for _, file := range v.Files {
+		if file.Type != FFprobeSourceTypeRemote {
+			continue
+		}
+		if grid && !uuids[file.UUID] {
+			continue
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// prefetchFiles fetch the remote files of channels which are on air or will be on air in the window,
+// so the files are ready before the scheduled play time.
+func (v *VLiveWorker) prefetchFiles(ctx context.Context) error {
+	configItems, err := rdb.HGetAll(ctx, SRS_VLIVE_CONFIG).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_VLIVE_CONFIG)
+	}
+
+	now := time.Now()
+	for platform, configItem := range configItems {
+		var config VLiveConfigure
+		if err = json.Unmarshal([]byte(configItem), &config); err != nil {
+			return errors.Wrapf(err, "unmarshal %v %v", platform, configItem)
+		}
+		if !config.Enabled {
+			continue
+		}
+
+		if state := querySchedule(config.Schedules); state != nil && !state.Active {
+			next, err := time.Parse(time.RFC3339, state.Next)
+			if err != nil || next.Sub(now) > mediaCachePrefetchWindow {
+				continue
+			}
+		}
+
+		for _, file := range config.remoteFiles(now, mediaCachePrefetchWindow) {
+			if _, err := mediaCache.Fetch(ctx, file.Target, file.Checksum); err != nil {
+				logger.Wf(ctx, "vLive: Ignore prefetch %v of %v err %+v", file.Target, platform, err)
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// startPrefetch start a goroutine to pre-fetch the remote files in background.
+func (v *VLiveWorker) startPrefetch(ctx context.Context) {
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+
+		for ctx.Err() == nil {
+			if err := v.prefetchFiles(ctx); err != nil {
+				logger.Wf(ctx, "vLive: Ignore prefetch err %+v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(mediaCachePrefetchInterval):
+			}
+		}
+	}()
+}
+
+func (v *VLiveWorker) handleRemote(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/ffmpeg/vlive/remote"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, qUrl, checksum string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string `json:"token"`
+				URL      *string `json:"url"`
+				Checksum *string `json:"checksum"`
+			}{
+				Token: &token, URL: &qUrl, Checksum: &checksum,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if !isMediaRemoteURL(qUrl) {
+				return errors.Errorf("invalid url %v, should be http(s):// or s3://bucket/key", qUrl)
+			}
+			u, err := url.Parse(qUrl)
+			if err != nil {
+				return errors.Wrapf(err, "parse %v", qUrl)
+			}
+			if !slicesContains(serverAllowVideoFiles, strings.ToLower(path.Ext(u.Path))) {
+				return errors.Errorf("invalid file ext %v, should be %v", path.Ext(u.Path), serverAllowVideoFiles)
+			}
+
+			// Fetch the file now, so the source can be probed from the local copy.
+			entry, err := mediaCache.Fetch(ctx, qUrl, checksum)
+			if err != nil {
+				return errors.Wrapf(err, "fetch %v", qUrl)
+			}
+
+			targetUUID := uuid.NewString()
+			ohttp.WriteData(ctx, w, r, &struct {
+				// The file name.
+				Name string `json:"name"`
+				// The file UUID.
+				UUID string `json:"uuid"`
+				// The file target name.
+				Target string `json:"target"`
+				// The file size in bytes.
+				Size int64 `json:"size"`
+				// The source type.
+				Type FFprobeSourceType `json:"type"`
+				// The SHA256 checksum.
+				Checksum string `json:"checksum"`
+			}{
+				Name: path.Base(u.Path), UUID: targetUUID, Target: qUrl,
+				Size: entry.Size, Type: FFprobeSourceTypeRemote, Checksum: entry.Checksum,
+			})
+
+			logger.Tf(ctx, "vLive: Update remote url ok, uuid=%v, %v, token=%vB", targetUUID, entry.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/vlive/cache/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			entries, size := mediaCache.Query()
+			ohttp.WriteData(ctx, w, r, &struct {
+				Limit int64              `json:"limit"`
+				Size  int64              `json:"size"`
+				Files []*MediaCacheEntry `json:"files"`
+			}{
+				Limit: mediaCache.limit, Size: size, Files: entries,
+			})
+			logger.Tf(ctx, "vLive: Query cache ok, files=%v, size=%v, token=%vB", len(entries), size, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/vlive/s3/update"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var config MediaS3Config
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token     *string `json:"token"`
+				Endpoint  *string `json:"endpoint"`
+				Region    *string `json:"region"`
+				AccessKey *string `json:"accessKey"`
+				SecretKey *string `json:"secretKey"`
+				PathStyle *bool   `json:"pathStyle"`
+			}{
+				Token: &token, Endpoint: &config.Endpoint, Region: &config.Region,
+				AccessKey: &config.AccessKey, SecretKey: &config.SecretKey, PathStyle: &config.PathStyle,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			config.Initialize()
+			if err := config.Validate(); err != nil {
+				return errors.Wrapf(err, "validate %v", config.String())
+			}
+
+			if err := rdb.HSet(ctx, SRS_MEDIA_S3,
+				"endpoint", config.Endpoint, "region", config.Region,
+				"accessKey", config.AccessKey, "secretKey", config.SecretKey,
+				"pathStyle", fmt.Sprintf("%v", config.PathStyle),
+			).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v %v", SRS_MEDIA_S3, config.String())
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "vLive: Update s3 ok, %v, token=%vB", config.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/vlive/s3/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			config, err := loadMediaS3Config(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "load s3 config")
+			}
+
+			// Never response the secret key.
+			config.SecretKey = ""
+			ohttp.WriteData(ctx, w, r, config)
+			logger.Tf(ctx, "vLive: Query s3 ok, %v, token=%vB", config.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
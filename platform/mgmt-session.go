@@ -0,0 +1,572 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// The kind of token for management API.
+const mgmtTokenKindAccess = "access"
+const mgmtTokenKindRefresh = "refresh"
+
+// The role of session, the viewer is not allowed to change the state of system, see mgmtViewerEndpoints.
+const mgmtRoleAdmin = "admin"
+const mgmtRoleViewer = "viewer"
+
+// The endpoints allowed for viewer, which only query the state of system and never response any secret. All
+// other endpoints are denied for viewer, no matter the method of request.
+var mgmtViewerEndpoints = map[string]bool{
+	"/terraform/v1/host/versions":            true,
+	"/terraform/v1/mgmt/versions":            true,
+	"/terraform/v1/ffmpeg/versions":          true,
+	"/terraform/v1/mgmt/status":              true,
+	"/terraform/v1/mgmt/token":               true,
+	"/terraform/v1/mgmt/token/refresh":       true,
+	"/terraform/v1/mgmt/logout":              true,
+	"/terraform/v1/mgmt/limits/query":        true,
+	"/terraform/v1/mgmt/beian/query":         true,
+	"/terraform/v1/mgmt/hphls/query":         true,
+	"/terraform/v1/mgmt/hlsll/query":         true,
+	"/terraform/v1/mgmt/streams/query":       true,
+	"/terraform/v1/monitoring/query":         true,
+	"/terraform/v1/hooks/record/query":       true,
+	"/terraform/v1/hooks/srs/ip/rules/query": true,
+}
+
+// The SRS API proxied for console, which is allowed for viewer by GET.
+var mgmtViewerSrsAPIs = []string{
+	"/api/v1/summaries", "/api/v1/vhosts/", "/api/v1/streams/", "/api/v1/clients/",
+}
+
+// authorizeMgmtRole check whether the role is allowed to request the endpoint by method.
+func authorizeMgmtRole(role, method, endpoint string) error {
+	if role != mgmtRoleViewer || mgmtViewerEndpoints[endpoint] {
+		return nil
+	}
+
+	if method == http.MethodGet {
+		for _, api := range mgmtViewerSrsAPIs {
+			if strings.HasPrefix(endpoint, api) {
+				return nil
+			}
+		}
+	}
+
+	return errors.Errorf("role %v not allowed to %v %v", role, method, endpoint)
+}
+
+// The internal header to identify the method and endpoint of request, which is set for all requests by
+// setMgmtEndpoint, so that Authenticate authorizes the role by the same token the handler verifies.
+const mgmtEndpointHeader = "X-Oryx-Endpoint"
+
+// setMgmtEndpoint identify the request for Authenticate, and overwrite the header from client.
+func setMgmtEndpoint(r *http.Request) {
+	r.Header.Set(mgmtEndpointHeader, fmt.Sprintf("%v %v", r.Method, r.URL.Path))
+}
+
+// authorizeMgmtEndpoint check whether the role is allowed to request the endpoint identified by the header,
+// see setMgmtEndpoint. The viewer is denied if the request is not identified.
+func authorizeMgmtEndpoint(role string, header http.Header) error {
+	var method, endpoint string
+	if parts := strings.SplitN(header.Get(mgmtEndpointHeader), " ", 2); len(parts) == 2 {
+		method, endpoint = parts[0], parts[1]
+	}
+	return authorizeMgmtRole(role, method, endpoint)
+}
+
+// The access token is short-lived, and the refresh token expires with the session.
+const mgmtAccessTokenExpire = 2 * time.Hour
+const mgmtRefreshTokenExpire = 30 * 24 * time.Hour
+
+// MgmtSession is a login session of management console.
+type MgmtSession struct {
+	// The session id.
+	ID string `json:"id"`
+	// The user and role of session, the user is empty when login by password.
+	User string `json:"user,omitempty"`
+	Role string `json:"role"`
+	// The IP and user agent of client when login.
+	IP        string `json:"ip"`
+	UserAgent string `json:"ua"`
+	// The time to create and last refresh the session.
+	CreateAt  string `json:"createAt"`
+	RefreshAt string `json:"refreshAt"`
+	// The session expires when the refresh token expires.
+	ExpireAt string `json:"expireAt"`
+	// The id of current refresh token, the previous refresh tokens are invalid.
+	RefreshID string `json:"refreshId"`
+}
+
+func (v *MgmtSession) String() string {
+	return fmt.Sprintf("id=%v, user=%v, role=%v, ip=%v, ua=%v, create=%v, refresh=%v, expire=%v",
+		v.ID, v.User, v.Role, v.IP, v.UserAgent, v.CreateAt, v.RefreshAt, v.ExpireAt)
+}
+
+// MgmtLoginResult is the tokens of session, for login or refresh.
+type MgmtLoginResult struct {
+	// The short-lived access token.
+	Token    string `json:"token"`
+	CreateAt string `json:"createAt"`
+	ExpireAt string `json:"expireAt"`
+	// The refresh token to create new access token.
+	RefreshToken    string `json:"refreshToken"`
+	RefreshExpireAt string `json:"refreshExpireAt"`
+	// The session id and role.
+	SessionID string `json:"sid"`
+	Role      string `json:"role"`
+}
+
+// parseMgmtToken parse and verify the signature of token by apiSecret.
+func parseMgmtToken(apiSecret, token string) (*MgmtTokenClaims, error) {
+	var claims MgmtTokenClaims
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if _, err := parser.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(apiSecret), nil
+	}); err != nil {
+		return nil, errors.Wrapf(err, "verify token")
+	}
+	return &claims, nil
+}
+
+// mgmtTokenID get the id to revoke the token, the legacy token has no id but the nonce.
+func mgmtTokenID(claims *MgmtTokenClaims) string {
+	if claims.ID != "" {
+		return claims.ID
+	}
+	return claims.Nonce
+}
+
+// verifyMgmtToken check whether the token is revoked, or the session is logged out.
+func verifyMgmtToken(ctx context.Context, claims *MgmtTokenClaims) error {
+	if id := mgmtTokenID(claims); id != "" {
+		revokedKey := fmt.Sprintf("%v:%v", SRS_TOKEN_REVOKED, id)
+		if n, err := rdb.Exists(ctx, revokedKey).Result(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "exists %v", revokedKey)
+		} else if n > 0 {
+			return errors.Errorf("token %v revoked", id)
+		}
+	}
+
+	// All tokens issued before logout all are invalid.
+	if revokeBefore, err := rdb.HGet(ctx, SRS_AUTH_SECRET, "revokeBefore").Int64(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v revokeBefore", SRS_AUTH_SECRET)
+	} else if revokeBefore > 0 && (claims.IssuedAt == nil || claims.IssuedAt.Unix() < revokeBefore) {
+		return errors.Errorf("token issued at %v is revoked", claims.IssuedAt)
+	}
+
+	if claims.SessionID != "" {
+		if exists, err := rdb.HExists(ctx, SRS_MGMT_SESSIONS, claims.SessionID).Result(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hexists %v %v", SRS_MGMT_SESSIONS, claims.SessionID)
+		} else if !exists {
+			return errors.Errorf("session %v logged out", claims.SessionID)
+		}
+	}
+
+	return nil
+}
+
+// revokeMgmtToken add the token to revocation list, until it expires.
+func revokeMgmtToken(ctx context.Context, claims *MgmtTokenClaims) error {
+	id := mgmtTokenID(claims)
+	if id == "" {
+		return errors.New("no token id")
+	}
+
+	expire := 365 * 24 * time.Hour
+	if claims.ExpiresAt != nil {
+		expire = time.Until(claims.ExpiresAt.Time) + time.Minute
+	}
+
+	revokedKey := fmt.Sprintf("%v:%v", SRS_TOKEN_REVOKED, id)
+	if err := rdb.Set(ctx, revokedKey, claims.SessionID, expire).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "set %v %v", revokedKey, expire)
+	}
+	return nil
+}
+
+// loadMgmtSession load the session by id, return nil if not exists.
+func loadMgmtSession(ctx context.Context, sid string) (*MgmtSession, error) {
+	b, err := rdb.HGet(ctx, SRS_MGMT_SESSIONS, sid).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hget %v %v", SRS_MGMT_SESSIONS, sid)
+	}
+	if b == "" {
+		return nil, nil
+	}
+
+	var session MgmtSession
+	if err := json.Unmarshal([]byte(b), &session); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", b)
+	}
+	return &session, nil
+}
+
+// saveMgmtSession save the session to redis.
+func saveMgmtSession(ctx context.Context, session *MgmtSession) error {
+	b, err := json.Marshal(session)
+	if err != nil {
+		return errors.Wrapf(err, "marshal %v", session.String())
+	}
+
+	if err := rdb.HSet(ctx, SRS_MGMT_SESSIONS, session.ID, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", SRS_MGMT_SESSIONS, session.ID, string(b))
+	}
+	return nil
+}
+
+// refreshMgmtSession create new access and refresh token for session, and the previous refresh
+// token is invalid.
+func refreshMgmtSession(ctx context.Context, apiSecret string, session *MgmtSession) (*MgmtLoginResult, error) {
+	expireAt, createAt, token, err := createToken(ctx, apiSecret, mgmtTokenKindAccess, session.ID, session.Role, mgmtAccessTokenExpire)
+	if err != nil {
+		return nil, errors.Wrapf(err, "build access token")
+	}
+
+	refreshExpireAt, _, refreshToken, err := createToken(ctx, apiSecret, mgmtTokenKindRefresh, session.ID, session.Role, mgmtRefreshTokenExpire)
+	if err != nil {
+		return nil, errors.Wrapf(err, "build refresh token")
+	}
+
+	claims, err := parseMgmtToken(apiSecret, refreshToken)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse refresh token")
+	}
+
+	session.RefreshAt = createAt.Format(time.RFC3339)
+	session.ExpireAt = refreshExpireAt.Format(time.RFC3339)
+	session.RefreshID = claims.ID
+	if err := saveMgmtSession(ctx, session); err != nil {
+		return nil, errors.Wrapf(err, "save session")
+	}
+
+	return &MgmtLoginResult{
+		Token: token, CreateAt: createAt.Format(time.RFC3339), ExpireAt: expireAt.Format(time.RFC3339),
+		RefreshToken: refreshToken, RefreshExpireAt: refreshExpireAt.Format(time.RFC3339),
+		SessionID: session.ID, Role: session.Role,
+	}, nil
+}
+
+// createMgmtSession create a new session for the client, when login by password or SSO.
+func createMgmtSession(ctx context.Context, r *http.Request, apiSecret, user, role string) (*MgmtLoginResult, error) {
+	session := &MgmtSession{
+		ID: uuid.NewString(), User: user, Role: role, IP: httpClientIP(r), UserAgent: r.UserAgent(),
+		CreateAt: time.Now().Format(time.RFC3339),
+	}
+
+	result, err := refreshMgmtSession(ctx, apiSecret, session)
+	if err != nil {
+		return nil, errors.Wrapf(err, "refresh session")
+	}
+
+	logger.Tf(ctx, "session create ok, %v", session.String())
+	return result, nil
+}
+
+func handleMgmtSessionService(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/mgmt/token/refresh"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var refreshToken string
+			if err := ParseBody(ctx, r.Body, &struct {
+				RefreshToken *string `json:"refreshToken"`
+			}{
+				RefreshToken: &refreshToken,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			if refreshToken == "" {
+				return errors.New("no refreshToken")
+			}
+
+			apiSecret := envApiSecret()
+			claims, err := parseMgmtToken(apiSecret, refreshToken)
+			if err != nil {
+				return errors.Wrapf(err, "parse refresh token")
+			}
+			if claims.Kind != mgmtTokenKindRefresh {
+				return errors.Errorf("invalid kind %v", claims.Kind)
+			}
+			if err := verifyMgmtToken(ctx, claims); err != nil {
+				return errors.Wrapf(err, "verify refresh token")
+			}
+
+			session, err := loadMgmtSession(ctx, claims.SessionID)
+			if err != nil {
+				return errors.Wrapf(err, "load session %v", claims.SessionID)
+			} else if session == nil {
+				return errors.Errorf("session %v logged out", claims.SessionID)
+			}
+
+			// The refresh token is rotated, so a reused refresh token might be stolen, and we logout the
+			// session for safety.
+			if session.RefreshID != claims.ID {
+				if err := rdb.HDel(ctx, SRS_MGMT_SESSIONS, session.ID).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hdel %v %v", SRS_MGMT_SESSIONS, session.ID)
+				}
+				return errors.Errorf("refresh token %v reused, session %v logged out", claims.ID, session.ID)
+			}
+
+			result, err := refreshMgmtSession(ctx, apiSecret, session)
+			if err != nil {
+				return errors.Wrapf(err, "refresh session")
+			}
+
+			ohttp.WriteData(ctx, w, r, result)
+			logger.Tf(ctx, "session refresh ok, %v, token=%vB", session.String(), len(refreshToken))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/token/revoke"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, revoke string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string `json:"token"`
+				Revoke *string `json:"revoke"`
+			}{
+				Token: &token, Revoke: &revoke,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if revoke == "" {
+				return errors.New("no revoke token")
+			}
+
+			claims, err := parseMgmtToken(apiSecret, revoke)
+			if err != nil {
+				return errors.Wrapf(err, "parse revoke token")
+			}
+
+			if err := revokeMgmtToken(ctx, claims); err != nil {
+				return errors.Wrapf(err, "revoke token")
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "token revoke ok, id=%v, sid=%v, token=%vB", mgmtTokenID(claims), claims.SessionID, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/logout"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if token == "" {
+				return errors.New("no token")
+			}
+
+			claims, err := parseMgmtToken(apiSecret, token)
+			if err != nil {
+				return errors.Wrapf(err, "parse token")
+			}
+
+			// Logout the session, or revoke the legacy token which has no session.
+			if claims.SessionID != "" {
+				if err := rdb.HDel(ctx, SRS_MGMT_SESSIONS, claims.SessionID).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hdel %v %v", SRS_MGMT_SESSIONS, claims.SessionID)
+				}
+			} else if err := revokeMgmtToken(ctx, claims); err != nil {
+				return errors.Wrapf(err, "revoke token")
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "logout ok, id=%v, sid=%v, token=%vB", mgmtTokenID(claims), claims.SessionID, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/logout-all"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			// Revoke all tokens issued before now, including the legacy tokens. Note that the bearer
+			// secret is not affected, so the integrations still work.
+			revokeBefore := time.Now().Unix() + 1
+			if err := rdb.HSet(ctx, SRS_AUTH_SECRET, "revokeBefore", revokeBefore).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v revokeBefore %v", SRS_AUTH_SECRET, revokeBefore)
+			}
+			if err := rdb.Del(ctx, SRS_MGMT_SESSIONS).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "del %v", SRS_MGMT_SESSIONS)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "logout all ok, revokeBefore=%v, token=%vB", revokeBefore, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/sessions/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			var current string
+			if claims, err := parseMgmtToken(apiSecret, token); err == nil {
+				current = claims.SessionID
+			}
+
+			configs, err := rdb.HGetAll(ctx, SRS_MGMT_SESSIONS).Result()
+			if err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hgetall %v", SRS_MGMT_SESSIONS)
+			}
+
+			type MgmtSessionStatus struct {
+				MgmtSession
+				// Whether it's the session of request.
+				Current bool `json:"current"`
+			}
+
+			sessions := []*MgmtSessionStatus{}
+			for sid, config := range configs {
+				var session MgmtSessionStatus
+				if err := json.Unmarshal([]byte(config), &session.MgmtSession); err != nil {
+					return errors.Wrapf(err, "unmarshal %v %v", sid, config)
+				}
+
+				// Cleanup the expired sessions.
+				if expireAt, err := time.Parse(time.RFC3339, session.ExpireAt); err == nil && expireAt.Before(time.Now()) {
+					if err := rdb.HDel(ctx, SRS_MGMT_SESSIONS, sid).Err(); err != nil && err != redis.Nil {
+						return errors.Wrapf(err, "hdel %v %v", SRS_MGMT_SESSIONS, sid)
+					}
+					continue
+				}
+
+				session.Current = sid == current
+				sessions = append(sessions, &session)
+			}
+
+			sort.Slice(sessions, func(i, j int) bool {
+				return sessions[i].RefreshAt > sessions[j].RefreshAt
+			})
+
+			ohttp.WriteData(ctx, w, r, sessions)
+			logger.Tf(ctx, "sessions query ok, sessions=%v, token=%vB", len(sessions), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/sessions/remove"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, sid string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				SID   *string `json:"sid"`
+			}{
+				Token: &token, SID: &sid,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if sid == "" {
+				return errors.New("no sid")
+			}
+
+			if err := rdb.HDel(ctx, SRS_MGMT_SESSIONS, sid).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hdel %v %v", SRS_MGMT_SESSIONS, sid)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "sessions remove ok, sid=%v, token=%vB", sid, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
+
+// mgmtSessionActor identify the session or token of request, for audit log. The user is the subject of
+// session, for example, the email of SSO user, or empty if unknown.
+func mgmtSessionActor(claims *MgmtTokenClaims, user string) string {
+	if claims.SessionID != "" && user != "" {
+		return fmt.Sprintf("%v/session(%v)", user, claims.SessionID)
+	}
+	if claims.SessionID != "" {
+		return fmt.Sprintf("session(%v)", claims.SessionID)
+	}
+	return fmt.Sprintf("token(%v)", mgmtTokenID(claims))
+}
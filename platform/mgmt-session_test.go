@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMgmtSession_ParseToken(t *testing.T) {
+	ctx := context.Background()
+	apiSecret := "0123456789abcdef"
+
+	_, _, token, err := createToken(ctx, apiSecret, mgmtTokenKindRefresh, "sid-1", mgmtRoleViewer, time.Minute)
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+
+	if claims, err := parseMgmtToken(apiSecret, token); err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if claims.Kind != mgmtTokenKindRefresh || claims.SessionID != "sid-1" || claims.ID == "" || claims.Role != mgmtRoleViewer {
+		t.Errorf("Fail for claims kind=%v, sid=%v, id=%v, role=%v", claims.Kind, claims.SessionID, claims.ID, claims.Role)
+	} else if actor := mgmtSessionActor(claims, ""); actor != "session(sid-1)" {
+		t.Errorf("Fail for actor %v", actor)
+	} else if actor := mgmtSessionActor(claims, "alice@example.com"); actor != "alice@example.com/session(sid-1)" {
+		t.Errorf("Fail for actor %v", actor)
+	}
+
+	if _, err := parseMgmtToken("invalid", token); err == nil {
+		t.Errorf("token signed by other secret should fail")
+	}
+
+	_, _, expired, err := createToken(ctx, apiSecret, mgmtTokenKindAccess, "sid-1", "", -time.Minute)
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if _, err := parseMgmtToken(apiSecret, expired); err == nil {
+		t.Errorf("expired token should fail")
+	}
+}
+
+func TestMgmtSession_AuthorizeRole(t *testing.T) {
+	for _, e := range []struct {
+		role     string
+		method   string
+		endpoint string
+		allowed  bool
+	}{
+		{role: mgmtRoleAdmin, method: "POST", endpoint: "/terraform/v1/mgmt/secrets/rotate", allowed: true},
+		{role: mgmtRoleViewer, method: "POST", endpoint: "/terraform/v1/mgmt/streams/query", allowed: true},
+		{role: mgmtRoleViewer, method: "POST", endpoint: "/terraform/v1/mgmt/secret/query", allowed: false},
+		{role: mgmtRoleViewer, method: "POST", endpoint: "/terraform/v1/ffmpeg/camera/ptz/move", allowed: false},
+		{role: mgmtRoleViewer, method: "GET", endpoint: "/terraform/v1/ai-talk/stage/hello-voices/xxx.aac", allowed: false},
+		{role: mgmtRoleViewer, method: "GET", endpoint: "/api/v1/streams/", allowed: true},
+		{role: mgmtRoleViewer, method: "DELETE", endpoint: "/api/v1/clients/123", allowed: false},
+		{role: mgmtRoleViewer, method: "GET", endpoint: "/api/v1/raw", allowed: false},
+	} {
+		if err := authorizeMgmtRole(e.role, e.method, e.endpoint); (err == nil) != e.allowed {
+			t.Errorf("Fail for %v %v %v, expect %v, err %v", e.role, e.method, e.endpoint, e.allowed, err)
+		}
+	}
+}
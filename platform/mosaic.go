@@ -0,0 +1,1088 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// The max number of inputs of a mosaic.
+const mosaicMaxInputs = 16
+
+// The interval to check the inputs, switch the cell if any input is up or down.
+const mosaicCheckInterval = 10 * time.Second
+
+// The timeout of input frames, to show the placeholder of cell when the input is stuck.
+const mosaicFeedTimeout = 3 * time.Second
+
+// The type of mosaic input.
+const (
+	// The stream in SRS, the source is the stream url, for example, live/livestream
+	MosaicInputTypeStream = "stream"
+	// The IP camera, the source is the camera platform.
+	MosaicInputTypeCamera = "camera"
+)
+
+var mosaicWorker *MosaicWorker
+
+type MosaicWorker struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// The mosaic tasks, key is uuid in string, value is *MosaicTask.
+	tasks sync.Map
+}
+
+func NewMosaicWorker() *MosaicWorker {
+	return &MosaicWorker{}
+}
+
+func (v *MosaicWorker) GetTask(uuid string) *MosaicTask {
+	if task, loaded := v.tasks.Load(uuid); loaded {
+		return task.(*MosaicTask)
+	}
+	return nil
+}
+
+func (v *MosaicWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/ffmpeg/mosaic/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			configs, err := rdb.HGetAll(ctx, SRS_MOSAIC_CONFIG).Result()
+			if err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hgetall %v", SRS_MOSAIC_CONFIG)
+			}
+
+			type MosaicQueryTask struct {
+				// The FFmpeg pid, 0 if not running.
+				PID int32 `json:"pid"`
+				// The inputs which are available, others are placeholders.
+				Inputs []string `json:"inputs"`
+				// The output stream URL.
+				Output string `json:"output"`
+				// The FFmpeg log.
+				Frame struct {
+					// The FFmpeg log lines.
+					Log string `json:"log"`
+					// The last update time.
+					Update string `json:"update"`
+				} `json:"frame"`
+			}
+			type MosaicQueryResult struct {
+				*MosaicConfig
+				Task *MosaicQueryTask `json:"task,omitempty"`
+			}
+
+			res := []*MosaicQueryResult{}
+			for id, b := range configs {
+				var config MosaicConfig
+				if err := json.Unmarshal([]byte(b), &config); err != nil {
+					return errors.Wrapf(err, "unmarshal %v %v", id, b)
+				}
+
+				result := &MosaicQueryResult{MosaicConfig: &config}
+				if task := v.GetTask(id); task != nil {
+					pid, inputs, output, frame, update := task.queryFrame()
+					if pid > 0 {
+						result.Task = &MosaicQueryTask{PID: pid, Inputs: inputs, Output: output}
+						result.Task.Frame.Log, result.Task.Frame.Update = frame, update
+					}
+				}
+				res = append(res, result)
+			}
+
+			ohttp.WriteData(ctx, w, r, res)
+			logger.Tf(ctx, "mosaic: Query ok, mosaics=%v, token=%vB", len(res), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/mosaic/update"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var config MosaicConfig
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				*MosaicConfig
+			}{
+				Token: &token, MosaicConfig: &config,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if config.UUID == "" {
+				config.UUID = uuid.NewString()
+			}
+			config.Initialize()
+			if err := config.Validate(); err != nil {
+				return errors.Wrapf(err, "validate %v", config.String())
+			}
+
+			if b, err := json.Marshal(config); err != nil {
+				return errors.Wrapf(err, "marshal %v", config.String())
+			} else if err = rdb.HSet(ctx, SRS_MOSAIC_CONFIG, config.UUID, string(b)).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v %v %v", SRS_MOSAIC_CONFIG, config.UUID, string(b))
+			}
+
+			// Restart the task to apply the configure, or the task is created by worker.
+			if task := v.GetTask(config.UUID); task != nil {
+				task.Restart(ctx)
+			}
+
+			ohttp.WriteData(ctx, w, r, &config)
+			logger.Tf(ctx, "mosaic: Update ok, %v, token=%vB", config.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/mosaic/remove"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, uuid string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				UUID  *string `json:"uuid"`
+			}{
+				Token: &token, UUID: &uuid,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if uuid == "" {
+				return errors.New("no uuid")
+			}
+
+			if err := rdb.HDel(ctx, SRS_MOSAIC_CONFIG, uuid).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hdel %v %v", SRS_MOSAIC_CONFIG, uuid)
+			}
+
+			// The task quits when configure is removed.
+			if task := v.GetTask(uuid); task != nil {
+				task.Restart(ctx)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "mosaic: Remove ok, uuid=%v, token=%vB", uuid, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
+
+func (v *MosaicWorker) Close() error {
+	if v.cancel != nil {
+		v.cancel()
+	}
+	v.wg.Wait()
+	return nil
+}
+
+func (v *MosaicWorker) Start(ctx context.Context) error {
+	wg := &v.wg
+
+	ctx, cancel := context.WithCancel(ctx)
+	v.cancel = cancel
+
+	ctx = logger.WithContext(ctx)
+	logger.Tf(ctx, "mosaic: start a worker")
+
+	// Load tasks from redis and force to kill all.
+	if objs, err := rdb.HGetAll(ctx, SRS_MOSAIC_TASK).Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_MOSAIC_TASK)
+	} else if len(objs) > 0 {
+		for uuid, obj := range objs {
+			logger.Tf(ctx, "Load task %v object %v", uuid, obj)
+
+			var task MosaicTask
+			if err = json.Unmarshal([]byte(obj), &task); err != nil {
+				return errors.Wrapf(err, "unmarshal %v %v", uuid, obj)
+			}
+
+			if task.PID > 0 {
+				task.cleanup(ctx)
+			}
+		}
+
+		if err = rdb.Del(ctx, SRS_MOSAIC_TASK).Err(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "del %v", SRS_MOSAIC_TASK)
+		}
+	}
+
+	// Create tasks for new configurations, the task quits itself when configure is removed.
+	loadTasks := func() error {
+		configs, err := rdb.HGetAll(ctx, SRS_MOSAIC_CONFIG).Result()
+		if err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hgetall %v", SRS_MOSAIC_CONFIG)
+		}
+
+		for id := range configs {
+			tv, loaded := v.tasks.LoadOrStore(id, &MosaicTask{UUID: id, mosaicWorker: v})
+			if loaded {
+				continue
+			}
+
+			task := tv.(*MosaicTask)
+			logger.Tf(ctx, "mosaic: create task %v", task.String())
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				if err := task.Run(ctx); err != nil {
+					logger.Wf(ctx, "run task %v err %+v", task.String(), err)
+				}
+			}()
+		}
+
+		return nil
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		// When startup, we try to wait for client to publish streams.
+		select {
+		case <-ctx.Done():
+		case <-time.After(3 * time.Second):
+		}
+		logger.Tf(ctx, "mosaic: Start to run tasks")
+
+		for ctx.Err() == nil {
+			duration := 3 * time.Second
+			if err := loadTasks(); err != nil {
+				logger.Wf(ctx, "ignore err %+v", err)
+				duration = 10 * time.Second
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(duration):
+			}
+		}
+	}()
+
+	return nil
+}
+
+// MosaicConfig is the composite of streams and cameras, in a grid or custom layout, published as a new
+// stream.
+type MosaicConfig struct {
+	// The ID of mosaic.
+	UUID string `json:"uuid"`
+	// The label for this mosaic.
+	Label string `json:"label"`
+	// Whether enabled.
+	Enabled bool `json:"enabled"`
+	// The RTMP server url, for example, rtmp://localhost/live
+	Server string `json:"server"`
+	// The RTMP stream and secret, for example, mosaic
+	Secret string `json:"secret"`
+
+	// The size of output video.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// The frame rate of output video.
+	Fps int `json:"fps"`
+	// The bitrate of output video in kbps.
+	Bitrate int `json:"bitrate"`
+
+	// The grid layout, the number of columns and rows.
+	Columns int `json:"columns,omitempty"`
+	Rows    int `json:"rows,omitempty"`
+	// The custom layout, use the grid if empty.
+	Cells []*MosaicCell `json:"cells,omitempty"`
+
+	// The inputs, placed in cells by order.
+	Inputs []*MosaicInput `json:"inputs"`
+}
+
+func (v *MosaicConfig) String() string {
+	return fmt.Sprintf("uuid=%v, label=%v, enabled=%v, server=%v, secret=%vB, size=%vx%v, fps=%v, bitrate=%v, grid=%vx%v, cells=%v, inputs=%v",
+		v.UUID, v.Label, v.Enabled, v.Server, len(v.Secret), v.Width, v.Height, v.Fps, v.Bitrate,
+		v.Columns, v.Rows, len(v.Cells), len(v.Inputs),
+	)
+}
+
+func (v *MosaicConfig) Initialize() {
+	if v.Server == "" {
+		v.Server = "rtmp://localhost/live"
+	}
+	if v.Width == 0 || v.Height == 0 {
+		v.Width, v.Height = 1280, 720
+	}
+	if v.Fps == 0 {
+		v.Fps = 25
+	}
+	if v.Bitrate == 0 {
+		v.Bitrate = 2000
+	}
+
+	// Use the smallest square grid for inputs, for example, 2x2 for 3 or 4 inputs.
+	if len(v.Cells) == 0 && len(v.Inputs) > 0 {
+		if v.Columns == 0 {
+			v.Columns = int(math.Ceil(math.Sqrt(float64(len(v.Inputs)))))
+		}
+		if v.Rows == 0 {
+			v.Rows = (len(v.Inputs) + v.Columns - 1) / v.Columns
+		}
+	}
+}
+
+func (v *MosaicConfig) Validate() error {
+	if v.UUID == "" {
+		return errors.New("no uuid")
+	}
+	if v.Secret == "" {
+		return errors.New("no secret")
+	}
+	if v.Width < 160 || v.Width > 3840 || v.Height < 90 || v.Height > 2160 {
+		return errors.Errorf("invalid size %vx%v", v.Width, v.Height)
+	}
+	if v.Fps < 1 || v.Fps > 60 {
+		return errors.Errorf("invalid fps %v", v.Fps)
+	}
+	if v.Bitrate < 100 || v.Bitrate > 20000 {
+		return errors.Errorf("invalid bitrate %v", v.Bitrate)
+	}
+
+	if len(v.Inputs) == 0 || len(v.Inputs) > mosaicMaxInputs {
+		return errors.Errorf("invalid inputs %v, should in [1, %v]", len(v.Inputs), mosaicMaxInputs)
+	}
+	output := v.outputStream()
+	for i, input := range v.Inputs {
+		if err := input.Validate(); err != nil {
+			return errors.Wrapf(err, "input #%v", i)
+		}
+		if input.Type == MosaicInputTypeStream && input.Source == output {
+			return errors.Errorf("input #%v is the output %v", i, output)
+		}
+	}
+
+	if len(v.Cells) > 0 {
+		if len(v.Cells) < len(v.Inputs) {
+			return errors.Errorf("no cell for inputs, cells=%v, inputs=%v", len(v.Cells), len(v.Inputs))
+		}
+		for i, cell := range v.Cells {
+			if err := cell.Validate(); err != nil {
+				return errors.Wrapf(err, "cell #%v", i)
+			}
+		}
+	} else if v.Columns < 1 || v.Rows < 1 || v.Columns*v.Rows < len(v.Inputs) {
+		return errors.Errorf("no cell for inputs, grid=%vx%v, inputs=%v", v.Columns, v.Rows, len(v.Inputs))
+	}
+
+	return nil
+}
+
+// outputStream get the stream url of output, for example, live/mosaic
+func (v *MosaicConfig) outputStream() string {
+	u, err := url.Parse(v.Server)
+	if err != nil {
+		return ""
+	}
+
+	stream := strings.SplitN(v.Secret, "?", 2)[0]
+	return path.Join(strings.Trim(u.Path, "/"), strings.Trim(stream, "/"))
+}
+
+// rects get the regions of inputs in pixels, aligned to even for YUV420.
+func (v *MosaicConfig) rects() []*mosaicRect {
+	even := func(v float64) int {
+		return int(math.Round(v)) / 2 * 2
+	}
+
+	var rects []*mosaicRect
+	if len(v.Cells) > 0 {
+		for _, cell := range v.Cells {
+			rects = append(rects, &mosaicRect{
+				X: even(cell.X * float64(v.Width)), Y: even(cell.Y * float64(v.Height)),
+				Width: even(cell.Width * float64(v.Width)), Height: even(cell.Height * float64(v.Height)),
+			})
+		}
+		return rects
+	}
+
+	width, height := even(float64(v.Width)/float64(v.Columns)), even(float64(v.Height)/float64(v.Rows))
+	for row := 0; row < v.Rows; row++ {
+		for column := 0; column < v.Columns; column++ {
+			rects = append(rects, &mosaicRect{
+				X: column * width, Y: row * height, Width: width, Height: height,
+			})
+		}
+	}
+	return rects
+}
+
+// MosaicCell is a region of custom layout, in ratio of the output, for example, x=0.5 is the center.
+type MosaicCell struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+func (v *MosaicCell) Validate() error {
+	if v.X < 0 || v.Y < 0 || v.Width <= 0 || v.Height <= 0 {
+		return errors.Errorf("invalid region x=%v, y=%v, width=%v, height=%v", v.X, v.Y, v.Width, v.Height)
+	}
+	if v.X+v.Width > 1 || v.Y+v.Height > 1 {
+		return errors.Errorf("out of output x=%v, y=%v, width=%v, height=%v", v.X, v.Y, v.Width, v.Height)
+	}
+	return nil
+}
+
+// MosaicInput is a stream or camera of mosaic.
+type MosaicInput struct {
+	// The type of input, stream or camera.
+	Type string `json:"type"`
+	// The stream url for stream, for example, live/livestream, or the platform for camera.
+	Source string `json:"source"`
+	// The label to show, supports {clock} and {date}, no label if empty.
+	Label string `json:"label,omitempty"`
+}
+
+func (v *MosaicInput) Validate() error {
+	if v.Type != MosaicInputTypeStream && v.Type != MosaicInputTypeCamera {
+		return errors.Errorf("invalid type %v", v.Type)
+	}
+	if v.Source == "" {
+		return errors.New("no source")
+	}
+	return nil
+}
+
+type mosaicRect struct {
+	X, Y, Width, Height int
+}
+
+// mosaicSource is the resolved input, the url is empty if not available.
+type mosaicSource struct {
+	URL   string
+	Label string
+}
+
+// resolveMosaicSources get the url of inputs, which is empty if the stream is not publishing, or the
+// camera is not streaming.
+func resolveMosaicSources(ctx context.Context, inputs []*MosaicInput) ([]*mosaicSource, error) {
+	var sources []*mosaicSource
+	for _, input := range inputs {
+		source := &mosaicSource{Label: input.Label}
+		sources = append(sources, source)
+
+		if input.Type == MosaicInputTypeCamera {
+			if task := cameraWorker.GetTask(input.Source); task != nil {
+				if target, ok := task.queryInput(); ok {
+					source.URL = target
+				}
+			}
+			continue
+		}
+
+		b, err := rdb.HGet(ctx, SRS_STREAM_ACTIVE, input.Source).Result()
+		if err != nil && err != redis.Nil {
+			return nil, errors.Wrapf(err, "hget %v %v", SRS_STREAM_ACTIVE, input.Source)
+		} else if b == "" {
+			continue
+		}
+
+		var stream SrsStream
+		if err := json.Unmarshal([]byte(b), &stream); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v", b)
+		}
+
+		source.URL = fmt.Sprintf("rtmp://localhost/%v/%v", stream.App, stream.Stream)
+		if stream.Vhost != "" && stream.Vhost != "__defaultVhost__" {
+			source.URL += fmt.Sprintf("?vhost=%v", stream.Vhost)
+		}
+	}
+	return sources, nil
+}
+
+// mosaicSignature identify the available inputs, to switch the cells when changed.
+func mosaicSignature(sources []*mosaicSource) string {
+	var urls []string
+	for _, source := range sources {
+		urls = append(urls, source.URL)
+	}
+	return strings.Join(urls, "|")
+}
+
+// buildMosaicArgs build the FFmpeg arguments, which overlays the cells on a black canvas. Each cell is a raw
+// video in pipe, fed by mosaicFeed, and the placeholder is drawn below the cell, which is shown when the
+// frame of cell is transparent. The prefix identify the text files of task, for example, mosaic-xxx, and
+// the output to this server is signed by the publish token key, see signPublishURL.
+func buildMosaicArgs(config *MosaicConfig, prefix, key string) ([]string, string, error) {
+	// The canvas is driven by the cells, which are fed in realtime.
+	args := []string{
+		"-f", "lavfi", "-i", fmt.Sprintf("color=c=black:s=%vx%v:r=%v", config.Width, config.Height, config.Fps),
+		"-f", "lavfi", "-i", "anullsrc=channel_layout=stereo:sample_rate=44100",
+	}
+
+	placeholder := overlayFile(prefix, "placeholder.txt")
+	if err := writeOverlayText(placeholder, "No Signal"); err != nil {
+		return nil, "", errors.Wrapf(err, "write placeholder")
+	}
+
+	rects := config.rects()
+	background := []string{}
+	for i := range config.Inputs {
+		rect := rects[i]
+		background = append(background,
+			fmt.Sprintf("drawbox=x=%v:y=%v:w=%v:h=%v:color=0x303030:t=fill", rect.X, rect.Y, rect.Width, rect.Height),
+			drawtextFilter(placeholder, "", 32, "gray", "",
+				fmt.Sprintf("%v+(%v-tw)/2", rect.X, rect.Width), fmt.Sprintf("%v+(%v-th)/2", rect.Y, rect.Height),
+			),
+		)
+
+		// The pipe of cell is the extra file of FFmpeg, which starts from fd 3.
+		args = append(args, "-f", "rawvideo", "-pix_fmt", "yuva420p",
+			"-s", fmt.Sprintf("%vx%v", rect.Width, rect.Height), "-r", fmt.Sprintf("%v", config.Fps),
+			"-i", fmt.Sprintf("pipe:%v", 3+i),
+		)
+	}
+
+	graph, chain := []string{fmt.Sprintf("[0:v]%v[bg]", strings.Join(background, ","))}, "[bg]"
+	for i, input := range config.Inputs {
+		rect := rects[i]
+
+		filter := fmt.Sprintf("%v[%v:v]overlay=x=%v:y=%v", chain, 2+i, rect.X, rect.Y)
+		if input.Label != "" {
+			file := overlayFile(prefix, fmt.Sprintf("label-%v.txt", i))
+			if err := writeOverlayText(file, expandOverlayText(input.Label, input.Label)); err != nil {
+				return nil, "", errors.Wrapf(err, "write label #%v", i)
+			}
+			filter += "," + drawtextFilter(file, "", 24, "white", "black@0.5",
+				fmt.Sprintf("%v+16", rect.X), fmt.Sprintf("%v+%v-th-16", rect.Y, rect.Height),
+			)
+		}
+
+		output := fmt.Sprintf("[base%v]", i)
+		if i == len(config.Inputs)-1 {
+			output = "[vout]"
+		}
+		graph = append(graph, filter+output)
+		chain = output
+	}
+
+	args = append(args, "-filter_complex", strings.Join(graph, ";"), "-map", "[vout]", "-map", "1:a",
+		"-c:v", "libx264", "-preset", "veryfast", "-tune", "zerolatency",
+		"-b:v", fmt.Sprintf("%vk", config.Bitrate), "-r", fmt.Sprintf("%v", config.Fps),
+		"-g", fmt.Sprintf("%v", config.Fps*2), "-bf", "0",
+		"-c:a", "aac", "-ac", "2", "-ar", "44100", "-b:a", "20k",
+	)
+
+	outputURL, outputArgs := buildEgressOutput(config.Server, config.Secret, nil)
+	publishURL, err := signPublishURL(key, outputURL)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "sign %v", outputURL)
+	}
+	args = append(args, outputArgs...)
+	args = append(args, publishURL)
+	return args, outputURL, nil
+}
+
+// mosaicFeed feed a cell of composite with raw video frames in realtime. The frame is the latest frame of
+// input, or transparent to show the placeholder when the input is not available, so the composite keeps
+// running when any input is up or down, and only the cell is switched.
+type mosaicFeed struct {
+	// The index of cell.
+	index int
+	// The size of cell, and fps of composite.
+	width, height, fps int
+
+	// The url of input, empty if not available.
+	url string
+	// The latest frame of input, nil if not available.
+	frame []byte
+	// The time of latest frame, to show the placeholder when input is stuck.
+	update time.Time
+	// Stop pulling the input.
+	cancel context.CancelFunc
+
+	// To protect the fields.
+	lock sync.Mutex
+}
+
+func NewMosaicFeed(index int, rect *mosaicRect, fps int) *mosaicFeed {
+	return &mosaicFeed{index: index, width: rect.Width, height: rect.Height, fps: fps}
+}
+
+// frameSize get the bytes of a frame in yuva420p, the alpha is a full plane as the luma.
+func (v *mosaicFeed) frameSize() int {
+	return v.width * v.height * 5 / 2
+}
+
+// latest get the latest frame of input, or the transparent frame if not available or stuck.
+func (v *mosaicFeed) latest(transparent []byte) []byte {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.frame == nil || time.Since(v.update) > mosaicFeedTimeout {
+		return transparent
+	}
+	return v.frame
+}
+
+// Write the frames to the pipe of composite in realtime, until the context is done or the pipe is broken.
+func (v *mosaicFeed) Write(ctx context.Context, w io.Writer) error {
+	// All zero is transparent, because the alpha is zero.
+	transparent := make([]byte, v.frameSize())
+
+	ticker := time.NewTicker(time.Second / time.Duration(v.fps))
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.Write(v.latest(transparent)); err != nil {
+			return errors.Wrapf(err, "write cell #%v", v.index)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Switch the input of cell, stop pulling the previous input, and pull the new one if not empty. Return
+// true if the input is changed.
+func (v *mosaicFeed) Switch(ctx context.Context, input string) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.url == input {
+		return false
+	}
+
+	if v.cancel != nil {
+		v.cancel()
+		v.cancel = nil
+	}
+	v.url, v.frame = input, nil
+
+	if input != "" {
+		ctx, cancel := context.WithCancel(ctx)
+		v.cancel = cancel
+		go v.pull(ctx, input)
+	}
+	return true
+}
+
+// pull the input by FFmpeg, scale to the size of cell, and keep the latest frame. Retry when FFmpeg quits,
+// until the input is switched.
+func (v *mosaicFeed) pull(ctx context.Context, input string) {
+	for ctx.Err() == nil {
+		if err := v.pullOnce(ctx, input); err != nil && ctx.Err() == nil {
+			logger.Wf(ctx, "mosaic: Ignore cell #%v input=%v err %+v", v.index, input, err)
+		}
+
+		v.lock.Lock()
+		v.frame = nil
+		v.lock.Unlock()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+func (v *mosaicFeed) pullOnce(ctx context.Context, input string) error {
+	var args []string
+	if strings.HasPrefix(input, "rtsp://") {
+		args = append(args, "-rtsp_transport", "tcp")
+	}
+	// Rebuild the stream url, because it may contain special characters.
+	if u, err := RebuildStreamURL(input); err != nil {
+		return errors.Wrapf(err, "rebuild %v", input)
+	} else {
+		args = append(args, "-i", u.String())
+	}
+
+	// Keep the aspect ratio, and convert to the raw frames of cell.
+	args = append(args, "-vf", fmt.Sprintf("scale=%v:%v:force_original_aspect_ratio=decrease,pad=%v:%v:(ow-iw)/2:(oh-ih)/2,setsar=1,format=yuva420p",
+		v.width, v.height, v.width, v.height,
+	), "-r", fmt.Sprintf("%v", v.fps), "-an", "-f", "rawvideo", "-pix_fmt", "yuva420p", "pipe:1")
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Wrapf(err, "pipe process")
+	}
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "execute ffmpeg %v", strings.Join(args, " "))
+	}
+	logger.Tf(ctx, "mosaic: Pull cell #%v, input=%v, pid=%v", v.index, input, cmd.Process.Pid)
+
+	for {
+		frame := make([]byte, v.frameSize())
+		if _, err = io.ReadFull(stdout, frame); err != nil {
+			break
+		}
+
+		v.lock.Lock()
+		v.frame, v.update = frame, time.Now()
+		v.lock.Unlock()
+	}
+
+	cmd.Process.Kill()
+	if werr := cmd.Wait(); werr != nil && err == io.EOF {
+		err = werr
+	}
+	return errors.Wrapf(err, "read cell #%v", v.index)
+}
+
+// MosaicTask is a task for FFmpeg to composite the inputs, with a configure.
+type MosaicTask struct {
+	// The ID for task, also the ID of configure.
+	UUID string `json:"uuid"`
+
+	// The available inputs.
+	Inputs []string `json:"inputs"`
+	// The output url.
+	Output string `json:"output"`
+
+	// FFmpeg pid.
+	PID int32 `json:"pid"`
+	// FFmpeg last frame.
+	frame string
+	// The last update time.
+	update time.Time
+
+	// The context for current task.
+	cancel context.CancelFunc
+
+	// The mosaic worker.
+	mosaicWorker *MosaicWorker
+
+	// To protect the fields.
+	lock sync.Mutex
+}
+
+func (v *MosaicTask) String() string {
+	return fmt.Sprintf("uuid=%v, inputs=%v, output=%v, pid=%v", v.UUID, len(v.Inputs), v.Output, v.PID)
+}
+
+func (v *MosaicTask) Restart(ctx context.Context) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.cancel != nil {
+		v.cancel()
+	}
+
+	return nil
+}
+
+func (v *MosaicTask) Run(ctx context.Context) error {
+	ctx = logger.WithContext(ctx)
+	logger.Tf(ctx, "mosaic: Run task %v", v.String())
+
+	// Return true if the configure is removed.
+	pfn := func(ctx context.Context) (bool, error) {
+		var config MosaicConfig
+		if b, err := rdb.HGet(ctx, SRS_MOSAIC_CONFIG, v.UUID).Result(); err != nil && err != redis.Nil {
+			return false, errors.Wrapf(err, "hget %v %v", SRS_MOSAIC_CONFIG, v.UUID)
+		} else if b == "" {
+			return true, nil
+		} else if err = json.Unmarshal([]byte(b), &config); err != nil {
+			return false, errors.Wrapf(err, "unmarshal %v", b)
+		}
+
+		// Ignore if not enabled.
+		if !config.Enabled {
+			return false, nil
+		}
+
+		sources, err := resolveMosaicSources(ctx, config.Inputs)
+		if err != nil {
+			return false, errors.Wrapf(err, "resolve inputs")
+		}
+
+		if err := v.doMosaic(ctx, &config, sources); err != nil {
+			return false, errors.Wrapf(err, "do mosaic")
+		}
+		return false, nil
+	}
+
+	for ctx.Err() == nil {
+		if removed, err := pfn(ctx); removed {
+			v.mosaicWorker.tasks.Delete(v.UUID)
+			if err := rdb.HDel(ctx, SRS_MOSAIC_TASK, v.UUID).Err(); err != nil && err != redis.Nil {
+				logger.Wf(ctx, "ignore hdel %v %v err %+v", SRS_MOSAIC_TASK, v.UUID, err)
+			}
+			logger.Tf(ctx, "mosaic: Task removed %v", v.String())
+			return nil
+		} else if err != nil {
+			logger.Wf(ctx, "ignore %v err %+v", v.String(), err)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(3500 * time.Millisecond):
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(300 * time.Millisecond):
+		}
+	}
+
+	return nil
+}
+
+func (v *MosaicTask) doMosaic(ctx context.Context, config *MosaicConfig, sources []*mosaicSource) error {
+	// Create context for current task.
+	parentCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	v.cancel = cancel
+
+	// Sign the output by publish token, because the legacy secret may be disabled.
+	key, err := queryPublishTokenKey(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "query key")
+	}
+
+	args, outputURL, err := buildMosaicArgs(config, fmt.Sprintf("mosaic-%v", v.UUID), key)
+	if err != nil {
+		return errors.Wrapf(err, "build args")
+	}
+
+	// Create a heartbeat to poll and manage the status of FFmpeg process.
+	heartbeat := NewFFmpegHeartbeat(cancel)
+
+	// Create the command object.
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	// Create a pipe for each cell, the composite never restarts when any input is up or down, instead the
+	// feed of cell switches between the input and placeholder.
+	var feeds []*mosaicFeed
+	var writers []*os.File
+	defer func() {
+		for _, w := range writers {
+			w.Close()
+		}
+	}()
+	for i, rect := range config.rects()[:len(config.Inputs)] {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return errors.Wrapf(err, "pipe cell #%v", i)
+		}
+		cmd.ExtraFiles, writers = append(cmd.ExtraFiles, r), append(writers, w)
+		feeds = append(feeds, NewMosaicFeed(i, rect, config.Fps))
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return errors.Wrapf(err, "pipe process")
+	}
+
+	err = cmd.Start()
+	for _, r := range cmd.ExtraFiles {
+		r.Close()
+	}
+	if err != nil {
+		return errors.Wrapf(err, "execute ffmpeg %v", strings.Join(args, " "))
+	}
+
+	v.PID = int32(cmd.Process.Pid)
+	v.Output = outputURL
+	v.switchFeeds(ctx, feeds, sources)
+	defer func() {
+		// If we got a PID, sleep for a while, to avoid too fast restart.
+		if v.PID > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(1 * time.Second):
+			}
+		}
+
+		// When canceled, we should still write to redis, so we must not use ctx(which is cancelled).
+		v.cleanup(parentCtx)
+		v.saveTask(parentCtx)
+	}()
+	logger.Tf(ctx, "mosaic: Start, uuid=%v, inputs=%v/%v, output=%v, pid=%v",
+		v.UUID, len(v.Inputs), len(sources), outputURL, v.PID)
+
+	if err := v.saveTask(ctx); err != nil {
+		return errors.Wrapf(err, "save task %v", v.String())
+	}
+
+	// Feed the cells, the composite is gone if any pipe is broken.
+	for i, feed := range feeds {
+		go func(feed *mosaicFeed, w io.Writer) {
+			if err := feed.Write(ctx, w); err != nil {
+				logger.Wf(ctx, "mosaic: Feed err %+v", err)
+				cancel()
+			}
+		}(feed, writers[i])
+	}
+
+	// Pull the latest log frame.
+	heartbeat.Polling(ctx, stderr)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case frame := <-heartbeat.FrameLogs:
+				v.updateFrame(frame)
+			}
+		}
+	}()
+
+	// Switch the cell when its input is up or down, to replace it with or by the placeholder.
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(mosaicCheckInterval):
+			}
+
+			if sources, err := resolveMosaicSources(ctx, config.Inputs); err != nil {
+				logger.Wf(ctx, "mosaic: Ignore resolve inputs err %+v", err)
+			} else if v.switchFeeds(ctx, feeds, sources) {
+				logger.Tf(ctx, "mosaic: Inputs changed, uuid=%v, to=%v", v.UUID, mosaicSignature(sources))
+				if err := v.saveTask(ctx); err != nil {
+					logger.Wf(ctx, "mosaic: Ignore save task %v err %+v", v.String(), err)
+				}
+			}
+		}
+	}()
+
+	// Process terminated, or user cancel the process.
+	select {
+	case <-parentCtx.Done():
+	case <-ctx.Done():
+	case <-heartbeat.PollingCtx.Done():
+	}
+	logger.Tf(ctx, "mosaic: Cycle stopping, uuid=%v, pid=%v", v.UUID, v.PID)
+
+	err = cmd.Wait()
+	logger.Tf(ctx, "mosaic: Cycle done, uuid=%v, pid=%v, err=%v", v.UUID, v.PID, err)
+	return err
+}
+
+// switchFeeds switch the input of each cell, and update the available inputs. Return true if any cell is
+// switched.
+func (v *MosaicTask) switchFeeds(ctx context.Context, feeds []*mosaicFeed, sources []*mosaicSource) bool {
+	var changed bool
+	var inputs []string
+	for i, feed := range feeds {
+		if feed.Switch(ctx, sources[i].URL) {
+			changed = true
+		}
+		if sources[i].URL != "" {
+			inputs = append(inputs, sources[i].URL)
+		}
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.Inputs = inputs
+	return changed
+}
+
+func (v *MosaicTask) updateFrame(frame string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.frame = strings.TrimSpace(frame)
+	v.update = time.Now()
+}
+
+func (v *MosaicTask) queryFrame() (int32, []string, string, string, string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.PID, v.Inputs, v.Output, v.frame, v.update.Format(time.RFC3339)
+}
+
+func (v *MosaicTask) saveTask(ctx context.Context) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	} else if err = rdb.HSet(ctx, SRS_MOSAIC_TASK, v.UUID, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", SRS_MOSAIC_TASK, v.UUID, string(b))
+	}
+
+	return nil
+}
+
+func (v *MosaicTask) cleanup(ctx context.Context) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.PID <= 0 {
+		return nil
+	}
+
+	logger.Wf(ctx, "kill task pid=%v", v.PID)
+	syscall.Kill(int(v.PID), syscall.SIGKILL)
+
+	v.PID = 0
+	v.cancel = nil
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMosaic_Validate(t *testing.T) {
+	inputs := func(n int) []*MosaicInput {
+		var inputs []*MosaicInput
+		for i := 0; i < n; i++ {
+			inputs = append(inputs, &MosaicInput{Type: MosaicInputTypeStream, Source: "live/cam"})
+		}
+		return inputs
+	}
+
+	for _, e := range []struct {
+		config  *MosaicConfig
+		ok      bool
+		columns int
+		rows    int
+	}{
+		{config: &MosaicConfig{Secret: "mosaic", Inputs: inputs(4)}, ok: true, columns: 2, rows: 2},
+		{config: &MosaicConfig{Secret: "mosaic", Inputs: inputs(3)}, ok: true, columns: 2, rows: 2},
+		{config: &MosaicConfig{Secret: "mosaic", Inputs: inputs(5)}, ok: true, columns: 3, rows: 2},
+		{config: &MosaicConfig{Secret: "mosaic", Inputs: inputs(3), Columns: 3}, ok: true, columns: 3, rows: 1},
+		{config: &MosaicConfig{Secret: "mosaic", Inputs: inputs(3), Columns: 1, Rows: 2}, ok: false},
+		{config: &MosaicConfig{Secret: "mosaic", Inputs: inputs(2), Cells: []*MosaicCell{{X: 0, Y: 0, Width: 1, Height: 1}}}, ok: false},
+		{config: &MosaicConfig{Secret: "mosaic", Inputs: inputs(1), Cells: []*MosaicCell{{X: 0.5, Y: 0, Width: 0.6, Height: 1}}}, ok: false},
+		{config: &MosaicConfig{Secret: "mosaic", Inputs: []*MosaicInput{{Type: "file", Source: "a.mp4"}}}, ok: false},
+		{config: &MosaicConfig{Secret: "mosaic"}, ok: false},
+		{config: &MosaicConfig{Inputs: inputs(1)}, ok: false},
+		// The output is one of the inputs.
+		{config: &MosaicConfig{Secret: "cam?secret=xxx", Inputs: inputs(1)}, ok: false},
+	} {
+		e.config.UUID = "mosaic-1"
+		e.config.Initialize()
+		if err := e.config.Validate(); (err == nil) != e.ok {
+			t.Errorf("Fail for %v, expect ok=%v, err %v", e.config.String(), e.ok, err)
+		} else if e.ok && (e.config.Columns != e.columns || e.config.Rows != e.rows) {
+			t.Errorf("Fail for %v, expect %vx%v", e.config.String(), e.columns, e.rows)
+		}
+	}
+}
+
+func TestMosaic_Rects(t *testing.T) {
+	config := &MosaicConfig{Width: 1280, Height: 720, Columns: 3, Rows: 2}
+	rects := config.rects()
+	if len(rects) != 6 {
+		t.Errorf("Fail for rects %v", len(rects))
+	} else if r := rects[4]; r.X != 426 || r.Y != 360 || r.Width != 426 || r.Height != 360 {
+		t.Errorf("Fail for rect %v", *r)
+	}
+
+	// The picture in picture.
+	config.Cells = []*MosaicCell{{X: 0, Y: 0, Width: 1, Height: 1}, {X: 0.7, Y: 0.7, Width: 0.25, Height: 0.25}}
+	rects = config.rects()
+	if len(rects) != 2 {
+		t.Errorf("Fail for rects %v", len(rects))
+	} else if r := rects[1]; r.X != 896 || r.Y != 504 || r.Width != 320 || r.Height != 180 {
+		t.Errorf("Fail for rect %v", *r)
+	}
+}
+
+func TestMosaic_BuildArgs(t *testing.T) {
+	dir := dirVLivePath
+	dirVLivePath = t.TempDir()
+	defer func() {
+		dirVLivePath = dir
+	}()
+
+	config := &MosaicConfig{UUID: "m1", Secret: "mosaic", Inputs: []*MosaicInput{
+		{Type: MosaicInputTypeStream, Source: "live/a", Label: "Hall"},
+		{Type: MosaicInputTypeCamera, Source: "door"},
+		{Type: MosaicInputTypeCamera, Source: "gate"},
+	}}
+	config.Initialize()
+
+	args, output, err := buildMosaicArgs(config, "mosaic-m1", "0123456789abcdef")
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+	if output != "rtmp://localhost/live/mosaic" {
+		t.Errorf("Fail for output %v", output)
+	}
+
+	// All cells are pipes, so the composite keeps running when any input is up or down.
+	line := strings.Join(args, " ")
+	for _, expect := range []string{
+		"-f lavfi -i color=c=black:s=1280x720:r=25 -f lavfi -i anullsrc",
+		"-f rawvideo -pix_fmt yuva420p -s 640x360 -r 25 -i pipe:3",
+		"-s 640x360 -r 25 -i pipe:5",
+		"[0:v]drawbox=x=0:y=0:w=640:h=360:color=0x303030:t=fill,drawtext=textfile=",
+		"x=640+(640-tw)/2:y=0+(360-th)/2",
+		"[bg][2:v]overlay=x=0:y=0,drawtext=",
+		"x=0+16:y=0+360-th-16",
+		"[base0][3:v]overlay=x=640:y=0[base1]",
+		"[base1][4:v]overlay=x=0:y=360[vout]",
+		"-map [vout] -map 1:a",
+		"-f flv rtmp://localhost/live/mosaic?pubToken=",
+	} {
+		if !strings.Contains(line, expect) {
+			t.Errorf("Fail for %v, expect %v", line, expect)
+		}
+	}
+	if strings.Contains(line, "-re ") || strings.Count(line, "drawtext") != 4 {
+		t.Errorf("Fail for %v, expect a label and three placeholders", line)
+	}
+
+	if b, err := os.ReadFile(overlayFile("mosaic-m1", "label-0.txt")); err != nil || string(b) != "Hall" {
+		t.Errorf("Fail for label %v, err %v", string(b), err)
+	}
+
+	// The second input is missing.
+	sources := []*mosaicSource{
+		{URL: "rtmp://localhost/live/a", Label: "Hall"}, {}, {URL: "rtsp://192.168.1.10/stream"},
+	}
+	if mosaicSignature(sources) != "rtmp://localhost/live/a||rtsp://192.168.1.10/stream" {
+		t.Errorf("Fail for signature %v", mosaicSignature(sources))
+	}
+}
+
+func TestMosaic_FeedFrame(t *testing.T) {
+	feed := NewMosaicFeed(0, &mosaicRect{Width: 4, Height: 2}, 25)
+	if feed.frameSize() != 20 {
+		t.Errorf("Fail for size %v", feed.frameSize())
+	}
+
+	transparent := make([]byte, feed.frameSize())
+	frame := bytes.Repeat([]byte{0xff}, feed.frameSize())
+	for _, c := range []struct {
+		frame  []byte
+		update time.Time
+		expect []byte
+	}{
+		{nil, time.Time{}, transparent},
+		{frame, time.Now(), frame},
+		{frame, time.Now().Add(-2 * mosaicFeedTimeout), transparent},
+	} {
+		feed.frame, feed.update = c.frame, c.update
+		if r := feed.latest(transparent); !bytes.Equal(r, c.expect) {
+			t.Errorf("Fail for frame %v, update %v, expect %v, got %v", c.frame, c.update, c.expect, r)
+		}
+	}
+
+	// Write the transparent frames, because no input.
+	feed.frame = nil
+	var b bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := feed.Write(ctx, &b); err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if b.Len() == 0 || b.Len()%feed.frameSize() != 0 || bytes.Count(b.Bytes(), []byte{0}) != b.Len() {
+		t.Errorf("Fail for written %vB", b.Len())
+	}
+}
@@ -0,0 +1,597 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// The state of OIDC login expires if user does not finish login in time.
+const oidcStateExpire = 10 * time.Minute
+
+// OIDCConfig is the config of OpenID Connect provider, for SSO of management console.
+type OIDCConfig struct {
+	// Whether enable SSO login.
+	Enabled bool `json:"enabled"`
+	// The issuer URL, for example, https://accounts.google.com
+	Issuer string `json:"issuer"`
+	// The client registered in IdP.
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+	// The redirect URL registered in IdP, default to the callback of current host.
+	RedirectURL string `json:"redirectUrl"`
+	// The scopes separated by space, default to openid profile email.
+	Scopes string `json:"scopes"`
+	// The claim of groups in ID token, default to groups.
+	GroupsClaim string `json:"groupsClaim"`
+	// Map the group to role, admin or viewer.
+	RoleMapping map[string]string `json:"roleMapping"`
+	// The role if no group matched, empty to reject the user.
+	DefaultRole string `json:"defaultRole"`
+}
+
+func (v *OIDCConfig) String() string {
+	return fmt.Sprintf("enabled=%v, issuer=%v, client=%v, secret=%vB, redirect=%v, scopes=%v, groups=%v, mapping=%v, default=%v",
+		v.Enabled, v.Issuer, v.ClientID, len(v.ClientSecret), v.RedirectURL, v.Scopes, v.GroupsClaim,
+		v.RoleMapping, v.DefaultRole)
+}
+
+// Check the config and set the default values.
+func (v *OIDCConfig) Initialize() error {
+	if v.Scopes == "" {
+		v.Scopes = "openid profile email"
+	}
+	if v.GroupsClaim == "" {
+		v.GroupsClaim = "groups"
+	}
+
+	if !v.Enabled {
+		return nil
+	}
+
+	if v.Issuer == "" {
+		return errors.New("no issuer")
+	}
+	if v.ClientID == "" {
+		return errors.New("no clientId")
+	}
+	for group, role := range v.RoleMapping {
+		if role != mgmtRoleAdmin && role != mgmtRoleViewer {
+			return errors.Errorf("invalid role %v of group %v", role, group)
+		}
+	}
+	if v.DefaultRole != "" && v.DefaultRole != mgmtRoleAdmin && v.DefaultRole != mgmtRoleViewer {
+		return errors.Errorf("invalid default role %v", v.DefaultRole)
+	}
+	return nil
+}
+
+// MapRole get the role of user by groups, the admin wins if user is in multiple groups.
+func (v *OIDCConfig) MapRole(groups []string) (string, error) {
+	var role string
+	for _, group := range groups {
+		if r := v.RoleMapping[group]; r == mgmtRoleAdmin {
+			return r, nil
+		} else if r != "" {
+			role = r
+		}
+	}
+
+	if role == "" {
+		role = v.DefaultRole
+	}
+	if role == "" {
+		return "", errors.Errorf("no role for groups %v", groups)
+	}
+	return role, nil
+}
+
+// loadOIDCConfig load the config from redis.
+func loadOIDCConfig(ctx context.Context) (*OIDCConfig, error) {
+	var config OIDCConfig
+	if b, err := rdb.Get(ctx, SRS_OIDC).Result(); err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "get %v", SRS_OIDC)
+	} else if b != "" {
+		if err := json.Unmarshal([]byte(b), &config); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v", b)
+		}
+	}
+
+	if err := config.Initialize(); err != nil {
+		return nil, errors.Wrapf(err, "init %v", config.String())
+	}
+	return &config, nil
+}
+
+// OIDCProvider is the OpenID Connect provider, discovered from the issuer.
+type OIDCProvider struct {
+	config *OIDCConfig
+
+	// The discovered endpoints.
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	Issuer                string `json:"issuer"`
+}
+
+func NewOIDCProvider(config *OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{config: config}
+}
+
+// oidcGetJSON request the URL and parse the response as JSON.
+func oidcGetJSON(ctx context.Context, target string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return errors.Wrapf(err, "new request %v", target)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "get %v", target)
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrapf(err, "read body")
+	}
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("get %v status %v, body %v", target, res.StatusCode, string(b))
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.Wrapf(err, "unmarshal %v", string(b))
+	}
+	return nil
+}
+
+// Discover the endpoints of provider, by the well-known configuration of issuer.
+func (v *OIDCProvider) Discover(ctx context.Context) error {
+	issuer := strings.TrimSuffix(v.config.Issuer, "/")
+	if err := oidcGetJSON(ctx, issuer+"/.well-known/openid-configuration", v); err != nil {
+		return errors.Wrapf(err, "discover %v", issuer)
+	}
+
+	if strings.TrimSuffix(v.Issuer, "/") != issuer {
+		return errors.Errorf("issuer %v not match %v", v.Issuer, issuer)
+	}
+	if v.AuthorizationEndpoint == "" || v.TokenEndpoint == "" || v.JWKSURI == "" {
+		return errors.Errorf("invalid provider, auth=%v, token=%v, jwks=%v",
+			v.AuthorizationEndpoint, v.TokenEndpoint, v.JWKSURI)
+	}
+	return nil
+}
+
+// AuthURL build the URL to redirect user to login, with PKCE challenge.
+func (v *OIDCProvider) AuthURL(redirectURL, state, nonce, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", v.config.ClientID)
+	q.Set("redirect_uri", redirectURL)
+	q.Set("scope", v.config.Scopes)
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	if strings.Contains(v.AuthorizationEndpoint, "?") {
+		return fmt.Sprintf("%v&%v", v.AuthorizationEndpoint, q.Encode())
+	}
+	return fmt.Sprintf("%v?%v", v.AuthorizationEndpoint, q.Encode())
+}
+
+// Exchange the authorization code for ID token.
+func (v *OIDCProvider) Exchange(ctx context.Context, code, redirectURL, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURL)
+	form.Set("client_id", v.config.ClientID)
+	form.Set("code_verifier", verifier)
+	if v.config.ClientSecret != "" {
+		form.Set("client_secret", v.config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errors.Wrapf(err, "new request %v", v.TokenEndpoint)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "post %v", v.TokenEndpoint)
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", errors.Wrapf(err, "read body")
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", errors.Errorf("exchange status %v, body %v", res.StatusCode, string(b))
+	}
+
+	var idToken string
+	if err := json.Unmarshal(b, &struct {
+		IDToken *string `json:"id_token"`
+	}{
+		IDToken: &idToken,
+	}); err != nil {
+		return "", errors.Wrapf(err, "unmarshal %v", string(b))
+	}
+	if idToken == "" {
+		return "", errors.New("no id_token")
+	}
+	return idToken, nil
+}
+
+// jwksKeys load the RSA public keys of provider, by the kid.
+func (v *OIDCProvider) jwksKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := oidcGetJSON(ctx, v.JWKSURI, &jwks); err != nil {
+		return nil, errors.Wrapf(err, "load jwks")
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range jwks.Keys {
+		if key.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode n of %v", key.Kid)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode e of %v", key.Kid)
+		}
+
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// VerifyIDToken verify the signature and claims of ID token, return the user and groups.
+func (v *OIDCProvider) VerifyIDToken(ctx context.Context, idToken, nonce string) (user string, groups []string, err error) {
+	keys, err := v.jwksKeys(ctx)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "load keys")
+	}
+
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}))
+	if _, err := parser.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := keys[kid]; ok {
+			return key, nil
+		}
+		// Allow no kid if there is only one key.
+		if kid == "" && len(keys) == 1 {
+			for _, key := range keys {
+				return key, nil
+			}
+		}
+		return nil, errors.Errorf("no key %v", kid)
+	}); err != nil {
+		return "", nil, errors.Wrapf(err, "verify id_token")
+	}
+
+	if !claims.VerifyIssuer(v.Issuer, true) {
+		return "", nil, errors.Errorf("invalid issuer %v", claims["iss"])
+	}
+	if !claims.VerifyAudience(v.config.ClientID, true) {
+		return "", nil, errors.Errorf("invalid audience %v", claims["aud"])
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return "", nil, errors.New("no exp")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return "", nil, errors.Errorf("invalid nonce %v", n)
+	}
+
+	// Use email or name as user, which is more readable than sub.
+	for _, key := range []string{"email", "preferred_username", "sub"} {
+		if s, ok := claims[key].(string); ok && s != "" {
+			user = s
+			break
+		}
+	}
+
+	switch vs := claims[v.config.GroupsClaim].(type) {
+	case []interface{}:
+		for _, e := range vs {
+			if s, ok := e.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	case string:
+		groups = strings.Fields(strings.ReplaceAll(vs, ",", " "))
+	}
+
+	return user, groups, nil
+}
+
+// oidcRedirectURL get the redirect URL for callback, by config or the host of request.
+func oidcRedirectURL(config *OIDCConfig, r *http.Request) string {
+	if config.RedirectURL != "" {
+		return config.RedirectURL
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return fmt.Sprintf("%v://%v/terraform/v1/mgmt/oidc/callback", scheme, r.Host)
+}
+
+// OIDCState is the state of login, to verify the callback.
+type OIDCState struct {
+	Nonce       string `json:"nonce"`
+	Verifier    string `json:"verifier"`
+	RedirectURL string `json:"redirectUrl"`
+}
+
+func handleMgmtOIDCService(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/mgmt/oidc/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			// No authentication, because the login page should know whether SSO is enabled.
+			config, err := loadOIDCConfig(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "load config")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				ohttp.WriteData(ctx, w, r, &struct {
+					Enabled bool `json:"enabled"`
+				}{
+					Enabled: config.Enabled,
+				})
+				return nil
+			}
+
+			// Never response the client secret.
+			config.ClientSecret = strings.Repeat("*", len(config.ClientSecret))
+
+			ohttp.WriteData(ctx, w, r, config)
+			logger.Tf(ctx, "oidc query ok, %v, token=%vB", config.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/oidc/update"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var config OIDCConfig
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				*OIDCConfig
+			}{
+				Token: &token, OIDCConfig: &config,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			// Keep the previous secret if not changed, because the query API masks it.
+			if previous, err := loadOIDCConfig(ctx); err != nil {
+				return errors.Wrapf(err, "load config")
+			} else if config.ClientSecret == "" || strings.Trim(config.ClientSecret, "*") == "" {
+				config.ClientSecret = previous.ClientSecret
+			}
+
+			if err := config.Initialize(); err != nil {
+				return errors.Wrapf(err, "init %v", config.String())
+			}
+
+			// Verify the issuer, so user knows the config is wrong immediately.
+			if config.Enabled {
+				if err := NewOIDCProvider(&config).Discover(ctx); err != nil {
+					return errors.Wrapf(err, "discover")
+				}
+			}
+
+			if b, err := json.Marshal(&config); err != nil {
+				return errors.Wrapf(err, "marshal %v", config.String())
+			} else if err := rdb.Set(ctx, SRS_OIDC, string(b), 0).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "set %v %vB", SRS_OIDC, len(b))
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "oidc update ok, %v, token=%vB", config.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/oidc/login"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			config, err := loadOIDCConfig(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "load config")
+			}
+			if !config.Enabled {
+				return errors.New("sso not enabled")
+			}
+
+			provider := NewOIDCProvider(config)
+			if err := provider.Discover(ctx); err != nil {
+				return errors.Wrapf(err, "discover")
+			}
+
+			state := strings.ReplaceAll(uuid.NewString(), "-", "")
+			stateObj := &OIDCState{
+				Nonce:       strings.ReplaceAll(uuid.NewString(), "-", ""),
+				Verifier:    strings.ReplaceAll(uuid.NewString()+uuid.NewString(), "-", ""),
+				RedirectURL: oidcRedirectURL(config, r),
+			}
+
+			stateKey := fmt.Sprintf("%v:%v", SRS_OIDC_STATE, state)
+			if b, err := json.Marshal(stateObj); err != nil {
+				return errors.Wrapf(err, "marshal state")
+			} else if err := rdb.Set(ctx, stateKey, string(b), oidcStateExpire).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "set %v %v", stateKey, string(b))
+			}
+
+			authURL := provider.AuthURL(stateObj.RedirectURL, state, stateObj.Nonce, stateObj.Verifier)
+			http.Redirect(w, r, authURL, http.StatusFound)
+			logger.Tf(ctx, "oidc login redirect to %v, state=%v", provider.AuthorizationEndpoint, state)
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/oidc/callback"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			q := r.URL.Query()
+			if e := q.Get("error"); e != "" {
+				return errors.Errorf("sso error %v, %v", e, q.Get("error_description"))
+			}
+
+			state, code := q.Get("state"), q.Get("code")
+			if state == "" || code == "" {
+				return errors.Errorf("no state or code")
+			}
+
+			// The state is used only once.
+			stateKey := fmt.Sprintf("%v:%v", SRS_OIDC_STATE, state)
+			b, err := rdb.Get(ctx, stateKey).Result()
+			if err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "get %v", stateKey)
+			} else if b == "" {
+				return errors.Errorf("invalid state %v", state)
+			}
+			if err := rdb.Del(ctx, stateKey).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "del %v", stateKey)
+			}
+
+			var stateObj OIDCState
+			if err := json.Unmarshal([]byte(b), &stateObj); err != nil {
+				return errors.Wrapf(err, "unmarshal %v", b)
+			}
+
+			config, err := loadOIDCConfig(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "load config")
+			}
+			if !config.Enabled {
+				return errors.New("sso not enabled")
+			}
+
+			provider := NewOIDCProvider(config)
+			if err := provider.Discover(ctx); err != nil {
+				return errors.Wrapf(err, "discover")
+			}
+
+			idToken, err := provider.Exchange(ctx, code, stateObj.RedirectURL, stateObj.Verifier)
+			if err != nil {
+				return errors.Wrapf(err, "exchange")
+			}
+
+			user, groups, err := provider.VerifyIDToken(ctx, idToken, stateObj.Nonce)
+			if err != nil {
+				return errors.Wrapf(err, "verify")
+			}
+
+			role, err := config.MapRole(groups)
+			if err != nil {
+				return errors.Wrapf(err, "user %v", user)
+			}
+
+			// The valid OIDC login is never limited by the login guard, and resets the failures of client.
+			if err := NewLoginGuard(httpClientIP(r)).OnSuccess(ctx); err != nil {
+				return errors.Wrapf(err, "login success")
+			}
+
+			apiSecret := envApiSecret()
+			result, err := createMgmtSession(ctx, r, apiSecret, user, role)
+			if err != nil {
+				return errors.Wrapf(err, "create session")
+			}
+
+			// Redirect to console with tokens in fragment, which is never sent to server.
+			locale, err := rdb.Get(ctx, SRS_LOCALE).Result()
+			if err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "get %v", SRS_LOCALE)
+			} else if locale == "" {
+				locale = "en"
+			}
+
+			fragment := url.Values{}
+			fragment.Set("token", result.Token)
+			fragment.Set("refreshToken", result.RefreshToken)
+			fragment.Set("sid", result.SessionID)
+			fragment.Set("role", result.Role)
+			fragment.Set("expireAt", result.ExpireAt)
+			http.Redirect(w, r, fmt.Sprintf("/mgmt/%v/routers-login#%v", locale, fragment.Encode()), http.StatusFound)
+
+			logger.Tf(ctx, "oidc login ok, user=%v, groups=%v, role=%v, sid=%v", user, groups, role, result.SessionID)
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// mockOIDCServer is a local OIDC provider, which issues the ID token for any code.
+type mockOIDCServer struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+	nonce    string
+	groups   []string
+	// The verifier received by token endpoint.
+	verifier string
+}
+
+func newMockOIDCServer(t *testing.T, clientID string) *mockOIDCServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Fail for err %+v", err)
+	}
+
+	v := &mockOIDCServer{key: key, clientID: clientID}
+	mux := http.NewServeMux()
+	v.Server = httptest.NewServer(mux)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 v.URL,
+			"authorization_endpoint": v.URL + "/authorize",
+			"token_endpoint":         v.URL + "/token",
+			"jwks_uri":               v.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA", "kid": "k1", "alg": "RS256",
+				"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good-code" || r.FormValue("client_id") != clientID {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		v.verifier = r.FormValue("code_verifier")
+
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "at", "token_type": "Bearer", "id_token": v.sign(t, v.URL, clientID, time.Hour),
+		})
+	})
+	return v
+}
+
+func (v *mockOIDCServer) sign(t *testing.T, issuer, audience string, expire time.Duration) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": issuer, "aud": audience, "sub": "u1", "email": "alice@example.com",
+		"exp": time.Now().Add(expire).Unix(), "iat": time.Now().Unix(),
+		"nonce": v.nonce, "groups": v.groups,
+	})
+	token.Header["kid"] = "k1"
+
+	s, err := token.SignedString(v.key)
+	if err != nil {
+		t.Fatalf("Fail for err %+v", err)
+	}
+	return s
+}
+
+func TestOIDC_LoginFlow(t *testing.T) {
+	ctx := context.Background()
+	server := newMockOIDCServer(t, "oryx")
+	defer server.Close()
+
+	config := &OIDCConfig{
+		Enabled: true, Issuer: server.URL, ClientID: "oryx",
+		RoleMapping: map[string]string{"ops": mgmtRoleAdmin, "dev": mgmtRoleViewer},
+	}
+	if err := config.Initialize(); err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+
+	provider := NewOIDCProvider(config)
+	if err := provider.Discover(ctx); err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+
+	redirectURL := "http://localhost/terraform/v1/mgmt/oidc/callback"
+	authURL := provider.AuthURL(redirectURL, "s1", "n1", "verifier")
+	if u, err := url.Parse(authURL); err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if q := u.Query(); q.Get("state") != "s1" || q.Get("nonce") != "n1" || q.Get("client_id") != "oryx" {
+		t.Errorf("Fail for url %v", authURL)
+	} else if challenge := sha256.Sum256([]byte("verifier")); q.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		t.Errorf("Fail for challenge %v", q.Get("code_challenge"))
+	}
+
+	if _, err := provider.Exchange(ctx, "bad-code", redirectURL, "verifier"); err == nil {
+		t.Errorf("Fail for should fail for bad code")
+	}
+
+	server.nonce, server.groups = "n1", []string{"dev", "ops"}
+	idToken, err := provider.Exchange(ctx, "good-code", redirectURL, "verifier")
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	} else if server.verifier != "verifier" {
+		t.Errorf("Fail for verifier %v", server.verifier)
+	}
+
+	user, groups, err := provider.VerifyIDToken(ctx, idToken, "n1")
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	} else if user != "alice@example.com" || strings.Join(groups, ",") != "dev,ops" {
+		t.Errorf("Fail for user=%v, groups=%v", user, groups)
+	}
+
+	if role, err := config.MapRole(groups); err != nil || role != mgmtRoleAdmin {
+		t.Errorf("Fail for role=%v, err %+v", role, err)
+	}
+
+	// The nonce, issuer, audience and expire must be verified.
+	if _, _, err := provider.VerifyIDToken(ctx, idToken, "n2"); err == nil {
+		t.Errorf("Fail for should fail for nonce")
+	}
+	for _, token := range []string{
+		server.sign(t, "http://evil", "oryx", time.Hour),
+		server.sign(t, server.URL, "other", time.Hour),
+		server.sign(t, server.URL, "oryx", -time.Hour),
+	} {
+		if _, _, err := provider.VerifyIDToken(ctx, token, "n1"); err == nil {
+			t.Errorf("Fail for should fail for %v", token)
+		}
+	}
+}
+
+func TestOIDC_MapRole(t *testing.T) {
+	for _, e := range []struct {
+		mapping     map[string]string
+		defaultRole string
+		groups      []string
+		role        string
+		err         bool
+	}{
+		{mapping: map[string]string{"ops": "admin"}, groups: []string{"ops"}, role: "admin"},
+		{mapping: map[string]string{"dev": "viewer"}, groups: []string{"dev"}, role: "viewer"},
+		{mapping: map[string]string{"dev": "viewer", "ops": "admin"}, groups: []string{"dev", "ops"}, role: "admin"},
+		{mapping: map[string]string{"ops": "admin"}, groups: []string{"dev"}, err: true},
+		{mapping: map[string]string{"ops": "admin"}, defaultRole: "viewer", groups: []string{"dev"}, role: "viewer"},
+		{mapping: nil, defaultRole: "viewer", groups: nil, role: "viewer"},
+	} {
+		config := &OIDCConfig{RoleMapping: e.mapping, DefaultRole: e.defaultRole}
+		if role, err := config.MapRole(e.groups); (err != nil) != e.err || role != e.role {
+			t.Errorf("Fail for %v, expect role=%v, actual role=%v, err %+v", e.groups, e.role, role, err)
+		}
+	}
+}
+
+func TestOIDC_ConfigInitialize(t *testing.T) {
+	for _, e := range []struct {
+		config OIDCConfig
+		err    bool
+	}{
+		{config: OIDCConfig{}},
+		{config: OIDCConfig{Enabled: true}, err: true},
+		{config: OIDCConfig{Enabled: true, Issuer: "https://idp"}, err: true},
+		{config: OIDCConfig{Enabled: true, Issuer: "https://idp", ClientID: "c"}},
+		{config: OIDCConfig{Enabled: true, Issuer: "https://idp", ClientID: "c", DefaultRole: "root"}, err: true},
+		{config: OIDCConfig{Enabled: true, Issuer: "https://idp", ClientID: "c", RoleMapping: map[string]string{"g": "root"}}, err: true},
+	} {
+		if err := e.config.Initialize(); (err != nil) != e.err {
+			t.Errorf("Fail for %v, err %+v", e.config.String(), err)
+		}
+	}
+}
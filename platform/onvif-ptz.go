@@ -0,0 +1,601 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The max timeout of continuous move, the camera stops after timeout even no stop request.
+const onvifPTZMaxTimeout = 60 * time.Second
+
+// OnvifPTZVector is the pan, tilt and zoom of PTZ, in the generic space of ONVIF. The omitted axis is
+// not moved.
+type OnvifPTZVector struct {
+	// The pan and tilt, in [-1, 1].
+	Pan  *float64 `json:"pan,omitempty"`
+	Tilt *float64 `json:"tilt,omitempty"`
+	// The zoom, in [-1, 1] for velocity and translation, in [0, 1] for position.
+	Zoom *float64 `json:"zoom,omitempty"`
+}
+
+func (v *OnvifPTZVector) String() string {
+	value := func(p *float64) string {
+		if p == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%v", *p)
+	}
+	return fmt.Sprintf("pan=%v, tilt=%v, zoom=%v", value(v.Pan), value(v.Tilt), value(v.Zoom))
+}
+
+// Validate the vector, the zoom is in [minZoom, 1], the pan and tilt are in [-1, 1].
+func (v *OnvifPTZVector) Validate(minZoom float64) error {
+	if v.Pan == nil && v.Tilt == nil && v.Zoom == nil {
+		return errors.New("no pan, tilt or zoom")
+	}
+	for name, p := range map[string]*float64{"pan": v.Pan, "tilt": v.Tilt} {
+		if p != nil && (*p < -1 || *p > 1) {
+			return errors.Errorf("invalid %v %v, should be in [-1, 1]", name, *p)
+		}
+	}
+	if v.Zoom != nil && (*v.Zoom < minZoom || *v.Zoom > 1) {
+		return errors.Errorf("invalid zoom %v, should be in [%v, 1]", *v.Zoom, minZoom)
+	}
+	return nil
+}
+
+// xml build the PanTilt and Zoom elements, the pan or tilt is 0 if the other is set.
+func (v *OnvifPTZVector) xml() string {
+	var sb strings.Builder
+	if v.Pan != nil || v.Tilt != nil {
+		var pan, tilt float64
+		if v.Pan != nil {
+			pan = *v.Pan
+		}
+		if v.Tilt != nil {
+			tilt = *v.Tilt
+		}
+		sb.WriteString(fmt.Sprintf(`<tt:PanTilt x="%v" y="%v"/>`, pan, tilt))
+	}
+	if v.Zoom != nil {
+		sb.WriteString(fmt.Sprintf(`<tt:Zoom x="%v"/>`, *v.Zoom))
+	}
+	return sb.String()
+}
+
+// OnvifPreset is the PTZ preset of camera.
+type OnvifPreset struct {
+	// The token and name of preset.
+	Token string `json:"token"`
+	Name  string `json:"name"`
+	// The position of preset, nil if unknown.
+	Position *OnvifPTZVector `json:"position,omitempty"`
+}
+
+// ptzSpeed build the speed element, empty if no speed.
+func ptzSpeed(speed *OnvifPTZVector) string {
+	if speed == nil {
+		return ""
+	}
+	return fmt.Sprintf(`<tptz:Speed>%v</tptz:Speed>`, speed.xml())
+}
+
+// PTZContinuousMove move the camera by velocity, until stop or timeout.
+func (v *OnvifClient) PTZContinuousMove(ctx context.Context, profile string, velocity *OnvifPTZVector, timeout time.Duration) error {
+	ptz, err := v.serviceXAddr(ctx, "PTZ")
+	if err != nil {
+		return errors.Wrapf(err, "ptz service")
+	}
+
+	var timeoutElem string
+	if timeout > 0 {
+		timeoutElem = fmt.Sprintf(`<tptz:Timeout>PT%vS</tptz:Timeout>`, timeout.Seconds())
+	}
+
+	var res struct{}
+	if err := v.call(ctx, ptz, fmt.Sprintf(`<tptz:ContinuousMove><tptz:ProfileToken>%v</tptz:ProfileToken>`+
+		`<tptz:Velocity>%v</tptz:Velocity>%v</tptz:ContinuousMove>`, xmlEscape(profile), velocity.xml(), timeoutElem,
+	), &res); err != nil {
+		return errors.Wrapf(err, "continuous move")
+	}
+	return nil
+}
+
+// PTZStop stop the pan, tilt and zoom.
+func (v *OnvifClient) PTZStop(ctx context.Context, profile string) error {
+	ptz, err := v.serviceXAddr(ctx, "PTZ")
+	if err != nil {
+		return errors.Wrapf(err, "ptz service")
+	}
+
+	var res struct{}
+	if err := v.call(ctx, ptz, fmt.Sprintf(`<tptz:Stop><tptz:ProfileToken>%v</tptz:ProfileToken>`+
+		`<tptz:PanTilt>true</tptz:PanTilt><tptz:Zoom>true</tptz:Zoom></tptz:Stop>`, xmlEscape(profile),
+	), &res); err != nil {
+		return errors.Wrapf(err, "stop")
+	}
+	return nil
+}
+
+// PTZAbsoluteMove move the camera to the position.
+func (v *OnvifClient) PTZAbsoluteMove(ctx context.Context, profile string, position, speed *OnvifPTZVector) error {
+	ptz, err := v.serviceXAddr(ctx, "PTZ")
+	if err != nil {
+		return errors.Wrapf(err, "ptz service")
+	}
+
+	var res struct{}
+	if err := v.call(ctx, ptz, fmt.Sprintf(`<tptz:AbsoluteMove><tptz:ProfileToken>%v</tptz:ProfileToken>`+
+		`<tptz:Position>%v</tptz:Position>%v</tptz:AbsoluteMove>`, xmlEscape(profile), position.xml(), ptzSpeed(speed),
+	), &res); err != nil {
+		return errors.Wrapf(err, "absolute move")
+	}
+	return nil
+}
+
+// PTZRelativeMove move the camera by the translation of current position.
+func (v *OnvifClient) PTZRelativeMove(ctx context.Context, profile string, translation, speed *OnvifPTZVector) error {
+	ptz, err := v.serviceXAddr(ctx, "PTZ")
+	if err != nil {
+		return errors.Wrapf(err, "ptz service")
+	}
+
+	var res struct{}
+	if err := v.call(ctx, ptz, fmt.Sprintf(`<tptz:RelativeMove><tptz:ProfileToken>%v</tptz:ProfileToken>`+
+		`<tptz:Translation>%v</tptz:Translation>%v</tptz:RelativeMove>`, xmlEscape(profile), translation.xml(), ptzSpeed(speed),
+	), &res); err != nil {
+		return errors.Wrapf(err, "relative move")
+	}
+	return nil
+}
+
+// PTZPresets get the presets of profile.
+func (v *OnvifClient) PTZPresets(ctx context.Context, profile string) ([]*OnvifPreset, error) {
+	ptz, err := v.serviceXAddr(ctx, "PTZ")
+	if err != nil {
+		return nil, errors.Wrapf(err, "ptz service")
+	}
+
+	type vector struct {
+		X *float64 `xml:"x,attr"`
+		Y *float64 `xml:"y,attr"`
+	}
+	var res struct {
+		Presets []struct {
+			Token   string  `xml:"token,attr"`
+			Name    string  `xml:"Name"`
+			PanTilt *vector `xml:"PTZPosition>PanTilt"`
+			Zoom    *vector `xml:"PTZPosition>Zoom"`
+		} `xml:"Body>GetPresetsResponse>Preset"`
+	}
+	if err := v.call(ctx, ptz, fmt.Sprintf(`<tptz:GetPresets><tptz:ProfileToken>%v</tptz:ProfileToken></tptz:GetPresets>`,
+		xmlEscape(profile),
+	), &res); err != nil {
+		return nil, errors.Wrapf(err, "get presets")
+	}
+
+	var presets []*OnvifPreset
+	for _, p := range res.Presets {
+		preset := &OnvifPreset{Token: p.Token, Name: p.Name}
+		if p.PanTilt != nil || p.Zoom != nil {
+			preset.Position = &OnvifPTZVector{}
+			if p.PanTilt != nil {
+				preset.Position.Pan, preset.Position.Tilt = p.PanTilt.X, p.PanTilt.Y
+			}
+			if p.Zoom != nil {
+				preset.Position.Zoom = p.Zoom.X
+			}
+		}
+		presets = append(presets, preset)
+	}
+	return presets, nil
+}
+
+// PTZGotoPreset move the camera to the preset.
+func (v *OnvifClient) PTZGotoPreset(ctx context.Context, profile, preset string, speed *OnvifPTZVector) error {
+	ptz, err := v.serviceXAddr(ctx, "PTZ")
+	if err != nil {
+		return errors.Wrapf(err, "ptz service")
+	}
+
+	var res struct{}
+	if err := v.call(ctx, ptz, fmt.Sprintf(`<tptz:GotoPreset><tptz:ProfileToken>%v</tptz:ProfileToken>`+
+		`<tptz:PresetToken>%v</tptz:PresetToken>%v</tptz:GotoPreset>`, xmlEscape(profile), xmlEscape(preset), ptzSpeed(speed),
+	), &res); err != nil {
+		return errors.Wrapf(err, "goto preset %v", preset)
+	}
+	return nil
+}
+
+// PTZSetPreset save the current position as preset, create a new preset if no token. Return the token
+// of preset.
+func (v *OnvifClient) PTZSetPreset(ctx context.Context, profile, name, preset string) (string, error) {
+	ptz, err := v.serviceXAddr(ctx, "PTZ")
+	if err != nil {
+		return "", errors.Wrapf(err, "ptz service")
+	}
+
+	var elems string
+	if name != "" {
+		elems += fmt.Sprintf(`<tptz:PresetName>%v</tptz:PresetName>`, xmlEscape(name))
+	}
+	if preset != "" {
+		elems += fmt.Sprintf(`<tptz:PresetToken>%v</tptz:PresetToken>`, xmlEscape(preset))
+	}
+
+	var res struct {
+		Token string `xml:"Body>SetPresetResponse>PresetToken"`
+	}
+	if err := v.call(ctx, ptz, fmt.Sprintf(`<tptz:SetPreset><tptz:ProfileToken>%v</tptz:ProfileToken>%v</tptz:SetPreset>`,
+		xmlEscape(profile), elems,
+	), &res); err != nil {
+		return "", errors.Wrapf(err, "set preset %v", name)
+	}
+	return strings.TrimSpace(res.Token), nil
+}
+
+// loadCameraOnvif find the ONVIF source of camera by UUID, in all camera configurations.
+func loadCameraOnvif(ctx context.Context, uuid string) (string, *CameraConfigure, *FFprobeSource, error) {
+	configItems, err := rdb.HGetAll(ctx, SRS_CAMERA_CONFIG).Result()
+	if err != nil && err != redis.Nil {
+		return "", nil, nil, errors.Wrapf(err, "hgetall %v", SRS_CAMERA_CONFIG)
+	}
+
+	for platform, configItem := range configItems {
+		var config CameraConfigure
+		if err = json.Unmarshal([]byte(configItem), &config); err != nil {
+			return "", nil, nil, errors.Wrapf(err, "unmarshal %v %v", platform, configItem)
+		}
+
+		for _, stream := range config.Streams {
+			if stream.UUID == uuid {
+				return platform, &config, stream, nil
+			}
+		}
+	}
+	return "", nil, nil, errors.Errorf("no camera source %v", uuid)
+}
+
+// newCameraOnvifClient create the ONVIF client of camera source, which should be an ONVIF device.
+func newCameraOnvifClient(ctx context.Context, uuid string) (*OnvifClient, string, error) {
+	_, _, stream, err := loadCameraOnvif(ctx, uuid)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "load %v", uuid)
+	}
+	if stream.Onvif == nil || stream.Onvif.XAddr == "" {
+		return nil, "", errors.Errorf("camera source %v is not onvif", uuid)
+	}
+
+	client := NewOnvifClient(stream.Onvif.XAddr, stream.Onvif.Username, stream.Onvif.Password)
+	if err := client.SyncTime(ctx); err != nil {
+		logger.Wf(ctx, "onvif: Ignore sync time of %v err %+v", stream.Onvif.XAddr, err)
+	}
+	return client, stream.Onvif.Profile, nil
+}
+
+func (v *CameraWorker) handlePTZ(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/ffmpeg/camera/onvif/credential"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, uuid string
+			var onvif OnvifSource
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string `json:"token"`
+				UUID     *string `json:"uuid"`
+				XAddr    *string `json:"xaddr"`
+				Profile  *string `json:"profile"`
+				Username *string `json:"username"`
+				Password *string `json:"password"`
+			}{
+				Token: &token, UUID: &uuid, XAddr: &onvif.XAddr, Profile: &onvif.Profile,
+				Username: &onvif.Username, Password: &onvif.Password,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			platform, config, stream, err := loadCameraOnvif(ctx, uuid)
+			if err != nil {
+				return errors.Wrapf(err, "load %v", uuid)
+			}
+
+			// Keep the device and profile if not specified, for example, only update the password.
+			if stream.Onvif != nil {
+				if onvif.XAddr == "" {
+					onvif.XAddr = stream.Onvif.XAddr
+				}
+				if onvif.Profile == "" {
+					onvif.Profile = stream.Onvif.Profile
+				}
+			}
+			if u, err := url.Parse(onvif.XAddr); err != nil {
+				return errors.Wrapf(err, "parse %v", onvif.XAddr)
+			} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return errors.Errorf("invalid xaddr %v", onvif.XAddr)
+			}
+			if onvif.Profile == "" {
+				return errors.New("no profile")
+			}
+			stream.Onvif = &onvif
+
+			if b, err := json.Marshal(config); err != nil {
+				return errors.Wrapf(err, "marshal %v", config.String())
+			} else if err = rdb.HSet(ctx, SRS_CAMERA_CONFIG, platform, string(b)).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v %v %v", SRS_CAMERA_CONFIG, platform, string(b))
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "Camera: Update onvif ok, platform=%v, uuid=%v, %v, token=%vB", platform, uuid, onvif.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/camera/ptz/move"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, uuid, mode string
+			var vector OnvifPTZVector
+			var speed *float64
+			var timeout float64
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token   *string   `json:"token"`
+				UUID    *string   `json:"uuid"`
+				Mode    *string   `json:"mode"`
+				Pan     **float64 `json:"pan"`
+				Tilt    **float64 `json:"tilt"`
+				Zoom    **float64 `json:"zoom"`
+				Speed   **float64 `json:"speed"`
+				Timeout *float64  `json:"timeout"`
+			}{
+				Token: &token, UUID: &uuid, Mode: &mode, Pan: &vector.Pan, Tilt: &vector.Tilt, Zoom: &vector.Zoom,
+				Speed: &speed, Timeout: &timeout,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			// The speed is applied to all axes.
+			var pSpeed *OnvifPTZVector
+			if speed != nil {
+				if *speed <= 0 || *speed > 1 {
+					return errors.Errorf("invalid speed %v, should be in (0, 1]", *speed)
+				}
+				pSpeed = &OnvifPTZVector{Pan: speed, Tilt: speed, Zoom: speed}
+			}
+
+			duration := time.Duration(timeout * float64(time.Second))
+			if duration < 0 || duration > onvifPTZMaxTimeout {
+				return errors.Errorf("invalid timeout %v, should be in [0, %v]", timeout, onvifPTZMaxTimeout.Seconds())
+			}
+
+			minZoom := float64(-1)
+			if mode == "absolute" {
+				minZoom = 0
+			}
+			if err := vector.Validate(minZoom); err != nil {
+				return errors.Wrapf(err, "validate %v", vector.String())
+			}
+
+			client, profile, err := newCameraOnvifClient(ctx, uuid)
+			if err != nil {
+				return errors.Wrapf(err, "client of %v", uuid)
+			}
+
+			switch mode {
+			case "continuous", "":
+				err = client.PTZContinuousMove(ctx, profile, &vector, duration)
+			case "absolute":
+				err = client.PTZAbsoluteMove(ctx, profile, &vector, pSpeed)
+			case "relative":
+				err = client.PTZRelativeMove(ctx, profile, &vector, pSpeed)
+			default:
+				return errors.Errorf("invalid mode %v, should be continuous, absolute or relative", mode)
+			}
+			if err != nil {
+				return errors.Wrapf(err, "move %v", uuid)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "Camera: PTZ move ok, uuid=%v, mode=%v, %v, timeout=%v, token=%vB", uuid, mode, vector.String(), duration, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/camera/ptz/stop"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, uuid string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				UUID  *string `json:"uuid"`
+			}{
+				Token: &token, UUID: &uuid,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			client, profile, err := newCameraOnvifClient(ctx, uuid)
+			if err != nil {
+				return errors.Wrapf(err, "client of %v", uuid)
+			}
+			if err := client.PTZStop(ctx, profile); err != nil {
+				return errors.Wrapf(err, "stop %v", uuid)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "Camera: PTZ stop ok, uuid=%v, token=%vB", uuid, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/camera/ptz/presets"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, uuid string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				UUID  *string `json:"uuid"`
+			}{
+				Token: &token, UUID: &uuid,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			client, profile, err := newCameraOnvifClient(ctx, uuid)
+			if err != nil {
+				return errors.Wrapf(err, "client of %v", uuid)
+			}
+			presets, err := client.PTZPresets(ctx, profile)
+			if err != nil {
+				return errors.Wrapf(err, "presets of %v", uuid)
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Presets []*OnvifPreset `json:"presets"`
+			}{
+				Presets: presets,
+			})
+			logger.Tf(ctx, "Camera: PTZ presets ok, uuid=%v, presets=%v, token=%vB", uuid, len(presets), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/camera/ptz/goto"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, uuid, preset string
+			var speed *float64
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string   `json:"token"`
+				UUID   *string   `json:"uuid"`
+				Preset *string   `json:"preset"`
+				Speed  **float64 `json:"speed"`
+			}{
+				Token: &token, UUID: &uuid, Preset: &preset, Speed: &speed,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if preset == "" {
+				return errors.New("no preset")
+			}
+			var pSpeed *OnvifPTZVector
+			if speed != nil {
+				if *speed <= 0 || *speed > 1 {
+					return errors.Errorf("invalid speed %v, should be in (0, 1]", *speed)
+				}
+				pSpeed = &OnvifPTZVector{Pan: speed, Tilt: speed, Zoom: speed}
+			}
+
+			client, profile, err := newCameraOnvifClient(ctx, uuid)
+			if err != nil {
+				return errors.Wrapf(err, "client of %v", uuid)
+			}
+			if err := client.PTZGotoPreset(ctx, profile, preset, pSpeed); err != nil {
+				return errors.Wrapf(err, "goto %v of %v", preset, uuid)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "Camera: PTZ goto preset ok, uuid=%v, preset=%v, token=%vB", uuid, preset, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/camera/ptz/preset"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, uuid, name, preset string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string `json:"token"`
+				UUID   *string `json:"uuid"`
+				Name   *string `json:"name"`
+				Preset *string `json:"preset"`
+			}{
+				Token: &token, UUID: &uuid, Name: &name, Preset: &preset,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if name == "" && preset == "" {
+				return errors.New("no name or preset")
+			}
+
+			client, profile, err := newCameraOnvifClient(ctx, uuid)
+			if err != nil {
+				return errors.Wrapf(err, "client of %v", uuid)
+			}
+			if preset, err = client.PTZSetPreset(ctx, profile, name, preset); err != nil {
+				return errors.Wrapf(err, "set preset %v of %v", name, uuid)
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Preset string `json:"preset"`
+			}{
+				Preset: preset,
+			})
+			logger.Tf(ctx, "Camera: PTZ set preset ok, uuid=%v, name=%v, preset=%v, token=%vB", uuid, name, preset, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
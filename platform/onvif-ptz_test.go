@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOnvifPTZ_Vector(t *testing.T) {
+	value := func(v float64) *float64 {
+		return &v
+	}
+
+	for _, e := range []struct {
+		vector  *OnvifPTZVector
+		minZoom float64
+		ok      bool
+		xml     string
+	}{
+		{vector: &OnvifPTZVector{Pan: value(0.5)}, minZoom: -1, ok: true, xml: `<tt:PanTilt x="0.5" y="0"/>`},
+		{vector: &OnvifPTZVector{Tilt: value(-1), Zoom: value(-0.2)}, minZoom: -1, ok: true, xml: `<tt:PanTilt x="0" y="-1"/><tt:Zoom x="-0.2"/>`},
+		{vector: &OnvifPTZVector{Zoom: value(0.3)}, minZoom: 0, ok: true, xml: `<tt:Zoom x="0.3"/>`},
+		{vector: &OnvifPTZVector{Zoom: value(-0.3)}, minZoom: 0, ok: false},
+		{vector: &OnvifPTZVector{Pan: value(1.5)}, minZoom: -1, ok: false},
+		{vector: &OnvifPTZVector{}, minZoom: -1, ok: false},
+	} {
+		if err := e.vector.Validate(e.minZoom); (err == nil) != e.ok {
+			t.Errorf("Fail for %v, expect ok=%v, err %v", e.vector.String(), e.ok, err)
+		} else if e.ok && e.vector.xml() != e.xml {
+			t.Errorf("Fail for %v, expect %v, actual %v", e.vector.String(), e.xml, e.vector.xml())
+		}
+	}
+}
+
+func TestOnvifPTZ_Client(t *testing.T) {
+	var requests []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		var req struct {
+			Body struct {
+				Inner []byte `xml:",innerxml"`
+			} `xml:"Body"`
+		}
+		xml.Unmarshal(b, &req)
+		body := string(req.Body.Inner)
+
+		response := func(s string) {
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" ` +
+				`xmlns:tt="http://www.onvif.org/ver10/schema"><env:Body>` + s + `</env:Body></env:Envelope>`))
+		}
+
+		if strings.Contains(body, "GetCapabilities") {
+			response(`<GetCapabilitiesResponse><Capabilities><tt:PTZ><tt:XAddr>` + server.URL + `/onvif/ptz</tt:XAddr></tt:PTZ></Capabilities></GetCapabilitiesResponse>`)
+			return
+		}
+		if r.URL.Path != "/onvif/ptz" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		requests = append(requests, body)
+		switch {
+		case strings.Contains(body, "GetPresets"):
+			response(`<GetPresetsResponse>` +
+				`<Preset token="1"><tt:Name>Stage</tt:Name><tt:PTZPosition><tt:PanTilt x="0.1" y="-0.2"/><tt:Zoom x="0.5"/></tt:PTZPosition></Preset>` +
+				`<Preset token="2"><tt:Name>Door</tt:Name></Preset>` +
+				`</GetPresetsResponse>`)
+		case strings.Contains(body, "SetPreset"):
+			response(`<SetPresetResponse><PresetToken>3</PresetToken></SetPresetResponse>`)
+		default:
+			response(`<Response/>`)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	client := NewOnvifClient(server.URL+"/onvif/device_service", "", "")
+	pan, speed := 0.5, 1.0
+
+	if err := client.PTZContinuousMove(ctx, "main", &OnvifPTZVector{Pan: &pan}, 2*time.Second); err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if v := requests[len(requests)-1]; !strings.Contains(v, `<tptz:ProfileToken>main</tptz:ProfileToken><tptz:Velocity><tt:PanTilt x="0.5" y="0"/></tptz:Velocity><tptz:Timeout>PT2S</tptz:Timeout>`) {
+		t.Errorf("Fail for request %v", v)
+	}
+
+	if err := client.PTZStop(ctx, "main"); err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if v := requests[len(requests)-1]; !strings.Contains(v, `<tptz:Stop>`) {
+		t.Errorf("Fail for request %v", v)
+	}
+
+	if err := client.PTZRelativeMove(ctx, "main", &OnvifPTZVector{Pan: &pan}, &OnvifPTZVector{Pan: &speed, Tilt: &speed}); err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if v := requests[len(requests)-1]; !strings.Contains(v, `<tptz:Translation><tt:PanTilt x="0.5" y="0"/></tptz:Translation><tptz:Speed><tt:PanTilt x="1" y="1"/></tptz:Speed>`) {
+		t.Errorf("Fail for request %v", v)
+	}
+
+	presets, err := client.PTZPresets(ctx, "main")
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if len(presets) != 2 || presets[0].Name != "Stage" || presets[0].Position == nil || *presets[0].Position.Zoom != 0.5 || presets[1].Position != nil {
+		t.Errorf("Fail for presets %v", presets)
+	}
+
+	if err := client.PTZGotoPreset(ctx, "main", "1", nil); err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if v := requests[len(requests)-1]; !strings.Contains(v, `<tptz:PresetToken>1</tptz:PresetToken></tptz:GotoPreset>`) {
+		t.Errorf("Fail for request %v", v)
+	}
+
+	if token, err := client.PTZSetPreset(ctx, "main", "Lobby & Bar", ""); err != nil || token != "3" {
+		t.Errorf("Fail for token %v, err %+v", token, err)
+	} else if v := requests[len(requests)-1]; !strings.Contains(v, `<tptz:PresetName>Lobby &amp; Bar</tptz:PresetName>`) {
+		t.Errorf("Fail for request %v", v)
+	}
+}
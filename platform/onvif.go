@@ -0,0 +1,612 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+)
+
+// The multicast address of WS-Discovery, and the default time to wait for the matches.
+const onvifDiscoveryAddress = "239.255.255.250:3702"
+const onvifDiscoveryTimeout = 3 * time.Second
+
+// The timeout of ONVIF SOAP request.
+const onvifRequestTimeout = 10 * time.Second
+
+// OnvifDevice is an ONVIF device found by WS-Discovery.
+type OnvifDevice struct {
+	// The endpoint reference of device, for example, urn:uuid:xxx
+	Endpoint string `json:"endpoint"`
+	// The URLs of device service, for example, http://192.168.1.10/onvif/device_service
+	XAddrs []string `json:"xaddrs"`
+	// The name, hardware and location, parsed from scopes.
+	Name     string `json:"name,omitempty"`
+	Hardware string `json:"hardware,omitempty"`
+	Location string `json:"location,omitempty"`
+	// The raw scopes of device.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+func (v *OnvifDevice) String() string {
+	return fmt.Sprintf("endpoint=%v, xaddrs=%v, name=%v, hardware=%v, location=%v",
+		v.Endpoint, v.XAddrs, v.Name, v.Hardware, v.Location,
+	)
+}
+
+// OnvifSource is the ONVIF device and profile of camera source.
+type OnvifSource struct {
+	// The URL of device service.
+	XAddr string `json:"xaddr"`
+	// The token of media profile.
+	Profile string `json:"profile"`
+	// The credentials of device, to control the camera.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+func (v *OnvifSource) String() string {
+	return fmt.Sprintf("xaddr=%v, profile=%v, username=%v, password=%vB", v.XAddr, v.Profile, v.Username, len(v.Password))
+}
+
+// redactOnvifSources copy the sources without the password of ONVIF device, for the response of query.
+func redactOnvifSources(sources []*FFprobeSource) []*FFprobeSource {
+	r := make([]*FFprobeSource, 0, len(sources))
+	for _, source := range sources {
+		if source != nil && source.Onvif != nil && source.Onvif.Password != "" {
+			s, onvif := *source, *source.Onvif
+			onvif.Password = ""
+			s.Onvif = &onvif
+			source = &s
+		}
+		r = append(r, source)
+	}
+	return r
+}
+
+// restoreOnvifPasswords restore the password of ONVIF device from the previous sources, by the same device
+// and username. Because the password is redacted in query response, it's empty if not changed by user.
+func restoreOnvifPasswords(sources, previous []*FFprobeSource) {
+	for _, source := range sources {
+		if source == nil || source.Onvif == nil || source.Onvif.Password != "" {
+			continue
+		}
+
+		for _, p := range previous {
+			if p != nil && p.Onvif != nil && p.Onvif.XAddr == source.Onvif.XAddr &&
+				p.Onvif.Username == source.Onvif.Username {
+				source.Onvif.Password = p.Onvif.Password
+				break
+			}
+		}
+	}
+}
+
+// buildWSDiscoveryProbe build the WS-Discovery probe for network video transmitters.
+func buildWSDiscoveryProbe(messageID string) []byte {
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>`+
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" `+
+		`xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" `+
+		`xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" `+
+		`xmlns:dn="http://www.onvif.org/ver10/network/wsdl">`+
+		`<s:Header>`+
+		`<a:Action s:mustUnderstand="1">http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</a:Action>`+
+		`<a:MessageID>%v</a:MessageID>`+
+		`<a:To s:mustUnderstand="1">urn:schemas-xmlsoap-org:ws:2005:04:discovery</a:To>`+
+		`</s:Header>`+
+		`<s:Body><d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe></s:Body>`+
+		`</s:Envelope>`, xmlEscape(messageID)))
+}
+
+// parseWSDiscoveryMatches parse the devices from the probe matches, which should relate to the probe.
+func parseWSDiscoveryMatches(b []byte, messageID string) ([]*OnvifDevice, error) {
+	var res struct {
+		RelatesTo string `xml:"Header>RelatesTo"`
+		Matches   []struct {
+			Address string `xml:"EndpointReference>Address"`
+			Scopes  string `xml:"Scopes"`
+			XAddrs  string `xml:"XAddrs"`
+		} `xml:"Body>ProbeMatches>ProbeMatch"`
+	}
+	if err := xml.Unmarshal(b, &res); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", string(b))
+	}
+	if res.RelatesTo != messageID {
+		return nil, errors.Errorf("relates to %v, expect %v", res.RelatesTo, messageID)
+	}
+
+	var devices []*OnvifDevice
+	for _, match := range res.Matches {
+		device := &OnvifDevice{
+			Endpoint: strings.TrimSpace(match.Address),
+			XAddrs:   strings.Fields(match.XAddrs),
+			Scopes:   strings.Fields(match.Scopes),
+		}
+		for _, scope := range device.Scopes {
+			for prefix, field := range map[string]*string{
+				"onvif://www.onvif.org/name/":     &device.Name,
+				"onvif://www.onvif.org/hardware/": &device.Hardware,
+				"onvif://www.onvif.org/location/": &device.Location,
+			} {
+				if strings.HasPrefix(scope, prefix) {
+					if value, err := url.PathUnescape(strings.TrimPrefix(scope, prefix)); err == nil {
+						*field = value
+					}
+				}
+			}
+		}
+		if device.Endpoint != "" && len(device.XAddrs) > 0 {
+			devices = append(devices, device)
+		}
+	}
+	return devices, nil
+}
+
+// discoverOnvifDevices send the probe to dst, and collect the matches until timeout. The probe is sent
+// twice, because UDP might be lost.
+func discoverOnvifDevices(ctx context.Context, conn net.PacketConn, dst net.Addr, timeout time.Duration) ([]*OnvifDevice, error) {
+	messageID := fmt.Sprintf("urn:uuid:%v", uuid.NewString())
+	probe := buildWSDiscoveryProbe(messageID)
+	for i := 0; i < 2; i++ {
+		if _, err := conn.WriteTo(probe, dst); err != nil {
+			return nil, errors.Wrapf(err, "send probe to %v", dst)
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, errors.Wrapf(err, "set deadline")
+	}
+
+	var devices []*OnvifDevice
+	found := make(map[string]bool)
+	b := make([]byte, 64*1024)
+	for ctx.Err() == nil {
+		nn, addr, err := conn.ReadFrom(b)
+		if err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				break
+			}
+			return nil, errors.Wrapf(err, "read matches")
+		}
+
+		matches, err := parseWSDiscoveryMatches(b[:nn], messageID)
+		if err != nil {
+			logger.Wf(ctx, "onvif: Ignore match from %v err %+v", addr, err)
+			continue
+		}
+		for _, device := range matches {
+			if !found[device.Endpoint] {
+				found[device.Endpoint] = true
+				devices = append(devices, device)
+			}
+		}
+	}
+	return devices, nil
+}
+
+// OnvifProfile is the media profile of ONVIF device.
+type OnvifProfile struct {
+	// The token and name of profile.
+	Token string `json:"token"`
+	Name  string `json:"name"`
+	// The video encoder, for example, H264.
+	Encoding  string `json:"encoding"`
+	Width     int32  `json:"width"`
+	Height    int32  `json:"height"`
+	FrameRate int    `json:"fps"`
+	// The bitrate in kbps.
+	Bitrate int `json:"bitrate"`
+	// The audio encoder, empty if no audio.
+	AudioEncoding string `json:"audioEncoding,omitempty"`
+}
+
+func (v *OnvifProfile) String() string {
+	return fmt.Sprintf("token=%v, name=%v, encoding=%v, size=%vx%v, fps=%v, bitrate=%v, audio=%v",
+		v.Token, v.Name, v.Encoding, v.Width, v.Height, v.FrameRate, v.Bitrate, v.AudioEncoding,
+	)
+}
+
+// OnvifClient is the SOAP client of ONVIF device, authenticated by WS-UsernameToken.
+type OnvifClient struct {
+	// The URL of device service.
+	XAddr string
+	// The credentials of device.
+	Username string
+	Password string
+
+	// The clock offset of device, to build the token.
+	offset time.Duration
+	// The HTTP client.
+	client *http.Client
+}
+
+func NewOnvifClient(xaddr, username, password string) *OnvifClient {
+	return &OnvifClient{
+		XAddr: xaddr, Username: username, Password: password,
+		client: &http.Client{Timeout: onvifRequestTimeout},
+	}
+}
+
+// security build the WS-UsernameToken header with password digest, empty if no username.
+func (v *OnvifClient) security() string {
+	if v.Username == "" {
+		return ""
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	created := time.Now().Add(v.offset).UTC().Format("2006-01-02T15:04:05Z")
+
+	h := sha1.New()
+	h.Write(nonce)
+	h.Write([]byte(created))
+	h.Write([]byte(v.Password))
+	digest := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	return fmt.Sprintf(`<wsse:Security s:mustUnderstand="1" `+
+		`xmlns:wsse="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd" `+
+		`xmlns:wsu="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">`+
+		`<wsse:UsernameToken><wsse:Username>%v</wsse:Username>`+
+		`<wsse:Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest">%v</wsse:Password>`+
+		`<wsse:Nonce EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary">%v</wsse:Nonce>`+
+		`<wsu:Created>%v</wsu:Created></wsse:UsernameToken></wsse:Security>`,
+		xmlEscape(v.Username), digest, base64.StdEncoding.EncodeToString(nonce), created,
+	)
+}
+
+// call the SOAP operation of service at xaddr, and parse the response envelope to res.
+func (v *OnvifClient) call(ctx context.Context, xaddr, body string, res interface{}) error {
+	envelope := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>`+
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" `+
+		`xmlns:tds="http://www.onvif.org/ver10/device/wsdl" `+
+		`xmlns:trt="http://www.onvif.org/ver10/media/wsdl" `+
+		`xmlns:tptz="http://www.onvif.org/ver20/ptz/wsdl" `+
+		`xmlns:tt="http://www.onvif.org/ver10/schema">`+
+		`<s:Header>%v</s:Header><s:Body>%v</s:Body></s:Envelope>`, v.security(), body)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, xaddr, strings.NewReader(envelope))
+	if err != nil {
+		return errors.Wrapf(err, "new request %v", xaddr)
+	}
+	req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+
+	r, err := v.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "post %v", xaddr)
+	}
+	defer r.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(r.Body, 1024*1024))
+	if err != nil {
+		return errors.Wrapf(err, "read %v", xaddr)
+	}
+
+	var fault struct {
+		Fault *struct {
+			Reason string `xml:"Reason>Text"`
+			String string `xml:"faultstring"`
+		} `xml:"Body>Fault"`
+	}
+	if err := xml.Unmarshal(b, &fault); err == nil && fault.Fault != nil {
+		return errors.Errorf("fault of %v, status=%v, reason=%v%v", xaddr, r.StatusCode, fault.Fault.Reason, fault.Fault.String)
+	}
+	if r.StatusCode != http.StatusOK {
+		return errors.Errorf("post %v status %v", xaddr, r.StatusCode)
+	}
+
+	if err := xml.Unmarshal(b, res); err != nil {
+		return errors.Wrapf(err, "unmarshal %v", string(b))
+	}
+	return nil
+}
+
+// SyncTime get the clock of device, because the token is rejected if the clock is different.
+func (v *OnvifClient) SyncTime(ctx context.Context) error {
+	var res struct {
+		Date struct {
+			Year  int `xml:"Year"`
+			Month int `xml:"Month"`
+			Day   int `xml:"Day"`
+		} `xml:"Body>GetSystemDateAndTimeResponse>SystemDateAndTime>UTCDateTime>Date"`
+		Time struct {
+			Hour   int `xml:"Hour"`
+			Minute int `xml:"Minute"`
+			Second int `xml:"Second"`
+		} `xml:"Body>GetSystemDateAndTimeResponse>SystemDateAndTime>UTCDateTime>Time"`
+	}
+
+	// The time is queried without token.
+	client := *v
+	client.Username = ""
+	if err := client.call(ctx, v.XAddr, `<tds:GetSystemDateAndTime/>`, &res); err != nil {
+		return errors.Wrapf(err, "get time")
+	}
+	if res.Date.Year == 0 {
+		return nil
+	}
+
+	t := time.Date(res.Date.Year, time.Month(res.Date.Month), res.Date.Day, res.Time.Hour, res.Time.Minute, res.Time.Second, 0, time.UTC)
+	v.offset = time.Until(t)
+	return nil
+}
+
+// serviceXAddr get the URL of service by category, Media or PTZ, use the device service if not specified.
+func (v *OnvifClient) serviceXAddr(ctx context.Context, category string) (string, error) {
+	var res struct {
+		Media string `xml:"Body>GetCapabilitiesResponse>Capabilities>Media>XAddr"`
+		PTZ   string `xml:"Body>GetCapabilitiesResponse>Capabilities>PTZ>XAddr"`
+	}
+	if err := v.call(ctx, v.XAddr, fmt.Sprintf(`<tds:GetCapabilities><tds:Category>%v</tds:Category></tds:GetCapabilities>`,
+		xmlEscape(category),
+	), &res); err != nil {
+		return "", errors.Wrapf(err, "get capabilities of %v", category)
+	}
+
+	xaddr := res.Media
+	if category == "PTZ" {
+		xaddr = res.PTZ
+	}
+	if xaddr == "" {
+		return v.XAddr, nil
+	}
+	return strings.TrimSpace(xaddr), nil
+}
+
+// Profiles get the media profiles of device.
+func (v *OnvifClient) Profiles(ctx context.Context) ([]*OnvifProfile, error) {
+	media, err := v.serviceXAddr(ctx, "Media")
+	if err != nil {
+		return nil, errors.Wrapf(err, "media service")
+	}
+
+	var res struct {
+		Profiles []struct {
+			Token string `xml:"token,attr"`
+			Name  string `xml:"Name"`
+			Video *struct {
+				Encoding  string `xml:"Encoding"`
+				Width     int32  `xml:"Resolution>Width"`
+				Height    int32  `xml:"Resolution>Height"`
+				FrameRate int    `xml:"RateControl>FrameRateLimit"`
+				Bitrate   int    `xml:"RateControl>BitrateLimit"`
+			} `xml:"VideoEncoderConfiguration"`
+			Audio *struct {
+				Encoding string `xml:"Encoding"`
+			} `xml:"AudioEncoderConfiguration"`
+		} `xml:"Body>GetProfilesResponse>Profiles"`
+	}
+	if err := v.call(ctx, media, `<trt:GetProfiles/>`, &res); err != nil {
+		return nil, errors.Wrapf(err, "get profiles")
+	}
+
+	var profiles []*OnvifProfile
+	for _, p := range res.Profiles {
+		// Ignore the profile without video, for example, the audio or metadata only profile.
+		if p.Video == nil {
+			continue
+		}
+
+		profile := &OnvifProfile{
+			Token: p.Token, Name: p.Name, Encoding: p.Video.Encoding,
+			Width: p.Video.Width, Height: p.Video.Height, FrameRate: p.Video.FrameRate, Bitrate: p.Video.Bitrate,
+		}
+		if p.Audio != nil {
+			profile.AudioEncoding = p.Audio.Encoding
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// StreamURI get the RTSP URL of profile.
+func (v *OnvifClient) StreamURI(ctx context.Context, token string) (string, error) {
+	media, err := v.serviceXAddr(ctx, "Media")
+	if err != nil {
+		return "", errors.Wrapf(err, "media service")
+	}
+
+	var res struct {
+		URI string `xml:"Body>GetStreamUriResponse>MediaUri>Uri"`
+	}
+	if err := v.call(ctx, media, fmt.Sprintf(`<trt:GetStreamUri><trt:StreamSetup>`+
+		`<tt:Stream>RTP-Unicast</tt:Stream><tt:Transport><tt:Protocol>RTSP</tt:Protocol></tt:Transport>`+
+		`</trt:StreamSetup><trt:ProfileToken>%v</trt:ProfileToken></trt:GetStreamUri>`, xmlEscape(token),
+	), &res); err != nil {
+		return "", errors.Wrapf(err, "get stream uri of %v", token)
+	}
+	if res.URI == "" {
+		return "", errors.Errorf("no stream uri of %v", token)
+	}
+	return strings.TrimSpace(res.URI), nil
+}
+
+// Sources get the RTSP streams of all profiles, as the sources of camera. The credentials are set in
+// the RTSP URL, because the camera typically uses the same credentials.
+func (v *OnvifClient) Sources(ctx context.Context) ([]*FFprobeSource, error) {
+	if err := v.SyncTime(ctx); err != nil {
+		logger.Wf(ctx, "onvif: Ignore sync time of %v err %+v", v.XAddr, err)
+	}
+
+	profiles, err := v.Profiles(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "profiles")
+	}
+
+	var sources []*FFprobeSource
+	for _, profile := range profiles {
+		uri, err := v.StreamURI(ctx, profile.Token)
+		if err != nil {
+			return nil, errors.Wrapf(err, "stream uri")
+		}
+
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse %v", uri)
+		}
+		if v.Username != "" && u.User == nil {
+			u.User = url.UserPassword(v.Username, v.Password)
+		}
+
+		source := &FFprobeSource{
+			Name: profile.Name, UUID: uuid.NewString(), Target: u.String(), Type: FFprobeSourceTypeStream,
+			Video: &FFprobeVideo{
+				CodecType: "video", CodecName: onvifCodecName(profile.Encoding),
+				Width: profile.Width, Height: profile.Height,
+			},
+			Onvif: &OnvifSource{XAddr: v.XAddr, Profile: profile.Token, Username: v.Username, Password: v.Password},
+		}
+		if profile.FrameRate > 0 {
+			source.Video.FrameRate = fmt.Sprintf("%v/1", profile.FrameRate)
+		}
+		if profile.Bitrate > 0 {
+			source.Video.Bitrate = fmt.Sprintf("%v", profile.Bitrate*1000)
+		}
+		if profile.AudioEncoding != "" {
+			source.Audio = &FFprobeAudio{CodecType: "audio", CodecName: onvifCodecName(profile.AudioEncoding)}
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// onvifCodecName convert the encoding of ONVIF to codec name of FFmpeg.
+func onvifCodecName(encoding string) string {
+	switch strings.ToUpper(encoding) {
+	case "JPEG":
+		return "mjpeg"
+	case "G711":
+		return "pcm_mulaw"
+	case "G726":
+		return "adpcm_g726"
+	}
+	return strings.ToLower(encoding)
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (v *CameraWorker) handleOnvif(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/ffmpeg/camera/onvif/discover"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var timeout float64
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token   *string  `json:"token"`
+				Timeout *float64 `json:"timeout"`
+			}{
+				Token: &token, Timeout: &timeout,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			duration := onvifDiscoveryTimeout
+			if timeout > 0 {
+				duration = time.Duration(timeout * float64(time.Second))
+			}
+			if duration > 30*time.Second {
+				return errors.Errorf("timeout %v too large", timeout)
+			}
+
+			dst, err := net.ResolveUDPAddr("udp4", onvifDiscoveryAddress)
+			if err != nil {
+				return errors.Wrapf(err, "resolve %v", onvifDiscoveryAddress)
+			}
+
+			conn, err := net.ListenPacket("udp4", ":0")
+			if err != nil {
+				return errors.Wrapf(err, "listen")
+			}
+			defer conn.Close()
+
+			devices, err := discoverOnvifDevices(ctx, conn, dst, duration)
+			if err != nil {
+				return errors.Wrapf(err, "discover")
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Devices []*OnvifDevice `json:"devices"`
+			}{
+				Devices: devices,
+			})
+			logger.Tf(ctx, "Camera: Discover onvif ok, timeout=%v, devices=%v, token=%vB", duration, len(devices), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/camera/onvif/profiles"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, xaddr, username, password string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string `json:"token"`
+				XAddr    *string `json:"xaddr"`
+				Username *string `json:"username"`
+				Password *string `json:"password"`
+			}{
+				Token: &token, XAddr: &xaddr, Username: &username, Password: &password,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if u, err := url.Parse(xaddr); err != nil {
+				return errors.Wrapf(err, "parse %v", xaddr)
+			} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return errors.Errorf("invalid xaddr %v", xaddr)
+			}
+
+			sources, err := NewOnvifClient(xaddr, username, password).Sources(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "query %v", xaddr)
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Files []*FFprobeSource `json:"files"`
+			}{
+				Files: sources,
+			})
+			logger.Tf(ctx, "Camera: Query onvif profiles ok, xaddr=%v, files=%v, token=%vB", xaddr, len(sources), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
@@ -0,0 +1,608 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The positions of overlay.
+var overlayPositions = []string{"top-left", "top-right", "bottom-left", "bottom-right", "center"}
+
+// The presets of x264 encoder.
+var overlayPresets = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium"}
+
+// The color of drawtext, for example, white, #ffffff or black@0.5
+var overlayColorRegexp = regexp.MustCompile(`^[A-Za-z0-9#]+(@[0-9.]+)?$`)
+
+// The image files allowed to use as logo.
+var overlayImageFiles = []string{".png", ".jpg", ".jpeg"}
+
+// TaskOverlay is the overlays on the video of vLive or camera, which forces to re-encode the video.
+type TaskOverlay struct {
+	// The logo image, also known as station bug.
+	Logo *OverlayLogo `json:"logo,omitempty"`
+	// The static or templated texts, for example, the clock or title.
+	Texts []*OverlayText `json:"texts,omitempty"`
+	// The scrolling ticker, the text can be updated at runtime.
+	Ticker *OverlayTicker `json:"ticker,omitempty"`
+	// The font file for texts and ticker, use the default font if empty.
+	Font string `json:"font,omitempty"`
+	// The encoder to re-encode the video, not used if the video is normalized by profile.
+	Encoder *OverlayEncoder `json:"encoder,omitempty"`
+}
+
+func (v *TaskOverlay) String() string {
+	return fmt.Sprintf("logo=%v, texts=%v, ticker=%v, font=%v, encoder=%v",
+		v.Logo != nil, len(v.Texts), v.Ticker != nil, v.Font, v.Encoder,
+	)
+}
+
+// OverlayLogo is the image overlay.
+type OverlayLogo struct {
+	// The image file.
+	Image string `json:"image"`
+	// The position, for example, top-right.
+	Position string `json:"position,omitempty"`
+	// The margin in pixels.
+	Margin int `json:"margin,omitempty"`
+	// The opacity, 0 to 1.
+	Opacity float64 `json:"opacity,omitempty"`
+	// The width in pixels to scale the image, keep the size if 0.
+	Width int `json:"width,omitempty"`
+}
+
+// OverlayText is the text overlay, the text supports templates, {clock}, {date} and {title}.
+type OverlayText struct {
+	// The text or template, for example, {title} {clock}
+	Text string `json:"text"`
+	// The position, for example, top-left.
+	Position string `json:"position,omitempty"`
+	// The margin in pixels.
+	Margin int `json:"margin,omitempty"`
+	// The font size in pixels.
+	FontSize int `json:"fontSize,omitempty"`
+	// The font color, for example, white.
+	Color string `json:"color,omitempty"`
+	// The color of box behind text, no box if empty, for example, black@0.5
+	Box string `json:"box,omitempty"`
+}
+
+// OverlayTicker is the scrolling text at the bottom or top.
+type OverlayTicker struct {
+	// The text of ticker.
+	Text string `json:"text"`
+	// Whether at top, default to bottom.
+	Top bool `json:"top,omitempty"`
+	// The speed in pixels per second.
+	Speed int `json:"speed,omitempty"`
+	// The font size in pixels.
+	FontSize int `json:"fontSize,omitempty"`
+	// The font color, for example, white.
+	Color string `json:"color,omitempty"`
+	// The color of background bar, for example, black@0.6
+	Box string `json:"box,omitempty"`
+}
+
+// OverlayEncoder is the encoder settings of video with overlay.
+type OverlayEncoder struct {
+	// The preset of x264, for example, veryfast.
+	Preset string `json:"preset,omitempty"`
+	// The bitrate in kbps.
+	Bitrate int `json:"bitrate,omitempty"`
+	// The frame rate.
+	Fps int `json:"fps,omitempty"`
+	// The GOP in seconds.
+	Gop int `json:"gop,omitempty"`
+}
+
+func (v *OverlayEncoder) String() string {
+	if v == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("preset=%v, bitrate=%v, fps=%v, gop=%v", v.Preset, v.Bitrate, v.Fps, v.Gop)
+}
+
+// Initialize set the default values of overlay.
+func (v *TaskOverlay) Initialize() {
+	if v.Logo != nil {
+		if v.Logo.Position == "" {
+			v.Logo.Position = "top-right"
+		}
+		if v.Logo.Opacity == 0 {
+			v.Logo.Opacity = 1
+		}
+	}
+	for _, text := range v.Texts {
+		if text != nil && text.Position == "" {
+			text.Position = "top-left"
+		}
+		if text != nil && text.FontSize == 0 {
+			text.FontSize = 32
+		}
+		if text != nil && text.Color == "" {
+			text.Color = "white"
+		}
+	}
+	if v.Ticker != nil {
+		if v.Ticker.Speed == 0 {
+			v.Ticker.Speed = 100
+		}
+		if v.Ticker.FontSize == 0 {
+			v.Ticker.FontSize = 32
+		}
+		if v.Ticker.Color == "" {
+			v.Ticker.Color = "white"
+		}
+		if v.Ticker.Box == "" {
+			v.Ticker.Box = "black@0.6"
+		}
+	}
+	if v.Encoder == nil {
+		v.Encoder = &OverlayEncoder{}
+	}
+	if v.Encoder.Preset == "" {
+		v.Encoder.Preset = "veryfast"
+	}
+	if v.Encoder.Bitrate == 0 {
+		v.Encoder.Bitrate = 2000
+	}
+	if v.Encoder.Fps == 0 {
+		v.Encoder.Fps = 25
+	}
+	if v.Encoder.Gop == 0 {
+		v.Encoder.Gop = 2
+	}
+}
+
+func (v *TaskOverlay) Validate() error {
+	if logo := v.Logo; logo != nil {
+		if logo.Image == "" {
+			return errors.New("no logo image")
+		}
+		if !slicesContains(overlayImageFiles, strings.ToLower(path.Ext(logo.Image))) {
+			return errors.Errorf("invalid logo image %v, should be %v", logo.Image, overlayImageFiles)
+		}
+		if !slicesContains(overlayPositions, logo.Position) {
+			return errors.Errorf("invalid logo position %v", logo.Position)
+		}
+		if logo.Opacity < 0 || logo.Opacity > 1 {
+			return errors.Errorf("invalid logo opacity %v, should be 0 to 1", logo.Opacity)
+		}
+		if logo.Margin < 0 || logo.Width < 0 {
+			return errors.Errorf("invalid logo margin %v or width %v", logo.Margin, logo.Width)
+		}
+	}
+
+	for i, text := range v.Texts {
+		if text == nil || text.Text == "" {
+			return errors.Errorf("no text #%v", i)
+		}
+		if !slicesContains(overlayPositions, text.Position) {
+			return errors.Errorf("invalid position %v of text #%v", text.Position, i)
+		}
+		if text.FontSize <= 0 || text.Margin < 0 {
+			return errors.Errorf("invalid font size %v or margin %v of text #%v", text.FontSize, text.Margin, i)
+		}
+		if !overlayColorRegexp.MatchString(text.Color) || (text.Box != "" && !overlayColorRegexp.MatchString(text.Box)) {
+			return errors.Errorf("invalid color %v or box %v of text #%v", text.Color, text.Box, i)
+		}
+	}
+
+	if ticker := v.Ticker; ticker != nil {
+		if ticker.Speed <= 0 || ticker.FontSize <= 0 {
+			return errors.Errorf("invalid ticker speed %v or font size %v", ticker.Speed, ticker.FontSize)
+		}
+		if !overlayColorRegexp.MatchString(ticker.Color) || !overlayColorRegexp.MatchString(ticker.Box) {
+			return errors.Errorf("invalid ticker color %v or box %v", ticker.Color, ticker.Box)
+		}
+	}
+
+	// The font file is in the filter, so it should not contain the special characters.
+	if v.Font != "" {
+		if strings.ContainsAny(v.Font, ":,;'\\[]") {
+			return errors.Errorf("invalid font %v", v.Font)
+		}
+		if _, err := os.Stat(v.Font); err != nil {
+			return errors.Wrapf(err, "no font %v", v.Font)
+		}
+	}
+
+	if encoder := v.Encoder; encoder != nil {
+		if !slicesContains(overlayPresets, encoder.Preset) {
+			return errors.Errorf("invalid preset %v, should be %v", encoder.Preset, overlayPresets)
+		}
+		if encoder.Bitrate <= 0 || encoder.Fps <= 0 || encoder.Fps > 60 || encoder.Gop <= 0 || encoder.Gop > 10 {
+			return errors.Errorf("invalid encoder %v", encoder.String())
+		}
+	}
+	return nil
+}
+
+// ffmpegStreamArgs is the arguments to map and encode the streams of input 0, to compose the
+// normalization and overlay of vLive and camera.
+type ffmpegStreamArgs struct {
+	// The extra inputs after the input 0, for example, the silent audio or logo image.
+	inputs []string
+	// The number of extra inputs.
+	nbInputs int
+	// The filter of video, which is a chain or graph from the video of input 0, empty if none.
+	videoFilter string
+	// The streams to map, for example, 0:v:0?
+	videoMap, audioMap string
+	// The codec arguments, for example, -c:v copy
+	videoCodec, audioCodec []string
+	// The extra output arguments, for example, -shortest
+	extra []string
+}
+
+// ffmpegCopyStreams copy the video and audio of input 0.
+func ffmpegCopyStreams() *ffmpegStreamArgs {
+	return &ffmpegStreamArgs{
+		videoMap: "0:v:0?", audioMap: "0:a:0?",
+		videoCodec: []string{"-c:v", "copy"}, audioCodec: []string{"-c:a", "copy"},
+	}
+}
+
+// addInput add an extra input, return the index of input.
+func (v *ffmpegStreamArgs) addInput(args ...string) int {
+	v.inputs = append(v.inputs, args...)
+	v.nbInputs++
+	return v.nbInputs
+}
+
+// copyVideo whether copy the video.
+func (v *ffmpegStreamArgs) copyVideo() bool {
+	return len(v.videoCodec) == 2 && v.videoCodec[1] == "copy"
+}
+
+// outputs build the output arguments, after all inputs.
+func (v *ffmpegStreamArgs) outputs() []string {
+	var args []string
+	if v.videoFilter != "" {
+		args = append(args, "-filter_complex",
+			fmt.Sprintf("[%v]%v[vout]", strings.TrimSuffix(v.videoMap, "?"), v.videoFilter), "-map", "[vout]",
+		)
+	} else if v.videoMap != "" {
+		args = append(args, "-map", v.videoMap)
+	}
+	if v.audioMap != "" {
+		args = append(args, "-map", v.audioMap)
+	}
+	args = append(args, v.videoCodec...)
+	args = append(args, v.audioCodec...)
+	args = append(args, v.extra...)
+	return args
+}
+
+// overlayPosition get the expression of x and y, by the size of outer and inner box.
+func overlayPosition(position string, margin int, outerW, outerH, innerW, innerH string) (x, y string) {
+	x, y = fmt.Sprintf("%v", margin), fmt.Sprintf("%v", margin)
+	if strings.HasSuffix(position, "-right") {
+		x = fmt.Sprintf("%v-%v-%v", outerW, innerW, margin)
+	}
+	if strings.HasPrefix(position, "bottom-") {
+		y = fmt.Sprintf("%v-%v-%v", outerH, innerH, margin)
+	}
+	if position == "center" {
+		x, y = fmt.Sprintf("(%v-%v)/2", outerW, innerW), fmt.Sprintf("(%v-%v)/2", outerH, innerH)
+	}
+	return
+}
+
+// overlayFile get the file of overlay for task, the prefix identify the task, for example, vlive-wx.
+func overlayFile(prefix, name string) string {
+	return path.Join(dirVLivePath, fmt.Sprintf("overlay-%v-%v", prefix, name))
+}
+
+// expandOverlayText escape the text for drawtext, and expand the templates.
+func expandOverlayText(text, title string) string {
+	escape := func(s string) string {
+		s = strings.ReplaceAll(s, "\\", "\\\\")
+		s = strings.ReplaceAll(s, "%", "\\%")
+		return strings.ReplaceAll(strings.ReplaceAll(s, "\r", ""), "\n", " ")
+	}
+
+	text = escape(text)
+	text = strings.ReplaceAll(text, "{clock}", "%{localtime:%H\\:%M\\:%S}")
+	text = strings.ReplaceAll(text, "{date}", "%{localtime:%Y-%m-%d}")
+	text = strings.ReplaceAll(text, "{title}", escape(title))
+	return text
+}
+
+// writeOverlayText write the text to file for drawtext, which reloads the file for each frame, so the
+// file is replaced by rename, to avoid reading a partial file.
+func writeOverlayText(file, text string) error {
+	tempFile := fmt.Sprintf("%v.tmp", file)
+	if err := os.WriteFile(tempFile, []byte(text), 0644); err != nil {
+		return errors.Wrapf(err, "write %v", tempFile)
+	}
+	if err := os.Rename(tempFile, file); err != nil {
+		return errors.Wrapf(err, "rename %v to %v", tempFile, file)
+	}
+	return nil
+}
+
+// drawtextFilter build the drawtext filter, which reads the text from file.
+func drawtextFilter(file, font string, fontSize int, color, box, x, y string) string {
+	opts := []string{
+		fmt.Sprintf("textfile=%v", file), "reload=1", "expansion=normal",
+		fmt.Sprintf("fontsize=%v", fontSize), fmt.Sprintf("fontcolor=%v", color),
+		fmt.Sprintf("x=%v", x), fmt.Sprintf("y=%v", y),
+	}
+	if font != "" {
+		opts = append(opts, fmt.Sprintf("fontfile=%v", font))
+	}
+	if box != "" {
+		opts = append(opts, "box=1", fmt.Sprintf("boxcolor=%v", box), "boxborderw=8")
+	}
+	return fmt.Sprintf("drawtext=%v", strings.Join(opts, ":"))
+}
+
+// applyOverlay apply the overlay to the video, write the text files for drawtext, and re-encode the
+// video by the encoder if it's copied. The prefix identify the files of task, for example, vlive-wx,
+// and the title is used by the template of text.
+func applyOverlay(stream *ffmpegStreamArgs, overlay *TaskOverlay, prefix, title string) error {
+	if overlay == nil || (overlay.Logo == nil && len(overlay.Texts) == 0 && overlay.Ticker == nil) {
+		return nil
+	}
+
+	// The filter before overlay, for example, scale by normalization.
+	var graph []string
+	chain := stream.videoFilter
+	if chain == "" {
+		chain = "null"
+	}
+
+	if logo := overlay.Logo; logo != nil {
+		index := stream.addInput("-loop", "1", "-i", logo.Image)
+
+		var filters []string
+		if logo.Width > 0 {
+			filters = append(filters, fmt.Sprintf("scale=%v:-1", logo.Width))
+		}
+		filters = append(filters, "format=rgba", fmt.Sprintf("colorchannelmixer=aa=%.2f", logo.Opacity))
+		graph = append(graph, fmt.Sprintf("%v[base]", chain), fmt.Sprintf("[%v:v]%v[logo]", index, strings.Join(filters, ",")))
+
+		x, y := overlayPosition(logo.Position, logo.Margin, "W", "H", "w", "h")
+		chain = fmt.Sprintf("[base][logo]overlay=x=%v:y=%v:shortest=1", x, y)
+	}
+
+	for i, text := range overlay.Texts {
+		file := overlayFile(prefix, fmt.Sprintf("text-%v.txt", i))
+		if err := writeOverlayText(file, expandOverlayText(text.Text, title)); err != nil {
+			return errors.Wrapf(err, "write text #%v", i)
+		}
+
+		x, y := overlayPosition(text.Position, text.Margin, "w", "h", "tw", "th")
+		chain += "," + drawtextFilter(file, overlay.Font, text.FontSize, text.Color, text.Box, x, y)
+	}
+
+	if ticker := overlay.Ticker; ticker != nil {
+		file := overlayFile(prefix, "ticker.txt")
+		if err := writeOverlayText(file, expandOverlayText(ticker.Text, title)); err != nil {
+			return errors.Wrapf(err, "write ticker")
+		}
+
+		// Scroll from right to left, and restart when the text is out of screen.
+		x := fmt.Sprintf("w-mod(t*%v\\,w+tw)", ticker.Speed)
+		y := "h-th-16"
+		if ticker.Top {
+			y = "16"
+		}
+		chain += "," + drawtextFilter(file, overlay.Font, ticker.FontSize, ticker.Color, ticker.Box, x, y)
+	}
+
+	graph = append(graph, chain)
+	stream.videoFilter = strings.Join(graph, ";")
+
+	// Overlay requires re-encoding, use the encoder if the video is copied, or keep the codec of profile.
+	if stream.copyVideo() {
+		encoder := overlay.Encoder
+		if encoder == nil {
+			encoder = &OverlayEncoder{Preset: "veryfast", Bitrate: 2000, Fps: 25, Gop: 2}
+		}
+		stream.videoCodec = []string{
+			"-c:v", "libx264", "-profile:v", "main", "-preset", encoder.Preset, "-tune", "zerolatency",
+			"-pix_fmt", "yuv420p", "-r", fmt.Sprintf("%v", encoder.Fps),
+			"-b:v", fmt.Sprintf("%vk", encoder.Bitrate), "-maxrate", fmt.Sprintf("%vk", encoder.Bitrate),
+			"-bufsize", fmt.Sprintf("%vk", encoder.Bitrate*2),
+			"-g", fmt.Sprintf("%v", encoder.Fps*encoder.Gop), "-keyint_min", fmt.Sprintf("%v", encoder.Fps*encoder.Gop),
+			"-sc_threshold", "0", "-bf", "0",
+		}
+	}
+	return nil
+}
+
+// updateTicker update the text of ticker at runtime, the drawtext reloads the file, so no restart.
+func updateTicker(overlay *TaskOverlay, prefix, title, text string) error {
+	if overlay == nil || overlay.Ticker == nil {
+		return errors.New("no ticker")
+	}
+
+	overlay.Ticker.Text = text
+	if err := writeOverlayText(overlayFile(prefix, "ticker.txt"), expandOverlayText(text, title)); err != nil {
+		return errors.Wrapf(err, "write ticker")
+	}
+	return nil
+}
+
+// The kinds of task which supports overlay.
+const (
+	overlayKindVLive  = "vlive"
+	overlayKindCamera = "camera"
+)
+
+// updateOverlayConfig load the configure of task by kind and platform, update the overlay by fn, then
+// save it. The title of task for fn is the label or platform.
+func updateOverlayConfig(ctx context.Context, kind, platform string, fn func(overlay **TaskOverlay, title string) error) error {
+	if platform == "" {
+		return errors.New("no platform")
+	}
+
+	var key string
+	var conf interface{}
+	var overlay **TaskOverlay
+	var label *string
+	switch kind {
+	case overlayKindVLive:
+		obj := &VLiveConfigure{}
+		key, conf, overlay, label = SRS_VLIVE_CONFIG, obj, &obj.Overlay, &obj.Label
+	case overlayKindCamera:
+		obj := &CameraConfigure{}
+		key, conf, overlay, label = SRS_CAMERA_CONFIG, obj, &obj.Overlay, &obj.Label
+	default:
+		return errors.Errorf("invalid kind %v", kind)
+	}
+
+	if b, err := rdb.HGet(ctx, key, platform).Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v %v", key, platform)
+	} else if b == "" {
+		return errors.Errorf("no %v %v", kind, platform)
+	} else if err = json.Unmarshal([]byte(b), conf); err != nil {
+		return errors.Wrapf(err, "unmarshal %v", b)
+	}
+
+	title := *label
+	if title == "" {
+		title = platform
+	}
+	if err := fn(overlay, title); err != nil {
+		return errors.Wrapf(err, "update overlay")
+	}
+
+	if b, err := json.Marshal(conf); err != nil {
+		return errors.Wrapf(err, "marshal %v", conf)
+	} else if err = rdb.HSet(ctx, key, platform, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", key, platform, string(b))
+	}
+	return nil
+}
+
+func handleOverlayService(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/ffmpeg/overlay/update"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, kind, platform string
+			var overlay TaskOverlay
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string      `json:"token"`
+				Kind     *string      `json:"kind"`
+				Platform *string      `json:"platform"`
+				Overlay  *TaskOverlay `json:"overlay"`
+			}{
+				Token: &token, Kind: &kind, Platform: &platform, Overlay: &overlay,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			overlay.Initialize()
+			if err := overlay.Validate(); err != nil {
+				return errors.Wrapf(err, "validate overlay")
+			}
+
+			// Move the uploaded logo to the directory of vLive, like the source files.
+			if logo := overlay.Logo; logo != nil && !strings.HasPrefix(logo.Image, dirVLivePath) {
+				if !strings.HasPrefix(logo.Image, dirUploadPath) {
+					return errors.Errorf("invalid logo image %v", logo.Image)
+				}
+
+				target := overlayFile(fmt.Sprintf("%v-%v", kind, platform), fmt.Sprintf("logo%v", path.Ext(logo.Image)))
+				if err := os.Rename(logo.Image, target); err != nil {
+					return errors.Wrapf(err, "rename %v to %v", logo.Image, target)
+				}
+				logo.Image = target
+			}
+			if logo := overlay.Logo; logo != nil {
+				if _, err := os.Stat(logo.Image); err != nil {
+					return errors.Wrapf(err, "no logo image %v", logo.Image)
+				}
+			}
+
+			if err := updateOverlayConfig(ctx, kind, platform, func(v **TaskOverlay, title string) error {
+				*v = &overlay
+				return nil
+			}); err != nil {
+				return errors.Wrapf(err, "update %v %v", kind, platform)
+			}
+
+			// Restart the task to apply the overlay.
+			if kind == overlayKindVLive {
+				if task := vLiveWorker.GetTask(platform); task != nil {
+					if err := task.Restart(ctx); err != nil {
+						return errors.Wrapf(err, "restart task %v", platform)
+					}
+				}
+			} else if task := cameraWorker.GetTask(platform); task != nil {
+				if err := task.Restart(ctx); err != nil {
+					return errors.Wrapf(err, "restart task %v", platform)
+				}
+			}
+
+			ohttp.WriteData(ctx, w, r, &overlay)
+			logger.Tf(ctx, "overlay: Update ok, kind=%v, platform=%v, overlay=%v, token=%vB",
+				kind, platform, overlay.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/overlay/ticker"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, kind, platform, text string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string `json:"token"`
+				Kind     *string `json:"kind"`
+				Platform *string `json:"platform"`
+				Text     *string `json:"text"`
+			}{
+				Token: &token, Kind: &kind, Platform: &platform, Text: &text,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			// Save the text, and write to the file of ticker, which is reloaded by FFmpeg on air.
+			if err := updateOverlayConfig(ctx, kind, platform, func(v **TaskOverlay, title string) error {
+				return updateTicker(*v, fmt.Sprintf("%v-%v", kind, platform), title, text)
+			}); err != nil {
+				return errors.Wrapf(err, "update %v %v", kind, platform)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "overlay: Update ticker ok, kind=%v, platform=%v, text=%vB, token=%vB",
+				kind, platform, len(text), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
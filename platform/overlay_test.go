@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOverlay_Validate(t *testing.T) {
+	for _, e := range []struct {
+		overlay *TaskOverlay
+		ok      bool
+	}{
+		{overlay: &TaskOverlay{}, ok: true},
+		{overlay: &TaskOverlay{Logo: &OverlayLogo{Image: "logo.png"}}, ok: true},
+		{overlay: &TaskOverlay{Logo: &OverlayLogo{Image: "logo.gif"}}, ok: false},
+		{overlay: &TaskOverlay{Logo: &OverlayLogo{Image: "logo.png", Position: "middle"}}, ok: false},
+		{overlay: &TaskOverlay{Logo: &OverlayLogo{Image: "logo.png", Opacity: 1.5}}, ok: false},
+		{overlay: &TaskOverlay{Texts: []*OverlayText{{Text: "{clock}"}}}, ok: true},
+		{overlay: &TaskOverlay{Texts: []*OverlayText{{Text: ""}}}, ok: false},
+		{overlay: &TaskOverlay{Texts: []*OverlayText{{Text: "Hi", Color: "white:x=0"}}}, ok: false},
+		{overlay: &TaskOverlay{Ticker: &OverlayTicker{Text: "News"}}, ok: true},
+		{overlay: &TaskOverlay{Ticker: &OverlayTicker{Text: "News", Box: "black@0.5;"}}, ok: false},
+		{overlay: &TaskOverlay{Font: "/fonts/a:b.ttf"}, ok: false},
+		{overlay: &TaskOverlay{Encoder: &OverlayEncoder{Preset: "placebo"}}, ok: false},
+	} {
+		e.overlay.Initialize()
+		if err := e.overlay.Validate(); (err == nil) != e.ok {
+			t.Errorf("Fail for %v, expect ok=%v, err %v", e.overlay.String(), e.ok, err)
+		}
+	}
+}
+
+func TestOverlay_ExpandText(t *testing.T) {
+	for _, e := range []struct {
+		text   string
+		expect string
+	}{
+		{text: "Hello", expect: "Hello"},
+		{text: "100%\nOK", expect: "100\\% OK"},
+		{text: "{title} {clock}", expect: "My\\\\TV %{localtime:%H\\:%M\\:%S}"},
+		{text: "{date}", expect: "%{localtime:%Y-%m-%d}"},
+	} {
+		if v := expandOverlayText(e.text, "My\\TV"); v != e.expect {
+			t.Errorf("Fail for %v, expect %v, actual %v", e.text, e.expect, v)
+		}
+	}
+}
+
+func TestOverlay_Apply(t *testing.T) {
+	dir := dirVLivePath
+	dirVLivePath = t.TempDir()
+	defer func() {
+		dirVLivePath = dir
+	}()
+
+	// No overlay, keep copying.
+	stream := ffmpegCopyStreams()
+	if err := applyOverlay(stream, &TaskOverlay{}, "vlive-wx", "wx"); err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if v := strings.Join(stream.outputs(), " "); v != "-map 0:v:0? -map 0:a:0? -c:v copy -c:a copy" {
+		t.Errorf("Fail for outputs %v", v)
+	}
+
+	overlay := &TaskOverlay{
+		Logo:   &OverlayLogo{Image: "logo.png", Opacity: 0.8, Margin: 10},
+		Texts:  []*OverlayText{{Text: "{title}"}},
+		Ticker: &OverlayTicker{Text: "Breaking news"},
+	}
+	overlay.Initialize()
+
+	stream = ffmpegCopyStreams()
+	if err := applyOverlay(stream, overlay, "vlive-wx", "wx"); err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+	if v := strings.Join(stream.inputs, " "); v != "-loop 1 -i logo.png" {
+		t.Errorf("Fail for inputs %v", v)
+	}
+
+	v := strings.Join(stream.outputs(), " ")
+	for _, expect := range []string{
+		"-filter_complex [0:v:0]null[base];[1:v]format=rgba,colorchannelmixer=aa=0.80[logo];[base][logo]overlay=x=W-w-10:y=10:shortest=1,drawtext=",
+		"overlay-vlive-wx-text-0.txt:reload=1", "x=w-mod(t*100\\,w+tw):y=h-th-16",
+		"[vout] -map [vout] -map 0:a:0? -c:v libx264", "-c:a copy",
+	} {
+		if !strings.Contains(v, expect) {
+			t.Errorf("Fail for %v, expect %v", v, expect)
+		}
+	}
+
+	// The ticker is updated at runtime, by writing the file.
+	if err := updateTicker(overlay, "vlive-wx", "wx", "Weather {title}"); err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if b, err := os.ReadFile(overlayFile("vlive-wx", "ticker.txt")); err != nil || string(b) != "Weather wx" {
+		t.Errorf("Fail for ticker %v, err %v", string(b), err)
+	}
+
+	// Keep the codec of normalization, and overlay after scaling.
+	stream = vLiveNormalizeArgs(&FFprobeSource{}, &VLiveProfile{Width: 1280, Height: 720, Fps: 25, Gop: 2, SampleRate: 44100})
+	if err := applyOverlay(stream, overlay, "vlive-wx", "wx"); err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if v := strings.Join(stream.outputs(), " "); !strings.Contains(v, "setsar=1[base];[2:v]") || !strings.Contains(v, "-preset veryfast") {
+		t.Errorf("Fail for outputs %v", v)
+	}
+}
@@ -0,0 +1,562 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// The query parameter of stream URL to carry the play token, for example:
+//
+//	https://ip/live/livestream.m3u8?playToken=xxx
+const playTokenParam = "playToken"
+
+// The default and max expire duration of play token.
+const playTokenDefaultExpire = 6 * time.Hour
+const playTokenMaxExpire = 365 * 24 * time.Hour
+
+// PlayTokenClaims is the claims of play token, which is signed by the playTokenKey.
+type PlayTokenClaims struct {
+	// The kind of token, must be play.
+	Kind string `json:"kind"`
+	// The app and stream that the token is allowed to play. If stream is empty, allow all streams of app.
+	App    string `json:"app"`
+	Stream string `json:"stream,omitempty"`
+	// The viewer IP that is bound to, empty for any IP.
+	IP string `json:"ip,omitempty"`
+	// The referer host that is bound to, empty for any referer.
+	Referer string `json:"referer,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (v *PlayTokenClaims) String() string {
+	var expireAt time.Time
+	if v.ExpiresAt != nil {
+		expireAt = v.ExpiresAt.Time
+	}
+	return fmt.Sprintf("kind=%v, app=%v, stream=%v, ip=%v, referer=%v, id=%v, expire=%v",
+		v.Kind, v.App, v.Stream, v.IP, v.Referer, v.ID, expireAt.Format(time.RFC3339))
+}
+
+// createPlayToken sign the claims by key, and set the id and expire time of claims.
+func createPlayToken(key string, claims *PlayTokenClaims, expire time.Duration) (string, error) {
+	if key == "" {
+		return "", errors.New("no key")
+	}
+
+	createAt := time.Now()
+	claims.Kind = "play"
+	claims.ID = uuid.NewString()
+	claims.IssuedAt = jwt.NewNumericDate(createAt)
+	claims.ExpiresAt = jwt.NewNumericDate(createAt.Add(expire))
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+	if err != nil {
+		return "", errors.Wrapf(err, "jwt sign")
+	}
+	return token, nil
+}
+
+// parsePlayToken verify the token by key, and check whether the viewer is allowed to play the stream.
+// The referer is the page URL of player, for example, the Referer header of HTTP request.
+func parsePlayToken(key, token, app, stream, ip, referer string) (*PlayTokenClaims, error) {
+	var claims PlayTokenClaims
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if _, err := parser.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(key), nil
+	}); err != nil {
+		return nil, errors.Wrapf(err, "verify token")
+	}
+
+	if claims.Kind != "play" {
+		return nil, errors.Errorf("invalid kind %v", claims.Kind)
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("no expire")
+	}
+	if claims.App != app || (claims.Stream != "" && claims.Stream != stream) {
+		return nil, errors.Errorf("stream %v/%v not match %v/%v", app, stream, claims.App, claims.Stream)
+	}
+	if claims.IP != "" && claims.IP != ip {
+		return nil, errors.Errorf("ip %v not match %v", ip, claims.IP)
+	}
+	if claims.Referer != "" {
+		var host string
+		if u, err := url.Parse(referer); err == nil {
+			host = u.Hostname()
+		}
+		if host != claims.Referer && !strings.HasSuffix(host, "."+claims.Referer) {
+			return nil, errors.Errorf("referer %v not match %v", referer, claims.Referer)
+		}
+	}
+
+	return &claims, nil
+}
+
+// The HLS segment file is named as [stream]-[seq]-[timestamp].ts, see srsGenerateConfig.
+var hlsSegmentSuffix = regexp.MustCompile(`-\d+-\d+$`)
+
+// parsePlayPath parse the app and stream from the HTTP path of HLS, FLV or segment file. For example,
+// /live/livestream.m3u8 or /live/livestream-10-1705123456789.ts is the app live and stream livestream.
+func parsePlayPath(p string) (app, stream string) {
+	app = strings.Trim(path.Dir(p), "/")
+	stream = strings.TrimSuffix(path.Base(p), path.Ext(p))
+	if path.Ext(p) == ".ts" {
+		stream = hlsSegmentSuffix.ReplaceAllString(stream, "")
+	}
+	return
+}
+
+// rewriteM3u8WithParam append the param to all URIs in m3u8, so the player carries the token when
+// requesting the segments.
+func rewriteM3u8WithParam(body, param string) string {
+	var lines []string
+	scan := bufio.NewScanner(strings.NewReader(body))
+	for scan.Scan() {
+		line := scan.Text()
+		if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			if strings.Contains(line, "?") {
+				line = fmt.Sprintf("%v&%v", line, param)
+			} else {
+				line = fmt.Sprintf("%v?%v", line, param)
+			}
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// parseTrustedProxies parse the IPs or CIDRs of trusted proxies, separated by comma, ignore the invalid ones.
+func parseTrustedProxies(proxies string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, proxy := range strings.Split(proxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		if _, ipnet, err := net.ParseCIDR(proxy); err == nil {
+			nets = append(nets, ipnet)
+		}
+	}
+	return nets
+}
+
+// isTrustedProxy whether the ip is in the trusted proxies.
+func isTrustedProxy(proxies []*net.IPNet, ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, proxy := range proxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// httpClientIP get the IP of client. Note that the X-Forwarded-For and X-Real-IP are only used when the peer
+// is a trusted proxy, such as NGINX, see TRUSTED_PROXIES, because the client is able to fake the headers.
+func httpClientIP(r *http.Request) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		peer = host
+	}
+
+	proxies := parseTrustedProxies(envTrustedProxies())
+	if !isTrustedProxy(proxies, peer) {
+		return peer
+	}
+
+	// The proxy appends the peer to X-Forwarded-For, so the client is the last one which is not a trusted
+	// proxy, and the ones before it might be faked by client.
+	if ips := r.Header.Get("X-Forwarded-For"); ips != "" {
+		forwarded := strings.Split(ips, ",")
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(forwarded[i])
+			if net.ParseIP(ip) == nil {
+				break
+			}
+			if !isTrustedProxy(proxies, ip) {
+				return ip
+			}
+		}
+	}
+	if ip := r.Header.Get("X-Real-IP"); net.ParseIP(ip) != nil {
+		return ip
+	}
+	return peer
+}
+
+// isPlayProtected whether the stream requires a play token. The protection is configured globally,
+// per app, or per stream, see handlePlayAuthService.
+func isPlayProtected(app, stream string) bool {
+	patterns := fastCache.playAuthPatterns()
+	return patterns["all"] == "true" || patterns[fmt.Sprintf("app:%v", app)] == "true" ||
+		patterns[fmt.Sprintf("stream:%v/%v", app, stream)] == "true"
+}
+
+// queryPlayTokenKey load the key to sign the play token, which is generated when boot.
+func queryPlayTokenKey(ctx context.Context) (string, error) {
+	key, err := rdb.HGet(ctx, SRS_AUTH_SECRET, "playTokenKey").Result()
+	if err != nil && err != redis.Nil {
+		return "", errors.Wrapf(err, "hget %v playTokenKey", SRS_AUTH_SECRET)
+	}
+	if key == "" {
+		return "", errors.New("system not boot yet")
+	}
+	return key, nil
+}
+
+// verifyPlayToken verify the token for the viewer to play the stream.
+func verifyPlayToken(ctx context.Context, token, app, stream, ip, referer string) (*PlayTokenClaims, error) {
+	if token == "" {
+		return nil, errors.Errorf("no token for %v/%v", app, stream)
+	}
+
+	key, err := queryPlayTokenKey(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "query key")
+	}
+
+	claims, err := parsePlayToken(key, token, app, stream, ip, referer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse token")
+	}
+	return claims, nil
+}
+
+// verifyPlayRequest verify the HTTP request to play the stream, return the param to propagate to the
+// segments, which is empty if the stream is not protected.
+func verifyPlayRequest(ctx context.Context, r *http.Request, app, stream string) (string, error) {
+	if !isPlayProtected(app, stream) {
+		return "", nil
+	}
+
+	token := r.URL.Query().Get(playTokenParam)
+	if _, err := verifyPlayToken(ctx, token, app, stream, httpClientIP(r), r.Header.Get("Referer")); err != nil {
+		return "", errors.Wrapf(err, "verify play")
+	}
+
+	return fmt.Sprintf("%v=%v", playTokenParam, url.QueryEscape(token)), nil
+}
+
+// verifyOnPlay verify the on_play event of SRS, for RTMP or WebRTC viewers.
+func verifyOnPlay(ctx context.Context, streamObj *SrsStream) (string, error) {
+	if !isPlayProtected(streamObj.App, streamObj.Stream) {
+		return "noVerify", nil
+	}
+
+	// The FFmpeg of platform pulls RTMP streams from localhost, such as forward, transcode and record. Note that
+	// the HTTP-FLV and WebRTC viewers are proxied by platform, so they are also from localhost, but not RTMP.
+	loopback := false
+	if ip := net.ParseIP(streamObj.IP); ip != nil && ip.IsLoopback() {
+		if strings.HasPrefix(streamObj.TcUrl, "rtmp://") {
+			return "loopback", nil
+		}
+		loopback = true
+	}
+
+	var token string
+	if q, err := url.ParseQuery(strings.TrimPrefix(streamObj.Param, "?")); err == nil {
+		token = q.Get(playTokenParam)
+	}
+
+	// For viewers proxied by platform, the IP is loopback, and the IP of viewer is already verified by
+	// verifyPlayRequest, so we only verify the token without the IP.
+	ip := streamObj.IP
+	if loopback {
+		ip = ""
+	}
+
+	claims, err := verifyPlayToken(ctx, token, streamObj.App, streamObj.Stream, ip, streamObj.PageURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "verify play")
+	}
+	return fmt.Sprintf("token(%v)", claims.ID), nil
+}
+
+// verifyRtcPlayRequest verify the WebRTC play request, by WHEP or the play API of SRS, which carries the
+// stream URL in the body, for example:
+//
+//	{"streamurl":"webrtc://ip/live/livestream?playToken=xxx","sdp":"..."}
+func verifyRtcPlayRequest(ctx context.Context, r *http.Request) error {
+	if strings.HasPrefix(r.URL.Path, "/rtc/v1/whep/") {
+		q := r.URL.Query()
+		if _, err := verifyPlayRequest(ctx, r, q.Get("app"), q.Get("stream")); err != nil {
+			return errors.Wrapf(err, "whep")
+		}
+		return nil
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/rtc/v1/play/") {
+		return nil
+	}
+
+	app, stream, q, err := parseRtcStream(r)
+	if err != nil {
+		return errors.Wrapf(err, "parse stream")
+	}
+	if !isPlayProtected(app, stream) {
+		return nil
+	}
+
+	token := q.Get(playTokenParam)
+	if _, err := verifyPlayToken(ctx, token, app, stream, httpClientIP(r), r.Header.Get("Referer")); err != nil {
+		return errors.Wrapf(err, "verify play")
+	}
+	return nil
+}
+
+// parseRtcStream parse the stream of WebRTC API, which is in the query for WHIP and WHEP, or in the streamurl of
+// body for the publish and play API of SRS. The body is restored to proxy to SRS.
+func parseRtcStream(r *http.Request) (app, stream string, query url.Values, err error) {
+	if !strings.HasPrefix(r.URL.Path, "/rtc/v1/publish/") && !strings.HasPrefix(r.URL.Path, "/rtc/v1/play/") {
+		q := r.URL.Query()
+		return q.Get("app"), q.Get("stream"), q, nil
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", "", nil, errors.Wrapf(err, "read body")
+	}
+	// Restore the body, to proxy to SRS.
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	var body struct {
+		StreamURL string `json:"streamurl"`
+	}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return "", "", nil, errors.Wrapf(err, "unmarshal %v", string(b))
+	}
+
+	u, err := url.Parse(body.StreamURL)
+	if err != nil {
+		return "", "", nil, errors.Wrapf(err, "parse %v", body.StreamURL)
+	}
+	return strings.Trim(path.Dir(u.Path), "/"), path.Base(u.Path), u.Query(), nil
+}
+
+// m3u8ParamResponseWriter buffers the m3u8 response, and rewrite the URIs with param when done.
+type m3u8ParamResponseWriter struct {
+	w      http.ResponseWriter
+	param  string
+	status int
+	body   bytes.Buffer
+}
+
+func (v *m3u8ParamResponseWriter) Header() http.Header {
+	return v.w.Header()
+}
+
+func (v *m3u8ParamResponseWriter) Write(b []byte) (int, error) {
+	return v.body.Write(b)
+}
+
+func (v *m3u8ParamResponseWriter) WriteHeader(statusCode int) {
+	v.status = statusCode
+}
+
+// Flush write the rewritten m3u8 to the underlayer response writer.
+func (v *m3u8ParamResponseWriter) Flush() error {
+	body := v.body.String()
+	if v.status == 0 || v.status == http.StatusOK {
+		body = rewriteM3u8WithParam(body, v.param)
+	}
+
+	v.w.Header().Set("Content-Length", fmt.Sprintf("%v", len(body)))
+	if v.status != 0 {
+		v.w.WriteHeader(v.status)
+	}
+	_, err := v.w.Write([]byte(body))
+	return err
+}
+
+func handlePlayAuthService(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/hooks/srs/play/token"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, app, stream, ip, referer string
+			var expire int64
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token   *string `json:"token"`
+				App     *string `json:"app"`
+				Stream  *string `json:"stream"`
+				IP      *string `json:"ip"`
+				Referer *string `json:"referer"`
+				Expire  *int64  `json:"expire"`
+			}{
+				Token: &token, App: &app, Stream: &stream, IP: &ip, Referer: &referer, Expire: &expire,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if app == "" {
+				return errors.New("no app")
+			}
+			if ip != "" && net.ParseIP(ip) == nil {
+				return errors.Errorf("invalid ip %v", ip)
+			}
+
+			duration := playTokenDefaultExpire
+			if expire < 0 {
+				return errors.Errorf("invalid expire %v", expire)
+			} else if expire > 0 {
+				duration = time.Duration(expire) * time.Second
+			}
+			if duration > playTokenMaxExpire {
+				return errors.Errorf("expire %v exceed %v", duration, playTokenMaxExpire)
+			}
+
+			key, err := queryPlayTokenKey(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "query key")
+			}
+
+			claims := &PlayTokenClaims{App: app, Stream: stream, IP: ip, Referer: referer}
+			playToken, err := createPlayToken(key, claims, duration)
+			if err != nil {
+				return errors.Wrapf(err, "create token")
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				// The play token.
+				Token string `json:"token"`
+				// The param to append to the stream URL.
+				Param string `json:"param"`
+				// The token id.
+				ID string `json:"id"`
+				// The expire time of token.
+				ExpireAt string `json:"expireAt"`
+			}{
+				Token: playToken, Param: fmt.Sprintf("%v=%v", playTokenParam, playToken),
+				ID: claims.ID, ExpireAt: claims.ExpiresAt.Time.Format(time.RFC3339),
+			})
+			logger.Tf(ctx, "play token create ok, %v, token=%vB", claims.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/srs/play/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			patterns, err := rdb.HGetAll(ctx, SRS_PLAY_AUTH).Result()
+			if err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hgetall %v", SRS_PLAY_AUTH)
+			}
+
+			ohttp.WriteData(ctx, w, r, patterns)
+			logger.Tf(ctx, "play auth query ok, patterns=%v, token=%vB", len(patterns), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/srs/play/apply"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, app, stream string
+			var enabled bool
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token   *string `json:"token"`
+				App     *string `json:"app"`
+				Stream  *string `json:"stream"`
+				Enabled *bool   `json:"enabled"`
+			}{
+				Token: &token, App: &app, Stream: &stream, Enabled: &enabled,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			// Protect all streams if no app, or all streams of app if no stream.
+			pattern := "all"
+			if app != "" && stream != "" {
+				pattern = fmt.Sprintf("stream:%v/%v", app, stream)
+			} else if app != "" {
+				pattern = fmt.Sprintf("app:%v", app)
+			} else if stream != "" {
+				return errors.Errorf("no app for stream %v", stream)
+			}
+
+			if enabled {
+				if err := rdb.HSet(ctx, SRS_PLAY_AUTH, pattern, "true").Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hset %v %v true", SRS_PLAY_AUTH, pattern)
+				}
+			} else {
+				if err := rdb.HDel(ctx, SRS_PLAY_AUTH, pattern).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hdel %v %v", SRS_PLAY_AUTH, pattern)
+				}
+			}
+
+			if err := fastCache.Refresh(ctx); err != nil {
+				return errors.Wrapf(err, "refresh fast cache")
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "play auth apply ok, pattern=%v, enabled=%v, token=%vB", pattern, enabled, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
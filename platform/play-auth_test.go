@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPlayAuth_ParseToken(t *testing.T) {
+	key := "0123456789abcdef"
+	token, err := createPlayToken(key, &PlayTokenClaims{App: "live", IP: "10.0.0.1", Referer: "example.com"}, time.Minute)
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+
+	for _, e := range []struct {
+		key     string
+		stream  string
+		ip      string
+		referer string
+		ok      bool
+	}{
+		{key: key, stream: "livestream", ip: "10.0.0.1", referer: "https://example.com/player.html", ok: true},
+		{key: key, stream: "other", ip: "10.0.0.1", referer: "https://www.example.com/", ok: true},
+		{key: key, stream: "livestream", ip: "10.0.0.2", referer: "https://example.com/", ok: false},
+		{key: key, stream: "livestream", ip: "10.0.0.1", referer: "https://badexample.com/", ok: false},
+		{key: key, stream: "livestream", ip: "10.0.0.1", referer: "", ok: false},
+		{key: "invalid", stream: "livestream", ip: "10.0.0.1", referer: "https://example.com/", ok: false},
+	} {
+		if _, err := parsePlayToken(e.key, token, "live", e.stream, e.ip, e.referer); (err == nil) != e.ok {
+			t.Errorf("verify %v failed, expect %v, err %v", e, e.ok, err)
+		}
+	}
+
+	// The publish token should never be used to play.
+	pubToken, err := createPublishToken(key, &PublishTokenClaims{App: "live", Stream: "livestream"}, time.Minute)
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if _, err := parsePlayToken(key, pubToken, "live", "livestream", "", ""); err == nil {
+		t.Errorf("publish token should fail")
+	}
+}
+
+func TestPlayAuth_ParsePath(t *testing.T) {
+	for _, e := range []struct {
+		path   string
+		app    string
+		stream string
+	}{
+		{path: "/live/livestream.m3u8", app: "live", stream: "livestream"},
+		{path: "/live/livestream.flv", app: "live", stream: "livestream"},
+		{path: "/live/livestream-10-1705123456789.ts", app: "live", stream: "livestream"},
+		{path: "/live/my-stream-3-1705123456789.ts", app: "live", stream: "my-stream"},
+		{path: "/live/my-stream.m3u8", app: "live", stream: "my-stream"},
+		{path: "/a/b/livestream.aac", app: "a/b", stream: "livestream"},
+	} {
+		if app, stream := parsePlayPath(e.path); app != e.app || stream != e.stream {
+			t.Errorf("Fail for %v, app=%v, stream=%v", e, app, stream)
+		}
+	}
+}
+
+func TestPlayAuth_RewriteM3u8(t *testing.T) {
+	body := "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10.0,\nlivestream-0-1.ts\n#EXTINF:10.0,\nlivestream-1-2.ts?hls_ctx=abc\n"
+	expect := "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10.0,\nlivestream-0-1.ts?playToken=xxx\n#EXTINF:10.0,\nlivestream-1-2.ts?hls_ctx=abc&playToken=xxx\n"
+	if v := rewriteM3u8WithParam(body, "playToken=xxx"); v != expect {
+		t.Errorf("Fail for rewrite, expect %v, actual %v", expect, v)
+	}
+}
+
+func TestPlayAuth_ClientIP(t *testing.T) {
+	proxies := os.Getenv("TRUSTED_PROXIES")
+	os.Setenv("TRUSTED_PROXIES", "127.0.0.1,10.0.0.0/8")
+	defer os.Setenv("TRUSTED_PROXIES", proxies)
+
+	for _, e := range []struct {
+		remote    string
+		realIP    string
+		forwarded string
+		expect    string
+	}{
+		// The headers from untrusted peer are ignored.
+		{remote: "1.2.3.4:5678", realIP: "8.8.8.8", forwarded: "8.8.8.8", expect: "1.2.3.4"},
+		{remote: "127.0.0.1:5678", expect: "127.0.0.1"},
+		{remote: "127.0.0.1:5678", realIP: "1.2.3.4", expect: "1.2.3.4"},
+		// The client fakes the first IP, and NGINX appends the real one.
+		{remote: "127.0.0.1:5678", realIP: "1.2.3.4", forwarded: "8.8.8.8, 1.2.3.4", expect: "1.2.3.4"},
+		// Skip the trusted proxies in chain.
+		{remote: "127.0.0.1:5678", forwarded: "8.8.8.8, 1.2.3.4, 10.0.0.2", expect: "1.2.3.4"},
+		{remote: "127.0.0.1:5678", forwarded: "invalid", expect: "127.0.0.1"},
+	} {
+		r := &http.Request{RemoteAddr: e.remote, Header: http.Header{}}
+		if e.realIP != "" {
+			r.Header.Set("X-Real-IP", e.realIP)
+		}
+		if e.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", e.forwarded)
+		}
+		if ip := httpClientIP(r); ip != e.expect {
+			t.Errorf("Fail for %v, expect %v, actual %v", e, e.expect, ip)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// The query parameter of stream URL to carry the publish token, for example:
+//
+//	rtmp://ip/live/livestream?pubToken=xxx
+const publishTokenParam = "pubToken"
+
+// The default and max expire duration of publish token.
+const publishTokenDefaultExpire = time.Hour
+const publishTokenMaxExpire = 365 * 24 * time.Hour
+
+// PublishTokenClaims is the claims of publish token, which is signed by the pubTokenKey, never by the
+// api secret, because the token is given to encoders and should never be used to access the API.
+type PublishTokenClaims struct {
+	// The kind of token, must be publish.
+	Kind string `json:"kind"`
+	// The app and stream that the token is allowed to publish to.
+	App    string `json:"app"`
+	Stream string `json:"stream"`
+	// The source IP that is bound to, empty for any IP.
+	IP string `json:"ip,omitempty"`
+	// Whether the token can be used only once.
+	Once bool `json:"once,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (v *PublishTokenClaims) String() string {
+	var expireAt time.Time
+	if v.ExpiresAt != nil {
+		expireAt = v.ExpiresAt.Time
+	}
+	return fmt.Sprintf("kind=%v, app=%v, stream=%v, ip=%v, once=%v, id=%v, expire=%v",
+		v.Kind, v.App, v.Stream, v.IP, v.Once, v.ID, expireAt.Format(time.RFC3339))
+}
+
+// createPublishToken sign the claims by key, and set the id and expire time of claims.
+func createPublishToken(key string, claims *PublishTokenClaims, expire time.Duration) (string, error) {
+	if key == "" {
+		return "", errors.New("no key")
+	}
+
+	createAt := time.Now()
+	claims.Kind = "publish"
+	claims.ID = uuid.NewString()
+	claims.IssuedAt = jwt.NewNumericDate(createAt)
+	claims.ExpiresAt = jwt.NewNumericDate(createAt.Add(expire))
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+	if err != nil {
+		return "", errors.Wrapf(err, "jwt sign")
+	}
+	return token, nil
+}
+
+// parsePublishToken verify the token by key, and check whether the stream is allowed to publish.
+// Note that the single-use is not checked here, because it depends on redis.
+func parsePublishToken(key, token string, streamObj *SrsStream) (*PublishTokenClaims, error) {
+	var claims PublishTokenClaims
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if _, err := parser.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(key), nil
+	}); err != nil {
+		return nil, errors.Wrapf(err, "verify token")
+	}
+
+	if claims.Kind != "publish" {
+		return nil, errors.Errorf("invalid kind %v", claims.Kind)
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("no expire")
+	}
+	if claims.App != streamObj.App || claims.Stream != streamObj.Stream {
+		return nil, errors.Errorf("stream %v/%v not match %v/%v",
+			streamObj.App, streamObj.Stream, claims.App, claims.Stream)
+	}
+	if claims.IP != "" && claims.IP != streamObj.IP {
+		return nil, errors.Errorf("ip %v not match %v", streamObj.IP, claims.IP)
+	}
+
+	return &claims, nil
+}
+
+// publishTokenFromParam parse the publish token from the param of stream, for example, the param is
+// ?pubToken=xxx&upstream=srt for SRT stream.
+func publishTokenFromParam(param string) string {
+	q, err := url.ParseQuery(strings.TrimPrefix(param, "?"))
+	if err != nil {
+		return ""
+	}
+	return q.Get(publishTokenParam)
+}
+
+// queryPublishTokenKey load the key to sign the publish token, which is generated when boot.
+func queryPublishTokenKey(ctx context.Context) (string, error) {
+	key, err := rdb.HGet(ctx, SRS_AUTH_SECRET, "pubTokenKey").Result()
+	if err != nil && err != redis.Nil {
+		return "", errors.Wrapf(err, "hget %v pubTokenKey", SRS_AUTH_SECRET)
+	}
+	if key == "" {
+		return "", errors.New("system not boot yet")
+	}
+	return key, nil
+}
+
+// verifyPublishToken verify the publish token of stream, and consume the token if it's single-use.
+func verifyPublishToken(ctx context.Context, token string, streamObj *SrsStream) (*PublishTokenClaims, error) {
+	key, err := queryPublishTokenKey(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "query key")
+	}
+
+	claims, err := parsePublishToken(key, token, streamObj)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse token")
+	}
+
+	// For single-use token, the nonce expires with the token, so we don't need to cleanup it.
+	if claims.Once {
+		nonceKey := fmt.Sprintf("%v:%v", SRS_PUBLISH_NONCE, claims.ID)
+		expire := time.Until(claims.ExpiresAt.Time) + time.Minute
+		if ok, err := rdb.SetNX(ctx, nonceKey, streamObj.StreamURL(), expire).Result(); err != nil {
+			return nil, errors.Wrapf(err, "setnx %v", nonceKey)
+		} else if !ok {
+			return nil, errors.Errorf("token %v already used", claims.ID)
+		}
+	}
+
+	return claims, nil
+}
+
+// The expire duration of publish token for platform task, which is verified once FFmpeg starts to publish.
+const platformPublishTokenExpire = 10 * time.Minute
+
+// signPublishURL sign the RTMP url by publish token, if publish to this server, for example, the url is
+// rtmp://localhost/live/livestream?secret=xxx, return the url without change if publish to other servers.
+func signPublishURL(key, outputURL string) (string, error) {
+	u, err := url.Parse(outputURL)
+	if err != nil || u.Scheme != "rtmp" {
+		return outputURL, nil
+	}
+	if host := u.Hostname(); host != "localhost" && host != "127.0.0.1" && host != "::1" {
+		return outputURL, nil
+	}
+
+	claims := &PublishTokenClaims{App: strings.Trim(path.Dir(u.Path), "/"), Stream: path.Base(u.Path)}
+	pubToken, err := createPublishToken(key, claims, platformPublishTokenExpire)
+	if err != nil {
+		return "", errors.Wrapf(err, "create token")
+	}
+
+	separator := "?"
+	if strings.Contains(outputURL, "?") {
+		separator = "&"
+	}
+	return fmt.Sprintf("%v%v%v=%v", outputURL, separator, publishTokenParam, pubToken), nil
+}
+
+// platformPublishURL sign the output of platform task, such as forward, vLive, camera, transcode and mosaic,
+// because the legacy secret may be disabled, see signPublishURL.
+func platformPublishURL(ctx context.Context, outputURL string) (string, error) {
+	key, err := queryPublishTokenKey(ctx)
+	if err != nil {
+		return "", errors.Wrapf(err, "query key")
+	}
+
+	signed, err := signPublishURL(key, outputURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "sign %v", outputURL)
+	}
+	return signed, nil
+}
+
+// isPublishLegacyEnabled whether allow to publish by the legacy secret, such as the global publish
+// secret or the live room secret. It's disabled for new install, and user must explicitly opt in, while
+// it's enabled for the existing install by migration, see initPublishLegacy.
+func isPublishLegacyEnabled(ctx context.Context) (bool, error) {
+	legacy, err := rdb.HGet(ctx, SRS_AUTH_SECRET, "pubLegacy").Result()
+	if err != nil && err != redis.Nil {
+		return false, errors.Wrapf(err, "hget %v pubLegacy", SRS_AUTH_SECRET)
+	}
+	return legacy == "true", nil
+}
+
+func handlePublishTokenService(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/hooks/srs/publish/token"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, app, stream, ip string
+			var expire int64
+			var once bool
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string `json:"token"`
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
+				IP     *string `json:"ip"`
+				Expire *int64  `json:"expire"`
+				Once   *bool   `json:"once"`
+			}{
+				Token: &token, App: &app, Stream: &stream, IP: &ip, Expire: &expire, Once: &once,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if app == "" {
+				return errors.New("no app")
+			}
+			if stream == "" {
+				return errors.New("no stream")
+			}
+			if ip != "" && net.ParseIP(ip) == nil {
+				return errors.Errorf("invalid ip %v", ip)
+			}
+
+			duration := publishTokenDefaultExpire
+			if expire < 0 {
+				return errors.Errorf("invalid expire %v", expire)
+			} else if expire > 0 {
+				duration = time.Duration(expire) * time.Second
+			}
+			if duration > publishTokenMaxExpire {
+				return errors.Errorf("expire %v exceed %v", duration, publishTokenMaxExpire)
+			}
+
+			key, err := queryPublishTokenKey(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "query key")
+			}
+
+			claims := &PublishTokenClaims{App: app, Stream: stream, IP: ip, Once: once}
+			pubToken, err := createPublishToken(key, claims, duration)
+			if err != nil {
+				return errors.Wrapf(err, "create token")
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				// The publish token.
+				Token string `json:"token"`
+				// The param to append to the stream URL.
+				Param string `json:"param"`
+				// The token id, also the nonce for single-use token.
+				ID string `json:"id"`
+				// The expire time of token.
+				ExpireAt string `json:"expireAt"`
+			}{
+				Token: pubToken, Param: fmt.Sprintf("%v=%v", publishTokenParam, pubToken),
+				ID: claims.ID, ExpireAt: claims.ExpiresAt.Time.Format(time.RFC3339),
+			})
+			logger.Tf(ctx, "publish token create ok, %v, token=%vB", claims.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/srs/secret/legacy"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var pubLegacy bool
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token     *string `json:"token"`
+				PubLegacy *bool   `json:"pubLegacy"`
+			}{
+				Token: &token, PubLegacy: &pubLegacy,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if err := rdb.HSet(ctx, SRS_AUTH_SECRET, "pubLegacy", fmt.Sprintf("%v", pubLegacy)).Err(); err != nil {
+				return errors.Wrapf(err, "hset %v pubLegacy %v", SRS_AUTH_SECRET, pubLegacy)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "hooks legacy secret, pubLegacy=%v, token=%vB", pubLegacy, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublishAuth_ParseToken(t *testing.T) {
+	key := "0123456789abcdef"
+	claims := &PublishTokenClaims{App: "live", Stream: "livestream", IP: "10.0.0.1"}
+	token, err := createPublishToken(key, claims, time.Minute)
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+
+	if param := "?" + publishTokenParam + "=" + token + "&upstream=srt"; publishTokenFromParam(param) != token {
+		t.Errorf("parse token from %v failed", param)
+	}
+
+	for _, e := range []struct {
+		key    string
+		stream SrsStream
+		ok     bool
+	}{
+		{key: key, stream: SrsStream{App: "live", Stream: "livestream", IP: "10.0.0.1"}, ok: true},
+		{key: key, stream: SrsStream{App: "live", Stream: "other", IP: "10.0.0.1"}, ok: false},
+		{key: key, stream: SrsStream{App: "live", Stream: "livestream", IP: "10.0.0.2"}, ok: false},
+		{key: "invalid", stream: SrsStream{App: "live", Stream: "livestream", IP: "10.0.0.1"}, ok: false},
+	} {
+		if _, err := parsePublishToken(e.key, token, &e.stream); (err == nil) != e.ok {
+			t.Errorf("verify %v failed, expect %v, err %v", e.stream.String(), e.ok, err)
+		}
+	}
+
+	expired, err := createPublishToken(key, &PublishTokenClaims{App: "live", Stream: "livestream"}, -time.Minute)
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if _, err := parsePublishToken(key, expired, &SrsStream{App: "live", Stream: "livestream"}); err == nil {
+		t.Errorf("expired token should fail")
+	}
+}
+
+func TestPublishAuth_SignURL(t *testing.T) {
+	key := "0123456789abcdef"
+	for _, e := range []struct {
+		url    string
+		signed bool
+		param  string
+	}{
+		{url: "rtmp://localhost/live/livestream", signed: true, param: ""},
+		{url: "rtmp://127.0.0.1/live/livestream_720p?secret=xxx", signed: true, param: "?secret=xxx"},
+		{url: "rtmp://example.com/live/livestream", signed: false},
+		{url: "srt://localhost:10080?streamid=#!::r=live/livestream,m=publish", signed: false},
+		{url: "-", signed: false},
+	} {
+		signed, err := signPublishURL(key, e.url)
+		if err != nil {
+			t.Errorf("Fail for %v, err %+v", e.url, err)
+			continue
+		}
+		if !e.signed {
+			if signed != e.url {
+				t.Errorf("Fail for %v, expect not signed, got %v", e.url, signed)
+			}
+			continue
+		}
+
+		u, err := url.Parse(signed)
+		if err != nil {
+			t.Errorf("Fail for %v, err %+v", signed, err)
+			continue
+		}
+		streamObj := &SrsStream{App: "live", Stream: path.Base(u.Path)}
+		if !strings.HasPrefix(signed, e.url) {
+			t.Errorf("Fail for %v, got %v", e.url, signed)
+		} else if _, err := parsePublishToken(key, publishTokenFromParam("?"+u.RawQuery), streamObj); err != nil {
+			t.Errorf("Fail for %v, err %+v", signed, err)
+		} else if e.param != "" && !strings.HasPrefix(signed, e.url+"&") {
+			t.Errorf("Fail for %v, expect keep %v", signed, e.param)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// Embed the timezone database, because the container might not have one.
+	_ "time/tzdata"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+)
+
+// The policy for the window which is started when the service is down, for example, restarting.
+const (
+	// Run the rest of the window, which is the default policy.
+	scheduleMissedRun = "run"
+	// Skip the window, and wait for the next one.
+	scheduleMissedSkip = "skip"
+)
+
+// The max years to search for the next time of cron expression.
+const cronSearchYears = 5
+
+// The time when service starts, to identify the missed windows.
+var scheduleBootTime = time.Now()
+
+// The macros of cron expression.
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// TaskSchedule is a window in which the task runs, for forward, vLive and IP camera. It's a one-off
+// window by start and stop, or a recurring window by cron and duration.
+type TaskSchedule struct {
+	// For one-off window, the start time in RFC3339, for example, 2024-01-01T20:00:00+08:00
+	Start string `json:"start,omitempty"`
+	// For one-off window, the stop time in RFC3339.
+	Stop string `json:"stop,omitempty"`
+	// For recurring window, the cron expression of start, in the format of "minute hour dom month dow",
+	// for example, "0 20 * * 5" is every Friday at 20:00.
+	Cron string `json:"cron,omitempty"`
+	// For recurring window, the duration of window in seconds.
+	Duration int `json:"duration,omitempty"`
+	// For recurring window, the timezone of cron, for example, Asia/Shanghai. Use UTC if empty.
+	Timezone string `json:"timezone,omitempty"`
+	// The policy for the window which is started when the service is down, run or skip.
+	Missed string `json:"missed,omitempty"`
+}
+
+func (v *TaskSchedule) String() string {
+	if v.Cron != "" {
+		return fmt.Sprintf("cron=%v, duration=%v, timezone=%v, missed=%v", v.Cron, v.Duration, v.Timezone, v.Missed)
+	}
+	return fmt.Sprintf("start=%v, stop=%v, missed=%v", v.Start, v.Stop, v.Missed)
+}
+
+// Validate the schedule.
+func (v *TaskSchedule) Validate() error {
+	if v.Missed != "" && v.Missed != scheduleMissedRun && v.Missed != scheduleMissedSkip {
+		return errors.Errorf("invalid missed %v", v.Missed)
+	}
+
+	if v.Cron == "" {
+		if v.Duration != 0 || v.Timezone != "" {
+			return errors.Errorf("duration and timezone are only for cron, %v", v.String())
+		}
+
+		start, err := time.Parse(time.RFC3339, v.Start)
+		if err != nil {
+			return errors.Wrapf(err, "parse start %v", v.Start)
+		}
+		stop, err := time.Parse(time.RFC3339, v.Stop)
+		if err != nil {
+			return errors.Wrapf(err, "parse stop %v", v.Stop)
+		}
+		if !stop.After(start) {
+			return errors.Errorf("stop %v should after start %v", v.Stop, v.Start)
+		}
+		return nil
+	}
+
+	if v.Start != "" || v.Stop != "" {
+		return errors.Errorf("start and stop are only for one-off window, %v", v.String())
+	}
+	if v.Duration <= 0 {
+		return errors.Errorf("invalid duration %v", v.Duration)
+	}
+	if _, err := time.LoadLocation(v.Timezone); err != nil {
+		return errors.Wrapf(err, "load timezone %v", v.Timezone)
+	}
+	if _, err := parseCron(v.Cron); err != nil {
+		return errors.Wrapf(err, "parse cron %v", v.Cron)
+	}
+	return nil
+}
+
+// window get the current window at now, return whether in the window.
+func (v *TaskSchedule) window(now time.Time) (start, stop time.Time, ok bool) {
+	if v.Cron == "" {
+		start, _ = time.Parse(time.RFC3339, v.Start)
+		stop, _ = time.Parse(time.RFC3339, v.Stop)
+		return start, stop, !now.Before(start) && now.Before(stop)
+	}
+
+	// The first window which starts after now-duration, is the current window if it starts before now.
+	cron, location, err := v.parse()
+	if err != nil {
+		return
+	}
+	duration := time.Duration(v.Duration) * time.Second
+	if start = cron.next(now.Add(-duration).In(location)); start.IsZero() || start.After(now) {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, start.Add(duration), true
+}
+
+// next get the start time of next window after now, zero if no more window.
+func (v *TaskSchedule) next(now time.Time) time.Time {
+	if v.Cron == "" {
+		if start, err := time.Parse(time.RFC3339, v.Start); err == nil && start.After(now) {
+			return start
+		}
+		return time.Time{}
+	}
+
+	cron, location, err := v.parse()
+	if err != nil {
+		return time.Time{}
+	}
+	return cron.next(now.In(location))
+}
+
+func (v *TaskSchedule) parse() (*cronSpec, *time.Location, error) {
+	location, err := time.LoadLocation(v.Timezone)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "load timezone %v", v.Timezone)
+	}
+
+	cron, err := parseCron(v.Cron)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "parse cron %v", v.Cron)
+	}
+	return cron, location, nil
+}
+
+// validateSchedules validate all schedules of task.
+func validateSchedules(schedules []*TaskSchedule) error {
+	for i, schedule := range schedules {
+		if schedule == nil {
+			return errors.Errorf("schedule #%v is nil", i)
+		}
+		if err := schedule.Validate(); err != nil {
+			return errors.Wrapf(err, "schedule #%v", i)
+		}
+	}
+	return nil
+}
+
+// ScheduleState is the state of schedules of task, which is updated by crontab.
+type ScheduleState struct {
+	// Whether the task is allowed to run, always true if no schedule.
+	Active bool `json:"active"`
+	// The stop time of current window, in RFC3339.
+	Stop string `json:"stop,omitempty"`
+	// The start time of next window, in RFC3339.
+	Next string `json:"next,omitempty"`
+	// Whether the current window is skipped, because it's started when the service is down.
+	Skipped bool `json:"skipped,omitempty"`
+
+	// To protect the fields.
+	lock sync.Mutex
+}
+
+func NewScheduleState() *ScheduleState {
+	return &ScheduleState{Active: true}
+}
+
+func (v *ScheduleState) String() string {
+	return fmt.Sprintf("active=%v, stop=%v, next=%v, skipped=%v", v.Active, v.Stop, v.Next, v.Skipped)
+}
+
+// Update the state by schedules at now, return the previous and current active state.
+func (v *ScheduleState) Update(schedules []*TaskSchedule, now time.Time) (from, to bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var active, skipped bool
+	var stop, next time.Time
+	if len(schedules) == 0 {
+		active = true
+	}
+
+	for _, schedule := range schedules {
+		if schedule == nil {
+			continue
+		}
+
+		if start, end, ok := schedule.window(now); ok {
+			if schedule.Missed == scheduleMissedSkip && start.Before(scheduleBootTime) {
+				skipped = true
+			} else {
+				active = true
+				if end.After(stop) {
+					stop = end
+				}
+			}
+		}
+
+		if start := schedule.next(now); !start.IsZero() && (next.IsZero() || start.Before(next)) {
+			next = start
+		}
+	}
+
+	from = v.Active
+	v.Active, v.Skipped = active, skipped && !active
+	v.Stop, v.Next = "", ""
+	if !stop.IsZero() {
+		v.Stop = stop.Format(time.RFC3339)
+	}
+	if !next.IsZero() {
+		v.Next = next.Format(time.RFC3339)
+	}
+	return from, v.Active
+}
+
+// IsActive whether the task is allowed to run.
+func (v *ScheduleState) IsActive() bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.Active
+}
+
+// Query get a copy of state.
+func (v *ScheduleState) Query() *ScheduleState {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return &ScheduleState{Active: v.Active, Stop: v.Stop, Next: v.Next, Skipped: v.Skipped}
+}
+
+// querySchedule get the state of schedules at now, nil if no schedule.
+func querySchedule(schedules []*TaskSchedule) *ScheduleState {
+	if len(schedules) == 0 {
+		return nil
+	}
+
+	state := NewScheduleState()
+	state.Update(schedules, time.Now())
+	return state.Query()
+}
+
+// cronSpec is the parsed cron expression, each field is a bitset.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	// Whether the day of month or week is *, to match day by both or either.
+	domStar, dowStar bool
+}
+
+// parseCron parse the cron expression, in the format of "minute hour dom month dow", each field is *,
+// a number, a range a-b, a list a,b, or with step like */5 or 1-10/2. The dow 0 and 7 are Sunday.
+func parseCron(expr string) (*cronSpec, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(expr)]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("expect 5 fields, actual %v", len(fields))
+	}
+
+	var c cronSpec
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, errors.Wrapf(err, "minute %v", fields[0])
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, errors.Wrapf(err, "hour %v", fields[1])
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, errors.Wrapf(err, "dom %v", fields[2])
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, errors.Wrapf(err, "month %v", fields[3])
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, errors.Wrapf(err, "dow %v", fields[4])
+	}
+
+	// The 7 is also Sunday.
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domStar, c.dowStar = strings.HasPrefix(fields[2], "*"), strings.HasPrefix(fields[4], "*")
+	return &c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if index := strings.Index(part, "/"); index >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[index+1:]); err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step %v", part)
+			}
+			rangePart = part[:index]
+		}
+
+		low, high := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.Errorf("invalid value %v", part)
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.Errorf("invalid value %v", part)
+				}
+			} else if step > 1 {
+				// The a/n is from a to max by step n.
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, errors.Errorf("out of range %v, should in [%v, %v]", part, min, max)
+		}
+
+		for i := low; i <= high; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func (v *cronSpec) matchDay(t time.Time) bool {
+	dom := v.dom&(1<<uint(t.Day())) != 0
+	dow := v.dow&(1<<uint(t.Weekday())) != 0
+	if v.domStar || v.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next get the next time matches the cron, which is after t, in the location of t. Zero if not found.
+func (v *cronSpec) next(t time.Time) time.Time {
+	location := t.Location()
+
+	// Start from the next minute, note that we use the absolute duration to avoid the DST issue.
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	for limit := t.Year() + cronSearchYears; t.Year() <= limit; {
+		if v.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, location)
+			continue
+		}
+		if !v.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, location)
+			continue
+		}
+		if v.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		if v.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule_Cron(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+
+	for _, e := range []struct {
+		cron string
+		now  time.Time
+		next time.Time
+	}{
+		// Every Friday at 20:00, 2024-01-01 is Monday.
+		{cron: "0 20 * * 5", now: time.Date(2024, 1, 1, 0, 0, 0, 0, shanghai), next: time.Date(2024, 1, 5, 20, 0, 0, 0, shanghai)},
+		{cron: "0 20 * * 5", now: time.Date(2024, 1, 5, 20, 0, 0, 0, shanghai), next: time.Date(2024, 1, 12, 20, 0, 0, 0, shanghai)},
+		{cron: "*/15 * * * *", now: time.Date(2024, 1, 1, 10, 7, 30, 0, time.UTC), next: time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC)},
+		{cron: "30 9-11/2 * * 1-5", now: time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC), next: time.Date(2024, 1, 1, 11, 30, 0, 0, time.UTC)},
+		{cron: "0 0 29 2 *", now: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), next: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{cron: "0 0 * * 7", now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), next: time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		// Either day of month or day of week matches, if both specified.
+		{cron: "0 0 15 * 1", now: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), next: time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)},
+		{cron: "@daily", now: time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC), next: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		// The 02:30 does not exist when DST starts on 2024-03-10 in New York.
+		{cron: "30 2 * * *", now: time.Date(2024, 3, 9, 12, 0, 0, 0, newYork), next: time.Date(2024, 3, 11, 2, 30, 0, 0, newYork)},
+	} {
+		spec, err := parseCron(e.cron)
+		if err != nil {
+			t.Errorf("Fail for %v, err %+v", e.cron, err)
+		} else if v := spec.next(e.now); !v.Equal(e.next) {
+			t.Errorf("Fail for %v at %v, expect %v, actual %v", e.cron, e.now, e.next, v)
+		}
+	}
+
+	for _, cron := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		if _, err := parseCron(cron); err == nil {
+			t.Errorf("Fail for %v, should fail", cron)
+		}
+	}
+}
+
+func TestSchedule_Validate(t *testing.T) {
+	for _, e := range []struct {
+		schedule TaskSchedule
+		err      bool
+	}{
+		{schedule: TaskSchedule{Start: "2024-01-01T20:00:00+08:00", Stop: "2024-01-01T22:00:00+08:00"}},
+		{schedule: TaskSchedule{Cron: "0 20 * * 5", Duration: 3600, Timezone: "Asia/Shanghai", Missed: "skip"}},
+		{schedule: TaskSchedule{Cron: "0 20 * * 5", Duration: 3600}},
+		{schedule: TaskSchedule{}, err: true},
+		{schedule: TaskSchedule{Start: "2024-01-01T22:00:00+08:00", Stop: "2024-01-01T20:00:00+08:00"}, err: true},
+		{schedule: TaskSchedule{Start: "2024-01-01 20:00:00", Stop: "2024-01-01T22:00:00+08:00"}, err: true},
+		{schedule: TaskSchedule{Cron: "0 20 * * 5"}, err: true},
+		{schedule: TaskSchedule{Cron: "0 20 * * 5", Duration: 3600, Timezone: "Mars/Base"}, err: true},
+		{schedule: TaskSchedule{Cron: "0 20 * * 5", Duration: 3600, Missed: "replay"}, err: true},
+		{schedule: TaskSchedule{Cron: "0 20 * * 5", Duration: 3600, Start: "2024-01-01T20:00:00+08:00"}, err: true},
+	} {
+		if err := e.schedule.Validate(); (err != nil) != e.err {
+			t.Errorf("Fail for %v, err %+v", e.schedule.String(), err)
+		}
+	}
+}
+
+func TestSchedule_State(t *testing.T) {
+	state := NewScheduleState()
+	if from, to := state.Update(nil, time.Now()); !from || !to {
+		t.Errorf("Fail for no schedule, from=%v, to=%v", from, to)
+	}
+
+	// Every day 20:00 to 21:00 in UTC, and a one-off window.
+	schedules := []*TaskSchedule{
+		{Cron: "0 20 * * *", Duration: 3600},
+		{Start: "2024-01-01T08:00:00Z", Stop: "2024-01-01T09:00:00Z"},
+	}
+	for _, e := range []struct {
+		now    time.Time
+		active bool
+		stop   string
+		next   string
+	}{
+		{now: time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC), next: "2024-01-01T08:00:00Z"},
+		{now: time.Date(2024, 1, 1, 8, 30, 0, 0, time.UTC), active: true, stop: "2024-01-01T09:00:00Z", next: "2024-01-01T20:00:00Z"},
+		{now: time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), next: "2024-01-01T20:00:00Z"},
+		{now: time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC), active: true, stop: "2024-01-01T21:00:00Z", next: "2024-01-02T20:00:00Z"},
+		{now: time.Date(2024, 1, 1, 20, 59, 59, 0, time.UTC), active: true, stop: "2024-01-01T21:00:00Z", next: "2024-01-02T20:00:00Z"},
+		{now: time.Date(2024, 1, 1, 21, 0, 0, 0, time.UTC), next: "2024-01-02T20:00:00Z"},
+	} {
+		state.Update(schedules, e.now)
+		if v := state.Query(); v.Active != e.active || v.Stop != e.stop || v.Next != e.next {
+			t.Errorf("Fail for %v, expect active=%v, stop=%v, next=%v, actual %v", e.now, e.active, e.stop, e.next, v.String())
+		}
+	}
+}
+
+func TestSchedule_Missed(t *testing.T) {
+	// The window is started before the service starts.
+	start := scheduleBootTime.Add(-10 * time.Minute).UTC().Format(time.RFC3339)
+	stop := scheduleBootTime.Add(10 * time.Minute).UTC().Format(time.RFC3339)
+
+	for _, e := range []struct {
+		missed  string
+		active  bool
+		skipped bool
+	}{
+		{missed: "", active: true},
+		{missed: scheduleMissedRun, active: true},
+		{missed: scheduleMissedSkip, skipped: true},
+	} {
+		state := NewScheduleState()
+		state.Update([]*TaskSchedule{{Start: start, Stop: stop, Missed: e.missed}}, scheduleBootTime)
+		if v := state.Query(); v.Active != e.active || v.Skipped != e.skipped {
+			t.Errorf("Fail for missed=%v, state %v", e.missed, v.String())
+		}
+	}
+}
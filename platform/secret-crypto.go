@@ -0,0 +1,799 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The prefix of encrypted value, the format is enc:v2:{kid}:{base64(nonce+ciphertext)}, and the aad is the
+// redis key and field, see secretAAD.
+const secretEncryptedPrefix = "enc:v2:"
+
+// The prefix of legacy encrypted value, the aad is only the redis key, so the ciphertext might be moved to other
+// field of the hash. It's decrypted by the redis key, and encrypted again when boot.
+const secretLegacyEncryptedPrefix = "enc:v1:"
+
+// The fields of redis hash which are encrypted, * for all fields of the hash. Note that the secret is
+// encrypted and decrypted by the redis hook, so it's transparent for the code which reads or writes it.
+var secretHashFields = map[string][]string{
+	SRS_AUTH_SECRET:       {"pubSecret", "pubTokenKey", "playTokenKey"},
+	SRS_TENCENT_CAM:       {"secretId", "secretKey"},
+	SRS_SYS_OPENAI:        {"key"},
+	SRS_FORWARD_CONFIG:    {"*"},
+	SRS_VLIVE_CONFIG:      {"*"},
+	SRS_MEDIA_S3:          {"secretKey"},
+	SRS_CAMERA_CONFIG:     {"*"},
+	SRS_MOSAIC_CONFIG:     {"*"},
+	SRS_TRANSCRIPT_CONFIG: {"*"},
+	SRS_OCR_CONFIG:        {"*"},
+	SRS_LIVE_ROOM:         {"*"},
+	SRS_DUBBING_PROJECTS:  {"*"},
+}
+
+// The redis string keys which are encrypted.
+var secretStringKeys = map[string]bool{
+	SRS_SECRET_PUBLISH: true,
+	SRS_OIDC:           true,
+}
+
+func isSecretHashField(key, field string) bool {
+	for _, f := range secretHashFields[key] {
+		if f == "*" || f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// secretAAD build the aad of secret, which binds the ciphertext to the redis key and the field of hash. The
+// field is empty for redis string key.
+func secretAAD(key, field string) string {
+	if field == "" {
+		return key
+	}
+	return fmt.Sprintf("%v#%v", key, field)
+}
+
+// secretRawContextKey is the key of context to bypass the redis hook, to read or write the raw value.
+type secretRawContextKey struct{}
+
+func withSecretRaw(ctx context.Context) context.Context {
+	return context.WithValue(ctx, secretRawContextKey{}, true)
+}
+
+// secretDeriveKey derive the 256 bits key from the master key, which might be a passphrase.
+func secretDeriveKey(master string) []byte {
+	b := sha256.Sum256([]byte(master))
+	return b[:]
+}
+
+// secretKeyID get the id of key, which is safe to store and log.
+func secretKeyID(key []byte) string {
+	b := sha256.Sum256(key)
+	return hex.EncodeToString(b[:4])
+}
+
+// secretSeal encrypt the plaintext by AES-256-GCM, the aad is bound to the ciphertext.
+func secretSeal(key []byte, aad, plaintext string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", errors.Wrapf(err, "new cipher")
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", errors.Wrapf(err, "new gcm")
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.Wrapf(err, "nonce")
+	}
+
+	b := gcm.Seal(nonce, nonce, []byte(plaintext), []byte(aad))
+	return base64.RawStdEncoding.EncodeToString(b), nil
+}
+
+// secretOpen decrypt the ciphertext which is sealed by secretSeal.
+func secretOpen(key []byte, aad, ciphertext string) (string, error) {
+	b, err := base64.RawStdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", errors.Wrapf(err, "decode")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", errors.Wrapf(err, "new cipher")
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", errors.Wrapf(err, "new gcm")
+	}
+
+	if len(b) < gcm.NonceSize() {
+		return "", errors.Errorf("invalid ciphertext %vB", len(b))
+	}
+
+	plaintext, err := gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], []byte(aad))
+	if err != nil {
+		return "", errors.Wrapf(err, "open")
+	}
+	return string(plaintext), nil
+}
+
+// SecretKeyring is the keys for envelope encryption. The secrets are encrypted by the data keys, and the
+// data keys are encrypted by the master key and stored in redis, while the master key is never stored in
+// redis. So the secrets can only be decrypted by platform, which owns the master key.
+type SecretKeyring struct {
+	// The master key, and the previous master keys for rotation.
+	master   []byte
+	previous [][]byte
+	// The data keys, kid to key.
+	keys map[string][]byte
+	// The current data key to encrypt secrets.
+	current string
+	// To protect the fields.
+	lock sync.RWMutex
+}
+
+func NewSecretKeyring(master string, previous []string) *SecretKeyring {
+	v := &SecretKeyring{master: secretDeriveKey(master), keys: make(map[string][]byte)}
+	for _, p := range previous {
+		if p != "" {
+			v.previous = append(v.previous, secretDeriveKey(p))
+		}
+	}
+	return v
+}
+
+// MasterID get the id of master key.
+func (v *SecretKeyring) MasterID() string {
+	return secretKeyID(v.master)
+}
+
+// Current get the id of current data key.
+func (v *SecretKeyring) Current() string {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	return v.current
+}
+
+// wrapKey encrypt the data key by master key, the format is {master kid}:{ciphertext}
+func (v *SecretKeyring) wrapKey(kid string, key []byte) (string, error) {
+	wrapped, err := secretSeal(v.master, kid, hex.EncodeToString(key))
+	if err != nil {
+		return "", errors.Wrapf(err, "wrap %v", kid)
+	}
+	return fmt.Sprintf("%v:%v", v.MasterID(), wrapped), nil
+}
+
+// unwrapKey decrypt the data key by master key or previous master keys, and whether it's wrapped by a
+// previous master key, which should be wrapped again.
+func (v *SecretKeyring) unwrapKey(kid, wrapped string) (key []byte, stale bool, err error) {
+	parts := strings.SplitN(wrapped, ":", 2)
+	if len(parts) != 2 {
+		return nil, false, errors.Errorf("invalid wrapped key %v", kid)
+	}
+
+	for i, master := range append([][]byte{v.master}, v.previous...) {
+		if secretKeyID(master) != parts[0] {
+			continue
+		}
+
+		s, err := secretOpen(master, kid, parts[1])
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "unwrap %v by master %v", kid, parts[0])
+		}
+
+		key, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "decode %v", kid)
+		}
+		return key, i > 0, nil
+	}
+	return nil, false, errors.Errorf("no master key %v for %v", parts[0], kid)
+}
+
+// AddKey add the data key, and use it as current key if current is true.
+func (v *SecretKeyring) AddKey(kid string, key []byte, current bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.keys[kid] = key
+	if current {
+		v.current = kid
+	}
+}
+
+// Encrypt the plaintext by current data key, the aad is the redis key and field, see secretAAD.
+func (v *SecretKeyring) Encrypt(key, field, plaintext string) (string, error) {
+	v.lock.RLock()
+	kid, dataKey := v.current, v.keys[v.current]
+	v.lock.RUnlock()
+
+	if dataKey == nil {
+		return "", errors.New("no data key")
+	}
+
+	ciphertext, err := secretSeal(dataKey, secretAAD(key, field), plaintext)
+	if err != nil {
+		return "", errors.Wrapf(err, "seal by %v", kid)
+	}
+	return fmt.Sprintf("%v%v:%v", secretEncryptedPrefix, kid, ciphertext), nil
+}
+
+// Decrypt the value, which is returned directly if not encrypted, for example, the legacy plaintext.
+func (v *SecretKeyring) Decrypt(key, field, value string) (string, error) {
+	kid, ciphertext, legacy, ok := secretParseValue(value)
+	if !ok {
+		return value, nil
+	}
+
+	v.lock.RLock()
+	dataKey := v.keys[kid]
+	v.lock.RUnlock()
+
+	if dataKey == nil {
+		return "", errors.Errorf("no data key %v", kid)
+	}
+
+	aad := secretAAD(key, field)
+	if legacy {
+		aad = key
+	}
+
+	plaintext, err := secretOpen(dataKey, aad, ciphertext)
+	if err != nil {
+		return "", errors.Wrapf(err, "open by %v", kid)
+	}
+	return plaintext, nil
+}
+
+// secretParseValue parse the encrypted value, return the kid and ciphertext, and whether it's the legacy
+// encrypted value, see secretLegacyEncryptedPrefix.
+func secretParseValue(value string) (kid, ciphertext string, legacy, ok bool) {
+	prefix := secretEncryptedPrefix
+	if legacy = strings.HasPrefix(value, secretLegacyEncryptedPrefix); legacy {
+		prefix = secretLegacyEncryptedPrefix
+	} else if !strings.HasPrefix(value, secretEncryptedPrefix) {
+		return "", "", false, false
+	}
+
+	parts := strings.SplitN(value[len(prefix):], ":", 2)
+	if len(parts) != 2 {
+		return "", "", false, false
+	}
+	return parts[0], parts[1], legacy, true
+}
+
+// secretArgString convert the redis argument to string.
+func secretArgString(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// SecretHook is the redis hook to encrypt the secrets when write, and decrypt when read.
+type SecretHook struct {
+	keyring *SecretKeyring
+}
+
+func NewSecretHook(keyring *SecretKeyring) *SecretHook {
+	return &SecretHook{keyring: keyring}
+}
+
+func (v *SecretHook) encrypt(key, field string, arg interface{}) (interface{}, error) {
+	s := secretArgString(arg)
+	if s == "" {
+		return arg, nil
+	}
+	if _, _, _, ok := secretParseValue(s); ok {
+		return arg, nil
+	}
+	return v.keyring.Encrypt(key, field, s)
+}
+
+func (v *SecretHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if ctx.Value(secretRawContextKey{}) != nil {
+		return ctx, nil
+	}
+
+	args := cmd.Args()
+	if len(args) < 3 {
+		return ctx, nil
+	}
+
+	key := secretArgString(args[1])
+	switch cmd.Name() {
+	case "hset", "hmset", "hsetnx":
+		for i := 2; i+1 < len(args); i += 2 {
+			field := secretArgString(args[i])
+			if !isSecretHashField(key, field) {
+				continue
+			}
+
+			arg, err := v.encrypt(key, field, args[i+1])
+			if err != nil {
+				return ctx, errors.Wrapf(err, "encrypt %v %v", key, args[i])
+			}
+			args[i+1] = arg
+		}
+	case "set":
+		if secretStringKeys[key] {
+			arg, err := v.encrypt(key, "", args[2])
+			if err != nil {
+				return ctx, errors.Wrapf(err, "encrypt %v", key)
+			}
+			args[2] = arg
+		}
+	}
+	return ctx, nil
+}
+
+func (v *SecretHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if ctx.Value(secretRawContextKey{}) != nil || cmd.Err() != nil {
+		return nil
+	}
+
+	args := cmd.Args()
+	if len(args) < 2 {
+		return nil
+	}
+
+	key := secretArgString(args[1])
+	if _, ok := secretHashFields[key]; !ok && !secretStringKeys[key] {
+		return nil
+	}
+
+	var err error
+	switch c := cmd.(type) {
+	case *redis.StringCmd:
+		if c.Name() == "get" && secretStringKeys[key] {
+			var s string
+			if s, err = v.keyring.Decrypt(key, "", c.Val()); err == nil {
+				c.SetVal(s)
+			}
+		} else if c.Name() == "hget" && len(args) > 2 && isSecretHashField(key, secretArgString(args[2])) {
+			var s string
+			if s, err = v.keyring.Decrypt(key, secretArgString(args[2]), c.Val()); err == nil {
+				c.SetVal(s)
+			}
+		}
+	case *redis.StringStringMapCmd:
+		if c.Name() == "hgetall" {
+			vals := c.Val()
+			for field, value := range vals {
+				if isSecretHashField(key, field) {
+					if vals[field], err = v.keyring.Decrypt(key, field, value); err != nil {
+						break
+					}
+				}
+			}
+		}
+	case *redis.SliceCmd:
+		if c.Name() == "hmget" {
+			vals := c.Val()
+			for i, value := range vals {
+				if s, ok := value.(string); ok && 2+i < len(args) && isSecretHashField(key, secretArgString(args[2+i])) {
+					if vals[i], err = v.keyring.Decrypt(key, secretArgString(args[2+i]), s); err != nil {
+						break
+					}
+				}
+			}
+		}
+	case *redis.StringSliceCmd:
+		// The ciphertext is bound to the field, which is not available for hvals, so use hgetall instead.
+		if c.Name() == "hvals" {
+			err = errors.New("hvals not supported, use hgetall")
+		}
+	}
+
+	if err != nil {
+		cmd.SetErr(errors.Wrapf(err, "decrypt %v", key))
+	}
+	return nil
+}
+
+func (v *SecretHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	for _, cmd := range cmds {
+		if _, err := v.BeforeProcess(ctx, cmd); err != nil {
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
+
+func (v *SecretHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		if err := v.AfterProcess(ctx, cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// secretKeyring is the global keyring, initialized when boot.
+var secretKeyring *SecretKeyring
+
+// loadSecretMasterKey load the master key from env, or from file which is generated if not exists. The master
+// key file must not be in the data volume, which is usually backup or shared with redis data, otherwise the
+// secrets are decrypted by anyone who owns the data.
+func loadSecretMasterKey(ctx context.Context) (string, error) {
+	if master := envPlatformMasterKey(); master != "" {
+		return master, nil
+	}
+
+	keyFile := envPlatformMasterKeyFile()
+	if keyFile == "" {
+		return "", errors.New("no master key, please set PLATFORM_MASTER_KEY or PLATFORM_MASTER_KEY_FILE")
+	}
+
+	dataDir := path.Join(conf.Pwd, "containers/data")
+	if absFile, err := filepath.Abs(keyFile); err != nil {
+		return "", errors.Wrapf(err, "abs %v", keyFile)
+	} else if absFile == dataDir || strings.HasPrefix(absFile, dataDir+"/") {
+		return "", errors.Errorf("master key file %v should not be in data volume %v", keyFile, dataDir)
+	}
+
+	if b, err := ioutil.ReadFile(keyFile); err == nil {
+		if master := strings.TrimSpace(string(b)); master != "" {
+			return master, nil
+		}
+		return "", errors.Errorf("empty master key file %v", keyFile)
+	} else if !os.IsNotExist(err) {
+		return "", errors.Wrapf(err, "read %v", keyFile)
+	}
+
+	// Generate the master key for the first run.
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrapf(err, "rand")
+	}
+	master := hex.EncodeToString(b)
+
+	if err := os.MkdirAll(path.Dir(keyFile), 0755); err != nil {
+		return "", errors.Wrapf(err, "mkdir %v", path.Dir(keyFile))
+	}
+	if err := ioutil.WriteFile(keyFile, []byte(master), 0600); err != nil {
+		return "", errors.Wrapf(err, "write %v", keyFile)
+	}
+	logger.Tf(ctx, "secret generate master key to %v", keyFile)
+
+	return master, nil
+}
+
+// initSecretKeyring load the master key and data keys, install the redis hook, and encrypt the legacy
+// plaintext secrets. It must be called after redis is ready, and before any secret is read.
+func initSecretKeyring(ctx context.Context) error {
+	master, err := loadSecretMasterKey(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "load master key")
+	}
+
+	var previous []string
+	for _, p := range strings.Split(envPlatformMasterKeyPrevious(), ",") {
+		previous = append(previous, strings.TrimSpace(p))
+	}
+
+	keyring := NewSecretKeyring(master, previous)
+	if err := loadSecretDataKeys(ctx, keyring); err != nil {
+		return errors.Wrapf(err, "load data keys")
+	}
+
+	// Generate the first data key.
+	if keyring.Current() == "" {
+		if err := rotateSecretDataKey(ctx, keyring); err != nil {
+			return errors.Wrapf(err, "generate data key")
+		}
+	}
+
+	secretKeyring = keyring
+	rdb.AddHook(NewSecretHook(keyring))
+
+	if n, err := reencryptSecrets(ctx, keyring, false); err != nil {
+		return errors.Wrapf(err, "migrate secrets")
+	} else if n > 0 {
+		logger.Tf(ctx, "secret migrate %v plaintext or legacy secrets", n)
+	}
+
+	logger.Tf(ctx, "secret init keyring ok, master=%v, current=%v", keyring.MasterID(), keyring.Current())
+	return nil
+}
+
+// loadSecretDataKeys load the data keys from redis, and wrap again by current master key if the data key
+// is wrapped by previous master key, for rotation of master key.
+func loadSecretDataKeys(ctx context.Context, keyring *SecretKeyring) error {
+	keys, err := rdb.HGetAll(ctx, SRS_SECRET_KEYS).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_SECRET_KEYS)
+	}
+
+	current := keys["current"]
+	for kid, wrapped := range keys {
+		if kid == "current" {
+			continue
+		}
+
+		key, stale, err := keyring.unwrapKey(kid, wrapped)
+		if err != nil {
+			return errors.Wrapf(err, "unwrap %v", kid)
+		}
+		keyring.AddKey(kid, key, kid == current)
+
+		if stale {
+			if wrapped, err = keyring.wrapKey(kid, key); err != nil {
+				return errors.Wrapf(err, "wrap %v", kid)
+			}
+			if err := rdb.HSet(ctx, SRS_SECRET_KEYS, kid, wrapped).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v %v", SRS_SECRET_KEYS, kid)
+			}
+			logger.Tf(ctx, "secret rewrap data key %v by master %v", kid, keyring.MasterID())
+		}
+	}
+
+	if current != "" && keyring.Current() != current {
+		return errors.Errorf("no current data key %v", current)
+	}
+	return nil
+}
+
+// rotateSecretDataKey generate a new data key, and use it as the current key.
+func rotateSecretDataKey(ctx context.Context, keyring *SecretKeyring) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return errors.Wrapf(err, "rand")
+	}
+	kid := secretKeyID(key)
+
+	wrapped, err := keyring.wrapKey(kid, key)
+	if err != nil {
+		return errors.Wrapf(err, "wrap %v", kid)
+	}
+
+	if err := rdb.HSet(ctx, SRS_SECRET_KEYS, kid, wrapped).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v", SRS_SECRET_KEYS, kid)
+	}
+	if err := rdb.HSet(ctx, SRS_SECRET_KEYS, "current", kid).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v current %v", SRS_SECRET_KEYS, kid)
+	}
+
+	keyring.AddKey(kid, key, true)
+	logger.Tf(ctx, "secret generate data key %v, master=%v", kid, keyring.MasterID())
+	return nil
+}
+
+// reencryptSecrets encrypt the plaintext secrets by current data key. If all is true, also encrypt the
+// secrets which are encrypted by other data keys, for rotation of data key. Return the number of secrets
+// which are encrypted.
+func reencryptSecrets(ctx context.Context, keyring *SecretKeyring, all bool) (int, error) {
+	raw := withSecretRaw(ctx)
+	current := keyring.Current()
+
+	reencrypt := func(key, field, value string) (string, bool, error) {
+		kid, _, legacy, encrypted := secretParseValue(value)
+		if value == "" || encrypted && !legacy && (!all || kid == current) {
+			return "", false, nil
+		}
+
+		plaintext, err := keyring.Decrypt(key, field, value)
+		if err != nil {
+			return "", false, errors.Wrapf(err, "decrypt %v", key)
+		}
+
+		ciphertext, err := keyring.Encrypt(key, field, plaintext)
+		if err != nil {
+			return "", false, errors.Wrapf(err, "encrypt %v", key)
+		}
+		return ciphertext, true, nil
+	}
+
+	var n int
+	for key := range secretHashFields {
+		values, err := rdb.HGetAll(raw, key).Result()
+		if err != nil && err != redis.Nil {
+			return n, errors.Wrapf(err, "hgetall %v", key)
+		}
+
+		for field, value := range values {
+			if !isSecretHashField(key, field) {
+				continue
+			}
+
+			if ciphertext, ok, err := reencrypt(key, field, value); err != nil {
+				return n, errors.Wrapf(err, "field %v", field)
+			} else if ok {
+				if err := rdb.HSet(raw, key, field, ciphertext).Err(); err != nil && err != redis.Nil {
+					return n, errors.Wrapf(err, "hset %v %v", key, field)
+				}
+				n++
+			}
+		}
+	}
+
+	for key := range secretStringKeys {
+		value, err := rdb.Get(raw, key).Result()
+		if err != nil && err != redis.Nil {
+			return n, errors.Wrapf(err, "get %v", key)
+		}
+
+		if ciphertext, ok, err := reencrypt(key, "", value); err != nil {
+			return n, err
+		} else if ok {
+			if err := rdb.Set(raw, key, ciphertext, 0).Err(); err != nil && err != redis.Nil {
+				return n, errors.Wrapf(err, "set %v", key)
+			}
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// querySecretStatus get the number of secrets by the data key, empty kid for plaintext.
+func querySecretStatus(ctx context.Context) (map[string]int, error) {
+	raw := withSecretRaw(ctx)
+	stat := make(map[string]int)
+
+	count := func(value string) {
+		if value == "" {
+			return
+		}
+		kid, _, _, _ := secretParseValue(value)
+		stat[kid]++
+	}
+
+	for key := range secretHashFields {
+		values, err := rdb.HGetAll(raw, key).Result()
+		if err != nil && err != redis.Nil {
+			return nil, errors.Wrapf(err, "hgetall %v", key)
+		}
+		for field, value := range values {
+			if isSecretHashField(key, field) {
+				count(value)
+			}
+		}
+	}
+
+	for key := range secretStringKeys {
+		value, err := rdb.Get(raw, key).Result()
+		if err != nil && err != redis.Nil {
+			return nil, errors.Wrapf(err, "get %v", key)
+		}
+		count(value)
+	}
+
+	return stat, nil
+}
+
+func handleSecretService(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/mgmt/secrets/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			stat, err := querySecretStatus(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "query status")
+			}
+
+			keys, err := rdb.HKeys(ctx, SRS_SECRET_KEYS).Result()
+			if err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hkeys %v", SRS_SECRET_KEYS)
+			}
+
+			var kids []string
+			for _, kid := range keys {
+				if kid != "current" {
+					kids = append(kids, kid)
+				}
+			}
+			sort.Strings(kids)
+
+			// Note that the empty kid is the number of plaintext secrets.
+			plaintext := stat[""]
+			delete(stat, "")
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Master    string         `json:"master"`
+				Current   string         `json:"current"`
+				Keys      []string       `json:"keys"`
+				Encrypted map[string]int `json:"encrypted"`
+				Plaintext int            `json:"plaintext"`
+			}{
+				Master: secretKeyring.MasterID(), Current: secretKeyring.Current(), Keys: kids,
+				Encrypted: stat, Plaintext: plaintext,
+			})
+			logger.Tf(ctx, "secrets query ok, current=%v, keys=%v, encrypted=%v, plaintext=%v, token=%vB",
+				secretKeyring.Current(), kids, stat, plaintext, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/mgmt/secrets/rotate"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			// The previous data keys are kept, so the secrets encrypted by them are still available.
+			previous := secretKeyring.Current()
+			if err := rotateSecretDataKey(ctx, secretKeyring); err != nil {
+				return errors.Wrapf(err, "rotate data key")
+			}
+
+			n, err := reencryptSecrets(ctx, secretKeyring, true)
+			if err != nil {
+				return errors.Wrapf(err, "reencrypt")
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Current string `json:"current"`
+				Count   int    `json:"count"`
+			}{
+				Current: secretKeyring.Current(), Count: n,
+			})
+			logger.Tf(ctx, "secrets rotate ok, previous=%v, current=%v, count=%v, token=%vB",
+				previous, secretKeyring.Current(), n, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
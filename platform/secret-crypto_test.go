@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestSecret_Keyring(t *testing.T) {
+	keyring := NewSecretKeyring("master", nil)
+	if _, err := keyring.Encrypt(SRS_TENCENT_CAM, "secretKey", "secret"); err == nil {
+		t.Errorf("Fail for should fail without data key")
+	}
+
+	key := []byte(strings.Repeat("k", 32))
+	keyring.AddKey("k1", key, true)
+
+	ciphertext, err := keyring.Encrypt(SRS_TENCENT_CAM, "secretKey", "secret")
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	} else if !strings.HasPrefix(ciphertext, "enc:v2:k1:") || strings.Contains(ciphertext, "secret") {
+		t.Errorf("Fail for ciphertext %v", ciphertext)
+	}
+
+	if plaintext, err := keyring.Decrypt(SRS_TENCENT_CAM, "secretKey", ciphertext); err != nil || plaintext != "secret" {
+		t.Errorf("Fail for plaintext %v, err %+v", plaintext, err)
+	}
+
+	// The ciphertext is bound to the redis key and field.
+	if _, err := keyring.Decrypt(SRS_OCR_CONFIG, "secretKey", ciphertext); err == nil {
+		t.Errorf("Fail for should fail for other key")
+	}
+	if _, err := keyring.Decrypt(SRS_TENCENT_CAM, "secretId", ciphertext); err == nil {
+		t.Errorf("Fail for should fail for other field")
+	}
+
+	// The legacy ciphertext is bound to the redis key only.
+	legacy, err := secretSeal(key, SRS_TENCENT_CAM, "secret")
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+	if plaintext, err := keyring.Decrypt(SRS_TENCENT_CAM, "secretId", "enc:v1:k1:"+legacy); err != nil || plaintext != "secret" {
+		t.Errorf("Fail for plaintext %v, err %+v", plaintext, err)
+	}
+
+	// The legacy plaintext is returned directly.
+	if plaintext, err := keyring.Decrypt(SRS_TENCENT_CAM, "secretKey", "legacy"); err != nil || plaintext != "legacy" {
+		t.Errorf("Fail for plaintext %v, err %+v", plaintext, err)
+	}
+
+	// Should fail if data key is not available.
+	if _, err := NewSecretKeyring("master", nil).Decrypt(SRS_TENCENT_CAM, "secretKey", ciphertext); err == nil {
+		t.Errorf("Fail for should fail without data key")
+	}
+}
+
+func TestSecret_WrapKey(t *testing.T) {
+	key := []byte(strings.Repeat("k", 32))
+
+	wrapped, err := NewSecretKeyring("old", nil).wrapKey("k1", key)
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+
+	// The key wrapped by previous master key should be available, and wrapped again.
+	if v, stale, err := NewSecretKeyring("new", []string{"old"}).unwrapKey("k1", wrapped); err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if !stale || string(v) != string(key) {
+		t.Errorf("Fail for stale=%v, key=%v", stale, string(v))
+	}
+
+	if v, stale, err := NewSecretKeyring("old", nil).unwrapKey("k1", wrapped); err != nil {
+		t.Errorf("Fail for err %+v", err)
+	} else if stale || string(v) != string(key) {
+		t.Errorf("Fail for stale=%v, key=%v", stale, string(v))
+	}
+
+	if _, _, err := NewSecretKeyring("new", nil).unwrapKey("k1", wrapped); err == nil {
+		t.Errorf("Fail for should fail without master key")
+	}
+	if _, _, err := NewSecretKeyring("old", nil).unwrapKey("k2", wrapped); err == nil {
+		t.Errorf("Fail for should fail for other kid")
+	}
+}
+
+func TestSecret_Hook(t *testing.T) {
+	ctx := context.Background()
+	keyring := NewSecretKeyring("master", nil)
+	keyring.AddKey("k1", []byte(strings.Repeat("k", 32)), true)
+	hook := NewSecretHook(keyring)
+
+	// Only the secret fields are encrypted.
+	cmd := redis.NewIntCmd(ctx, "hset", SRS_TENCENT_CAM, "appId", "100", "secretKey", "sk")
+	if _, err := hook.BeforeProcess(ctx, cmd); err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+	args := cmd.Args()
+	if args[3] != "100" || !strings.HasPrefix(args[5].(string), "enc:v2:") {
+		t.Errorf("Fail for args %v", args)
+	}
+	ciphertext := args[5].(string)
+
+	// Never encrypt the value which is already encrypted.
+	cmd = redis.NewIntCmd(ctx, "hset", SRS_TENCENT_CAM, "secretKey", ciphertext)
+	if _, err := hook.BeforeProcess(ctx, cmd); err != nil || cmd.Args()[3] != ciphertext {
+		t.Errorf("Fail for args %v, err %+v", cmd.Args(), err)
+	}
+
+	// Never encrypt when write the raw value.
+	cmd = redis.NewIntCmd(ctx, "hset", SRS_TENCENT_CAM, "secretKey", "sk")
+	if _, err := hook.BeforeProcess(withSecretRaw(ctx), cmd); err != nil || cmd.Args()[3] != "sk" {
+		t.Errorf("Fail for args %v, err %+v", cmd.Args(), err)
+	}
+
+	hget := redis.NewStringCmd(ctx, "hget", SRS_TENCENT_CAM, "secretKey")
+	hget.SetVal(ciphertext)
+	if err := hook.AfterProcess(ctx, hget); err != nil || hget.Err() != nil || hget.Val() != "sk" {
+		t.Errorf("Fail for val %v, err %+v, %+v", hget.Val(), err, hget.Err())
+	}
+
+	hgetall := redis.NewStringStringMapCmd(ctx, "hgetall", SRS_TENCENT_CAM)
+	hgetall.SetVal(map[string]string{"appId": "100", "secretKey": ciphertext})
+	if err := hook.AfterProcess(ctx, hgetall); err != nil || hgetall.Err() != nil {
+		t.Errorf("Fail for err %+v, %+v", err, hgetall.Err())
+	} else if v := hgetall.Val(); v["appId"] != "100" || v["secretKey"] != "sk" {
+		t.Errorf("Fail for val %v", v)
+	}
+
+	// Should fail if the ciphertext is moved to other field.
+	hget = redis.NewStringCmd(ctx, "hget", SRS_TENCENT_CAM, "secretId")
+	hget.SetVal(ciphertext)
+	if err := hook.AfterProcess(ctx, hget); err != nil || hget.Err() == nil {
+		t.Errorf("Fail for should fail, val %v", hget.Val())
+	}
+
+	// The string key is encrypted as a whole.
+	set := redis.NewStatusCmd(ctx, "set", SRS_OIDC, `{"clientSecret":"cs"}`, "ex", 0)
+	if _, err := hook.BeforeProcess(ctx, set); err != nil || !strings.HasPrefix(set.Args()[2].(string), "enc:v2:") {
+		t.Errorf("Fail for args %v, err %+v", set.Args(), err)
+	}
+
+	get := redis.NewStringCmd(ctx, "get", SRS_OIDC)
+	get.SetVal(set.Args()[2].(string))
+	if err := hook.AfterProcess(ctx, get); err != nil || get.Val() != `{"clientSecret":"cs"}` {
+		t.Errorf("Fail for val %v, err %+v", get.Val(), err)
+	}
+
+	// The other keys are not changed.
+	set = redis.NewStatusCmd(ctx, "set", SRS_LOCALE, "en")
+	if _, err := hook.BeforeProcess(ctx, set); err != nil || set.Args()[2] != "en" {
+		t.Errorf("Fail for args %v, err %+v", set.Args(), err)
+	}
+
+	// Should fail if the value is corrupted.
+	hget = redis.NewStringCmd(ctx, "hget", SRS_TENCENT_CAM, "secretKey")
+	hget.SetVal("enc:v2:k1:xxx")
+	if err := hook.AfterProcess(ctx, hget); err != nil || hget.Err() == nil {
+		t.Errorf("Fail for should fail, val %v", hget.Val())
+	}
+}
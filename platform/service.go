@@ -100,8 +100,8 @@ func (v *httpService) Run(ctx context.Context) error {
 				return
 			}
 
-			// Handle by service handler.
-			serviceHandler.ServeHTTP(w, r)
+			// Handle by service handler, and audit the management API.
+			auditWorker.Serve(w, r, serviceHandler)
 		})
 	}
 
@@ -212,6 +212,20 @@ func (v *httpService) Run(ctx context.Context) error {
 	return nil
 }
 
+// httpForbiddenError is the error to response with HTTP 403 by ohttp.WriteError, see ohttp.HTTPStatus.
+type httpForbiddenError struct {
+	error
+}
+
+func (v httpForbiddenError) Status() int {
+	return http.StatusForbidden
+}
+
+// writeForbidden response the error with HTTP 403, by the same body as ohttp.WriteError.
+func writeForbidden(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) {
+	ohttp.WriteError(ctx, w, r, httpForbiddenError{err})
+}
+
 func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 	ohttp.Server = fmt.Sprintf("Oryx/%v", version)
 
@@ -219,6 +233,10 @@ func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 		return errors.Wrapf(err, "handle callback")
 	}
 
+	if err := auditWorker.Handle(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle audit")
+	}
+
 	if err := transcriptWorker.Handle(ctx, handler); err != nil {
 		return errors.Wrapf(err, "handle transcript")
 	}
@@ -264,6 +282,13 @@ func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 		return errors.Wrapf(err, "handle IP camera")
 	}
 
+	if err := mosaicWorker.Handle(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle mosaic")
+	}
+
+	handleEgressOutputService(ctx, handler)
+	handleOverlayService(ctx, handler)
+
 	if err := handleHooksService(ctx, handler); err != nil {
 		return errors.Wrapf(err, "handle hooks")
 	}
@@ -290,6 +315,9 @@ func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 	handleMgmtEnvs(ctx, handler)
 	handleMgmtToken(ctx, handler)
 	handleMgmtLogin(ctx, handler)
+	handleMgmtSessionService(ctx, handler)
+	handleMgmtOIDCService(ctx, handler)
+	handleSecretService(ctx, handler)
 	handleMgmtStatus(ctx, handler)
 	handleMgmtBilibili(ctx, handler)
 	handleMgmtLimitsQuery(ctx, handler)
@@ -365,6 +393,15 @@ func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 		// Proxy to SRS RTC API, by /rtc/ prefix.
 		if strings.HasPrefix(r.URL.Path, "/rtc/") {
 			q := r.URL.Query()
+			if err := verifyRtcIPRules(ctx, r); err != nil {
+				writeForbidden(ctx, w, r, err)
+				return
+			}
+			if err := verifyRtcPlayRequest(ctx, r); err != nil {
+				writeForbidden(ctx, w, r, err)
+				return
+			}
+
 			if eip := q.Get("eip"); eip != "" {
 				logger.Tf(ctx, "Proxy %v to backend 1985, eip=%v, query is %v",
 					r.URL.Path, eip, r.URL.RawQuery)
@@ -416,6 +453,57 @@ func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 			return
 		}
 
+		// Verify the play token for protected streams, and propagate the token to HLS segments. The protected
+		// streams must not be cached by CDN or shared proxies.
+		cacheControl := "public"
+		if strings.HasSuffix(r.URL.Path, ".flv") || strings.HasSuffix(r.URL.Path, ".m3u8") ||
+			strings.HasSuffix(r.URL.Path, ".ts") || strings.HasSuffix(r.URL.Path, ".aac") ||
+			strings.HasSuffix(r.URL.Path, ".mp3") {
+			app, stream := parsePlayPath(r.URL.Path)
+			// The renditions of ABR ladder use the rules and token of master playlist.
+			if master := queryTranscodeMaster(app, stream); master != nil {
+				stream = master.Stream
+			}
+			if err := verifyIPRules(ctx, IPRuleScopePlay, app, stream, httpClientIP(r)); err != nil {
+				writeForbidden(ctx, w, r, err)
+				return
+			}
+
+			param, err := verifyPlayRequest(ctx, r, app, stream)
+			if err != nil {
+				writeForbidden(ctx, w, r, err)
+				return
+			}
+			if param != "" {
+				cacheControl = "private"
+			}
+
+			if param != "" && strings.HasSuffix(r.URL.Path, ".m3u8") {
+				// Request the plain m3u8, because we need to rewrite the body.
+				r.Header.Del("Accept-Encoding")
+				r.Header.Del("Range")
+
+				mw := &m3u8ParamResponseWriter{w: w, param: param}
+				defer func() {
+					if err := mw.Flush(); err != nil {
+						logger.Wf(ctx, "Rewrite m3u8 %v failed, err %+v", r.URL.Path, err)
+					}
+				}()
+				w = mw
+			}
+		}
+
+		// Serve the HLS master playlist of ABR ladder, generated by transcode task.
+		if strings.HasSuffix(r.URL.Path, ".m3u8") {
+			app, stream := parsePlayPath(r.URL.Path)
+			if master := queryTranscodeMaster(app, stream); master != nil && master.Stream == stream {
+				w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+				w.Header().Set("Cache-Control", "no-cache")
+				fmt.Fprint(w, master.Body)
+				return
+			}
+		}
+
 		// Always directly serve the HLS ts files.
 		if fastCache.HLSHighPerformance && strings.HasSuffix(r.URL.Path, ".m3u8") {
 			var m3u8ExpireInSeconds int = 10
@@ -423,12 +511,12 @@ func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 				m3u8ExpireInSeconds = 1 // Note that we use smaller expire time that fragment duration.
 			}
 
-			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%v", m3u8ExpireInSeconds))
+			w.Header().Set("Cache-Control", fmt.Sprintf("%v, max-age=%v", cacheControl, m3u8ExpireInSeconds))
 			hlsFileServer.ServeHTTP(w, r)
 			return
 		}
 		if strings.HasSuffix(r.URL.Path, ".ts") {
-			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%v", 600))
+			w.Header().Set("Cache-Control", fmt.Sprintf("%v, max-age=%v", cacheControl, 600))
 			hlsFileServer.ServeHTTP(w, r)
 			return
 		}
@@ -548,22 +636,14 @@ func handleMgmtInit(ctx context.Context, handler *http.ServeMux) {
 			}
 
 			apiSecret := envApiSecret()
-			expireAt, createAt, token, err := createToken(ctx, envApiSecret())
+			result, err := createMgmtSession(ctx, r, apiSecret, "", mgmtRoleAdmin)
 			if err != nil {
-				return errors.Wrapf(err, "build token")
+				return errors.Wrapf(err, "create session")
 			}
 
-			ohttp.WriteData(ctx, w, r, &struct {
-				Token    string `json:"token"`
-				CreateAt string `json:"createAt"`
-				ExpireAt string `json:"expireAt"`
-				// Allow user to directly use Bearer token.
-				Bearer string `json:"bearer"`
-			}{
-				Token: token, CreateAt: createAt.Format(time.RFC3339), ExpireAt: expireAt.Format(time.RFC3339),
-				Bearer: apiSecret,
-			})
-			logger.Tf(ctx, "init password ok, create=%v, expire=%v, password=%vB", createAt, expireAt, len(password))
+			ohttp.WriteData(ctx, w, r, result)
+			logger.Tf(ctx, "init password ok, sid=%v, create=%v, expire=%v, password=%vB",
+				result.SessionID, result.CreateAt, result.ExpireAt, len(password))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -727,7 +807,29 @@ func handleMgmtToken(ctx context.Context, handler *http.ServeMux) {
 				return errors.Wrapf(err, "authenticate")
 			}
 
-			expireAt, createAt, token, err := createToken(ctx, envApiSecret())
+			// The access token of session might be in the header, for management console.
+			if parts := strings.Split(r.Header.Get("Authorization"), " "); token == "" && len(parts) == 2 {
+				token = parts[1]
+			}
+
+			// Create access token for the session, or create a new session for bearer or legacy token.
+			var sid, role string
+			if claims, err := parseMgmtToken(apiSecret, token); err == nil {
+				sid, role = claims.SessionID, claims.Role
+			}
+			if sid == "" {
+				result, err := createMgmtSession(ctx, r, apiSecret, "", mgmtRoleAdmin)
+				if err != nil {
+					return errors.Wrapf(err, "create session")
+				}
+
+				ohttp.WriteData(ctx, w, r, result)
+				logger.Tf(ctx, "login by token ok, sid=%v, create=%v, expire=%v, token=%vB",
+					result.SessionID, result.CreateAt, result.ExpireAt, len(token))
+				return nil
+			}
+
+			expireAt, createAt, token, err := createToken(ctx, apiSecret, mgmtTokenKindAccess, sid, role, mgmtAccessTokenExpire)
 			if err != nil {
 				return errors.Wrapf(err, "build token")
 			}
@@ -736,10 +838,13 @@ func handleMgmtToken(ctx context.Context, handler *http.ServeMux) {
 				Token    string `json:"token"`
 				CreateAt string `json:"createAt"`
 				ExpireAt string `json:"expireAt"`
+				// The session id.
+				SessionID string `json:"sid"`
 			}{
 				Token: token, CreateAt: createAt.Format(time.RFC3339), ExpireAt: expireAt.Format(time.RFC3339),
+				SessionID: sid,
 			})
-			logger.Tf(ctx, "login by token ok, create=%v, expire=%v, token=%vB", createAt, expireAt, len(token))
+			logger.Tf(ctx, "login by token ok, sid=%v, create=%v, expire=%v, token=%vB", sid, createAt, expireAt, len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -767,48 +872,65 @@ func handleMgmtLogin(ctx context.Context, handler *http.ServeMux) {
 				return errors.Wrapf(err, "read body")
 			}
 
-			var password string
+			var password, challenge string
 			if err := json.Unmarshal(b, &struct {
-				Password *string `json:"password"`
+				Password  *string `json:"password"`
+				Challenge *string `json:"challenge"`
 			}{
-				Password: &password,
+				Password: &password, Challenge: &challenge,
 			}); err != nil {
-				return errors.Wrapf(err, "json unmarshal %v", string(b))
+				return errors.Wrapf(err, "json unmarshal %vB", len(b))
 			}
 
 			if password == "" {
 				return errors.New("no password")
 			}
 
-			if password != envMgmtPassword() {
-				wait := time.Duration(10) * time.Second
-				logger.Wf(ctx, "Invalid password, wait for %v", wait)
+			// Reject the client if locked, require challenge if too many failures, or delay if too many
+			// failures of all clients.
+			guard := NewLoginGuard(httpClientIP(r))
+			requireChallenge, delay, err := guard.Check(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "check login")
+			}
+			if delay {
+				guard.Delay(ctx)
+			}
+			if requireChallenge {
+				if err := guard.VerifyChallenge(ctx, challenge); err != nil {
+					if _, err := guard.OnFailure(ctx, r, "challenge"); err != nil {
+						return errors.Wrapf(err, "login failure")
+					}
+					guard.Delay(ctx)
+					return errors.Wrapf(err, "verify challenge")
+				}
+			}
 
-				select {
-				case <-time.After(wait):
-				case <-ctx.Done():
+			if password != envMgmtPassword() {
+				lockout, err := guard.OnFailure(ctx, r, "password")
+				if err != nil {
+					return errors.Wrapf(err, "login failure")
 				}
+				guard.Delay(ctx)
+				if lockout > 0 {
+					return errors.Errorf("invalid password, locked for %v", lockout)
+				}
+				return errors.New("invalid password")
+			}
 
-				return errors.Errorf("invalid password, wait %v", wait)
+			if err := guard.OnSuccess(ctx); err != nil {
+				return errors.Wrapf(err, "login success")
 			}
 
 			apiSecret := envApiSecret()
-			expireAt, createAt, token, err := createToken(ctx, apiSecret)
+			result, err := createMgmtSession(ctx, r, apiSecret, "", mgmtRoleAdmin)
 			if err != nil {
-				return errors.Wrapf(err, "build token")
+				return errors.Wrapf(err, "create session")
 			}
 
-			ohttp.WriteData(ctx, w, r, &struct {
-				Token    string `json:"token"`
-				CreateAt string `json:"createAt"`
-				ExpireAt string `json:"expireAt"`
-				// Allow user to directly use Bearer token.
-				Bearer string `json:"bearer"`
-			}{
-				Token: token, CreateAt: createAt.Format(time.RFC3339), ExpireAt: expireAt.Format(time.RFC3339),
-				Bearer: apiSecret,
-			})
-			logger.Tf(ctx, "login by password ok, create=%v, expire=%v, token=%vB", createAt, expireAt, len(token))
+			ohttp.WriteData(ctx, w, r, result)
+			logger.Tf(ctx, "login by password ok, sid=%v, create=%v, expire=%v, token=%vB",
+				result.SessionID, result.CreateAt, result.ExpireAt, len(result.Token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+)
+
+func TestService_WriteForbidden(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/rtc/v1/whep/?app=live&stream=livestream", nil)
+	writeForbidden(context.Background(), w, r, errors.Wrapf(errors.New("denied by ip rule"), "verify"))
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Fail for status, expect %v, actual %v", http.StatusForbidden, w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "denied by ip rule") {
+		t.Errorf("Fail for body %v", body)
+	}
+}
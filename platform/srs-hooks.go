@@ -38,6 +38,8 @@ const (
 	SrsActionOnPublish SrsAction = "on_publish"
 	// The unpublish action.
 	SrsActionOnUnpublish = "on_unpublish"
+	// The play action.
+	SrsActionOnPlay = "on_play"
 
 	// The hls action, for SRS server only.
 	SrsActionOnHls = "on_hls"
@@ -49,6 +51,13 @@ const (
 
 	// The on_ocr action.
 	SrsActionOnOcr = "on_ocr"
+
+	// The login failed action, for Oryx only.
+	SrsActionOnLoginFailed = "on_login_failed"
+	// The health of forward destination changed action, for Oryx only.
+	SrsActionOnForwardHealth = "on_forward_health"
+	// The online state of IP camera changed action, for Oryx only.
+	SrsActionOnCameraHealth = "on_camera_health"
 )
 
 func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
@@ -73,14 +82,6 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
-			if noAuth, err := rdb.HGet(ctx, SRS_AUTH_SECRET, "pubNoAuth").Result(); err != nil && err != redis.Nil {
-				return errors.Wrapf(err, "hget %v pubNoAuth", SRS_AUTH_SECRET)
-			} else if noAuth == "true" {
-				ohttp.WriteData(ctx, w, r, nil)
-				logger.Tf(ctx, "srs hooks disabled")
-				return nil
-			}
-
 			b, err := ioutil.ReadAll(r.Body)
 			if err != nil {
 				return errors.Wrapf(err, "read body")
@@ -98,8 +99,43 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "json unmarshal %v", string(b))
 			}
 
-			verifiedBy := "noVerify"
+			// Reject the client by IP rules, before verifying the token or secret.
 			if action == SrsActionOnPublish {
+				if err := verifyStreamIPRules(ctx, IPRuleScopePublish, &streamObj); err != nil {
+					return errors.Wrapf(err, "ip rules action=%v", action)
+				}
+			} else if action == SrsActionOnPlay {
+				if err := verifyStreamIPRules(ctx, IPRuleScopePlay, &streamObj); err != nil {
+					return errors.Wrapf(err, "ip rules action=%v", action)
+				}
+			}
+
+			// The IP rules are always applied, even if the publish auth is disabled.
+			if noAuth, err := rdb.HGet(ctx, SRS_AUTH_SECRET, "pubNoAuth").Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v pubNoAuth", SRS_AUTH_SECRET)
+			} else if noAuth == "true" {
+				ohttp.WriteData(ctx, w, r, nil)
+				logger.Tf(ctx, "srs hooks disabled, action=%v, %v", action, streamObj.String())
+				return nil
+			}
+
+			verifiedBy := "noVerify"
+			pubToken := publishTokenFromParam(streamObj.Param)
+			if action == SrsActionOnPublish && pubToken != "" {
+				// The signed publish token is bound to the stream, and it has a expire time.
+				claims, err := verifyPublishToken(ctx, pubToken, &streamObj)
+				if err != nil {
+					return errors.Wrapf(err, "invalid token stream=%v, ip=%v, action=%v", streamObj.Stream, streamObj.IP, action)
+				}
+				verifiedBy = fmt.Sprintf("token(%v)", claims.ID)
+			} else if action == SrsActionOnPublish {
+				// Only allow the legacy secret if enabled, or the token is required.
+				if legacy, err := isPublishLegacyEnabled(ctx); err != nil {
+					return errors.Wrapf(err, "query legacy")
+				} else if !legacy {
+					return errors.Errorf("no token stream=%v, param=%v, action=%v", streamObj.Stream, streamObj.Param, action)
+				}
+
 				// Note that we allow pass secret by params or in stream name, for example, some encoder does not support params
 				// with ?secret=xxx, so it will fail when url is:
 				//      rtmp://ip/live/livestream?secret=xxx
@@ -127,6 +163,11 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 				if !isSecretOK(publish, streamObj.Stream, streamObj.Param) {
 					return errors.Errorf("invalid normal stream=%v, param=%v, action=%v", streamObj.Stream, streamObj.Param, action)
 				}
+			} else if action == SrsActionOnPlay {
+				// The signed play token is required only if the stream is protected.
+				if verifiedBy, err = verifyOnPlay(ctx, &streamObj); err != nil {
+					return errors.Wrapf(err, "invalid play stream=%v, ip=%v, action=%v", streamObj.Stream, streamObj.IP, action)
+				}
 			}
 
 			// Verify some actions, before all other hooks.
@@ -176,7 +217,7 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 						return errors.Wrapf(err, "hset %v %v", SRS_STREAM_RTC_ACTIVE, streamURL)
 					}
 				}
-			} else if action == "on_play" {
+			} else if action == SrsActionOnPlay {
 				if err := rdb.HIncrBy(ctx, SRS_STAT_COUNTER, "play", 1).Err(); err != nil && err != redis.Nil {
 					return errors.Wrapf(err, "hincrby %v play 1", SRS_STAT_COUNTER)
 				}
@@ -222,10 +263,17 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 				return errors.New("system not boot yet")
 			}
 
+			legacy, err := isPublishLegacyEnabled(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "query legacy")
+			}
+
 			ohttp.WriteData(ctx, w, r, &struct {
 				Publish string `json:"publish"`
+				// Whether allow to publish by the secret, or only by the signed token.
+				Legacy bool `json:"pubLegacy"`
 			}{
-				Publish: publish,
+				Publish: publish, Legacy: legacy,
 			})
 			logger.Tf(ctx, "srs secret ok ok, token=%vB", len(token))
 			return nil
@@ -702,6 +750,18 @@ func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
 		}
 	})
 
+	if err := handlePublishTokenService(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle publish token")
+	}
+
+	if err := handlePlayAuthService(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle play auth")
+	}
+
+	if err := handleIPRulesService(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle ip rules")
+	}
+
 	if err := handleOnHls(ctx, handler); err != nil {
 		return errors.Wrapf(err, "handle hooks")
 	}
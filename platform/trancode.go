@@ -99,6 +99,10 @@ func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) er
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			if err := config.Validate(); err != nil {
+				return errors.Wrapf(err, "validate %v", config.String())
+			}
+
 			if b, err := json.Marshal(config); err != nil {
 				return errors.Wrapf(err, "marshal conf %v", config)
 			} else if err := rdb.HSet(ctx, SRS_TRANSCODE_CONFIG, "global", string(b)).Err(); err != nil && err != redis.Nil {
@@ -145,6 +149,7 @@ func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) er
 			}
 
 			pid, input, output, frame, update := v.task.queryFrame()
+			master := v.task.queryMaster()
 
 			res := struct {
 				// The task uuid.
@@ -155,6 +160,10 @@ func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) er
 				InputStream string `json:"input"`
 				// The output stream URL.
 				OutputStream string `json:"output"`
+				// The HLS master playlist of ABR ladder, for example, /live/livestream.m3u8
+				Master string `json:"master,omitempty"`
+				// The output stream URL of each rendition.
+				Renditions []string `json:"renditions,omitempty"`
 				// The FFmpeg log.
 				Frame struct {
 					// The FFmpeg log lines.
@@ -171,6 +180,10 @@ func (v *TranscodeWorker) Handle(ctx context.Context, handler *http.ServeMux) er
 				res.Frame.Log = frame
 				res.Frame.Update = update
 			}
+			if pid > 0 && master != nil {
+				res.Master = fmt.Sprintf("/%v/%v.m3u8", master.App, master.Stream)
+				res.Renditions = v.task.queryOutputs()
+			}
 
 			ohttp.WriteData(ctx, w, r, &res)
 			logger.Tf(ctx, "transcode task ok, %v, pid=%v, input=%v, output=%v, frame=%v, update=%v, token=%vB",
@@ -269,12 +282,15 @@ type TranscodeConfig struct {
 	Server string `json:"server"`
 	// The RTMP stream and secret, for example, livestream
 	Secret string `json:"secret"`
+	// The ABR ladder, each rendition is published as stream with suffix, for example, livestream_720p, and the
+	// HLS master playlist is served as the stream, for example, livestream.m3u8
+	Ladder []*TranscodeRendition `json:"ladder,omitempty"`
 }
 
 func (v TranscodeConfig) String() string {
-	return fmt.Sprintf("all=%v, vcodec=%v, acodec=%v, vbitrate=%v, abitrate=%v, achannels=%v, vprofile=%v, vpreset=%v, server=%v, secret=%v",
+	return fmt.Sprintf("all=%v, vcodec=%v, acodec=%v, vbitrate=%v, abitrate=%v, achannels=%v, vprofile=%v, vpreset=%v, server=%v, secret=%v, ladder=%v",
 		v.All, v.VideoCodec, v.AudioCodec, v.VideoBitrate, v.AudioBitrate, v.AudioChannels, v.VideoProfile,
-		v.VideoPreset, v.Server, v.Secret,
+		v.VideoPreset, v.Server, v.Secret, len(v.Ladder),
 	)
 }
 
@@ -288,6 +304,10 @@ type TranscodeTask struct {
 	inputStreamURL string
 	// The output url
 	Output string `json:"output"`
+	// The output url of each rendition, for ABR ladder.
+	outputs []string
+	// The HLS master playlist, for ABR ladder.
+	master *TranscodeMaster
 
 	// FFmpeg pid.
 	PID int32 `json:"pid"`
@@ -365,9 +385,14 @@ func (v *TranscodeTask) Run(ctx context.Context) error {
 				return nil, errors.Wrapf(err, "unmarshal %v", value)
 			}
 
-			// Ignore the transcode stream itself.
-			if isSameStream(fmt.Sprintf("%v/%v", v.config.Server, v.config.Secret),
-				fmt.Sprintf("rtmp://%v/%v/%v", stream.Vhost, stream.App, stream.Stream)) {
+			// Ignore the transcode stream itself, and the renditions of ABR ladder.
+			var isOutput bool
+			for _, output := range v.config.outputStreams() {
+				if isSameStream(output, fmt.Sprintf("rtmp://%v/%v/%v", stream.Vhost, stream.App, stream.Stream)) {
+					isOutput = true
+				}
+			}
+			if isOutput {
 				continue
 			}
 
@@ -467,11 +492,27 @@ func (v *TranscodeTask) doTranscode(ctx context.Context, input *SrsStream) error
 	inputURL := fmt.Sprintf("rtmp://%v/%v/%v", host, input.App, input.Stream)
 
 	// Build output URL.
-	outputServer := strings.ReplaceAll(v.config.Server, "localhost", host)
-	if !strings.HasSuffix(outputServer, "/") && !strings.HasPrefix(v.config.Secret, "/") && v.config.Secret != "" {
-		outputServer += "/"
+	outputURL := v.config.outputStream(host, "")
+
+	// Probe the audio of source for ABR ladder, because the audio only rendition requires audio.
+	hasAudio := true
+	if len(v.config.Ladder) > 0 {
+		probeCtx, probeCancel := context.WithTimeout(ctx, 15*time.Second)
+		_, _, audio, err := FFprobeFileFormat(probeCtx, inputURL)
+		probeCancel()
+		if err != nil {
+			return errors.Wrapf(err, "probe %v", inputURL)
+		}
+		if hasAudio = audio != nil; !hasAudio {
+			logger.Wf(ctx, "transcode: Skip audio only renditions, no audio of %v", inputURL)
+		}
+	}
+
+	// Build the master playlist for ABR ladder.
+	master, err := NewTranscodeMaster(&v.config, hasAudio)
+	if err != nil {
+		return errors.Wrapf(err, "master playlist")
 	}
-	outputURL := fmt.Sprintf("%v%v", outputServer, v.config.Secret)
 
 	// Create a heartbeat to poll and manage the status of FFmpeg process.
 	heartbeat := NewFFmpegHeartbeat(cancel)
@@ -494,27 +535,23 @@ func (v *TranscodeTask) doTranscode(ctx context.Context, input *SrsStream) error
 	} else {
 		args = append(args, "-i", inputURL)
 	}
-	args = append(args,
-		"-vcodec", v.config.VideoCodec,
-		"-profile:v", v.config.VideoProfile,
-		"-preset:v", v.config.VideoPreset,
-		"-tune", "zerolatency", // Low latency mode.
-		"-b:v", fmt.Sprintf("%vk", v.config.VideoBitrate),
-		"-r", "25", "-g", "50", // Set gop to 2s.
-		"-bf", "0", // Disable B frame for WebRTC.
-		"-acodec", v.config.AudioCodec,
-		"-b:a", fmt.Sprintf("%vk", v.config.AudioBitrate),
-	)
-	if v.config.AudioChannels > 0 {
-		args = append(args, "-ac", fmt.Sprintf("%v", v.config.AudioChannels))
+	// Sign the outputs by publish token, because the legacy secret may be disabled.
+	key, err := queryPublishTokenKey(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "query key")
 	}
-	// If RTMP use flv, if SRT use mpegts, otherwise do not set.
-	if strings.HasPrefix(outputURL, "rtmp://") || strings.HasPrefix(outputURL, "rtmps://") {
-		args = append(args, "-f", "flv")
-	} else if strings.HasPrefix(outputURL, "srt://") {
-		args = append(args, "-pes_payload_size", "0", "-f", "mpegts")
+	var outputs []string
+	if master != nil {
+		var ladderArgs []string
+		if ladderArgs, outputs, err = buildTranscodeLadder(&v.config, host, key, hasAudio); err != nil {
+			return errors.Wrapf(err, "build ladder")
+		}
+		args = append(args, ladderArgs...)
+	} else if publishURL, err := signPublishURL(key, outputURL); err != nil {
+		return errors.Wrapf(err, "sign %v", outputURL)
+	} else {
+		args = append(args, v.buildOutputArgs(publishURL)...)
 	}
-	args = append(args, outputURL)
 	// Create the command object.
 	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 
@@ -529,6 +566,7 @@ func (v *TranscodeTask) doTranscode(ctx context.Context, input *SrsStream) error
 
 	v.PID = int32(cmd.Process.Pid)
 	v.Input, v.inputStreamURL, v.Output = inputURL, input.StreamURL(), outputURL
+	v.outputs, v.master = outputs, master
 	defer func() {
 		// If we got a PID, sleep for a while, to avoid too fast restart.
 		if v.PID > 0 {
@@ -576,6 +614,33 @@ func (v *TranscodeTask) doTranscode(ctx context.Context, input *SrsStream) error
 	return err
 }
 
+// buildOutputArgs build the FFmpeg args after input, to encode a single rendition.
+func (v *TranscodeTask) buildOutputArgs(outputURL string) []string {
+	args := []string{}
+	args = append(args,
+		"-vcodec", v.config.VideoCodec,
+		"-profile:v", v.config.VideoProfile,
+		"-preset:v", v.config.VideoPreset,
+		"-tune", "zerolatency", // Low latency mode.
+		"-b:v", fmt.Sprintf("%vk", v.config.VideoBitrate),
+		"-r", "25", "-g", "50", // Set gop to 2s.
+		"-bf", "0", // Disable B frame for WebRTC.
+		"-acodec", v.config.AudioCodec,
+		"-b:a", fmt.Sprintf("%vk", v.config.AudioBitrate),
+	)
+	if v.config.AudioChannels > 0 {
+		args = append(args, "-ac", fmt.Sprintf("%v", v.config.AudioChannels))
+	}
+	// If RTMP use flv, if SRT use mpegts, otherwise do not set.
+	if strings.HasPrefix(outputURL, "rtmp://") || strings.HasPrefix(outputURL, "rtmps://") {
+		args = append(args, "-f", "flv")
+	} else if strings.HasPrefix(outputURL, "srt://") {
+		args = append(args, "-pes_payload_size", "0", "-f", "mpegts")
+	}
+	args = append(args, outputURL)
+	return args
+}
+
 func (v *TranscodeTask) updateFrame(frame string) {
 	v.lock.Lock()
 	defer v.lock.Unlock()
@@ -590,6 +655,22 @@ func (v *TranscodeTask) queryFrame() (int32, string, string, string, string) {
 	return v.PID, v.inputStreamURL, v.Output, v.frame, v.update.Format(time.RFC3339)
 }
 
+func (v *TranscodeTask) queryOutputs() []string {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return append([]string{}, v.outputs...)
+}
+
+// queryMaster get the HLS master playlist of ABR ladder, nil if not running or no ladder.
+func (v *TranscodeTask) queryMaster() *TranscodeMaster {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.PID <= 0 {
+		return nil
+	}
+	return v.master
+}
+
 func (v *TranscodeTask) saveTask(ctx context.Context) error {
 	v.lock.Lock()
 	defer v.lock.Unlock()
@@ -616,6 +697,7 @@ func (v *TranscodeTask) cleanup(ctx context.Context) error {
 
 	v.PID = 0
 	v.cancel = nil
+	v.outputs, v.master = nil, nil
 
 	return nil
 }
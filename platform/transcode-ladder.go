@@ -0,0 +1,328 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	// From ossrs.
+	"github.com/ossrs/go-oryx-lib/errors"
+)
+
+// The max number of renditions in ABR ladder.
+const transcodeLadderMaxRenditions = 6
+
+// The name of rendition, which is used as the suffix of stream, for example, livestream_720p.
+var transcodeRenditionName = regexp.MustCompile(`^[0-9a-zA-Z]+$`)
+
+// TranscodeRendition is a rendition of ABR ladder, for example, 1080p, 720p, 480p or audio only.
+type TranscodeRendition struct {
+	// The name of rendition, for example, 720p.
+	Name string `json:"name"`
+	// The video width, 0 to keep the aspect ratio by height.
+	Width int `json:"width,omitempty"`
+	// The video height.
+	Height int `json:"height,omitempty"`
+	// The video bitrate in kbps.
+	VideoBitrate int `json:"vbitrate,omitempty"`
+	// The audio bitrate in kbps, 0 to use the audio bitrate of transcode config.
+	AudioBitrate int `json:"abitrate,omitempty"`
+	// Whether audio only rendition, without video.
+	AudioOnly bool `json:"audioOnly,omitempty"`
+}
+
+func (v TranscodeRendition) String() string {
+	return fmt.Sprintf("name=%v, width=%v, height=%v, vbitrate=%v, abitrate=%v, audioOnly=%v",
+		v.Name, v.Width, v.Height, v.VideoBitrate, v.AudioBitrate, v.AudioOnly,
+	)
+}
+
+// Validate the ABR ladder of transcode config. Note that the ladder is optional, and only a single rendition
+// is produced if empty.
+func (v *TranscodeConfig) Validate() error {
+	if len(v.Ladder) == 0 {
+		return nil
+	}
+
+	if len(v.Ladder) > transcodeLadderMaxRenditions {
+		return errors.Errorf("ladder %v exceeds %v", len(v.Ladder), transcodeLadderMaxRenditions)
+	}
+	if !strings.HasPrefix(v.Server, "rtmp://") {
+		return errors.Errorf("ladder requires rtmp server, got %v", v.Server)
+	}
+	if v.Secret == "" {
+		return errors.New("ladder requires stream")
+	}
+
+	names := make(map[string]bool)
+	var videos int
+	for _, r := range v.Ladder {
+		if r == nil {
+			return errors.New("empty rendition")
+		}
+		if !transcodeRenditionName.MatchString(r.Name) {
+			return errors.Errorf("invalid name %v", r.Name)
+		}
+		if names[r.Name] {
+			return errors.Errorf("duplicated name %v", r.Name)
+		}
+		names[r.Name] = true
+
+		if r.Width < 0 || r.Height < 0 || r.VideoBitrate < 0 || r.AudioBitrate < 0 {
+			return errors.Errorf("invalid rendition %v", r.String())
+		}
+		if r.AudioOnly {
+			continue
+		}
+
+		// The H.264 requires even size.
+		if r.Height <= 0 || r.Height%2 != 0 || r.Width%2 != 0 {
+			return errors.Errorf("invalid size %vx%v of %v", r.Width, r.Height, r.Name)
+		}
+		if r.VideoBitrate <= 0 {
+			return errors.Errorf("no video bitrate of %v", r.Name)
+		}
+		videos++
+	}
+
+	if videos == 0 {
+		return errors.New("no video rendition")
+	}
+
+	return nil
+}
+
+// outputStream build the output stream URL, with the suffix of rendition if not empty.
+func (v *TranscodeConfig) outputStream(host, name string) string {
+	outputServer := strings.ReplaceAll(v.Server, "localhost", host)
+	if !strings.HasSuffix(outputServer, "/") && !strings.HasPrefix(v.Secret, "/") && v.Secret != "" {
+		outputServer += "/"
+	}
+	if name == "" {
+		return fmt.Sprintf("%v%v", outputServer, v.Secret)
+	}
+
+	// Insert the suffix before the query string, for example, livestream_720p?secret=xxx
+	secret, query := v.Secret, ""
+	if index := strings.Index(secret, "?"); index >= 0 {
+		secret, query = secret[:index], secret[index:]
+	}
+	return fmt.Sprintf("%v%v_%v%v", outputServer, secret, name, query)
+}
+
+// outputStreams get all output streams, to avoid using the output as input.
+func (v *TranscodeConfig) outputStreams() []string {
+	if len(v.Ladder) == 0 {
+		return []string{v.outputStream("localhost", "")}
+	}
+
+	var streams []string
+	for _, r := range v.Ladder {
+		streams = append(streams, v.outputStream("localhost", r.Name))
+	}
+	return streams
+}
+
+// renditions get the renditions to encode, the audio only renditions are skipped if the source has no
+// audio, because FFmpeg fails when an output has no stream.
+func (v *TranscodeConfig) renditions(hasAudio bool) []*TranscodeRendition {
+	var renditions []*TranscodeRendition
+	for _, r := range v.Ladder {
+		if r.AudioOnly && !hasAudio {
+			continue
+		}
+		renditions = append(renditions, r)
+	}
+	return renditions
+}
+
+// audioBitrate get the audio bitrate of rendition, default to the transcode config.
+func (v *TranscodeConfig) audioBitrate(r *TranscodeRendition) int {
+	if r.AudioBitrate > 0 {
+		return r.AudioBitrate
+	}
+	return v.AudioBitrate
+}
+
+// buildTranscodeLadder build the FFmpeg args after input, to encode all renditions in one process. The video
+// is decoded once and scaled for each rendition, all renditions use the same fps and gop without scene cut, so
+// the keyframes are aligned, and the player is able to switch between renditions at any segment boundary. The
+// outputs to this server are signed by the publish token key, see signPublishURL.
+func buildTranscodeLadder(config *TranscodeConfig, host, key string, hasAudio bool) (args, outputs []string, err error) {
+	var videos []*TranscodeRendition
+	for _, r := range config.Ladder {
+		if !r.AudioOnly {
+			videos = append(videos, r)
+		}
+	}
+
+	var filters []string
+	var splits string
+	for i := range videos {
+		splits += fmt.Sprintf("[s%v]", i)
+	}
+	filters = append(filters, fmt.Sprintf("[0:v]split=%v%v", len(videos), splits))
+	for i, r := range videos {
+		width := r.Width
+		if width == 0 {
+			width = -2
+		}
+		filters = append(filters, fmt.Sprintf("[s%v]scale=%v:%v[v%v]", i, width, r.Height, i))
+	}
+	args = append(args, "-filter_complex", strings.Join(filters, ";"))
+
+	var index int
+	for _, r := range config.renditions(hasAudio) {
+		output := config.outputStream(host, r.Name)
+		outputs = append(outputs, output)
+
+		if r.AudioOnly {
+			args = append(args, "-map", "0:a?", "-vn")
+		} else {
+			args = append(args,
+				"-map", fmt.Sprintf("[v%v]", index), "-map", "0:a?",
+				"-vcodec", config.VideoCodec,
+				"-profile:v", config.VideoProfile,
+				"-preset:v", config.VideoPreset,
+				"-tune", "zerolatency", // Low latency mode.
+				"-b:v", fmt.Sprintf("%vk", r.VideoBitrate),
+				"-maxrate", fmt.Sprintf("%vk", r.VideoBitrate),
+				"-bufsize", fmt.Sprintf("%vk", r.VideoBitrate*2),
+				"-r", "25", "-g", "50", "-keyint_min", "50", // Set gop to 2s, for all renditions.
+				"-sc_threshold", "0", // Disable scene cut, to align keyframes.
+				"-bf", "0", // Disable B frame for WebRTC.
+			)
+			index++
+		}
+
+		args = append(args,
+			"-acodec", config.AudioCodec,
+			"-b:a", fmt.Sprintf("%vk", config.audioBitrate(r)),
+		)
+		if config.AudioChannels > 0 {
+			args = append(args, "-ac", fmt.Sprintf("%v", config.AudioChannels))
+		}
+
+		publishURL, err := signPublishURL(key, output)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "sign %v", output)
+		}
+		args = append(args, "-f", "flv", publishURL)
+	}
+
+	return
+}
+
+// transcodeVideoCodec get the RFC6381 codec of H.264 rendition, for the CODECS of master playlist. The level
+// is estimated by the height, which is the level x264 chooses for the common sizes at 25fps.
+func transcodeVideoCodec(profile string, height int) string {
+	pp := "4d40" // The main profile.
+	if profile == "baseline" {
+		pp = "42e0"
+	} else if profile == "high" {
+		pp = "6400"
+	}
+
+	level := 0x33 // The level 5.1, for 2K and 4K.
+	if height <= 480 {
+		level = 0x1e
+	} else if height <= 720 {
+		level = 0x1f
+	} else if height <= 1080 {
+		level = 0x28
+	}
+	return fmt.Sprintf("avc1.%v%02x", pp, level)
+}
+
+// buildTranscodeMaster build the HLS master playlist of ABR ladder, the variants are the HLS of each rendition
+// stream, which is in the same directory of master playlist. All variants have CODECS, because the player
+// requires it to switch between audio only and video variants.
+func buildTranscodeMaster(config *TranscodeConfig, stream string, hasAudio bool) string {
+	lines := []string{"#EXTM3U", "#EXT-X-VERSION:3"}
+	for _, r := range config.renditions(hasAudio) {
+		var bandwidth int
+		var codecs []string
+		if !r.AudioOnly {
+			bandwidth += r.VideoBitrate * 1000
+			codecs = append(codecs, transcodeVideoCodec(config.VideoProfile, r.Height))
+		}
+		if hasAudio {
+			bandwidth += config.audioBitrate(r) * 1000
+			codecs = append(codecs, "mp4a.40.2")
+		}
+
+		line := fmt.Sprintf(`#EXT-X-STREAM-INF:BANDWIDTH=%v,CODECS="%v"`, bandwidth, strings.Join(codecs, ","))
+		if !r.AudioOnly && r.Width > 0 {
+			line += fmt.Sprintf(",RESOLUTION=%vx%v", r.Width, r.Height)
+		}
+		lines = append(lines, line)
+		lines = append(lines, fmt.Sprintf("%v_%v.m3u8", stream, r.Name))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// TranscodeMaster is the HLS master playlist of ABR ladder, served by platform.
+type TranscodeMaster struct {
+	// The app and stream of master playlist, for example, live and livestream.
+	App    string
+	Stream string
+	// The names of renditions.
+	Names []string
+	// The body of master playlist.
+	Body string
+}
+
+// NewTranscodeMaster create the master playlist by the transcode config, return nil if no ladder. The audio only
+// renditions are excluded if the source has no audio.
+func NewTranscodeMaster(config *TranscodeConfig, hasAudio bool) (*TranscodeMaster, error) {
+	if len(config.Ladder) == 0 {
+		return nil, nil
+	}
+
+	u, err := url.Parse(config.outputStream("localhost", ""))
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse %v", config.outputStream("localhost", ""))
+	}
+
+	app, stream := strings.Trim(path.Dir(u.Path), "/"), path.Base(u.Path)
+	v := &TranscodeMaster{App: app, Stream: stream, Body: buildTranscodeMaster(config, stream, hasAudio)}
+	for _, r := range config.renditions(hasAudio) {
+		v.Names = append(v.Names, r.Name)
+	}
+	return v, nil
+}
+
+// Match whether the stream is the master or any rendition, return the master stream.
+func (v *TranscodeMaster) Match(app, stream string) (string, bool) {
+	if v == nil || app != v.App {
+		return "", false
+	}
+	if stream == v.Stream {
+		return v.Stream, true
+	}
+	for _, name := range v.Names {
+		if stream == fmt.Sprintf("%v_%v", v.Stream, name) {
+			return v.Stream, true
+		}
+	}
+	return "", false
+}
+
+// queryTranscodeMaster get the master playlist of ABR ladder, if the app and stream is the master or any
+// rendition, return nil if not match.
+func queryTranscodeMaster(app, stream string) *TranscodeMaster {
+	if transcodeWorker == nil || transcodeWorker.task == nil {
+		return nil
+	}
+
+	master := transcodeWorker.task.queryMaster()
+	if _, ok := master.Match(app, stream); !ok {
+		return nil
+	}
+	return master
+}
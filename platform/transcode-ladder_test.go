@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTranscodeLadder_Validate(t *testing.T) {
+	ladder := func(renditions ...*TranscodeRendition) *TranscodeConfig {
+		return &TranscodeConfig{Server: "rtmp://localhost/live", Secret: "livestream", Ladder: renditions}
+	}
+
+	for _, e := range []struct {
+		config *TranscodeConfig
+		ok     bool
+	}{
+		{config: &TranscodeConfig{Server: "srt://localhost:10080", Secret: "livestream"}, ok: true},
+		{config: ladder(&TranscodeRendition{Name: "720p", Height: 720, VideoBitrate: 2000}), ok: true},
+		{config: ladder(&TranscodeRendition{Name: "720p", Width: 1280, Height: 720, VideoBitrate: 2000},
+			&TranscodeRendition{Name: "audio", AudioOnly: true}), ok: true},
+		{config: ladder(&TranscodeRendition{Name: "audio", AudioOnly: true}), ok: false},
+		{config: ladder(&TranscodeRendition{Name: "720p", Height: 720}), ok: false},
+		{config: ladder(&TranscodeRendition{Name: "720p", Height: 721, VideoBitrate: 2000}), ok: false},
+		{config: ladder(&TranscodeRendition{Name: "720_p", Height: 720, VideoBitrate: 2000}), ok: false},
+		{config: ladder(&TranscodeRendition{Name: "720p", Height: 720, VideoBitrate: 2000},
+			&TranscodeRendition{Name: "720p", Height: 720, VideoBitrate: 1000}), ok: false},
+		{config: &TranscodeConfig{Server: "srt://localhost:10080", Secret: "livestream", Ladder: []*TranscodeRendition{
+			{Name: "720p", Height: 720, VideoBitrate: 2000},
+		}}, ok: false},
+	} {
+		if err := e.config.Validate(); (err == nil) != e.ok {
+			t.Errorf("Fail for %v, expect ok=%v, err %v", e.config.String(), e.ok, err)
+		}
+	}
+}
+
+func TestTranscodeLadder_BuildArgs(t *testing.T) {
+	config := &TranscodeConfig{
+		VideoCodec: "libx264", VideoProfile: "main", VideoPreset: "veryfast", AudioCodec: "aac", AudioBitrate: 64,
+		Server: "rtmp://localhost/live", Secret: "livestream?secret=xxx", Ladder: []*TranscodeRendition{
+			{Name: "1080p", Width: 1920, Height: 1080, VideoBitrate: 4000, AudioBitrate: 128},
+			{Name: "480p", Height: 480, VideoBitrate: 800},
+			{Name: "audio", AudioOnly: true},
+		},
+	}
+
+	args, outputs, err := buildTranscodeLadder(config, "127.0.0.1", "0123456789abcdef", true)
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+	if len(outputs) != 3 || outputs[1] != "rtmp://127.0.0.1/live/livestream_480p?secret=xxx" {
+		t.Errorf("Fail for outputs %v", outputs)
+	}
+
+	line := strings.Join(args, " ")
+	for _, expect := range []string{
+		"-filter_complex [0:v]split=2[s0][s1];[s0]scale=1920:1080[v0];[s1]scale=-2:480[v1]",
+		"-map [v0] -map 0:a? -vcodec libx264",
+		"-b:v 4000k -maxrate 4000k -bufsize 8000k -r 25 -g 50 -keyint_min 50 -sc_threshold 0",
+		"-b:a 128k -f flv rtmp://127.0.0.1/live/livestream_1080p?secret=xxx&pubToken=",
+		"-map [v1] -map 0:a?",
+		"-b:a 64k -f flv rtmp://127.0.0.1/live/livestream_480p?secret=xxx",
+		"-map 0:a? -vn -acodec aac -b:a 64k -f flv rtmp://127.0.0.1/live/livestream_audio?secret=xxx",
+	} {
+		if !strings.Contains(line, expect) {
+			t.Errorf("Fail for %v, expect %v", line, expect)
+		}
+	}
+
+	master, err := NewTranscodeMaster(config, true)
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+	if master.App != "live" || master.Stream != "livestream" {
+		t.Errorf("Fail for master %v/%v", master.App, master.Stream)
+	}
+	if expect := "#EXTM3U\n#EXT-X-VERSION:3\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=4128000,CODECS=\"avc1.4d4028,mp4a.40.2\",RESOLUTION=1920x1080\nlivestream_1080p.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=864000,CODECS=\"avc1.4d401e,mp4a.40.2\"\nlivestream_480p.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=64000,CODECS=\"mp4a.40.2\"\nlivestream_audio.m3u8\n"; master.Body != expect {
+		t.Errorf("Fail for master %v", master.Body)
+	}
+
+	for _, e := range []struct {
+		app    string
+		stream string
+		ok     bool
+	}{
+		{app: "live", stream: "livestream", ok: true},
+		{app: "live", stream: "livestream_480p", ok: true},
+		{app: "live", stream: "livestream_720p", ok: false},
+		{app: "other", stream: "livestream", ok: false},
+	} {
+		if stream, ok := master.Match(e.app, e.stream); ok != e.ok || (ok && stream != "livestream") {
+			t.Errorf("Fail for %v/%v, expect %v, actual %v %v", e.app, e.stream, e.ok, stream, ok)
+		}
+	}
+
+	// Without ladder, no master playlist.
+	if master, err := NewTranscodeMaster(&TranscodeConfig{Server: "rtmp://localhost/live", Secret: "livestream"}, true); master != nil || err != nil {
+		t.Errorf("Fail for master %v, err %v", master, err)
+	}
+}
+
+func TestTranscodeLadder_NoAudio(t *testing.T) {
+	config := &TranscodeConfig{
+		VideoCodec: "libx264", VideoProfile: "high", VideoPreset: "veryfast", AudioCodec: "aac", AudioBitrate: 64,
+		Server: "rtmp://localhost/live", Secret: "livestream", Ladder: []*TranscodeRendition{
+			{Name: "720p", Width: 1280, Height: 720, VideoBitrate: 2000},
+			{Name: "audio", AudioOnly: true},
+		},
+	}
+
+	// The audio only rendition is skipped, because FFmpeg fails for output without stream.
+	if args, outputs, err := buildTranscodeLadder(config, "127.0.0.1", "0123456789abcdef", false); err != nil || len(outputs) != 1 {
+		t.Errorf("Fail for outputs %v, err %v", outputs, err)
+	} else if line := strings.Join(args, " "); strings.Contains(line, "-vn") {
+		t.Errorf("Fail for %v, expect no audio only rendition", line)
+	}
+
+	master, err := NewTranscodeMaster(config, false)
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+	if expect := "#EXTM3U\n#EXT-X-VERSION:3\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2000000,CODECS=\"avc1.64001f\",RESOLUTION=1280x720\nlivestream_720p.m3u8\n"; master.Body != expect {
+		t.Errorf("Fail for master %v", master.Body)
+	}
+	if _, ok := master.Match("live", "livestream_audio"); ok {
+		t.Errorf("Fail for match audio only rendition")
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/ossrs/go-oryx-lib/errors"
 	"github.com/ossrs/go-oryx-lib/logger"
 
@@ -279,9 +280,18 @@ const (
 	// For virtual live channel/stream.
 	SRS_VLIVE_CONFIG = "SRS_VLIVE_CONFIG"
 	SRS_VLIVE_TASK   = "SRS_VLIVE_TASK"
+	// The normalized files of vLive, transcoded to the channel profile.
+	SRS_VLIVE_NORMALIZE = "SRS_VLIVE_NORMALIZE"
+	// The playback position of vLive, to resume after restart.
+	SRS_VLIVE_POSITION = "SRS_VLIVE_POSITION"
+	// The S3-compatible object storage to fetch remote files of vLive.
+	SRS_MEDIA_S3 = "SRS_MEDIA_S3"
 	// For IP camera live channel/stream.
 	SRS_CAMERA_CONFIG = "SRS_CAMERA_CONFIG"
 	SRS_CAMERA_TASK   = "SRS_CAMERA_TASK"
+	// For mosaic composite of streams and cameras.
+	SRS_MOSAIC_CONFIG = "SRS_MOSAIC_CONFIG"
+	SRS_MOSAIC_TASK   = "SRS_MOSAIC_TASK"
 	// For transcoding.
 	SRS_TRANSCODE_CONFIG = "SRS_TRANSCODE_CONFIG"
 	SRS_TRANSCODE_TASK   = "SRS_TRANSCODE_TASK"
@@ -307,6 +317,21 @@ const (
 	// About authentication.
 	SRS_AUTH_SECRET    = "SRS_AUTH_SECRET"
 	SRS_SECRET_PUBLISH = "SRS_SECRET_PUBLISH"
+	SRS_PUBLISH_NONCE  = "SRS_PUBLISH_NONCE"
+	SRS_PLAY_AUTH      = "SRS_PLAY_AUTH"
+	SRS_IP_RULES       = "SRS_IP_RULES"
+	SRS_IP_RULES_HITS  = "SRS_IP_RULES_HITS"
+	SRS_SECRET_KEYS    = "SRS_SECRET_KEYS"
+	// For login sessions of management.
+	SRS_MGMT_SESSIONS  = "SRS_MGMT_SESSIONS"
+	SRS_TOKEN_REVOKED  = "SRS_TOKEN_REVOKED"
+	SRS_LOGIN_FAILURES = "SRS_LOGIN_FAILURES"
+	SRS_LOGIN_LOCKOUT  = "SRS_LOGIN_LOCKOUT"
+	SRS_OIDC           = "SRS_OIDC"
+	SRS_OIDC_STATE     = "SRS_OIDC_STATE"
+	// For audit log.
+	SRS_AUDIT_LOG    = "SRS_AUDIT_LOG"
+	SRS_AUDIT_CONFIG = "SRS_AUDIT_CONFIG"
 	// For system settings.
 	SRS_LOCALE          = "SRS_LOCALE"
 	SRS_FIRST_BOOT      = "SRS_FIRST_BOOT"
@@ -347,12 +372,16 @@ const FFprobeSourceTypeUpload FFprobeSourceType = "upload"
 const FFprobeSourceTypeFile FFprobeSourceType = "file"
 const FFprobeSourceTypeYTDL FFprobeSourceType = "ytdl"
 const FFprobeSourceTypeStream FFprobeSourceType = "stream"
+const FFprobeSourceTypeRemote FFprobeSourceType = "remote"
 
 // For vLive upload directory.
 var dirUploadPath = path.Join(".", "upload")
 var dirVLivePath = path.Join(".", "vlive")
 var dirDubbingPath = path.Join(".", "dub")
 
+// For camera motion recording, the segments before moved to record.
+var dirMotionPath = path.Join(".", "record", "motion")
+
 // For Oryx to use the files.
 const serverDataDirectory = "/data"
 
@@ -375,6 +404,26 @@ func envMgmtPassword() string {
 	return os.Getenv("MGMT_PASSWORD")
 }
 
+// The hook to verify the challenge of login, for example, a CAPTCHA service.
+func envMgmtLoginChallenge() string {
+	return os.Getenv("MGMT_LOGIN_CHALLENGE")
+}
+
+// The IPs or CIDRs separated by comma, which are not affected by the failures of all clients when login.
+func envMgmtLoginAllowlist() string {
+	return os.Getenv("MGMT_LOGIN_ALLOWLIST")
+}
+
+// Whether lockout all clients when too many failures of all clients, default to off, only delay the login.
+func envMgmtLoginGlobalLockout() string {
+	return os.Getenv("MGMT_LOGIN_GLOBAL_LOCKOUT")
+}
+
+// The IPs or CIDRs of trusted proxies separated by comma, to get the client IP from X-Forwarded-For.
+func envTrustedProxies() string {
+	return os.Getenv("TRUSTED_PROXIES")
+}
+
 func envSelfSignedCertificate() string {
 	return os.Getenv("AUTO_SELF_SIGNED_CERTIFICATE")
 }
@@ -487,6 +536,11 @@ func envCameraLimit() string {
 	return os.Getenv("SRS_CAMERA_LIMIT")
 }
 
+// The size limit of media cache in MB, for remote files of vLive.
+func envMediaCacheLimit() string {
+	return os.Getenv("SRS_MEDIA_CACHE_LIMIT")
+}
+
 func envGoPprof() string {
 	return os.Getenv("GO_PPROF")
 }
@@ -495,6 +549,20 @@ func envYtdlProxy() string {
 	return os.Getenv("YTDL_PROXY")
 }
 
+// The master key to encrypt the secrets in redis, or the file of master key.
+func envPlatformMasterKey() string {
+	return os.Getenv("PLATFORM_MASTER_KEY")
+}
+
+func envPlatformMasterKeyFile() string {
+	return os.Getenv("PLATFORM_MASTER_KEY_FILE")
+}
+
+// The previous master keys separated by comma, for rotation of master key.
+func envPlatformMasterKeyPrevious() string {
+	return os.Getenv("PLATFORM_MASTER_KEY_PREVIOUS")
+}
+
 // rdb is a global redis client object.
 var rdb *redis.Client
 
@@ -513,18 +581,31 @@ func InitRdb() error {
 	return nil
 }
 
-// For platform to build token by jwt.
-func createToken(ctx context.Context, apiSecret string) (expireAt, createAt time.Time, token string, err error) {
-	createAt, expireAt = time.Now(), time.Now().Add(365*24*time.Hour)
-
-	claims := struct {
-		Version string `json:"v"`
-		Nonce   string `json:"nonce"`
-		jwt.RegisteredClaims
-	}{
-		Version: "1.0",
-		Nonce:   fmt.Sprintf("%x", rand.Uint64()),
+// MgmtTokenClaims is the claims of token for management API, signed by apiSecret.
+type MgmtTokenClaims struct {
+	Version string `json:"v"`
+	Nonce   string `json:"nonce"`
+	// The kind of token, access or refresh. It's empty for the legacy token.
+	Kind string `json:"kind,omitempty"`
+	// The session id of token, empty for the legacy token.
+	SessionID string `json:"sid,omitempty"`
+	// The role of session, empty for admin.
+	Role string `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// For platform to build token by jwt, the kind is access or refresh, and bound to the session.
+func createToken(ctx context.Context, apiSecret, kind, sid, role string, expire time.Duration) (expireAt, createAt time.Time, token string, err error) {
+	createAt, expireAt = time.Now(), time.Now().Add(expire)
+
+	claims := MgmtTokenClaims{
+		Version:   "1.0",
+		Nonce:     fmt.Sprintf("%x", rand.Uint64()),
+		Kind:      kind,
+		SessionID: sid,
+		Role:      role,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			ExpiresAt: jwt.NewNumericDate(expireAt),
 			IssuedAt:  jwt.NewNumericDate(createAt),
 		},
@@ -1158,6 +1239,10 @@ type M3u8VoDArtifact struct {
 	// The ts files of this m3u8.
 	Files []*TsFile `json:"files"`
 
+	// For camera motion recording only.
+	// The motion event which triggers the record.
+	Motion *CameraMotionEvent `json:"motion,omitempty"`
+
 	// For DVR only.
 	// The COS bucket name.
 	Bucket string `json:"bucket"`
@@ -1286,13 +1371,19 @@ type SrsStream struct {
 
 	Server string `json:"server_id,omitempty"`
 	Client string `json:"client_id,omitempty"`
+	// The client IP address, reported by SRS in the callback.
+	IP string `json:"ip,omitempty"`
+	// The tcUrl of client, for example, rtmp://localhost/live or webrtc://localhost/live
+	TcUrl string `json:"tcUrl,omitempty"`
+	// The page URL of player, reported by SRS in the on_play callback.
+	PageURL string `json:"pageUrl,omitempty"`
 
 	Update string `json:"update,omitempty"`
 }
 
 func (v *SrsStream) String() string {
-	return fmt.Sprintf("vhost=%v, app=%v, stream=%v, param=%v, server=%v, client=%v, update=%v",
-		v.Vhost, v.App, v.Stream, v.Param, v.Server, v.Client, v.Update,
+	return fmt.Sprintf("vhost=%v, app=%v, stream=%v, param=%v, server=%v, client=%v, ip=%v, update=%v",
+		v.Vhost, v.App, v.Stream, v.Param, v.Server, v.Client, v.IP, v.Update,
 	)
 }
 
@@ -1362,20 +1453,38 @@ func Authenticate(ctx context.Context, apiSecret, token string, header http.Head
 			return errors.Wrapf(err, "parse bearer token")
 		}
 
-		if authSecret != apiSecret {
+		if authSecret == apiSecret {
+			return nil
+		}
+
+		// The bearer is the access token of session, for management console.
+		if token = authSecret; !strings.Contains(token, ".") {
 			return errors.New("invalid bearer token")
 		}
-		return nil
 	}
 
 	// Verify token first, @see https://www.npmjs.com/package/jsonwebtoken#errors--codes
 	// See https://pkg.go.dev/github.com/golang-jwt/jwt/v4#example-Parse-Hmac
-	if _, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		return []byte(apiSecret), nil
-	}); err != nil {
+	claims, err := parseMgmtToken(apiSecret, token)
+	if err != nil {
 		return errors.Wrapf(err, "verify token %v", token)
 	}
 
+	// The refresh token is only used to create new access token.
+	if claims.Kind == mgmtTokenKindRefresh {
+		return errors.New("refresh token not allowed")
+	}
+
+	// The viewer is only allowed to query the state of system.
+	if err := authorizeMgmtEndpoint(claims.Role, header); err != nil {
+		return errors.Wrapf(err, "authorize")
+	}
+
+	// Check whether the token is revoked, or the session is logged out.
+	if err := verifyMgmtToken(ctx, claims); err != nil {
+		return errors.Wrapf(err, "verify token")
+	}
+
 	return nil
 }
 
@@ -1560,6 +1669,8 @@ type FFprobeVideo struct {
 	Height int32 `json:"height"`
 	// The pixel format, for example, yuv420p, yuv422p, yuv444p, yuv410p, yuv411p, yuvj420p,
 	PixFormat string `json:"pix_fmt"`
+	// The frame rate, for example, 25/1 or 30000/1001.
+	FrameRate string `json:"r_frame_rate"`
 	// The level of video.
 	Level int32 `json:"level"`
 	// The bitrate in bps.
@@ -1620,6 +1731,10 @@ type FFprobeSource struct {
 	Target string `json:"target"`
 	// The source type.
 	Type FFprobeSourceType `json:"type"`
+	// The SHA256 checksum of remote file, in hex.
+	Checksum string `json:"checksum,omitempty"`
+	// The ONVIF device and profile of camera stream.
+	Onvif *OnvifSource `json:"onvif,omitempty"`
 	// The file format by ffprobe.
 	Format *FFprobeFormat `json:"format"`
 	// The video information by ffprobe.
@@ -1685,6 +1800,8 @@ type FFmpegHeartbeat struct {
 	MaxStreamDuration time.Duration
 	// The abnormal slow speed, such as 0.5x.
 	AbnormalFastSpeed float64
+	// Notify each filtered log of FFmpeg, for example, to detect the failure of tee slave.
+	OnLog func(line string)
 }
 
 // NewFFmpegHeartbeat create a new FFmpeg heartbeat manager, with cancelFFmpeg to cancel the FFmpeg
@@ -1778,6 +1895,10 @@ func (v *FFmpegHeartbeat) Polling(ctx context.Context, stderr io.Reader) {
 			v.exitingNormally = true
 		}
 
+		if v.OnLog != nil {
+			v.OnLog(line)
+		}
+
 		// Handle the extra logs.
 		if !strings.Contains(line, "size=") && !strings.Contains(line, "time=") {
 			v.extraLogs = append(v.extraLogs, line)
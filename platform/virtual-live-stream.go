@@ -51,6 +51,14 @@ func (v *VLiveWorker) GetTask(platform string) *VLiveTask {
 	return nil
 }
 
+// OnSchedule update the schedules of all tasks, called by crontab.
+func (v *VLiveWorker) OnSchedule(ctx context.Context, now time.Time) {
+	v.tasks.Range(func(key, value interface{}) bool {
+		value.(*VLiveTask).onSchedule(ctx, now)
+		return true
+	})
+}
+
 func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/ffmpeg/vlive/secret"
 	logger.Tf(ctx, "Handle %v", ep)
@@ -112,6 +120,10 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 					}
 					if err = targetConf.Update(&userConf); err != nil {
 						return errors.Wrapf(err, "update %v with %v", targetConf.String(), userConf.String())
+					} else if err = validateEgressOutput(targetConf.Server, targetConf.Output); err != nil {
+						return errors.Wrapf(err, "validate output %v", targetConf.String())
+					} else if err = validateSchedules(targetConf.Schedules); err != nil {
+						return errors.Wrapf(err, "validate schedules %v", targetConf.String())
 					} else if newB, err := json.Marshal(&targetConf); err != nil {
 						return errors.Wrapf(err, "marshal %v", targetConf.String())
 					} else if err = rdb.HSet(ctx, SRS_VLIVE_CONFIG, userConf.Platform, string(newB)).Err(); err != nil && err != redis.Nil {
@@ -194,6 +206,22 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 						"files":    config.Files,
 					}
 
+					if schedule := querySchedule(config.Schedules); schedule != nil {
+						elem["schedule"] = schedule
+					}
+
+					if task := vLiveWorker.GetTask(config.Platform); task != nil {
+						if playout := task.queryPlayout(); playout != nil {
+							elem["playlist"] = playout
+						}
+						if grid := task.queryGrid(); grid != nil {
+							elem["grid"] = grid
+						}
+						if position := task.queryPosition(); position != nil {
+							elem["position"] = position
+						}
+					}
+
 					if pid > 0 {
 						elem["source"] = inputUUID
 						elem["start"] = starttime
@@ -616,6 +644,8 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 				Target string `json:"target"`
 				// The source type.
 				Type FFprobeSourceType `json:"type"`
+				// The SHA256 checksum of remote file.
+				Checksum string `json:"checksum"`
 			}
 
 			var token, platform string
@@ -642,7 +672,7 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			// Always cleanup the files in upload.
 			var tempFiles []string
 			for _, f := range files {
-				if f.Type != FFprobeSourceTypeStream {
+				if f.Type != FFprobeSourceTypeStream && f.Type != FFprobeSourceTypeRemote {
 					tempFiles = append(tempFiles, f.Target)
 				}
 			}
@@ -660,7 +690,14 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 				if f.Target == "" {
 					return errors.New("no target")
 				}
-				if f.Type != FFprobeSourceTypeStream {
+				if f.Type == FFprobeSourceTypeRemote {
+					if !isMediaRemoteURL(f.Target) {
+						return errors.Errorf("invalid remote url %v", f.Target)
+					}
+					if _, err := parseMediaChecksum(f.Checksum); err != nil {
+						return errors.Wrapf(err, "checksum of %v", f.Target)
+					}
+				} else if f.Type != FFprobeSourceTypeStream {
 					if _, err := os.Stat(f.Target); err != nil {
 						return errors.Wrapf(err, "no file %v", f.Target)
 					}
@@ -690,6 +727,16 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			// Parse file information and move file from dirUploadPath to dirVLivePath.
 			for _, file := range files {
 				// Probe file information.
+				// Probe the remote file from the local copy in cache, which is fetched if not cached.
+				probeTarget := file.Target
+				if file.Type == FFprobeSourceTypeRemote {
+					entry, err := mediaCache.Fetch(ctx, file.Target, file.Checksum)
+					if err != nil {
+						return errors.Wrapf(err, "fetch %v", file.Target)
+					}
+					probeTarget, file.Size, file.Checksum = entry.Target, entry.Size, entry.Checksum
+				}
+
 				toCtx, toCancelFunc := context.WithTimeout(ctx, 15*time.Second)
 				defer toCancelFunc()
 
@@ -698,18 +745,18 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 					"-show_format", "-show_streams",
 				}
 				// For RTSP stream source, always use TCP transport.
-				if strings.HasPrefix(file.Target, "rtsp://") {
+				if strings.HasPrefix(probeTarget, "rtsp://") {
 					args = append(args, "-rtsp_transport", "tcp")
 				}
 				// Rebuild the stream url, because it may contain special characters.
-				if strings.Contains(file.Target, "://") {
-					if u, err := RebuildStreamURL(file.Target); err != nil {
-						return errors.Wrapf(err, "rebuild %v", file.Target)
+				if strings.Contains(probeTarget, "://") {
+					if u, err := RebuildStreamURL(probeTarget); err != nil {
+						return errors.Wrapf(err, "rebuild %v", probeTarget)
 					} else {
 						args = append(args, "-i", u.String())
 					}
 				} else {
-					args = append(args, "-i", file.Target)
+					args = append(args, "-i", probeTarget)
 				}
 
 				// TODO: FIXME: Use FFprobeFileFormat.
@@ -785,11 +832,12 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 				parsedFile := &FFprobeSource{
 					Name: file.Name, Path: file.Path, Size: uint64(file.Size), UUID: file.UUID,
-					Target: file.Target,
+					Target: file.Target, Checksum: file.Checksum,
 					Type:   file.Type,
 					Format: &format.Format, Video: matchVideo, Audio: matchAudio,
 				}
-				if file.Type != FFprobeSourceTypeStream {
+				// The remote file is kept in cache, which is evicted by LRU.
+				if file.Type != FFprobeSourceTypeStream && file.Type != FFprobeSourceTypeRemote {
 					parsedFile.Target = path.Join(dirVLivePath, fmt.Sprintf("%v%v", file.UUID, path.Ext(file.Target)))
 					if err = os.Rename(file.Target, parsedFile.Target); err != nil {
 						return errors.Wrapf(err, "rename %v to %v", file.Target, parsedFile.Target)
@@ -814,7 +862,7 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 
 				// Remove old files.
 				for _, f := range confObj.Files {
-					if f.Type != FFprobeSourceTypeStream {
+					if f.Type != FFprobeSourceTypeStream && f.Type != FFprobeSourceTypeRemote {
 						if _, err := os.Stat(f.Target); err == nil {
 							os.Remove(f.Target)
 						}
@@ -849,6 +897,11 @@ func (v *VLiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 		}
 	})
 
+	v.handlePlaylist(ctx, handler)
+	v.handleGrid(ctx, handler)
+	v.handleProfile(ctx, handler)
+	v.handlePosition(ctx, handler)
+	v.handleRemote(ctx, handler)
 	return nil
 }
 
@@ -881,7 +934,7 @@ func (v *VLiveWorker) Start(ctx context.Context) error {
 				return errors.Wrapf(err, "unmarshal %v %v", uuid, obj)
 			}
 
-			if task.PID > 0 {
+			if task.PID > 0 || task.ItemPID > 0 {
 				task.cleanup(ctx)
 			}
 		}
@@ -941,6 +994,10 @@ func (v *VLiveWorker) Start(ctx context.Context) error {
 		return nil
 	}
 
+	// Normalize the files to the profile of channel in background.
+	v.startNormalize(ctx)
+	v.startPrefetch(ctx)
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -983,14 +1040,26 @@ type VLiveConfigure struct {
 	Customed bool `json:"custom"`
 	// The label for this configure.
 	Label string `json:"label"`
+	// The protocol options of output, for example, the passphrase of SRT.
+	Output *EgressOutput `json:"output,omitempty"`
+	// The windows in which the task runs, always run if empty.
+	Schedules []*TaskSchedule `json:"schedules,omitempty"`
+	// The playlist to play the files one by one, play the first file in loop if empty.
+	Playlist *VLivePlaylist `json:"playlist,omitempty"`
+	// The wall-clock program grid, which overrides the playlist if set.
+	Grid *VLiveGrid `json:"grid,omitempty"`
+	// The profile to normalize the sources, copy the sources if empty.
+	Profile *VLiveProfile `json:"profile,omitempty"`
+	// The logo, text and ticker overlays.
+	Overlay *TaskOverlay `json:"overlay,omitempty"`
 
 	// The input files for vLive.
 	Files []*FFprobeSource `json:"files"`
 }
 
 func (v VLiveConfigure) String() string {
-	return fmt.Sprintf("platform=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v, files=%v",
-		v.Platform, v.Server, v.Secret, v.Enabled, v.Customed, v.Label, v.Files,
+	return fmt.Sprintf("platform=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v, output=%v, files=%v",
+		v.Platform, v.Server, v.Secret, v.Enabled, v.Customed, v.Label, v.Output.String(), v.Files,
 	)
 }
 
@@ -1001,6 +1070,24 @@ func (v *VLiveConfigure) Update(u *VLiveConfigure) error {
 	v.Label = u.Label
 	v.Enabled = u.Enabled
 	v.Customed = u.Customed
+	if u.Output != nil {
+		v.Output = u.Output
+	}
+	if u.Schedules != nil {
+		v.Schedules = u.Schedules
+	}
+	if u.Playlist != nil {
+		v.Playlist = u.Playlist
+	}
+	if u.Grid != nil {
+		v.Grid = u.Grid
+	}
+	if u.Profile != nil {
+		v.Profile = u.Profile
+	}
+	if u.Overlay != nil {
+		v.Overlay = u.Overlay
+	}
 	v.Files = append([]*FFprobeSource{}, u.Files...)
 	return nil
 }
@@ -1021,6 +1108,18 @@ type VLiveTask struct {
 
 	// FFmpeg pid.
 	PID int32 `json:"pid"`
+	// The pid of FFmpeg process for current item of playlist.
+	ItemPID int32 `json:"itemPid,omitempty"`
+	// The playout of playlist.
+	playout *VLivePlayout
+	// The playout of program grid.
+	grid *VLiveGridPlayout
+	// The playback position, to resume after restart.
+	position *VLivePosition
+	// The position of source when the time of FFmpeg is 0, and the duration to wrap the position.
+	positionBase, positionDuration float64
+	// The last time to save the position.
+	positionSaved time.Time
 	// FFmpeg last frame.
 	frame string
 	// The last update time.
@@ -1029,6 +1128,8 @@ type VLiveTask struct {
 	starttime *time.Time
 	// The first ready time.
 	firstReadyTime *time.Time
+	// The state of schedules, updated by crontab.
+	schedule *ScheduleState
 
 	// The context for current task.
 	cancel context.CancelFunc
@@ -1065,6 +1166,12 @@ func (v *VLiveTask) cleanup(ctx context.Context) error {
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
+	if v.ItemPID > 0 {
+		logger.Wf(ctx, "kill item pid=%v", v.ItemPID)
+		syscall.Kill(int(v.ItemPID), syscall.SIGKILL)
+		v.ItemPID = 0
+	}
+
 	if v.PID <= 0 {
 		return nil
 	}
@@ -1086,6 +1193,9 @@ func (v *VLiveTask) Restart(ctx context.Context) error {
 		v.cancel()
 	}
 
+	// Play the playlist from the start, and select the program of grid again.
+	v.playout, v.grid = nil, nil
+
 	// Reload config from redis.
 	if b, err := rdb.HGet(ctx, SRS_VLIVE_CONFIG, v.Platform).Result(); err != nil {
 		return errors.Wrapf(err, "hget %v %v", SRS_VLIVE_CONFIG, v.Platform)
@@ -1127,8 +1237,30 @@ func (v *VLiveTask) queryFrame() (int32, string, string, string, string, string)
 	return v.PID, v.inputUUID, v.frame, update, starttime, ready
 }
 
+// onSchedule update the state of schedules, and stop FFmpeg when the window is over.
+func (v *VLiveTask) onSchedule(ctx context.Context, now time.Time) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.schedule == nil {
+		return
+	}
+
+	from, to := v.schedule.Update(v.config.Schedules, now)
+	if from == to {
+		return
+	}
+
+	logger.Tf(ctx, "vLive: Schedule changed, platform=%v, active=%v, state is %v", v.Platform, to, v.schedule.Query().String())
+	if !to && v.cancel != nil {
+		v.cancel()
+	}
+}
+
 func (v *VLiveTask) Initialize(ctx context.Context, w *VLiveWorker) error {
 	v.vLiveWorker = w
+	v.schedule = NewScheduleState()
+	v.schedule.Update(v.config.Schedules, time.Now())
 	logger.Tf(ctx, "vLive: Initialize uuid=%v, platform=%v", v.UUID, v.Platform)
 
 	if err := v.saveTask(ctx); err != nil {
@@ -1155,9 +1287,65 @@ func (v *VLiveTask) Run(ctx context.Context) error {
 		return file
 	}
 
+	selectPlayout := func() *VLivePlayout {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+
+		if v.config.Playlist == nil || len(v.config.Playlist.Items) == 0 {
+			return nil
+		}
+
+		if v.playout == nil {
+			v.playout = NewVLivePlayout(v.config.Playlist)
+			logger.Tf(ctx, "vLive: Use playlist %v for platform=%v", v.config.Playlist.String(), v.Platform)
+
+			// Resume the item of playlist, for example, after restart or crash.
+			if pos, err := loadVLivePosition(ctx, v.Platform); err != nil {
+				logger.Wf(ctx, "vLive: Ignore load position err %+v", err)
+			} else if pos != nil && pos.Item != "" && v.playout.Resume(pos.Item, pos.Position) {
+				logger.Tf(ctx, "vLive: Resume playlist %v for platform=%v", pos.String(), v.Platform)
+			}
+		}
+		return v.playout
+	}
+
+	selectGrid := func() *VLiveGridPlayout {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+
+		if v.config.Grid == nil || len(v.config.Grid.Programs) == 0 {
+			return nil
+		}
+
+		if v.grid == nil {
+			v.grid = NewVLiveGridPlayout(v.config.Grid, v.config.Files)
+			logger.Tf(ctx, "vLive: Use grid %v for platform=%v", v.config.Grid.String(), v.Platform)
+		}
+		return v.grid
+	}
+
 	pfn := func(ctx context.Context) error {
-		// Ignore when not enabled.
-		if !v.config.Enabled {
+		// Ignore when not enabled, or not in the scheduled window.
+		if !v.config.Enabled || !v.schedule.IsActive() {
+			return nil
+		}
+
+		// Play the program grid if exists, which selects the item by wall-clock.
+		if grid := selectGrid(); grid != nil {
+			if err := v.doPlaylist(ctx, grid); err != nil {
+				return errors.Wrapf(err, "do grid")
+			}
+			return nil
+		}
+
+		// Play the playlist if exists, and keep the playout to continue after failure.
+		if playout := selectPlayout(); playout != nil {
+			if playout.Ended() {
+				return nil
+			}
+			if err := v.doPlaylist(ctx, playout); err != nil {
+				return errors.Wrapf(err, "do playlist")
+			}
 			return nil
 		}
 
@@ -1205,11 +1393,9 @@ func (v *VLiveTask) doVirtualLiveStream(ctx context.Context, input *FFprobeSourc
 	host := "localhost"
 
 	// Build output URL.
-	outputServer := strings.ReplaceAll(v.config.Server, "localhost", host)
-	if !strings.HasSuffix(outputServer, "/") && !strings.HasPrefix(v.config.Secret, "/") && v.config.Secret != "" {
-		outputServer += "/"
-	}
-	outputURL := fmt.Sprintf("%v%v", outputServer, v.config.Secret)
+	outputURL, outputArgs := buildEgressOutput(
+		strings.ReplaceAll(v.config.Server, "localhost", host), v.config.Secret, v.config.Output,
+	)
 
 	// Create a heartbeat to poll and manage the status of FFmpeg process.
 	heartbeat := NewFFmpegHeartbeat(cancel)
@@ -1218,35 +1404,62 @@ func (v *VLiveTask) doVirtualLiveStream(ctx context.Context, input *FFprobeSourc
 		v.starttime = nil
 	}()
 
+	// Play the remote file from the local copy in cache, which is never evicted during playing.
+	input, release, err := acquireVLiveSource(ctx, input)
+	if err != nil {
+		return errors.Wrapf(err, "acquire source")
+	}
+	defer release()
+
+	// Copy the source, or use the normalized file, or transcode on air, by the profile of channel.
+	target, stream := buildVLiveSourceArgs(ctx, input, v.config.Profile)
+	if err := applyOverlay(stream, v.config.Overlay, fmt.Sprintf("%v-%v", overlayKindVLive, v.Platform), v.title()); err != nil {
+		return errors.Wrapf(err, "overlay")
+	}
+
+	// Resume from the last position of file, for example, after restart or crash.
+	pos, err := loadVLivePosition(ctx, v.Platform)
+	if err != nil {
+		return errors.Wrapf(err, "load position")
+	}
+	base := resumePosition(pos, input)
+	v.startPosition(input, base)
+
 	// Start FFmpeg process.
 	args := []string{}
-	if input.Type == FFprobeSourceTypeFile || input.Type == FFprobeSourceTypeUpload || input.Type == FFprobeSourceTypeYTDL {
+	if input.Type == FFprobeSourceTypeFile || input.Type == FFprobeSourceTypeUpload || input.Type == FFprobeSourceTypeYTDL ||
+		input.Type == FFprobeSourceTypeRemote {
 		args = append(args, "-stream_loop", "-1")
 		args = append(args, "-re")
+		if base > 0 {
+			args = append(args, "-ss", fmt.Sprintf("%.3f", base))
+		}
 	}
 	// For RTSP stream source, always use TCP transport.
-	if strings.HasPrefix(input.Target, "rtsp://") {
+	if strings.HasPrefix(target, "rtsp://") {
 		args = append(args, "-rtsp_transport", "tcp")
 	}
 	// Rebuild the stream url, because it may contain special characters.
-	if strings.Contains(input.Target, "://") {
-		if u, err := RebuildStreamURL(input.Target); err != nil {
-			return errors.Wrapf(err, "rebuild %v", input.Target)
+	if strings.Contains(target, "://") {
+		if u, err := RebuildStreamURL(target); err != nil {
+			return errors.Wrapf(err, "rebuild %v", target)
 		} else {
 			args = append(args, "-i", u.String())
 			heartbeat.Parse(u)
 		}
 	} else {
-		args = append(args, "-i", input.Target)
+		args = append(args, "-i", target)
 	}
-	args = append(args, "-c", "copy")
-	// If RTMP use flv, if SRT use mpegts, otherwise do not set.
-	if strings.HasPrefix(outputURL, "rtmp://") || strings.HasPrefix(outputURL, "rtmps://") {
-		args = append(args, "-f", "flv")
-	} else if strings.HasPrefix(outputURL, "srt://") {
-		args = append(args, "-pes_payload_size", "0", "-f", "mpegts")
+	args = append(args, stream.inputs...)
+	args = append(args, stream.outputs()...)
+	// The format and protocol options of output, for example, flv for RTMP, mpegts for SRT.
+	args = append(args, outputArgs...)
+	// Sign the output by publish token, because the legacy secret may be disabled.
+	publishURL, err := platformPublishURL(ctx, outputURL)
+	if err != nil {
+		return errors.Wrapf(err, "sign %v", outputURL)
 	}
-	args = append(args, outputURL)
+	args = append(args, publishURL)
 	// Create the command object.
 	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 
@@ -1296,6 +1509,7 @@ func (v *VLiveTask) doVirtualLiveStream(ctx context.Context, input *FFprobeSourc
 				return
 			case frame := <-heartbeat.FrameLogs:
 				v.updateFrame(frame)
+				v.updatePosition(ctx, frame)
 			}
 		}
 	}()
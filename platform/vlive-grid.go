@@ -0,0 +1,698 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The type of entry in the program grid.
+const (
+	vLiveGridProgram = "program"
+	vLiveGridFiller  = "filler"
+	vLiveGridSlate   = "slate"
+)
+
+// The max days of EPG.
+const vLiveEPGMaxDays = 14
+
+// The program which remains less than this duration is treated as ended, to avoid playing a tiny piece.
+const vLiveGridMinRemain = time.Second
+
+// The max duration of a gap item, when no more program, to check the grid again.
+const vLiveGridMaxGap = time.Hour
+
+// VLiveGrid is the wall-clock schedule of vLive channel, the programs start at the time of day, and the
+// gaps between programs are filled by the fillers, or a black slate if no filler.
+type VLiveGrid struct {
+	// The timezone of the start time of programs, for example, Asia/Shanghai. Use UTC if empty.
+	Timezone string `json:"timezone,omitempty"`
+	// The programs of channel.
+	Programs []*VLiveProgram `json:"programs"`
+	// The UUIDs of source files to fill the gap between programs, play one by one.
+	Fillers []string `json:"fillers,omitempty"`
+	// Whether the EPG is public, so the set-top player can query it without token.
+	Public bool `json:"public,omitempty"`
+}
+
+func (v *VLiveGrid) String() string {
+	return fmt.Sprintf("timezone=%v, programs=%v, fillers=%v, public=%v",
+		v.Timezone, len(v.Programs), len(v.Fillers), v.Public,
+	)
+}
+
+// VLiveProgram is a program in the grid, which starts at the time of day.
+type VLiveProgram struct {
+	// The id of program, generated if empty.
+	ID string `json:"id"`
+	// The start time of day, in the format of HH:MM or HH:MM:SS, for example, 09:30
+	Start string `json:"start"`
+	// The days of week to play, 0 is Sunday. Play every day if empty.
+	Days []int `json:"days,omitempty"`
+	// The UUID of source file, which should be one of the files of vLive.
+	Source string `json:"source"`
+	// The duration in seconds, use the duration of file if 0.
+	Duration float64 `json:"duration,omitempty"`
+	// The title of program, for EPG.
+	Title string `json:"title,omitempty"`
+	// The description of program, for EPG.
+	Description string `json:"desc,omitempty"`
+}
+
+func (v *VLiveProgram) String() string {
+	return fmt.Sprintf("id=%v, start=%v, days=%v, source=%v, duration=%v, title=%v",
+		v.ID, v.Start, v.Days, v.Source, v.Duration, v.Title,
+	)
+}
+
+// offset get the offset of start time in the day.
+func (v *VLiveProgram) offset() (time.Duration, error) {
+	parts := strings.Split(v.Start, ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return 0, errors.Errorf("invalid start %v", v.Start)
+	}
+
+	var values []int
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 || (i == 0 && value > 23) || (i > 0 && value > 59) {
+			return 0, errors.Errorf("invalid start %v", v.Start)
+		}
+		values = append(values, value)
+	}
+	if len(values) == 2 {
+		values = append(values, 0)
+	}
+
+	return time.Duration(values[0])*time.Hour + time.Duration(values[1])*time.Minute +
+		time.Duration(values[2])*time.Second, nil
+}
+
+// VLiveGridEntry is an entry of grid, which is a program, filler or slate in a time range.
+type VLiveGridEntry struct {
+	// The type of entry, program, filler or slate.
+	Type string `json:"type"`
+	// The start time in RFC3339.
+	Start string `json:"start"`
+	// The stop time in RFC3339.
+	Stop string `json:"stop"`
+	// The program, nil for filler or slate.
+	Program *VLiveProgram `json:"program,omitempty"`
+
+	start, stop time.Time
+}
+
+// vLiveFileDuration get the duration of source file, 0 if unknown.
+func vLiveFileDuration(files []*FFprobeSource, source string) float64 {
+	for _, f := range files {
+		if f.UUID == source && f.Format != nil {
+			if duration, err := strconv.ParseFloat(f.Format.Duration, 64); err == nil {
+				return duration
+			}
+		}
+	}
+	return 0
+}
+
+// Initialize generate the id for new programs.
+func (v *VLiveGrid) Initialize() {
+	for _, program := range v.Programs {
+		if program != nil && program.ID == "" {
+			program.ID = uuid.NewString()
+		}
+	}
+}
+
+// Validate the grid, the source of program and filler should be one of the files, and the programs
+// should not overlap.
+func (v *VLiveGrid) Validate(files []*FFprobeSource) error {
+	if _, err := time.LoadLocation(v.Timezone); err != nil {
+		return errors.Wrapf(err, "load timezone %v", v.Timezone)
+	}
+
+	hasSource := func(source string) bool {
+		for _, f := range files {
+			if f.UUID == source {
+				return true
+			}
+		}
+		return false
+	}
+
+	ids := make(map[string]bool)
+	for i, program := range v.Programs {
+		if program == nil {
+			return errors.Errorf("program #%v is nil", i)
+		}
+		if ids[program.ID] {
+			return errors.Errorf("duplicated program %v", program.ID)
+		}
+		ids[program.ID] = true
+
+		if _, err := program.offset(); err != nil {
+			return errors.Wrapf(err, "program #%v", i)
+		}
+		for _, day := range program.Days {
+			if day < 0 || day > 6 {
+				return errors.Errorf("invalid day %v of program #%v", day, i)
+			}
+		}
+		if !hasSource(program.Source) {
+			return errors.Errorf("no source %v of program #%v", program.Source, i)
+		}
+		if program.Duration < 0 || (program.Duration == 0 && vLiveFileDuration(files, program.Source) <= 0) {
+			return errors.Errorf("no duration of program #%v", i)
+		}
+	}
+
+	for _, filler := range v.Fillers {
+		if !hasSource(filler) {
+			return errors.Errorf("no source %v of filler", filler)
+		}
+	}
+
+	// Check the overlap for a week, from Monday, including the programs of previous day.
+	location, _ := time.LoadLocation(v.Timezone)
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, location)
+	entries := v.programs(files, from, from.AddDate(0, 0, 7))
+	for i := 1; i < len(entries); i++ {
+		if entries[i].start.Before(entries[i-1].stop) {
+			return errors.Errorf("program %v overlaps with %v", entries[i].Program.String(), entries[i-1].Program.String())
+		}
+	}
+	return nil
+}
+
+// programs get the programs in the range [from, to), including the program which starts before from and
+// is still playing, sorted by start time.
+func (v *VLiveGrid) programs(files []*FFprobeSource, from, to time.Time) []*VLiveGridEntry {
+	location, err := time.LoadLocation(v.Timezone)
+	if err != nil {
+		return nil
+	}
+	from, to = from.In(location), to.In(location)
+
+	var entries []*VLiveGridEntry
+	for day := time.Date(from.Year(), from.Month(), from.Day()-1, 0, 0, 0, 0, location); day.Before(to); day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, location) {
+		for _, program := range v.Programs {
+			if len(program.Days) > 0 && !intsContains(program.Days, int(day.Weekday())) {
+				continue
+			}
+
+			offset, err := program.offset()
+			if err != nil {
+				continue
+			}
+
+			duration := program.Duration
+			if duration <= 0 {
+				duration = vLiveFileDuration(files, program.Source)
+			}
+			if duration <= 0 {
+				continue
+			}
+
+			// Use the wall-clock time of day, which is correct when DST changes.
+			start := time.Date(day.Year(), day.Month(), day.Day(), int(offset.Hours()), int(offset.Minutes())%60, int(offset.Seconds())%60, 0, location)
+			stop := start.Add(time.Duration(duration * float64(time.Second)))
+			if !stop.After(from) || !start.Before(to) {
+				continue
+			}
+
+			entries = append(entries, &VLiveGridEntry{
+				Type: vLiveGridProgram, Program: program, start: start, stop: stop,
+				Start: start.Format(time.RFC3339), Stop: stop.Format(time.RFC3339),
+			})
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].start.Before(entries[j].start)
+	})
+	return entries
+}
+
+// Daily get the grid of the range [from, to), the gaps are filled by filler or slate.
+func (v *VLiveGrid) Daily(files []*FFprobeSource, from, to time.Time) []*VLiveGridEntry {
+	gap := vLiveGridSlate
+	if len(v.Fillers) > 0 {
+		gap = vLiveGridFiller
+	}
+
+	var entries []*VLiveGridEntry
+	addGap := func(start, stop time.Time) {
+		if stop.After(start) {
+			entries = append(entries, &VLiveGridEntry{
+				Type: gap, start: start, stop: stop,
+				Start: start.Format(time.RFC3339), Stop: stop.Format(time.RFC3339),
+			})
+		}
+	}
+
+	cursor := from
+	for _, entry := range v.programs(files, from, to) {
+		addGap(cursor, entry.start)
+		entries = append(entries, entry)
+		if entry.stop.After(cursor) {
+			cursor = entry.stop
+		}
+	}
+	addGap(cursor, to)
+	return entries
+}
+
+func intsContains(elems []int, v int) bool {
+	for _, s := range elems {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// VLiveGridPlayout is the playout of program grid, which selects the item by wall-clock.
+type VLiveGridPlayout struct {
+	// The grid.
+	grid *VLiveGrid
+	// The files of vLive.
+	files []*FFprobeSource
+	// The index of next filler.
+	filler int
+	// The current entry and item.
+	entry *VLiveGridEntry
+	item  *VLivePlaylistItem
+	// The start time of current item.
+	itemStart time.Time
+	// To get the current time, for test.
+	now func() time.Time
+
+	// To protect the fields.
+	lock sync.Mutex
+}
+
+func NewVLiveGridPlayout(grid *VLiveGrid, files []*FFprobeSource) *VLiveGridPlayout {
+	return &VLiveGridPlayout{grid: grid, files: files, now: time.Now}
+}
+
+// Current always returns nil, because the item is selected by wall-clock.
+func (v *VLiveGridPlayout) Current() *VLivePlaylistItem {
+	return nil
+}
+
+// Next select the item by wall-clock. If a program is playing, join it at the position of now. If in a
+// gap, play the filler or slate until the next program.
+func (v *VLiveGridPlayout) Next() *VLivePlaylistItem {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	now := v.now()
+	entries := v.grid.programs(v.files, now, now.Add(48*time.Hour))
+
+	var next *VLiveGridEntry
+	for _, entry := range entries {
+		if !entry.start.After(now) && entry.stop.Sub(now) >= vLiveGridMinRemain {
+			position := now.Sub(entry.start).Seconds()
+			if position < vLiveGridMinRemain.Seconds() {
+				position = 0
+			}
+			v.entry, v.itemStart = entry, now
+			v.item = &VLivePlaylistItem{
+				ID: entry.Program.ID, Source: entry.Program.Source, In: position,
+				Out: entry.stop.Sub(entry.start).Seconds(),
+			}
+			return v.item
+		}
+		if entry.start.After(now) {
+			next = entry
+			break
+		}
+	}
+
+	// Fill the gap until next program.
+	stop := now.Add(vLiveGridMaxGap)
+	if next != nil {
+		stop = next.start
+	}
+	gap := stop.Sub(now).Seconds()
+
+	entry := &VLiveGridEntry{Type: vLiveGridSlate, start: now, stop: stop}
+	item := &VLivePlaylistItem{ID: vLiveGridSlate, Out: gap}
+	if len(v.grid.Fillers) > 0 {
+		source := v.grid.Fillers[v.filler%len(v.grid.Fillers)]
+		v.filler++
+
+		entry.Type = vLiveGridFiller
+		item = &VLivePlaylistItem{ID: vLiveGridFiller, Source: source, Out: gap}
+		if duration := vLiveFileDuration(v.files, source); duration > 0 && duration < gap {
+			item.Out = duration
+		}
+	}
+	entry.Start, entry.Stop = entry.start.Format(time.RFC3339), entry.stop.Format(time.RFC3339)
+
+	v.entry, v.item, v.itemStart = entry, item, now
+	return item
+}
+
+func (v *VLiveGridPlayout) Done() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.entry, v.item = nil, nil
+}
+
+func (v *VLiveGridPlayout) Len() int {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return len(v.grid.Programs) + len(v.grid.Fillers) + 1
+}
+
+// Update the grid and files when it's on air, the current item is not interrupted.
+func (v *VLiveGridPlayout) Update(grid *VLiveGrid, files []*FFprobeSource) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.grid, v.files = grid, files
+}
+
+// Query the current entry of grid.
+func (v *VLiveGridPlayout) Query() *VLivePlayoutState {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	state := &VLivePlayoutState{Index: -1}
+	if v.item == nil {
+		return state
+	}
+
+	state.Item, state.Source = v.item.ID, v.item.Source
+	state.Position = v.item.In + v.now().Sub(v.itemStart).Seconds()
+	state.Duration = v.item.Out
+	if state.Position > v.item.Out {
+		state.Position = v.item.Out
+	}
+	for i, program := range v.grid.Programs {
+		if program.ID == v.item.ID {
+			state.Index = i
+		}
+	}
+	return state
+}
+
+// XMLTV is the EPG in XMLTV format, see http://wiki.xmltv.org/index.php/XMLTVFormat
+type XMLTV struct {
+	XMLName       xml.Name          `xml:"tv"`
+	GeneratorName string            `xml:"generator-info-name,attr"`
+	Channels      []XMLTVChannel    `xml:"channel"`
+	Programmes    []*XMLTVProgramme `xml:"programme"`
+}
+
+type XMLTVChannel struct {
+	ID          string `xml:"id,attr"`
+	DisplayName string `xml:"display-name"`
+}
+
+type XMLTVProgramme struct {
+	Start       string `xml:"start,attr"`
+	Stop        string `xml:"stop,attr"`
+	Channel     string `xml:"channel,attr"`
+	Title       string `xml:"title"`
+	Description string `xml:"desc,omitempty"`
+}
+
+// The time format of XMLTV, for example, 20240101090000 +0800
+const xmltvTimeFormat = "20060102150405 -0700"
+
+// buildXMLTV build the EPG of channel in XMLTV format.
+func buildXMLTV(channel, name string, entries []*VLiveGridEntry) ([]byte, error) {
+	tv := &XMLTV{GeneratorName: "oryx", Channels: []XMLTVChannel{{ID: channel, DisplayName: name}}}
+	for _, entry := range entries {
+		if entry.Program == nil {
+			continue
+		}
+
+		title := entry.Program.Title
+		if title == "" {
+			title = entry.Program.ID
+		}
+		tv.Programmes = append(tv.Programmes, &XMLTVProgramme{
+			Start: entry.start.Format(xmltvTimeFormat), Stop: entry.stop.Format(xmltvTimeFormat),
+			Channel: channel, Title: title, Description: entry.Program.Description,
+		})
+	}
+
+	b, err := xml.MarshalIndent(tv, "", "  ")
+	if err != nil {
+		return nil, errors.Wrapf(err, "marshal xmltv")
+	}
+	return append([]byte(xml.Header+"<!DOCTYPE tv SYSTEM \"xmltv.dtd\">\n"), b...), nil
+}
+
+// queryGrid get the state of grid playout, nil if not grid.
+func (v *VLiveTask) queryGrid() *VLivePlayoutState {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.grid == nil {
+		return nil
+	}
+	return v.grid.Query()
+}
+
+// updateGrid update the grid when it's on air, restart if not playing a grid.
+func (v *VLiveTask) updateGrid(ctx context.Context, grid *VLiveGrid) error {
+	v.lock.Lock()
+	playout := v.grid
+	if playout != nil {
+		v.config.Grid = grid
+	}
+	files := v.config.Files
+	v.lock.Unlock()
+
+	if playout == nil {
+		return v.Restart(ctx)
+	}
+
+	playout.Update(grid, files)
+	return nil
+}
+
+// loadVLiveConfigure load the configure of vLive by platform.
+func loadVLiveConfigure(ctx context.Context, platform string) (*VLiveConfigure, error) {
+	if platform == "" {
+		return nil, errors.New("no platform")
+	}
+
+	var conf VLiveConfigure
+	if b, err := rdb.HGet(ctx, SRS_VLIVE_CONFIG, platform).Result(); err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hget %v %v", SRS_VLIVE_CONFIG, platform)
+	} else if b == "" {
+		return nil, errors.Errorf("no vLive %v", platform)
+	} else if err = json.Unmarshal([]byte(b), &conf); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", b)
+	}
+	return &conf, nil
+}
+
+func (v *VLiveWorker) handleGrid(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/ffmpeg/vlive/grid/update"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, platform string
+			var grid VLiveGrid
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string    `json:"token"`
+				Platform *string    `json:"platform"`
+				Grid     *VLiveGrid `json:"grid"`
+			}{
+				Token: &token, Platform: &platform, Grid: &grid,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			conf, err := loadVLiveConfigure(ctx, platform)
+			if err != nil {
+				return errors.Wrapf(err, "load %v", platform)
+			}
+
+			grid.Initialize()
+			if err := grid.Validate(conf.Files); err != nil {
+				return errors.Wrapf(err, "validate grid")
+			}
+			conf.Grid = &grid
+
+			if b, err := json.Marshal(conf); err != nil {
+				return errors.Wrapf(err, "marshal %v", conf.String())
+			} else if err = rdb.HSet(ctx, SRS_VLIVE_CONFIG, platform, string(b)).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v %v %v", SRS_VLIVE_CONFIG, platform, string(b))
+			}
+
+			// Apply to the grid on air, without interrupting the current item.
+			if task := v.GetTask(platform); task != nil {
+				if err := task.updateGrid(ctx, &grid); err != nil {
+					return errors.Wrapf(err, "update task %v", platform)
+				}
+			}
+
+			ohttp.WriteData(ctx, w, r, &grid)
+			logger.Tf(ctx, "vLive: Update grid ok, platform=%v, grid=%v, token=%vB", platform, grid.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/vlive/grid/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, platform, date string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string `json:"token"`
+				Platform *string `json:"platform"`
+				Date     *string `json:"date"`
+			}{
+				Token: &token, Platform: &platform, Date: &date,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			conf, err := loadVLiveConfigure(ctx, platform)
+			if err != nil {
+				return errors.Wrapf(err, "load %v", platform)
+			}
+			if conf.Grid == nil {
+				return errors.Errorf("no grid of %v", platform)
+			}
+
+			// The day of grid, in the timezone of grid, default to today.
+			location, err := time.LoadLocation(conf.Grid.Timezone)
+			if err != nil {
+				return errors.Wrapf(err, "load timezone %v", conf.Grid.Timezone)
+			}
+			day := time.Now().In(location)
+			if date != "" {
+				if day, err = time.ParseInLocation("2006-01-02", date, location); err != nil {
+					return errors.Wrapf(err, "parse date %v", date)
+				}
+			}
+			from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, location)
+			to := from.AddDate(0, 0, 1)
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Platform string            `json:"platform"`
+				Date     string            `json:"date"`
+				Timezone string            `json:"timezone"`
+				Entries  []*VLiveGridEntry `json:"entries"`
+			}{
+				Platform: platform, Date: from.Format("2006-01-02"), Timezone: location.String(),
+				Entries: conf.Grid.Daily(conf.Files, from, to),
+			})
+			logger.Tf(ctx, "vLive: Query grid ok, platform=%v, date=%v, token=%vB", platform, date, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	// The EPG of channel, for example, /terraform/v1/ffmpeg/vlive/epg/wx.xml or wx.json, with optional
+	// query days, the number of days from today.
+	ep = "/terraform/v1/ffmpeg/vlive/epg/"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			filename := r.URL.Path[len("/terraform/v1/ffmpeg/vlive/epg/"):]
+			format := "json"
+			if strings.HasSuffix(filename, ".xml") {
+				format = "xml"
+			}
+			platform := strings.TrimSuffix(strings.TrimSuffix(filename, ".xml"), ".json")
+
+			conf, err := loadVLiveConfigure(ctx, platform)
+			if err != nil {
+				return errors.Wrapf(err, "load %v", platform)
+			}
+			if conf.Grid == nil {
+				return errors.Errorf("no grid of %v", platform)
+			}
+
+			// The EPG is public if enabled, for set-top player, or requires the token.
+			if !conf.Grid.Public {
+				apiSecret := envApiSecret()
+				if err := Authenticate(ctx, apiSecret, r.URL.Query().Get("token"), r.Header); err != nil {
+					return errors.Wrapf(err, "authenticate")
+				}
+			}
+
+			days := 2
+			if v := r.URL.Query().Get("days"); v != "" {
+				if days, err = strconv.Atoi(v); err != nil || days <= 0 || days > vLiveEPGMaxDays {
+					return errors.Errorf("invalid days %v, should be 1 to %v", v, vLiveEPGMaxDays)
+				}
+			}
+
+			location, err := time.LoadLocation(conf.Grid.Timezone)
+			if err != nil {
+				return errors.Wrapf(err, "load timezone %v", conf.Grid.Timezone)
+			}
+			now := time.Now().In(location)
+			from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+			entries := conf.Grid.programs(conf.Files, from, from.AddDate(0, 0, days))
+
+			name := conf.Label
+			if name == "" {
+				name = platform
+			}
+
+			if format == "xml" {
+				b, err := buildXMLTV(platform, name, entries)
+				if err != nil {
+					return errors.Wrapf(err, "build xmltv")
+				}
+
+				w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+				w.Write(b)
+			} else {
+				ohttp.WriteData(ctx, w, r, &struct {
+					Channel  string            `json:"channel"`
+					Name     string            `json:"name"`
+					Timezone string            `json:"timezone"`
+					Entries  []*VLiveGridEntry `json:"entries"`
+				}{
+					Channel: platform, Name: name, Timezone: location.String(), Entries: entries,
+				})
+			}
+
+			logger.Tf(ctx, "vLive: Query EPG ok, platform=%v, format=%v, days=%v, entries=%v", platform, format, days, len(entries))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
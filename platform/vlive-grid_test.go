@@ -0,0 +1,155 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVLiveGrid_Validate(t *testing.T) {
+	files := []*FFprobeSource{
+		{UUID: "news", Format: &FFprobeFormat{Duration: "1800"}},
+		{UUID: "show", Format: &FFprobeFormat{Duration: "3600"}},
+		{UUID: "stream"},
+	}
+
+	for _, e := range []struct {
+		grid *VLiveGrid
+		ok   bool
+	}{
+		{grid: &VLiveGrid{Programs: []*VLiveProgram{{ID: "1", Start: "09:00", Source: "news"}}}, ok: true},
+		{grid: &VLiveGrid{Programs: []*VLiveProgram{{ID: "1", Start: "9:30:10", Source: "news"}}}, ok: true},
+		{grid: &VLiveGrid{Timezone: "Asia/Shanghai", Programs: []*VLiveProgram{
+			{ID: "1", Start: "09:00", Source: "news"}, {ID: "2", Start: "09:30", Source: "show"},
+		}}, ok: true},
+		{grid: &VLiveGrid{Timezone: "Invalid/Zone"}, ok: false},
+		{grid: &VLiveGrid{Programs: []*VLiveProgram{{ID: "1", Start: "24:00", Source: "news"}}}, ok: false},
+		{grid: &VLiveGrid{Programs: []*VLiveProgram{{ID: "1", Start: "09", Source: "news"}}}, ok: false},
+		{grid: &VLiveGrid{Programs: []*VLiveProgram{{ID: "1", Start: "09:00", Source: "none"}}}, ok: false},
+		{grid: &VLiveGrid{Programs: []*VLiveProgram{{ID: "1", Start: "09:00", Days: []int{7}, Source: "news"}}}, ok: false},
+		// The stream has no duration.
+		{grid: &VLiveGrid{Programs: []*VLiveProgram{{ID: "1", Start: "09:00", Source: "stream"}}}, ok: false},
+		{grid: &VLiveGrid{Programs: []*VLiveProgram{{ID: "1", Start: "09:00", Source: "stream", Duration: 600}}}, ok: true},
+		// Overlap with the previous program.
+		{grid: &VLiveGrid{Programs: []*VLiveProgram{
+			{ID: "1", Start: "09:00", Source: "show"}, {ID: "2", Start: "09:30", Source: "news"},
+		}}, ok: false},
+		// Not overlap, because in different days.
+		{grid: &VLiveGrid{Programs: []*VLiveProgram{
+			{ID: "1", Start: "09:00", Days: []int{1}, Source: "show"}, {ID: "2", Start: "09:30", Days: []int{2}, Source: "news"},
+		}}, ok: true},
+		// Overlap with the program of previous day, which crosses midnight.
+		{grid: &VLiveGrid{Programs: []*VLiveProgram{
+			{ID: "1", Start: "23:30", Source: "show"}, {ID: "2", Start: "00:00", Source: "news"},
+		}}, ok: false},
+		{grid: &VLiveGrid{Programs: []*VLiveProgram{{ID: "1", Start: "09:00", Source: "news"}}, Fillers: []string{"none"}}, ok: false},
+		{grid: &VLiveGrid{Programs: []*VLiveProgram{{ID: "1", Start: "09:00", Source: "news"}, {ID: "1", Start: "10:00", Source: "news"}}}, ok: false},
+	} {
+		if err := e.grid.Validate(files); (err == nil) != e.ok {
+			t.Errorf("Fail for %v, expect ok=%v, err %v", e.grid.String(), e.ok, err)
+		}
+	}
+}
+
+func TestVLiveGrid_Daily(t *testing.T) {
+	files := []*FFprobeSource{
+		{UUID: "news", Format: &FFprobeFormat{Duration: "1800"}},
+		{UUID: "movie", Format: &FFprobeFormat{Duration: "7200"}},
+	}
+	grid := &VLiveGrid{Programs: []*VLiveProgram{
+		{ID: "news", Start: "09:00", Source: "news"},
+		{ID: "movie", Start: "23:00", Source: "movie"},
+	}}
+
+	from := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	entries := grid.Daily(files, from, from.AddDate(0, 0, 1))
+
+	var types, ranges []string
+	for _, e := range entries {
+		types = append(types, e.Type)
+		ranges = append(ranges, e.start.Format("15:04")+"-"+e.stop.Format("15:04"))
+	}
+	// The movie of previous day crosses midnight, so it's the first entry.
+	if v := strings.Join(types, ","); v != "program,slate,program,slate,program" {
+		t.Errorf("Fail for types %v", v)
+	}
+	if v := strings.Join(ranges, ","); v != "23:00-01:00,01:00-09:00,09:00-09:30,09:30-23:00,23:00-01:00" {
+		t.Errorf("Fail for ranges %v", v)
+	}
+
+	grid.Fillers = []string{"news"}
+	if entries := grid.Daily(files, from, from.AddDate(0, 0, 1)); entries[1].Type != vLiveGridFiller {
+		t.Errorf("Fail for type %v", entries[1].Type)
+	}
+}
+
+func TestVLiveGrid_Playout(t *testing.T) {
+	files := []*FFprobeSource{
+		{UUID: "news", Format: &FFprobeFormat{Duration: "1800"}},
+		{UUID: "ad", Format: &FFprobeFormat{Duration: "600"}},
+	}
+	grid := &VLiveGrid{Timezone: "Asia/Shanghai", Programs: []*VLiveProgram{
+		{ID: "news", Start: "09:00", Source: "news"},
+	}}
+	location, _ := time.LoadLocation("Asia/Shanghai")
+
+	now := time.Date(2024, 1, 2, 9, 10, 0, 0, location)
+	playout := NewVLiveGridPlayout(grid, files)
+	playout.now = func() time.Time { return now }
+
+	// Join the program at the position of now.
+	if item := playout.Next(); item.ID != "news" || item.In != 600 || item.Out != 1800 {
+		t.Errorf("Fail for item %v", item.String())
+	}
+	if state := playout.Query(); state.Index != 0 || state.Position != 600 {
+		t.Errorf("Fail for state %v", state)
+	}
+
+	// No filler, play slate until the next program.
+	now = time.Date(2024, 1, 2, 8, 50, 0, 0, location)
+	if item := playout.Next(); item.Source != "" || item.Out != 600 {
+		t.Errorf("Fail for item %v", item.String())
+	}
+
+	// The filler is cut by the next program.
+	grid.Fillers = []string{"ad"}
+	now = time.Date(2024, 1, 2, 8, 55, 0, 0, location)
+	if item := playout.Next(); item.Source != "ad" || item.Out != 300 {
+		t.Errorf("Fail for item %v", item.String())
+	}
+	now = time.Date(2024, 1, 2, 8, 0, 0, 0, location)
+	if item := playout.Next(); item.Source != "ad" || item.Out != 600 {
+		t.Errorf("Fail for item %v", item.String())
+	}
+
+	// The program almost ends, play filler.
+	now = time.Date(2024, 1, 2, 9, 29, 59, 500, location)
+	if item := playout.Next(); item.Source != "ad" {
+		t.Errorf("Fail for item %v", item.String())
+	}
+}
+
+func TestVLiveGrid_XMLTV(t *testing.T) {
+	location, _ := time.LoadLocation("Asia/Shanghai")
+	grid := &VLiveGrid{Timezone: "Asia/Shanghai", Programs: []*VLiveProgram{
+		{ID: "news", Start: "09:00", Source: "news", Duration: 1800, Title: "News & Weather", Description: "Daily news"},
+	}}
+
+	from := time.Date(2024, 1, 2, 0, 0, 0, 0, location)
+	b, err := buildXMLTV("wx", "My Channel", grid.programs(nil, from, from.AddDate(0, 0, 1)))
+	if err != nil {
+		t.Errorf("Fail for err %+v", err)
+		return
+	}
+
+	for _, expect := range []string{
+		`<tv generator-info-name="oryx">`,
+		`<channel id="wx">`, `<display-name>My Channel</display-name>`,
+		`<programme start="20240102090000 +0800" stop="20240102093000 +0800" channel="wx">`,
+		`<title>News &amp; Weather</title>`, `<desc>Daily news</desc>`,
+	} {
+		if !strings.Contains(string(b), expect) {
+			t.Errorf("Fail for %v, expect %v", string(b), expect)
+		}
+	}
+}
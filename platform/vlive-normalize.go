@@ -0,0 +1,577 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The status of normalized file.
+const (
+	vLiveNormalizePending = "pending"
+	vLiveNormalizeRunning = "running"
+	vLiveNormalizeDone    = "done"
+	vLiveNormalizeFailed  = "failed"
+)
+
+// The max time to transcode a file to the channel profile.
+const vLiveNormalizeTimeout = 6 * time.Hour
+
+// The interval to check the files to normalize.
+const vLiveNormalizeInterval = 10 * time.Second
+
+// VLiveProfile is the profile of vLive channel, all sources are normalized to this profile, so the
+// player never sees the change of codec, resolution or audio sample rate when switching files.
+type VLiveProfile struct {
+	// The video codec, only h264 for now.
+	VideoCodec string `json:"vcodec"`
+	// The video resolution, for example, 1280x720.
+	Width  int32 `json:"width"`
+	Height int32 `json:"height"`
+	// The frame rate, for example, 25 or 30.
+	Fps int `json:"fps"`
+	// The GOP in seconds.
+	Gop int `json:"gop"`
+	// The video bitrate in kbps.
+	VideoBitrate int `json:"vbitrate"`
+	// The audio codec, only aac for now.
+	AudioCodec string `json:"acodec"`
+	// The audio sample rate in Hz, for example, 44100 or 48000.
+	SampleRate int `json:"sampleRate"`
+	// The audio channels, 1 or 2.
+	Channels int32 `json:"channels"`
+	// The audio bitrate in kbps.
+	AudioBitrate int `json:"abitrate"`
+}
+
+func (v *VLiveProfile) String() string {
+	return fmt.Sprintf("vcodec=%v, size=%vx%v, fps=%v, gop=%v, vbitrate=%v, acodec=%v, rate=%v, channels=%v, abitrate=%v",
+		v.VideoCodec, v.Width, v.Height, v.Fps, v.Gop, v.VideoBitrate, v.AudioCodec, v.SampleRate, v.Channels, v.AudioBitrate,
+	)
+}
+
+// Initialize set the default values of profile.
+func (v *VLiveProfile) Initialize() {
+	if v.VideoCodec == "" {
+		v.VideoCodec = "h264"
+	}
+	if v.Width == 0 || v.Height == 0 {
+		v.Width, v.Height = 1280, 720
+	}
+	if v.Fps == 0 {
+		v.Fps = 25
+	}
+	if v.Gop == 0 {
+		v.Gop = 2
+	}
+	if v.VideoBitrate == 0 {
+		v.VideoBitrate = 1200
+	}
+	if v.AudioCodec == "" {
+		v.AudioCodec = "aac"
+	}
+	if v.SampleRate == 0 {
+		v.SampleRate = 44100
+	}
+	if v.Channels == 0 {
+		v.Channels = 2
+	}
+	if v.AudioBitrate == 0 {
+		v.AudioBitrate = 64
+	}
+}
+
+func (v *VLiveProfile) Validate() error {
+	if v.VideoCodec != "h264" {
+		return errors.Errorf("invalid vcodec %v, should be h264", v.VideoCodec)
+	}
+	if v.Width <= 0 || v.Height <= 0 || v.Width > 3840 || v.Height > 2160 || v.Width%2 != 0 || v.Height%2 != 0 {
+		return errors.Errorf("invalid size %vx%v", v.Width, v.Height)
+	}
+	if v.Fps <= 0 || v.Fps > 60 {
+		return errors.Errorf("invalid fps %v, should be 1 to 60", v.Fps)
+	}
+	if v.Gop <= 0 || v.Gop > 10 {
+		return errors.Errorf("invalid gop %v, should be 1 to 10 seconds", v.Gop)
+	}
+	if v.VideoBitrate <= 0 {
+		return errors.Errorf("invalid vbitrate %v", v.VideoBitrate)
+	}
+	if v.AudioCodec != "aac" {
+		return errors.Errorf("invalid acodec %v, should be aac", v.AudioCodec)
+	}
+	if v.SampleRate != 44100 && v.SampleRate != 48000 {
+		return errors.Errorf("invalid sample rate %v, should be 44100 or 48000", v.SampleRate)
+	}
+	if v.Channels != 1 && v.Channels != 2 {
+		return errors.Errorf("invalid channels %v, should be 1 or 2", v.Channels)
+	}
+	if v.AudioBitrate <= 0 {
+		return errors.Errorf("invalid abitrate %v", v.AudioBitrate)
+	}
+	return nil
+}
+
+// signature identify the profile, the normalized file should be transcoded again if changed.
+func (v *VLiveProfile) signature() string {
+	return fmt.Sprintf("%v-%vx%v-%v-%v-%v-%v-%v-%v-%v",
+		v.VideoCodec, v.Width, v.Height, v.Fps, v.Gop, v.VideoBitrate, v.AudioCodec, v.SampleRate, v.Channels, v.AudioBitrate,
+	)
+}
+
+// parseFrameRate parse the frame rate of ffprobe, for example, 30000/1001 is 29.97
+func parseFrameRate(rate string) float64 {
+	parts := strings.Split(rate, "/")
+	num, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0
+	}
+	if len(parts) == 1 {
+		return num
+	}
+
+	den, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || den == 0 {
+		return 0
+	}
+	return num / den
+}
+
+// vLiveNormalizeReasons get the reasons why the file should be normalized to the profile, empty if
+// the file can be copied. Note that the GOP is not probed, so it's ignored.
+func vLiveNormalizeReasons(file *FFprobeSource, profile *VLiveProfile) []string {
+	var reasons []string
+
+	if video := file.Video; video == nil {
+		reasons = append(reasons, "no video")
+	} else {
+		if video.CodecName != profile.VideoCodec {
+			reasons = append(reasons, fmt.Sprintf("vcodec %v", video.CodecName))
+		}
+		if video.Width != profile.Width || video.Height != profile.Height {
+			reasons = append(reasons, fmt.Sprintf("size %vx%v", video.Width, video.Height))
+		}
+		// The frame rate is unknown for files probed by old version, ignore it.
+		if fps := parseFrameRate(video.FrameRate); fps > 0 && (fps < float64(profile.Fps)-0.1 || fps > float64(profile.Fps)+0.1) {
+			reasons = append(reasons, fmt.Sprintf("fps %v", video.FrameRate))
+		}
+	}
+
+	if audio := file.Audio; audio == nil {
+		reasons = append(reasons, "no audio")
+	} else {
+		if audio.CodecName != profile.AudioCodec {
+			reasons = append(reasons, fmt.Sprintf("acodec %v", audio.CodecName))
+		}
+		if audio.SampleRate != fmt.Sprintf("%v", profile.SampleRate) {
+			reasons = append(reasons, fmt.Sprintf("rate %v", audio.SampleRate))
+		}
+		if audio.Channels != profile.Channels {
+			reasons = append(reasons, fmt.Sprintf("channels %v", audio.Channels))
+		}
+	}
+
+	return reasons
+}
+
+// vLiveNormalizeArgs build the transcode arguments of FFmpeg, the input is the file or stream, and the
+// silent audio is generated if no audio.
+func vLiveNormalizeArgs(file *FFprobeSource, profile *VLiveProfile) *ffmpegStreamArgs {
+	stream := &ffmpegStreamArgs{videoMap: "0:v:0", audioMap: "0:a:0"}
+	if file.Audio == nil {
+		index := stream.addInput("-f", "lavfi", "-i",
+			fmt.Sprintf("anullsrc=channel_layout=stereo:sample_rate=%v", profile.SampleRate),
+		)
+		stream.audioMap, stream.extra = fmt.Sprintf("%v:a:0", index), []string{"-shortest"}
+	}
+
+	// Scale to fit the size, and pad to the size, to keep the aspect ratio.
+	stream.videoFilter = fmt.Sprintf("scale=%v:%v:force_original_aspect_ratio=decrease,pad=%v:%v:(ow-iw)/2:(oh-ih)/2,setsar=1",
+		profile.Width, profile.Height, profile.Width, profile.Height,
+	)
+	stream.videoCodec = []string{
+		"-r", fmt.Sprintf("%v", profile.Fps),
+		"-c:v", "libx264", "-profile:v", "main", "-preset", "veryfast", "-pix_fmt", "yuv420p",
+		"-b:v", fmt.Sprintf("%vk", profile.VideoBitrate), "-maxrate", fmt.Sprintf("%vk", profile.VideoBitrate),
+		"-bufsize", fmt.Sprintf("%vk", profile.VideoBitrate*2),
+		"-g", fmt.Sprintf("%v", profile.Fps*profile.Gop), "-keyint_min", fmt.Sprintf("%v", profile.Fps*profile.Gop),
+		"-sc_threshold", "0", "-bf", "0",
+	}
+	stream.audioCodec = []string{
+		"-c:a", "aac", "-ar", fmt.Sprintf("%v", profile.SampleRate), "-ac", fmt.Sprintf("%v", profile.Channels),
+		"-b:a", fmt.Sprintf("%vk", profile.AudioBitrate),
+	}
+	return stream
+}
+
+// VLiveNormalized is the state of file normalized to the channel profile.
+type VLiveNormalized struct {
+	// The UUID of source file.
+	UUID string `json:"uuid"`
+	// The signature of profile.
+	Signature string `json:"signature"`
+	// The status, pending, running, done or failed.
+	Status string `json:"status"`
+	// The normalized file.
+	Target string `json:"target,omitempty"`
+	// The error if failed.
+	Error string `json:"error,omitempty"`
+	// The update time, in RFC3339.
+	Update string `json:"update"`
+}
+
+func (v *VLiveNormalized) String() string {
+	return fmt.Sprintf("uuid=%v, signature=%v, status=%v, target=%v, error=%v",
+		v.UUID, v.Signature, v.Status, v.Target, v.Error,
+	)
+}
+
+// loadVLiveNormalized load the state of normalized file, nil if not exists.
+func loadVLiveNormalized(ctx context.Context, uuid string) (*VLiveNormalized, error) {
+	b, err := rdb.HGet(ctx, SRS_VLIVE_NORMALIZE, uuid).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hget %v %v", SRS_VLIVE_NORMALIZE, uuid)
+	}
+	if b == "" {
+		return nil, nil
+	}
+
+	var obj VLiveNormalized
+	if err = json.Unmarshal([]byte(b), &obj); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", b)
+	}
+	return &obj, nil
+}
+
+func saveVLiveNormalized(ctx context.Context, obj *VLiveNormalized) error {
+	obj.Update = time.Now().Format(time.RFC3339)
+	if b, err := json.Marshal(obj); err != nil {
+		return errors.Wrapf(err, "marshal %v", obj.String())
+	} else if err = rdb.HSet(ctx, SRS_VLIVE_NORMALIZE, obj.UUID, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", SRS_VLIVE_NORMALIZE, obj.UUID, string(b))
+	}
+	return nil
+}
+
+// VLiveSourceDecision is the decision of source file, whether copy, use the normalized file, or
+// transcode on air.
+type VLiveSourceDecision struct {
+	// The UUID of source file.
+	UUID string `json:"uuid"`
+	// Whether copy the source file.
+	Copy bool `json:"copy"`
+	// The reasons to normalize the file.
+	Reasons []string `json:"reasons,omitempty"`
+	// The state of normalized file, nil if stream or copy.
+	Normalized *VLiveNormalized `json:"normalized,omitempty"`
+}
+
+// decideVLiveSource decide how to play the source file by the profile, the profile is optional.
+func decideVLiveSource(ctx context.Context, file *FFprobeSource, profile *VLiveProfile) (*VLiveSourceDecision, error) {
+	decision := &VLiveSourceDecision{UUID: file.UUID, Copy: true}
+	if profile == nil {
+		return decision, nil
+	}
+
+	if decision.Reasons = vLiveNormalizeReasons(file, profile); len(decision.Reasons) == 0 {
+		return decision, nil
+	}
+	decision.Copy = false
+
+	// The stream is always transcoded on air, it's impossible to transcode in advance.
+	if file.Type == FFprobeSourceTypeStream {
+		return decision, nil
+	}
+
+	normalized, err := loadVLiveNormalized(ctx, file.UUID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load %v", file.UUID)
+	}
+	if normalized != nil && normalized.Signature == profile.signature() {
+		decision.Normalized = normalized
+	} else {
+		decision.Normalized = &VLiveNormalized{UUID: file.UUID, Status: vLiveNormalizePending}
+	}
+	return decision, nil
+}
+
+// buildVLiveSourceArgs build the stream arguments for source file, by the profile of channel. Use the
+// normalized file if ready, or transcode on air if not, so the air-time work is cheap when the file is
+// normalized in background. The target is the input file or stream to use.
+func buildVLiveSourceArgs(ctx context.Context, file *FFprobeSource, profile *VLiveProfile) (target string, stream *ffmpegStreamArgs) {
+	decision, err := decideVLiveSource(ctx, file, profile)
+	if err != nil {
+		logger.Wf(ctx, "vLive: Ignore decide %v err %+v", file.UUID, err)
+		decision = &VLiveSourceDecision{UUID: file.UUID, Reasons: []string{err.Error()}}
+	}
+
+	if decision.Copy {
+		return file.Target, ffmpegCopyStreams()
+	}
+
+	if normalized := decision.Normalized; normalized != nil && normalized.Status == vLiveNormalizeDone {
+		if _, err := os.Stat(normalized.Target); err == nil {
+			return normalized.Target, ffmpegCopyStreams()
+		}
+	}
+
+	logger.Tf(ctx, "vLive: Transcode %v on air, reasons=%v", file.UUID, decision.Reasons)
+	return file.Target, vLiveNormalizeArgs(file, profile)
+}
+
+// normalizeVLiveFile transcode the file to the profile, to a file in dirVLivePath.
+func normalizeVLiveFile(ctx context.Context, file *FFprobeSource, profile *VLiveProfile) (*VLiveNormalized, error) {
+	obj := &VLiveNormalized{
+		UUID: file.UUID, Signature: profile.signature(), Status: vLiveNormalizeRunning,
+		Target: path.Join(dirVLivePath, fmt.Sprintf("%v.normalized.ts", file.UUID)),
+	}
+	if err := saveVLiveNormalized(ctx, obj); err != nil {
+		return nil, errors.Wrapf(err, "save %v", obj.String())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, vLiveNormalizeTimeout)
+	defer cancel()
+
+	// Write to a temporary file, then rename it, so the playout never uses a partial file.
+	tempFile := fmt.Sprintf("%v.tmp", obj.Target)
+	defer os.Remove(tempFile)
+
+	// Transcode the remote file from the local copy in cache.
+	local, release, err := acquireVLiveSource(ctx, file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "acquire %v", file.UUID)
+	}
+	defer release()
+
+	stream := vLiveNormalizeArgs(file, profile)
+	args := []string{"-y", "-i", local.Target}
+	args = append(args, stream.inputs...)
+	args = append(args, stream.outputs()...)
+	args = append(args, "-f", "mpegts", tempFile)
+
+	starttime := time.Now()
+	if b, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput(); err != nil {
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		obj.Status, obj.Error = vLiveNormalizeFailed, lines[len(lines)-1]
+	} else if err = os.Rename(tempFile, obj.Target); err != nil {
+		obj.Status, obj.Error = vLiveNormalizeFailed, err.Error()
+	} else {
+		obj.Status = vLiveNormalizeDone
+	}
+
+	if err := saveVLiveNormalized(ctx, obj); err != nil {
+		return nil, errors.Wrapf(err, "save %v", obj.String())
+	}
+	logger.Tf(ctx, "vLive: Normalize file %v, profile=%v, cost=%v, %v",
+		file.UUID, profile.String(), time.Since(starttime), obj.String())
+	return obj, nil
+}
+
+// normalizeFiles normalize the files of all channels one by one, and remove the normalized files which
+// are not used anymore. Note that the failed file is not transcoded again until the profile changes.
+func (v *VLiveWorker) normalizeFiles(ctx context.Context) error {
+	configItems, err := rdb.HGetAll(ctx, SRS_VLIVE_CONFIG).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_VLIVE_CONFIG)
+	}
+
+	used := make(map[string]bool)
+	for platform, configItem := range configItems {
+		var config VLiveConfigure
+		if err = json.Unmarshal([]byte(configItem), &config); err != nil {
+			return errors.Wrapf(err, "unmarshal %v %v", platform, configItem)
+		}
+
+		for _, file := range config.Files {
+			decision, err := decideVLiveSource(ctx, file, config.Profile)
+			if err != nil {
+				return errors.Wrapf(err, "decide %v", file.UUID)
+			}
+			if decision.Normalized == nil {
+				continue
+			}
+
+			used[file.UUID] = true
+			if decision.Normalized.Status != vLiveNormalizePending {
+				continue
+			}
+
+			if _, err := normalizeVLiveFile(ctx, file, config.Profile); err != nil {
+				return errors.Wrapf(err, "normalize %v", file.UUID)
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
+	}
+
+	objs, err := rdb.HGetAll(ctx, SRS_VLIVE_NORMALIZE).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_VLIVE_NORMALIZE)
+	}
+	for uuid, b := range objs {
+		if used[uuid] {
+			continue
+		}
+
+		var obj VLiveNormalized
+		if err := json.Unmarshal([]byte(b), &obj); err == nil && obj.Target != "" {
+			os.Remove(obj.Target)
+		}
+		if err := rdb.HDel(ctx, SRS_VLIVE_NORMALIZE, uuid).Err(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hdel %v %v", SRS_VLIVE_NORMALIZE, uuid)
+		}
+		logger.Tf(ctx, "vLive: Remove normalized file %v", b)
+	}
+
+	return nil
+}
+
+// startNormalize start a goroutine to normalize the files in background.
+func (v *VLiveWorker) startNormalize(ctx context.Context) {
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+
+		// The running state is lost when restart, so transcode it again.
+		if objs, err := rdb.HGetAll(ctx, SRS_VLIVE_NORMALIZE).Result(); err != nil && err != redis.Nil {
+			logger.Wf(ctx, "vLive: Ignore hgetall %v err %+v", SRS_VLIVE_NORMALIZE, err)
+		} else {
+			for uuid, b := range objs {
+				var obj VLiveNormalized
+				if err := json.Unmarshal([]byte(b), &obj); err == nil && obj.Status == vLiveNormalizeRunning {
+					rdb.HDel(ctx, SRS_VLIVE_NORMALIZE, uuid)
+				}
+			}
+		}
+
+		for ctx.Err() == nil {
+			if err := v.normalizeFiles(ctx); err != nil {
+				logger.Wf(ctx, "vLive: Ignore normalize err %+v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(vLiveNormalizeInterval):
+			}
+		}
+	}()
+}
+
+func (v *VLiveWorker) handleProfile(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/ffmpeg/vlive/profile/update"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, platform string
+			var profile VLiveProfile
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string       `json:"token"`
+				Platform *string       `json:"platform"`
+				Profile  *VLiveProfile `json:"profile"`
+			}{
+				Token: &token, Platform: &platform, Profile: &profile,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			conf, err := loadVLiveConfigure(ctx, platform)
+			if err != nil {
+				return errors.Wrapf(err, "load %v", platform)
+			}
+
+			profile.Initialize()
+			if err := profile.Validate(); err != nil {
+				return errors.Wrapf(err, "validate profile")
+			}
+			conf.Profile = &profile
+
+			if b, err := json.Marshal(conf); err != nil {
+				return errors.Wrapf(err, "marshal %v", conf.String())
+			} else if err = rdb.HSet(ctx, SRS_VLIVE_CONFIG, platform, string(b)).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v %v %v", SRS_VLIVE_CONFIG, platform, string(b))
+			}
+
+			// Restart the vLive to apply the profile, the files are transcoded on air before normalized.
+			if task := v.GetTask(platform); task != nil {
+				if err := task.Restart(ctx); err != nil {
+					return errors.Wrapf(err, "restart task %v", platform)
+				}
+			}
+
+			ohttp.WriteData(ctx, w, r, &profile)
+			logger.Tf(ctx, "vLive: Update profile ok, platform=%v, profile=%v, token=%vB", platform, profile.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/vlive/profile/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, platform string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string `json:"token"`
+				Platform *string `json:"platform"`
+			}{
+				Token: &token, Platform: &platform,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			conf, err := loadVLiveConfigure(ctx, platform)
+			if err != nil {
+				return errors.Wrapf(err, "load %v", platform)
+			}
+
+			var decisions []*VLiveSourceDecision
+			for _, file := range conf.Files {
+				decision, err := decideVLiveSource(ctx, file, conf.Profile)
+				if err != nil {
+					return errors.Wrapf(err, "decide %v", file.UUID)
+				}
+				decisions = append(decisions, decision)
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				Profile *VLiveProfile          `json:"profile"`
+				Files   []*VLiveSourceDecision `json:"files"`
+			}{
+				Profile: conf.Profile, Files: decisions,
+			})
+			logger.Tf(ctx, "vLive: Query profile ok, platform=%v, files=%v, token=%vB", platform, len(decisions), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVLiveNormalize_Profile(t *testing.T) {
+	profile := &VLiveProfile{}
+	profile.Initialize()
+	if err := profile.Validate(); err != nil {
+		t.Errorf("Fail for %v, err %+v", profile.String(), err)
+	}
+
+	for _, e := range []*VLiveProfile{
+		{VideoCodec: "h265"},
+		{Width: 1279, Height: 720},
+		{Fps: 120},
+		{Gop: 20},
+		{AudioCodec: "mp3"},
+		{SampleRate: 22050},
+		{Channels: 6},
+	} {
+		e.Initialize()
+		if err := e.Validate(); err == nil {
+			t.Errorf("Fail for %v, should fail", e.String())
+		}
+	}
+}
+
+func TestVLiveNormalize_Reasons(t *testing.T) {
+	profile := &VLiveProfile{}
+	profile.Initialize()
+
+	video := func(codec string, width, height int32, rate string) *FFprobeVideo {
+		return &FFprobeVideo{CodecName: codec, Width: width, Height: height, FrameRate: rate}
+	}
+	audio := func(codec, rate string, channels int32) *FFprobeAudio {
+		return &FFprobeAudio{CodecName: codec, SampleRate: rate, Channels: channels}
+	}
+
+	for _, e := range []struct {
+		file    *FFprobeSource
+		reasons string
+	}{
+		{file: &FFprobeSource{Video: video("h264", 1280, 720, "25/1"), Audio: audio("aac", "44100", 2)}},
+		// The frame rate is unknown for old files.
+		{file: &FFprobeSource{Video: video("h264", 1280, 720, ""), Audio: audio("aac", "44100", 2)}},
+		{file: &FFprobeSource{Video: video("h264", 1280, 720, "30000/1001"), Audio: audio("aac", "44100", 2)}, reasons: "fps 30000/1001"},
+		{file: &FFprobeSource{Video: video("h265", 1920, 1080, "25/1"), Audio: audio("aac", "44100", 2)}, reasons: "vcodec h265,size 1920x1080"},
+		{file: &FFprobeSource{Video: video("h264", 1280, 720, "25/1"), Audio: audio("mp3", "48000", 1)}, reasons: "acodec mp3,rate 48000,channels 1"},
+		{file: &FFprobeSource{Video: video("h264", 1280, 720, "25/1")}, reasons: "no audio"},
+	} {
+		if v := strings.Join(vLiveNormalizeReasons(e.file, profile), ","); v != e.reasons {
+			t.Errorf("Fail for %v, expect %v, actual %v", e.file.String(), e.reasons, v)
+		}
+	}
+}
+
+func TestVLiveNormalize_Args(t *testing.T) {
+	profile := &VLiveProfile{Fps: 30, Gop: 2}
+	profile.Initialize()
+
+	stream := vLiveNormalizeArgs(&FFprobeSource{Audio: &FFprobeAudio{}}, profile)
+	if len(stream.inputs) != 0 {
+		t.Errorf("Fail for inputs %v", stream.inputs)
+	}
+	if v := strings.Join(stream.outputs(), " "); !strings.Contains(v, "-filter_complex [0:v:0]scale=1280:720") ||
+		!strings.Contains(v, "[vout] -map [vout] -map 0:a:0") ||
+		!strings.Contains(v, "-r 30") || !strings.Contains(v, "-g 60") ||
+		!strings.Contains(v, "pad=1280:720") || !strings.Contains(v, "-ar 44100 -ac 2") {
+		t.Errorf("Fail for outputs %v", v)
+	}
+
+	// Generate silent audio if no audio.
+	stream = vLiveNormalizeArgs(&FFprobeSource{}, profile)
+	if v := strings.Join(stream.inputs, " "); v != "-f lavfi -i anullsrc=channel_layout=stereo:sample_rate=44100" {
+		t.Errorf("Fail for inputs %v", v)
+	}
+	if v := strings.Join(stream.outputs(), " "); !strings.Contains(v, "-map [vout] -map 1:a:0") || !strings.HasSuffix(v, "-shortest") {
+		t.Errorf("Fail for outputs %v", v)
+	}
+
+	for _, e := range []struct {
+		rate string
+		fps  float64
+	}{
+		{rate: "25/1", fps: 25}, {rate: "30", fps: 30}, {rate: "0/0", fps: 0}, {rate: "", fps: 0},
+	} {
+		if v := parseFrameRate(e.rate); v != e.fps {
+			t.Errorf("Fail for %v, expect %v, actual %v", e.rate, e.fps, v)
+		}
+	}
+}
@@ -0,0 +1,753 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The max number of items in a playlist.
+const vLivePlaylistLimit = 1000
+
+// VLivePlaylist is the playlist of vLive, to play the files one by one, as a linear channel.
+type VLivePlaylist struct {
+	// The items to play, in order.
+	Items []*VLivePlaylistItem `json:"items"`
+	// Whether shuffle the items, for each cycle.
+	Shuffle bool `json:"shuffle"`
+	// Whether loop the playlist, or stop at the end.
+	Loop bool `json:"loop"`
+}
+
+func (v *VLivePlaylist) String() string {
+	return fmt.Sprintf("items=%v, shuffle=%v, loop=%v", len(v.Items), v.Shuffle, v.Loop)
+}
+
+// Initialize generate the id for new items.
+func (v *VLivePlaylist) Initialize() {
+	for _, item := range v.Items {
+		if item != nil && item.ID == "" {
+			item.ID = uuid.NewString()
+		}
+	}
+}
+
+// Validate the playlist, the source of item should be one of the files.
+func (v *VLivePlaylist) Validate(files []*FFprobeSource) error {
+	if len(v.Items) == 0 {
+		return errors.New("no items")
+	}
+	if len(v.Items) > vLivePlaylistLimit {
+		return errors.Errorf("too many items %v, limit %v", len(v.Items), vLivePlaylistLimit)
+	}
+
+	ids := make(map[string]bool)
+	for i, item := range v.Items {
+		if item == nil {
+			return errors.Errorf("item #%v is nil", i)
+		}
+		if ids[item.ID] {
+			return errors.Errorf("duplicated item %v", item.ID)
+		}
+		ids[item.ID] = true
+
+		var file *FFprobeSource
+		for _, f := range files {
+			if f.UUID == item.Source {
+				file = f
+				break
+			}
+		}
+		if file == nil {
+			return errors.Errorf("no source %v of item #%v", item.Source, i)
+		}
+
+		if item.In < 0 || item.Out < 0 || (item.Out > 0 && item.Out <= item.In) {
+			return errors.Errorf("invalid in %v and out %v of item #%v", item.In, item.Out, i)
+		}
+		if file.Type == FFprobeSourceTypeStream && (item.In > 0 || item.Out > 0) {
+			return errors.Errorf("no in and out for stream of item #%v", i)
+		}
+	}
+	return nil
+}
+
+// VLivePlaylistItem is an item of playlist, which is a file of vLive, with optional in and out points.
+type VLivePlaylistItem struct {
+	// The id of item, generated if empty.
+	ID string `json:"id"`
+	// The UUID of source file, which should be one of the files of vLive.
+	Source string `json:"source"`
+	// The in point in seconds, play from the start if 0.
+	In float64 `json:"in,omitempty"`
+	// The out point in seconds, play to the end if 0.
+	Out float64 `json:"out,omitempty"`
+}
+
+func (v *VLivePlaylistItem) String() string {
+	return fmt.Sprintf("id=%v, source=%v, in=%v, out=%v", v.ID, v.Source, v.In, v.Out)
+}
+
+// VLivePlayoutState is the state of playout, for query.
+type VLivePlayoutState struct {
+	// The id of current item.
+	Item string `json:"item,omitempty"`
+	// The source of current item.
+	Source string `json:"source,omitempty"`
+	// The index of current item in playlist.
+	Index int `json:"index"`
+	// The position of current item in seconds, from the start of file.
+	Position float64 `json:"position"`
+	// The duration of current item in seconds, 0 if unknown.
+	Duration float64 `json:"duration,omitempty"`
+	// The number of cycles played.
+	Cycles int `json:"cycles"`
+	// Whether the playlist is ended, for playlist without loop.
+	Ended bool `json:"ended"`
+}
+
+// VLivePlayout is the playout of playlist, which selects the next item, and allows to update the
+// playlist when it's on air.
+type VLivePlayout struct {
+	// The playlist.
+	playlist *VLivePlaylist
+	// The order of items to play, for current cycle, the item ids.
+	order []string
+	// The cursor of order, the next item to play.
+	cursor int
+	// The number of cycles started.
+	cycles int
+	// The current item, which is not done.
+	current *VLivePlaylistItem
+	// The start time of current item.
+	itemStart time.Time
+	// Whether the playlist is ended.
+	ended bool
+
+	// To protect the fields.
+	lock sync.Mutex
+}
+
+func NewVLivePlayout(playlist *VLivePlaylist) *VLivePlayout {
+	return &VLivePlayout{playlist: playlist}
+}
+
+func (v *VLivePlayout) itemIDs() []string {
+	var ids []string
+	for _, item := range v.playlist.Items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func (v *VLivePlayout) itemOf(id string) (int, *VLivePlaylistItem) {
+	for i, item := range v.playlist.Items {
+		if item.ID == id {
+			return i, item
+		}
+	}
+	return -1, nil
+}
+
+// Current get the current item which is not done, for example, interrupted by failure.
+func (v *VLivePlayout) Current() *VLivePlaylistItem {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.current != nil {
+		v.itemStart = time.Now()
+	}
+	return v.current
+}
+
+// Next select the next item, nil if ended.
+func (v *VLivePlayout) Next() *VLivePlaylistItem {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for !v.ended {
+		// Start a new cycle, or stop at the end.
+		if v.cursor >= len(v.order) {
+			if v.cycles > 0 && !v.playlist.Loop {
+				v.ended = true
+				break
+			}
+			if len(v.playlist.Items) == 0 {
+				return nil
+			}
+
+			v.order, v.cursor, v.cycles = v.itemIDs(), 0, v.cycles+1
+			if v.playlist.Shuffle {
+				rand.Shuffle(len(v.order), func(i, j int) {
+					v.order[i], v.order[j] = v.order[j], v.order[i]
+				})
+			}
+		}
+
+		id := v.order[v.cursor]
+		v.cursor++
+
+		// Ignore the item which is removed.
+		if _, item := v.itemOf(id); item != nil {
+			v.current, v.itemStart = item, time.Now()
+			return item
+		}
+	}
+
+	v.current = nil
+	return nil
+}
+
+// Done mark the current item is done.
+func (v *VLivePlayout) Done() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.current = nil
+}
+
+// Len get the number of items.
+func (v *VLivePlayout) Len() int {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return len(v.playlist.Items)
+}
+
+// Ended whether the playlist is ended.
+func (v *VLivePlayout) Ended() bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.ended
+}
+
+// Update the playlist when it's on air, the current item is not interrupted. If ordered, play the item
+// after the current item in the new playlist. If shuffled, the new items are inserted to the rest of
+// current cycle randomly.
+func (v *VLivePlayout) Update(playlist *VLivePlaylist) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.playlist = playlist
+	v.ended = false
+
+	if !playlist.Shuffle {
+		v.order = v.itemIDs()
+		if v.current != nil {
+			if index, _ := v.itemOf(v.current.ID); index >= 0 {
+				v.cursor = index + 1
+			}
+		}
+		if v.cursor > len(v.order) {
+			v.cursor = len(v.order)
+		}
+		return
+	}
+
+	// The played items of current cycle.
+	played := make(map[string]bool)
+	for _, id := range v.order[:v.cursor] {
+		played[id] = true
+	}
+
+	// Keep the rest items, and insert the new items randomly.
+	var rest []string
+	for _, id := range v.order[v.cursor:] {
+		if _, item := v.itemOf(id); item != nil {
+			rest = append(rest, id)
+		}
+	}
+	for _, id := range v.itemIDs() {
+		if played[id] || slicesContains(rest, id) {
+			continue
+		}
+		index := rand.Intn(len(rest) + 1)
+		rest = append(rest[:index], append([]string{id}, rest[index:]...)...)
+	}
+	v.order = append(v.order[:v.cursor], rest...)
+}
+
+// Query the state of playout.
+func (v *VLivePlayout) Query() *VLivePlayoutState {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	state := &VLivePlayoutState{Cycles: v.cycles, Ended: v.ended, Index: -1}
+	if v.current == nil {
+		return state
+	}
+
+	state.Item, state.Source = v.current.ID, v.current.Source
+	state.Index, _ = v.itemOf(v.current.ID)
+	state.Position = v.current.In + time.Since(v.itemStart).Seconds()
+	if v.current.Out > 0 {
+		state.Duration = v.current.Out - v.current.In
+		if state.Position > v.current.Out {
+			state.Position = v.current.Out
+		}
+	}
+	return state
+}
+
+// vLiveItemSource is the source of items to play, for example, the playlist or the program grid.
+type vLiveItemSource interface {
+	// Current get the current item which is interrupted, nil if none.
+	Current() *VLivePlaylistItem
+	// Next select the next item to play, nil if ended.
+	Next() *VLivePlaylistItem
+	// Done mark the current item is done.
+	Done()
+	// Len get the number of items, to detect all items failed.
+	Len() int
+}
+
+// doPlaylist play the playlist by a FFmpeg process which reads MPEG-TS from stdin, and a FFmpeg process
+// for each item which writes MPEG-TS to stdin, with timestamp offset to keep it continuous. So the
+// connection of output is kept alive when switching items.
+func (v *VLiveTask) doPlaylist(ctx context.Context, playout vLiveItemSource) error {
+	// Create context for current task.
+	parentCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	v.cancel = cancel
+
+	// Build input URL.
+	host := "localhost"
+
+	// Build output URL.
+	outputURL, outputArgs := buildEgressOutput(
+		strings.ReplaceAll(v.config.Server, "localhost", host), v.config.Secret, v.config.Output,
+	)
+
+	// Create a heartbeat to poll and manage the status of FFmpeg process.
+	heartbeat := NewFFmpegHeartbeat(cancel)
+	v.starttime, v.firstReadyTime = &heartbeat.starttime, nil
+	defer func() {
+		v.starttime = nil
+	}()
+
+	// The pipe from items to output.
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return errors.Wrapf(err, "create pipe")
+	}
+	defer pw.Close()
+
+	// The overlay is applied by the output, so it's continuous when switching items.
+	stream := ffmpegCopyStreams()
+	if err := applyOverlay(stream, v.config.Overlay, fmt.Sprintf("%v-%v", overlayKindVLive, v.Platform), v.title()); err != nil {
+		return errors.Wrapf(err, "overlay")
+	}
+
+	args := []string{"-fflags", "+genpts", "-f", "mpegts", "-i", "pipe:0"}
+	args = append(args, stream.inputs...)
+	args = append(args, stream.outputs()...)
+	args = append(args, outputArgs...)
+	// Sign the output by publish token, because the legacy secret may be disabled.
+	publishURL, err := platformPublishURL(ctx, outputURL)
+	if err != nil {
+		return errors.Wrapf(err, "sign %v", outputURL)
+	}
+	args = append(args, publishURL)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdin = pr
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		pr.Close()
+		return errors.Wrapf(err, "pipe process")
+	}
+
+	err = cmd.Start()
+	pr.Close()
+	if err != nil {
+		return errors.Wrapf(err, "execute ffmpeg %v", strings.Join(args, " "))
+	}
+
+	v.PID = int32(cmd.Process.Pid)
+	v.Input, v.inputUUID, v.Output = "pipe:0", "", outputURL
+	defer func() {
+		// If we got a PID, sleep for a while, to avoid too fast restart.
+		if v.PID > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(1 * time.Second):
+			}
+		}
+
+		// When canceled, we should still write to redis, so we must not use ctx(which is cancelled).
+		v.cleanup(parentCtx)
+		v.saveTask(parentCtx)
+	}()
+	logger.Tf(ctx, "vLive: Start playlist, platform=%v, pid=%v", v.Platform, v.PID)
+
+	if err := v.saveTask(ctx); err != nil {
+		return errors.Wrapf(err, "save task %v", v.String())
+	}
+
+	// Pull the latest log frame.
+	heartbeat.Polling(ctx, stderr)
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.firstReadyCtx.Done():
+			v.firstReadyTime = &heartbeat.firstReadyTime
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case frame := <-heartbeat.FrameLogs:
+				v.updateFrame(frame)
+				v.updatePosition(ctx, frame)
+			}
+		}
+	}()
+
+	// Feed the items to output, close the pipe when done, so the output FFmpeg quits.
+	feedDone := make(chan error, 1)
+	go func() {
+		err := v.feedPlaylist(ctx, playout, pw, heartbeat.starttime)
+		pw.Close()
+		feedDone <- err
+	}()
+
+	// Process terminated, or user cancel the process.
+	select {
+	case <-parentCtx.Done():
+	case <-ctx.Done():
+	case <-heartbeat.PollingCtx.Done():
+	}
+	logger.Tf(ctx, "vLive: Playlist stopping, platform=%v, pid=%v", v.Platform, v.PID)
+
+	err = cmd.Wait()
+	cancel()
+	feedErr := <-feedDone
+	logger.Tf(ctx, "vLive: Playlist done, platform=%v, pid=%v, err=%v, feed=%v", v.Platform, v.PID, err, feedErr)
+
+	if feedErr != nil {
+		return errors.Wrapf(feedErr, "feed playlist")
+	}
+	return err
+}
+
+// feedPlaylist play the items one by one, write to the output pipe, until ended or canceled. The
+// timestamp of item is offset by the elapsed time since start, because items are played in realtime.
+func (v *VLiveTask) feedPlaylist(ctx context.Context, playout vLiveItemSource, output *os.File, starttime time.Time) error {
+	var failures int
+	for ctx.Err() == nil {
+		item := playout.Current()
+		if item == nil {
+			item = playout.Next()
+		}
+		if item == nil {
+			logger.Tf(ctx, "vLive: Playlist ended, platform=%v", v.Platform)
+			return nil
+		}
+
+		v.lock.Lock()
+		var input *FFprobeSource
+		for _, f := range v.config.Files {
+			if f.UUID == item.Source {
+				input = f
+				break
+			}
+		}
+		v.lock.Unlock()
+
+		// Ignore the item if source is removed, note that the item without source is a slate.
+		if item.Source != "" && input == nil {
+			logger.Wf(ctx, "vLive: Ignore item %v, no source", item.String())
+			playout.Done()
+			continue
+		}
+
+		offset := time.Since(starttime).Seconds()
+		v.startItemPosition(item, offset)
+		err := v.doPlaylistItem(ctx, item, input, output, offset)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// Skip the failed item, but stop if all items failed, to avoid busy loop.
+		playout.Done()
+		if err == nil {
+			failures = 0
+			continue
+		}
+
+		failures++
+		logger.Wf(ctx, "vLive: Ignore item %v, failures=%v, err %+v", item.String(), failures, err)
+		if failures >= playout.Len() {
+			return errors.Wrapf(err, "all items failed")
+		}
+	}
+	return nil
+}
+
+func (v *VLiveTask) doPlaylistItem(ctx context.Context, item *VLivePlaylistItem, input *FFprobeSource, output *os.File, offset float64) error {
+	args := []string{}
+	if input == nil {
+		// Generate the black slate with silent audio, to fill the gap of program grid.
+		width, height := v.slateSize()
+		args = append(args, "-re",
+			"-f", "lavfi", "-i", fmt.Sprintf("color=c=black:s=%vx%v:r=25", width, height),
+			"-f", "lavfi", "-i", "anullsrc=channel_layout=stereo:sample_rate=44100",
+			"-c:v", "libx264", "-preset", "ultrafast", "-tune", "zerolatency", "-pix_fmt", "yuv420p", "-g", "50",
+			"-c:a", "aac", "-ac", "2", "-ar", "44100", "-b:a", "20k",
+		)
+	} else {
+		// Normalize the item to the profile of channel, so the player never sees the change of codec.
+		v.lock.Lock()
+		profile := v.config.Profile
+		v.lock.Unlock()
+
+		// Play the remote file from the local copy in cache, which is never evicted during playing.
+		local, release, err := acquireVLiveSource(ctx, input)
+		if err != nil {
+			return errors.Wrapf(err, "acquire source")
+		}
+		defer release()
+		target, stream := buildVLiveSourceArgs(ctx, local, profile)
+
+		if input.Type == FFprobeSourceTypeFile || input.Type == FFprobeSourceTypeUpload || input.Type == FFprobeSourceTypeYTDL ||
+			input.Type == FFprobeSourceTypeRemote {
+			args = append(args, "-re")
+			if item.In > 0 {
+				args = append(args, "-ss", fmt.Sprintf("%.3f", item.In))
+			}
+		}
+		// For RTSP stream source, always use TCP transport.
+		if strings.HasPrefix(target, "rtsp://") {
+			args = append(args, "-rtsp_transport", "tcp")
+		}
+		// Rebuild the stream url, because it may contain special characters.
+		if strings.Contains(target, "://") {
+			if u, err := RebuildStreamURL(target); err != nil {
+				return errors.Wrapf(err, "rebuild %v", target)
+			} else {
+				args = append(args, "-i", u.String())
+			}
+		} else {
+			args = append(args, "-i", target)
+		}
+		args = append(args, stream.inputs...)
+		args = append(args, stream.outputs()...)
+	}
+	if item.Out > 0 {
+		args = append(args, "-t", fmt.Sprintf("%.3f", item.Out-item.In))
+	}
+	args = append(args, "-output_ts_offset", fmt.Sprintf("%.3f", offset), "-f", "mpegts", "pipe:1")
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdout = output
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "execute ffmpeg %v", strings.Join(args, " "))
+	}
+
+	v.lock.Lock()
+	v.ItemPID = int32(cmd.Process.Pid)
+	v.lock.Unlock()
+	v.saveTask(ctx)
+	logger.Tf(ctx, "vLive: Play item %v, offset=%.3f, pid=%v", item.String(), offset, cmd.Process.Pid)
+
+	err := cmd.Wait()
+
+	v.lock.Lock()
+	v.ItemPID = 0
+	v.lock.Unlock()
+
+	return err
+}
+
+// title get the title of channel, for the template of overlay.
+func (v *VLiveTask) title() string {
+	if v.config.Label != "" {
+		return v.config.Label
+	}
+	return v.Platform
+}
+
+// slateSize get the size of slate, same to the profile or the first video file, to avoid changing the
+// resolution.
+func (v *VLiveTask) slateSize() (width, height int32) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if profile := v.config.Profile; profile != nil {
+		return profile.Width, profile.Height
+	}
+	for _, f := range v.config.Files {
+		if f.Video != nil && f.Video.Width > 0 && f.Video.Height > 0 {
+			return f.Video.Width, f.Video.Height
+		}
+	}
+	return 1280, 720
+}
+
+// queryPlayout get the state of playout, nil if not playlist.
+func (v *VLiveTask) queryPlayout() *VLivePlayoutState {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.playout == nil {
+		return nil
+	}
+	return v.playout.Query()
+}
+
+// updatePlaylist update the playlist when it's on air, restart if not playing a playlist.
+func (v *VLiveTask) updatePlaylist(ctx context.Context, playlist *VLivePlaylist) error {
+	v.lock.Lock()
+	playout := v.playout
+	if playout != nil {
+		v.config.Playlist = playlist
+	}
+	v.lock.Unlock()
+
+	if playout == nil {
+		return v.Restart(ctx)
+	}
+
+	playout.Update(playlist)
+	return nil
+}
+
+func (v *VLiveWorker) handlePlaylist(ctx context.Context, handler *http.ServeMux) {
+	// Load the configure, and update the playlist by fn, then save and apply it.
+	updatePlaylist := func(ctx context.Context, platform string, fn func(conf *VLiveConfigure) error) (*VLivePlaylist, error) {
+		if platform == "" {
+			return nil, errors.New("no platform")
+		}
+
+		var conf VLiveConfigure
+		if b, err := rdb.HGet(ctx, SRS_VLIVE_CONFIG, platform).Result(); err != nil && err != redis.Nil {
+			return nil, errors.Wrapf(err, "hget %v %v", SRS_VLIVE_CONFIG, platform)
+		} else if b == "" {
+			return nil, errors.Errorf("no vLive %v", platform)
+		} else if err = json.Unmarshal([]byte(b), &conf); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v", b)
+		}
+
+		if err := fn(&conf); err != nil {
+			return nil, err
+		}
+
+		conf.Playlist.Initialize()
+		if err := conf.Playlist.Validate(conf.Files); err != nil {
+			return nil, errors.Wrapf(err, "validate playlist")
+		}
+
+		if b, err := json.Marshal(&conf); err != nil {
+			return nil, errors.Wrapf(err, "marshal %v", conf.String())
+		} else if err = rdb.HSet(ctx, SRS_VLIVE_CONFIG, platform, string(b)).Err(); err != nil && err != redis.Nil {
+			return nil, errors.Wrapf(err, "hset %v %v %v", SRS_VLIVE_CONFIG, platform, string(b))
+		}
+
+		// Apply to the playlist on air, without interrupting the current item.
+		if task := v.GetTask(platform); task != nil {
+			if err := task.updatePlaylist(ctx, conf.Playlist); err != nil {
+				return nil, errors.Wrapf(err, "update task %v", platform)
+			}
+		}
+		return conf.Playlist, nil
+	}
+
+	ep := "/terraform/v1/ffmpeg/vlive/playlist/update"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, platform string
+			var playlist VLivePlaylist
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string        `json:"token"`
+				Platform *string        `json:"platform"`
+				Playlist *VLivePlaylist `json:"playlist"`
+			}{
+				Token: &token, Platform: &platform, Playlist: &playlist,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			res, err := updatePlaylist(ctx, platform, func(conf *VLiveConfigure) error {
+				conf.Playlist = &playlist
+				return nil
+			})
+			if err != nil {
+				return errors.Wrapf(err, "update playlist of %v", platform)
+			}
+
+			ohttp.WriteData(ctx, w, r, res)
+			logger.Tf(ctx, "vLive: Update playlist ok, platform=%v, playlist=%v, token=%vB", platform, res.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ffmpeg/vlive/playlist/append"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, platform string
+			var items []*VLivePlaylistItem
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string               `json:"token"`
+				Platform *string               `json:"platform"`
+				Items    *[]*VLivePlaylistItem `json:"items"`
+			}{
+				Token: &token, Platform: &platform, Items: &items,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if len(items) == 0 {
+				return errors.New("no items")
+			}
+
+			res, err := updatePlaylist(ctx, platform, func(conf *VLiveConfigure) error {
+				if conf.Playlist == nil {
+					conf.Playlist = &VLivePlaylist{}
+				}
+				conf.Playlist.Items = append(conf.Playlist.Items, items...)
+				return nil
+			})
+			if err != nil {
+				return errors.Wrapf(err, "append playlist of %v", platform)
+			}
+
+			ohttp.WriteData(ctx, w, r, res)
+			logger.Tf(ctx, "vLive: Append playlist ok, platform=%v, items=%v, token=%vB", platform, len(items), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
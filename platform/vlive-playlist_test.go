@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func newTestPlaylist(loop, shuffle bool, ids ...string) *VLivePlaylist {
+	playlist := &VLivePlaylist{Loop: loop, Shuffle: shuffle}
+	for _, id := range ids {
+		playlist.Items = append(playlist.Items, &VLivePlaylistItem{ID: id, Source: "s" + id})
+	}
+	return playlist
+}
+
+// playNext play count items and return the ids, the item is done when selected.
+func playNext(playout *VLivePlayout, count int) string {
+	var ids []string
+	for i := 0; i < count; i++ {
+		item := playout.Next()
+		if item == nil {
+			break
+		}
+		ids = append(ids, item.ID)
+		playout.Done()
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestVLivePlaylist_Ordered(t *testing.T) {
+	playout := NewVLivePlayout(newTestPlaylist(false, false, "a", "b", "c"))
+	if v := playNext(playout, 10); v != "a,b,c" {
+		t.Errorf("Fail for %v", v)
+	}
+	if !playout.Ended() {
+		t.Errorf("Fail for not ended")
+	}
+
+	// Append when ended, continue to play the new item.
+	playout.Update(newTestPlaylist(false, false, "a", "b", "c", "d"))
+	if v := playNext(playout, 10); v != "d" {
+		t.Errorf("Fail for %v", v)
+	}
+
+	playout = NewVLivePlayout(newTestPlaylist(true, false, "a", "b"))
+	if v := playNext(playout, 5); v != "a,b,a,b,a" {
+		t.Errorf("Fail for %v", v)
+	}
+	if v := playout.Query(); v.Cycles != 3 || v.Ended {
+		t.Errorf("Fail for %v", v)
+	}
+}
+
+func TestVLivePlaylist_UpdateOnAir(t *testing.T) {
+	playout := NewVLivePlayout(newTestPlaylist(false, false, "a", "b", "c"))
+	if item := playout.Next(); item == nil || item.ID != "a" {
+		t.Errorf("Fail for %v", item)
+		return
+	}
+
+	// Reorder when playing a, the next is the item after a in the new order.
+	playout.Update(newTestPlaylist(false, false, "c", "a", "b"))
+	if v := playout.Query(); v.Item != "a" || v.Index != 1 || v.Source != "sa" {
+		t.Errorf("Fail for %v", v)
+	}
+	if v := playout.Current(); v == nil || v.ID != "a" {
+		t.Errorf("Fail for current %v", v)
+	}
+	playout.Done()
+	if v := playNext(playout, 10); v != "b" {
+		t.Errorf("Fail for %v", v)
+	}
+
+	// The removed item is ignored.
+	playout = NewVLivePlayout(newTestPlaylist(false, true, "a", "b", "c"))
+	first := playout.Next()
+	playout.Done()
+	var rest []string
+	for _, id := range []string{"a", "b", "c", "d"} {
+		if id != first.ID {
+			rest = append(rest, id)
+		}
+	}
+	playout.Update(newTestPlaylist(false, true, append([]string{first.ID}, rest...)...))
+	played := strings.Split(playNext(playout, 10), ",")
+	sort.Strings(played)
+	if v := strings.Join(played, ","); v != strings.Join(rest, ",") {
+		t.Errorf("Fail for played %v, expect %v", v, rest)
+	}
+}
+
+func TestVLivePlaylist_Validate(t *testing.T) {
+	files := []*FFprobeSource{
+		{UUID: "f1", Type: FFprobeSourceTypeUpload}, {UUID: "s1", Type: FFprobeSourceTypeStream},
+	}
+	for _, e := range []struct {
+		items []*VLivePlaylistItem
+		err   bool
+	}{
+		{items: []*VLivePlaylistItem{{Source: "f1"}, {Source: "f1", In: 10, Out: 20}, {Source: "s1"}}},
+		{items: nil, err: true},
+		{items: []*VLivePlaylistItem{{Source: "f2"}}, err: true},
+		{items: []*VLivePlaylistItem{{Source: "f1", In: 20, Out: 10}}, err: true},
+		{items: []*VLivePlaylistItem{{Source: "f1", In: -1}}, err: true},
+		{items: []*VLivePlaylistItem{{Source: "s1", In: 10}}, err: true},
+		{items: []*VLivePlaylistItem{{ID: "a", Source: "f1"}, {ID: "a", Source: "f1"}}, err: true},
+	} {
+		playlist := &VLivePlaylist{Items: e.items}
+		playlist.Initialize()
+		if err := playlist.Validate(files); (err != nil) != e.err {
+			t.Errorf("Fail for %v, err %+v", playlist.String(), err)
+		}
+	}
+}
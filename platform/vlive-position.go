@@ -0,0 +1,317 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The interval to save the playback position of vLive.
+const vLivePositionSaveInterval = 5 * time.Second
+
+// VLivePosition is the playback position of vLive, to resume after restart.
+type VLivePosition struct {
+	// The UUID of source file.
+	Source string `json:"source"`
+	// The id of playlist item, empty if not playlist.
+	Item string `json:"item,omitempty"`
+	// The position in seconds of source file.
+	Position float64 `json:"position"`
+	// The update time, in RFC3339.
+	Update string `json:"update"`
+}
+
+func (v *VLivePosition) String() string {
+	return fmt.Sprintf("source=%v, item=%v, position=%.3f, update=%v", v.Source, v.Item, v.Position, v.Update)
+}
+
+// parseFFmpegTime parse the time of FFmpeg log to seconds, for example, 00:10:09.38
+func parseFFmpegTime(timestamp string) (float64, error) {
+	negative := strings.HasPrefix(timestamp, "-")
+	parts := strings.Split(strings.TrimPrefix(timestamp, "-"), ":")
+	if len(parts) != 3 {
+		return 0, errors.Errorf("invalid time %v", timestamp)
+	}
+
+	var seconds float64
+	for _, part := range parts {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "parse %v of %v", part, timestamp)
+		}
+		seconds = seconds*60 + value
+	}
+
+	if negative {
+		return -seconds, nil
+	}
+	return seconds, nil
+}
+
+func loadVLivePosition(ctx context.Context, platform string) (*VLivePosition, error) {
+	b, err := rdb.HGet(ctx, SRS_VLIVE_POSITION, platform).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hget %v %v", SRS_VLIVE_POSITION, platform)
+	}
+	if b == "" {
+		return nil, nil
+	}
+
+	var obj VLivePosition
+	if err = json.Unmarshal([]byte(b), &obj); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", b)
+	}
+	return &obj, nil
+}
+
+func saveVLivePosition(ctx context.Context, platform string, obj *VLivePosition) error {
+	obj.Update = time.Now().Format(time.RFC3339)
+	if b, err := json.Marshal(obj); err != nil {
+		return errors.Wrapf(err, "marshal %v", obj.String())
+	} else if err = rdb.HSet(ctx, SRS_VLIVE_POSITION, platform, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", SRS_VLIVE_POSITION, platform, string(b))
+	}
+	return nil
+}
+
+// resumePosition get the position to resume the source file, 0 if not resume. The position is wrapped
+// by the duration, because the file is played in loop.
+func resumePosition(pos *VLivePosition, file *FFprobeSource) float64 {
+	if pos == nil || pos.Item != "" || pos.Source != file.UUID || pos.Position <= 0 {
+		return 0
+	}
+	if file.Type == FFprobeSourceTypeStream {
+		return 0
+	}
+
+	position := pos.Position
+	if duration := vLiveFileDuration([]*FFprobeSource{file}, file.UUID); duration > 0 {
+		position = math.Mod(position, duration)
+	}
+	return position
+}
+
+// startPosition start to track the position of source file, which is played from base in seconds.
+func (v *VLiveTask) startPosition(source *FFprobeSource, base float64) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.position = &VLivePosition{Source: source.UUID, Position: base}
+	v.positionBase, v.positionSaved = base, time.Time{}
+	v.positionDuration = vLiveFileDuration([]*FFprobeSource{source}, source.UUID)
+	if source.Type == FFprobeSourceTypeStream {
+		v.position = nil
+	}
+}
+
+// startItemPosition start to track the position of playlist item, which starts at offset of output.
+func (v *VLiveTask) startItemPosition(item *VLivePlaylistItem, offset float64) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.position = &VLivePosition{Source: item.Source, Item: item.ID, Position: item.In}
+	v.positionBase, v.positionDuration = item.In-offset, 0
+
+	// The item of grid is selected by wall-clock, and the slate has no source, so never resume.
+	if v.grid != nil || item.Source == "" {
+		v.position = nil
+	}
+}
+
+// updatePosition update the position by the time of FFmpeg log, and save it for a while.
+func (v *VLiveTask) updatePosition(ctx context.Context, frame string) {
+	timestamp, _, err := ParseFFmpegCycleLog(frame)
+	if err != nil {
+		return
+	}
+	t, err := parseFFmpegTime(timestamp)
+	if err != nil {
+		return
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.position == nil {
+		return
+	}
+
+	position := v.positionBase + t
+	if v.positionDuration > 0 {
+		position = math.Mod(position, v.positionDuration)
+	}
+	if position < 0 {
+		return
+	}
+	v.position.Position = position
+
+	// Never save when canceled, because the position might be changed by seek.
+	if ctx.Err() != nil || time.Since(v.positionSaved) < vLivePositionSaveInterval {
+		return
+	}
+	v.positionSaved = time.Now()
+
+	if err := saveVLivePosition(ctx, v.Platform, v.position); err != nil {
+		logger.Wf(ctx, "vLive: Ignore save position %v err %+v", v.position.String(), err)
+	}
+}
+
+// queryPosition get the current position, nil if not playing.
+func (v *VLiveTask) queryPosition() *VLivePosition {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.position == nil {
+		return nil
+	}
+
+	position := *v.position
+	return &position
+}
+
+// Seek to the position of current source or item, or restart the current item. The FFmpeg is restarted
+// and resumes from the position.
+func (v *VLiveTask) Seek(ctx context.Context, position float64, restart bool) (*VLivePosition, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.position == nil {
+		return nil, errors.Errorf("no position of %v", v.Platform)
+	}
+	pos := *v.position
+
+	// The range of item or file.
+	var start, stop float64
+	if pos.Item != "" && v.playout != nil {
+		start, stop = v.playout.itemRange(pos.Item)
+	}
+	if stop == 0 {
+		stop = vLiveFileDuration(v.config.Files, pos.Source)
+	}
+
+	if restart {
+		position = start
+	}
+	if position < start || (stop > 0 && position >= stop) {
+		return nil, errors.Errorf("invalid position %v, should be in [%v, %v)", position, start, stop)
+	}
+	pos.Position = position
+
+	// Cancel the FFmpeg before saving, so the old position is never saved.
+	if v.cancel != nil {
+		v.cancel()
+	}
+	v.position = &pos
+	if pos.Item != "" && v.playout != nil {
+		v.playout.Resume(pos.Item, position)
+	}
+
+	if err := saveVLivePosition(ctx, v.Platform, &pos); err != nil {
+		return nil, errors.Wrapf(err, "save position")
+	}
+	return &pos, nil
+}
+
+// itemRange get the in and out point of item.
+func (v *VLivePlayout) itemRange(id string) (in, out float64) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if _, item := v.itemOf(id); item != nil {
+		return item.In, item.Out
+	}
+	return 0, 0
+}
+
+// Resume the playout at the item, play from the position. The rest items of current cycle are played
+// after it.
+func (v *VLivePlayout) Resume(id string, position float64) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	index, item := v.itemOf(id)
+	if item == nil || (item.Out > 0 && position >= item.Out) {
+		return false
+	}
+
+	if v.cycles == 0 || v.current == nil || v.current.ID != id {
+		v.order, v.cursor = v.itemIDs(), index+1
+		if v.playlist.Shuffle {
+			rest := append(append([]string{}, v.order[:index]...), v.order[index+1:]...)
+			rand.Shuffle(len(rest), func(i, j int) {
+				rest[i], rest[j] = rest[j], rest[i]
+			})
+			v.order, v.cursor = append([]string{id}, rest...), 1
+		}
+		if v.cycles == 0 {
+			v.cycles = 1
+		}
+	}
+
+	// Play a copy of item from the position, the item in playlist is not changed.
+	resumed := *item
+	if position > resumed.In {
+		resumed.In = position
+	}
+	v.current, v.itemStart, v.ended = &resumed, time.Now(), false
+	return true
+}
+
+func (v *VLiveWorker) handlePosition(ctx context.Context, handler *http.ServeMux) {
+	ep := "/terraform/v1/ffmpeg/vlive/seek"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, platform string
+			var position float64
+			var restart bool
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string  `json:"token"`
+				Platform *string  `json:"platform"`
+				Position *float64 `json:"position"`
+				Restart  *bool    `json:"restart"`
+			}{
+				Token: &token, Platform: &platform, Position: &position, Restart: &restart,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			task := v.GetTask(platform)
+			if task == nil {
+				return errors.Errorf("no task of %v", platform)
+			}
+
+			pos, err := task.Seek(ctx, position, restart)
+			if err != nil {
+				return errors.Wrapf(err, "seek %v", platform)
+			}
+
+			ohttp.WriteData(ctx, w, r, pos)
+			logger.Tf(ctx, "vLive: Seek ok, platform=%v, restart=%v, %v, token=%vB", platform, restart, pos.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestVLivePosition_ParseTime(t *testing.T) {
+	for _, e := range []struct {
+		timestamp string
+		seconds   float64
+		ok        bool
+	}{
+		{timestamp: "00:00:00.00", seconds: 0, ok: true},
+		{timestamp: "00:10:09.38", seconds: 609.38, ok: true},
+		{timestamp: "01:00:00.50", seconds: 3600.5, ok: true},
+		{timestamp: "-00:00:00.04", seconds: -0.04, ok: true},
+		{timestamp: "N/A", ok: false},
+		{timestamp: "00:xx:00", ok: false},
+	} {
+		if v, err := parseFFmpegTime(e.timestamp); (err == nil) != e.ok || (e.ok && (v-e.seconds > 0.001 || e.seconds-v > 0.001)) {
+			t.Errorf("Fail for %v, expect %v, actual %v, err %v", e.timestamp, e.seconds, v, err)
+		}
+	}
+}
+
+func TestVLivePosition_Resume(t *testing.T) {
+	file := &FFprobeSource{UUID: "a", Type: FFprobeSourceTypeUpload, Format: &FFprobeFormat{Duration: "100"}}
+	for _, e := range []struct {
+		pos      *VLivePosition
+		position float64
+	}{
+		{pos: nil, position: 0},
+		{pos: &VLivePosition{Source: "a", Position: 30}, position: 30},
+		{pos: &VLivePosition{Source: "a", Position: 130}, position: 30},
+		{pos: &VLivePosition{Source: "b", Position: 30}, position: 0},
+		{pos: &VLivePosition{Source: "a", Item: "1", Position: 30}, position: 0},
+	} {
+		if v := resumePosition(e.pos, file); v != e.position {
+			t.Errorf("Fail for %v, expect %v, actual %v", e.pos, e.position, v)
+		}
+	}
+
+	stream := &FFprobeSource{UUID: "a", Type: FFprobeSourceTypeStream}
+	if v := resumePosition(&VLivePosition{Source: "a", Position: 30}, stream); v != 0 {
+		t.Errorf("Fail for stream, position %v", v)
+	}
+}
+
+func TestVLivePosition_Track(t *testing.T) {
+	task := &VLiveTask{Platform: "wx", config: &VLiveConfigure{}}
+
+	// The file is played in loop from 90s, the position wraps by duration.
+	file := &FFprobeSource{UUID: "a", Type: FFprobeSourceTypeUpload, Format: &FFprobeFormat{Duration: "100"}}
+	task.startPosition(file, 90)
+	task.positionSaved = time.Now().Add(time.Hour)
+	task.updatePosition(context.Background(), "size=1kB time=00:00:15.00 bitrate=1.0kbits/s speed=1x")
+	if v := task.queryPosition(); v == nil || v.Source != "a" || v.Position != 5 {
+		t.Errorf("Fail for position %v", v)
+	}
+
+	// The item starts at 20s of file, and at 100s of output.
+	task.startItemPosition(&VLivePlaylistItem{ID: "1", Source: "a", In: 20, Out: 80}, 100)
+	task.positionSaved = time.Now().Add(time.Hour)
+	task.updatePosition(context.Background(), "size=1kB time=00:01:50.00 bitrate=1.0kbits/s speed=1x")
+	if v := task.queryPosition(); v == nil || v.Item != "1" || v.Position != 30 {
+		t.Errorf("Fail for position %v", v)
+	}
+
+	// The slate has no position.
+	task.startItemPosition(&VLivePlaylistItem{ID: vLiveGridSlate}, 0)
+	if v := task.queryPosition(); v != nil {
+		t.Errorf("Fail for position %v", v)
+	}
+}
+
+func TestVLivePosition_PlayoutResume(t *testing.T) {
+	playout := NewVLivePlayout(&VLivePlaylist{Items: []*VLivePlaylistItem{
+		{ID: "1", Source: "a"}, {ID: "2", Source: "b", In: 10, Out: 60}, {ID: "3", Source: "c"},
+	}, Loop: true})
+
+	if ok := playout.Resume("2", 70); ok {
+		t.Errorf("Fail for resume out of range")
+	}
+	if ok := playout.Resume("2", 30); !ok {
+		t.Errorf("Fail for resume")
+	}
+
+	// Play the resumed item from position, then the next items, and loop.
+	if item := playout.Current(); item == nil || item.ID != "2" || item.In != 30 || item.Out != 60 {
+		t.Errorf("Fail for item %v", item)
+	}
+	playout.Done()
+	if item := playout.Next(); item == nil || item.ID != "3" {
+		t.Errorf("Fail for item %v", item)
+	}
+	if item := playout.Next(); item == nil || item.ID != "1" {
+		t.Errorf("Fail for item %v", item)
+	}
+
+	// The item in playlist is not changed.
+	if in, out := playout.itemRange("2"); in != 10 || out != 60 {
+		t.Errorf("Fail for range %v, %v", in, out)
+	}
+}
@@ -37,6 +37,31 @@ import resources from "./resources/locale.json";
 import {SrsEnvContext} from "./components/SrsEnvContext";
 import Popouts from "./pages/Popouts";
 
+// Refresh the access token and retry the request once, if the access token is expired, for example, the
+// computer wakes up from sleep.
+axios.interceptors.response.use(response => response, async (error) => {
+  const config = error?.config;
+  const message = `${error?.response?.data || ''}`;
+  if (!config || config.oryxRetry || !message.includes('token is expired')) throw error;
+
+  const data = await Token.refresh().catch(() => null);
+  if (!data) throw error;
+
+  // Retry with the new access token, in body or Authorization header.
+  config.oryxRetry = true;
+  if (config.headers?.Authorization) {
+    config.headers.Authorization = `Bearer ${data.token}`;
+  }
+  if (typeof config.data === 'string' && config.data.includes('"token"')) {
+    try {
+      config.data = JSON.stringify({...JSON.parse(config.data), token: data.token});
+    } catch (e) {
+      console.warn(`Token: Ignore invalid body, ${e}`);
+    }
+  }
+  return axios(config);
+});
+
 function App() {
   const [env, setEnv] = React.useState(null);
 
@@ -34,16 +34,19 @@ export function buildUrls(defaultUrl, secret, env) {
 
   const urls = {};
 
+  // The param to publish, the signed token if the legacy secret is disabled.
+  const publishParam = secret?.pubToken ? `pubToken=${secret.pubToken}` : `secret=${secret?.publish}`;
+
   // Build RTMP url.
   if (true) {
     const rtmpPort = isDefaultPort(env.rtmpPort) ? '' : `:${env.rtmpPort}`;
     urls.rtmpServer = `rtmp://${defaultHostname}${rtmpPort}/${defaultApp}/`;
-    urls.rtmpStreamKey = secret ? `${defaultStream}?secret=${secret.publish}` : defaultStream;
+    urls.rtmpStreamKey = secret ? `${defaultStream}?${publishParam}` : defaultStream;
   }
 
   // Build SRT url.
   if (true) {
-    const secretQuery = secret ? `?secret=${secret.publish}` : '';
+    const secretQuery = secret ? `?${publishParam}` : '';
     const srtPort = env.srtPort ? `:${env.srtPort}` : '';
     urls.srtPublishUrl = `srt://${defaultHostname}${srtPort}?streamid=#!::r=${defaultApp}/${defaultStream}${secretQuery},m=publish`;
     urls.srtPlayUrl = `srt://${defaultHostname}${srtPort}?streamid=#!::r=${defaultApp}/${defaultStream},latency=20,m=request`;
@@ -58,7 +61,7 @@ export function buildUrls(defaultUrl, secret, env) {
 
   // The player url.
   if (true) {
-    const secretQuery = secret ? `?secret=${secret.publish}` : '';
+    const secretQuery = secret ? `?${publishParam}` : '';
     const schema = defaultSchema;
     const httpPort = env.httpPort ? env.httpPort : defaultPort;
     const httpUrlPort = isDefaultPort(httpPort) ? '' : `:${httpPort}`;
@@ -74,7 +77,7 @@ export function buildUrls(defaultUrl, secret, env) {
 
   // For WebRTC url.
   if (true) {
-    const secretQuery = secret ? `&secret=${secret.publish}` : '';
+    const secretQuery = secret ? `&${publishParam}` : '';
     const httpPort = env.httpPort ? env.httpPort : defaultPort;
     const httpUrlPort = isDefaultPort(httpPort) ? '' : `:${httpPort}`;
     urls.rtcPublisher = `/players/whip.html?schema=https&port=${httpPort}&api=${httpPort}&autostart=true&stream=${defaultStream}${secretQuery}`;
@@ -150,11 +153,32 @@ export default function useUrls() {
     });
   }, [handleError, setSecret, setLoading]);
 
+  // The signed token to publish the stream, if the legacy secret is disabled. It's empty if failed, for example,
+  // the viewer is not allowed to create token, then fallback to the secret.
+  const [pubToken, setPubToken] = React.useState();
+  React.useEffect(() => {
+    if (loading || !secret || secret.pubLegacy || !rtmpStreamName) return;
+
+    setPubToken(undefined);
+    axios.post('/terraform/v1/hooks/srs/publish/token', {
+      app: 'live', stream: rtmpStreamName, expire: 30 * 24 * 3600,
+    }, {
+      headers: Token.loadBearerHeader(),
+    }).then(res => {
+      setPubToken(res.data.data.token);
+      console.log(`Status: Create publish token ok, stream=${rtmpStreamName}, expire=${res.data.data.expireAt}`);
+    }).catch(e => {
+      setPubToken('');
+      console.warn(`Status: Create publish token failed, stream=${rtmpStreamName}, err=${e}`);
+    });
+  }, [loading, secret, rtmpStreamName, setPubToken]);
+
   React.useEffect(() => {
-    // Ignore if not loaded the secret.
+    // Ignore if not loaded the secret, or the publish token.
     if (loading) return;
+    if (secret && !secret.pubLegacy && pubToken === undefined) return;
 
-    const urls = buildUrls(`live/${rtmpStreamName}`, secret, env);
+    const urls = buildUrls(`live/${rtmpStreamName}`, secret && {...secret, pubToken}, env);
 
     // Build RTMP url.
     if (true) {
@@ -201,7 +225,7 @@ export default function useUrls() {
     }
 
     setReady(true);
-  }, [loading, secret, rtmpStreamName, env, setReady])
+  }, [loading, secret, pubToken, rtmpStreamName, env, setReady])
 
   return {
     ready,
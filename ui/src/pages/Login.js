@@ -25,12 +25,37 @@ function LoginImpl({onLogin}) {
   const [plaintext, setPlaintext] = React.useState(true);
   const [password, setPassword] = React.useState();
   const [operating, setOperating] = React.useState(false);
+  const [ssoEnabled, setSsoEnabled] = React.useState(false);
   const navigate = useNavigate();
   const passwordRef = React.useRef();
   const plaintextRef = React.useRef();
   const handleError = useErrorHandler();
   const {t} = useTranslation();
 
+  // Save the token from SSO callback, which is in the fragment of URL.
+  React.useEffect(() => {
+    const params = new URLSearchParams(window.location.hash.replace(/^#/, ''));
+    if (!params.get('token')) return;
+
+    const data = {
+      token: params.get('token'), refreshToken: params.get('refreshToken'), sid: params.get('sid'),
+      role: params.get('role'), expireAt: params.get('expireAt') || undefined,
+    };
+    console.log(`Login: SSO OK, token is ${Tools.mask(data)}`);
+    Token.save(data);
+    window.history.replaceState(null, '', window.location.pathname);
+
+    onLogin && onLogin();
+    navigate('/routers-scenario');
+  }, [onLogin, navigate]);
+
+  // Whether SSO is enabled.
+  React.useEffect(() => {
+    axios.post('/terraform/v1/mgmt/oidc/query').then(res => {
+      setSsoEnabled(res.data.data?.enabled);
+    }).catch(e => console.warn(`Login: Query SSO failed, ${e}`));
+  }, []);
+
   // Verify the token if exists.
   React.useEffect(() => {
     const token = Token.load();
@@ -107,6 +132,9 @@ function LoginImpl({onLogin}) {
           <Button variant="primary" type="submit" disabled={operating} onClick={(e) => handleLogin(e)}>
             {t('login.labelLogin')}
           </Button> &nbsp;
+          {ssoEnabled && <><Button variant="secondary" href='/terraform/v1/mgmt/oidc/login'>
+            {t('login.labelSSO')}
+          </Button> &nbsp;</>}
           {operating && <Spinner animation="border" variant="success" style={{verticalAlign: 'middle'}} />}
         </Form>
       </Container>
@@ -7,9 +7,20 @@ const SRS_TERRAFORM_TOKEN = 'SRS_TERRAFORM_TOKEN';
 const ORYX_LOCALE = 'ORYX_LOCALE';
 const SRS_STREAM_NAME = 'SRS_STREAM_NAME';
 
+// The access token is refreshed before it expires, in milliseconds.
+const TOKEN_REFRESH_AHEAD = 5 * 60 * 1000;
+
+// The pending refresh, because the refresh token is rotated, and reusing it will logout the session.
+let tokenRefreshing = null;
+let tokenRefreshTimer = null;
+
 export const Token = {
   save: (data) => {
-    localStorage.setItem(SRS_TERRAFORM_TOKEN, JSON.stringify(data));
+    // Never store the API secret, the console only uses the access token of session.
+    const session = {...data};
+    delete session.bearer;
+    localStorage.setItem(SRS_TERRAFORM_TOKEN, JSON.stringify(session));
+    Token.schedule();
   },
   load: () => {
     const info = localStorage.getItem(SRS_TERRAFORM_TOKEN);
@@ -18,27 +29,62 @@ export const Token = {
     const o = JSON.parse(info);
     return {token: o.token};
   },
-  updateBearer: (bearer) => {
-    const info = localStorage.getItem(SRS_TERRAFORM_TOKEN);
-    const o = JSON.parse(info || '{}');
-    o.bearer = bearer;
-    localStorage.setItem(SRS_TERRAFORM_TOKEN, JSON.stringify(o));
-  },
   loadBearer: () => {
     const info = localStorage.getItem(SRS_TERRAFORM_TOKEN);
     const o = JSON.parse(info || '{}');
-    return {token: o.bearer};
+    return {token: o.token};
   },
   loadBearerHeader: () => {
     const info = localStorage.getItem(SRS_TERRAFORM_TOKEN);
     const o = JSON.parse(info || '{}');
-    return o?.bearer ? {'Authorization': `Bearer ${o?.bearer}`} : {};
+    return o?.token ? {'Authorization': `Bearer ${o?.token}`} : {};
   },
   remove: () => {
+    if (tokenRefreshTimer) clearTimeout(tokenRefreshTimer);
+    tokenRefreshTimer = null;
     localStorage.removeItem(SRS_TERRAFORM_TOKEN);
   },
+  // Create new access token by the refresh token, and the refresh token is also rotated.
+  refresh: () => {
+    if (tokenRefreshing) return tokenRefreshing;
+
+    const info = localStorage.getItem(SRS_TERRAFORM_TOKEN);
+    const o = JSON.parse(info || '{}');
+    if (!o.refreshToken) return Promise.reject(new Error('no refresh token'));
+
+    tokenRefreshing = fetch('/terraform/v1/mgmt/token/refresh', {
+      method: 'POST', headers: {'Content-Type': 'application/json'},
+      body: JSON.stringify({refreshToken: o.refreshToken}),
+    }).then(async (res) => {
+      if (!res.ok) throw new Error(`refresh failed, status=${res.status}, ${await res.text()}`);
+
+      const data = (await res.json()).data;
+      console.log(`Token: Refresh ok, token is ${Tools.mask(data)}`);
+      Token.save({...o, ...data});
+      return data;
+    }).finally(() => {
+      tokenRefreshing = null;
+    });
+    return tokenRefreshing;
+  },
+  // Schedule to refresh the access token before it expires.
+  schedule: () => {
+    if (tokenRefreshTimer) clearTimeout(tokenRefreshTimer);
+    tokenRefreshTimer = null;
+
+    const info = localStorage.getItem(SRS_TERRAFORM_TOKEN);
+    const o = JSON.parse(info || '{}');
+    if (!o.refreshToken || !o.expireAt) return;
+
+    const timeout = Math.max(new Date(o.expireAt).getTime() - Date.now() - TOKEN_REFRESH_AHEAD, 0);
+    tokenRefreshTimer = setTimeout(() => {
+      Token.refresh().catch(e => console.warn(`Token: Refresh failed, ${e}`));
+    }, timeout);
+  },
 };
 
+Token.schedule();
+
 export const Locale = {
   _cache: null,
   save: (data) => {